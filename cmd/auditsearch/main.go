@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/audit"
+)
+
+func main() {
+	var q audit.Query
+	flag.StringVar(&q.OrderID, "order", "", "only records of this order ID")
+	flag.StringVar(&q.AccountID, "account", "", "only records of this account")
+	flag.StringVar(&q.ClientID, "client", "", "only records of this client ID")
+	from := flag.String("from", "", "only records at or after this time (RFC 3339)")
+	to := flag.String("to", "", "only records before this time (RFC 3339)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-order id] [-account id] [-client id] [-from time] [-to time] <audit directory>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if q.From, err = parseTime(*from); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -from: %v\n", err)
+		os.Exit(2)
+	}
+	if q.To, err = parseTime(*to); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -to: %v\n", err)
+		os.Exit(2)
+	}
+
+	matched := 0
+	err = audit.Search(flag.Arg(0), q, func(r audit.Record) error {
+		matched++
+		_, err := fmt.Printf("%s\n", r.Marshal())
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d records\n", matched)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
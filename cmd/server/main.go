@@ -1,20 +1,286 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Rishabhsingh78/orderMatchingEngine/internals/apis"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/audit"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/candles"
 	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/executions"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/fix"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/journal"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/marketdata"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/shard"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/tradestore"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
 )
 
 func main() {
-	// Initialize Engine
-	eng := engine.NewEngine()
+	marketPolicy := flag.String("market-policy", string(engine.MarketOrderFillAndKill),
+		"default handling of market orders without a time in force: FILL_AND_KILL or ALL_OR_NONE")
+	protectionTicks := flag.Int64("market-protection-ticks", 0,
+		"max ticks a market order may trade away from the best price on arrival (0 disables)")
+	journalDir := flag.String("journal", "", "directory of the command journal and snapshots (empty disables journaling)")
+	journalSync := flag.String("journal-sync", string(journal.SyncAlways), "journal fsync policy: always, interval or none")
+	journalSyncInterval := flag.Duration("journal-sync-interval", 100*time.Millisecond, "fsync interval for -journal-sync=interval")
+	segmentSize := flag.Int64("journal-segment-size", journal.DefaultOptions().SegmentSize, "journal segment size in bytes")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "how often to snapshot the engine (0 disables periodic snapshots)")
+	pruneJournal := flag.Bool("prune-journal", true, "delete journal segments and snapshots superseded by a new snapshot")
+	requireAccounts := flag.Bool("require-accounts", false, "reject orders that don't name an account")
+	instrumentsFile := flag.String("instruments", "instruments.json", "JSON file of instruments to list at startup (empty allows any symbol)")
+	haltPolicy := flag.String("halt-policy", string(engine.HaltReject),
+		"handling of orders submitted while a book is halted or pre-open: REJECT or QUEUE")
+	breakerBps := flag.Int64("circuit-breaker-bps", 0, "max price move in basis points before a book halts (0 disables)")
+	breakerWindow := flag.Duration("circuit-breaker-window", 5*time.Minute, "how long a circuit breaker reference price lasts (0 keeps it until the book reopens)")
+	breakerHalt := flag.Duration("circuit-breaker-halt", 5*time.Minute, "how long a circuit breaker halt lasts (0 halts until reopened by hand)")
+	tradesDir := flag.String("trades-dir", "", "directory of trade history segments (empty keeps trade history in memory only)")
+	tradesCapacity := flag.Int("trades-capacity", tradestore.DefaultOptions().Capacity, "trades per symbol kept in memory")
+	tradesSegmentSize := flag.Int64("trades-segment-size", tradestore.DefaultOptions().SegmentSize, "trade history segment size in bytes")
+	candlesCapacity := flag.Int("candles-capacity", candles.DefaultCapacity, "candles per symbol and interval kept in memory")
+	apiKeys := flag.String("api-keys", "", "JSON file of API keys to the client IDs they authenticate (empty leaves every client anonymous)")
+	auditDir := flag.String("audit", "", "directory of the audit log of order instructions and executions (empty disables it)")
+	auditMaxBytes := flag.Int64("audit-max-bytes", audit.DefaultOptions().MaxBytes, "audit log file size in bytes after which a new file is started")
+	auditMaxAge := flag.Duration("audit-max-age", audit.DefaultOptions().MaxAge, "audit log file age after which a new file is started")
+	fixAddr := flag.String("fix", "", "address of the FIX 4.4 order entry gateway, e.g. :9878 (empty disables it)")
+	fixCompID := flag.String("fix-comp-id", fix.DefaultOptions().CompID, "the FIX gateway's CompID")
+	fixClients := flag.String("fix-clients", "", "comma-separated SenderCompIDs allowed to log on to the FIX gateway (required with -fix)")
+	fixAccounts := flag.String("fix-accounts", "", "comma-separated SENDERCOMPID=ACCOUNT pairs naming the accounts each FIX session may use")
+	fixDir := flag.String("fix-dir", "", "directory of FIX session sequence numbers and sent messages (empty keeps them in memory only)")
 
-	// Initialize Handlers
-	handler := apis.NewHandler(eng)
+	shards := flag.Int("shards", 0, "number of engine shards to split symbols across (0 runs a single engine)")
+	shardAssign := flag.String("shard-assign", "", "comma-separated SYMBOL=SHARD pins; other symbols are spread by hash")
+	flag.Parse()
+
+	marketConfig := engine.DefaultMarketOrderConfig()
+	marketConfig.Policy = engine.MarketOrderPolicy(*marketPolicy)
+	marketConfig.ProtectionTicks = *protectionTicks
+	if !marketConfig.Policy.Valid() || *protectionTicks < 0 {
+		log.Fatalf("invalid market order config: policy=%s protection_ticks=%d", *marketPolicy, *protectionTicks)
+	}
+	tradingConfig := engine.TradingConfig{
+		HaltPolicy: engine.HaltPolicy(*haltPolicy),
+		CircuitBreaker: engine.CircuitBreaker{
+			MaxMoveBps:   *breakerBps,
+			WindowMillis: breakerWindow.Milliseconds(),
+			HaltMillis:   breakerHalt.Milliseconds(),
+		},
+	}
+	if !tradingConfig.Valid() {
+		log.Fatalf("invalid trading config: %+v", tradingConfig)
+	}
+	if *fixAddr != "" && *fixClients == "" {
+		log.Fatal("-fix needs -fix-clients")
+	}
+	configure := func(eng *engine.Engine) {
+		eng.MarketConfig = marketConfig
+		eng.TradingConfig = tradingConfig
+		eng.RequireAccounts(*requireAccounts)
+	}
+	journalOpts := journal.Options{
+		Sync:         journal.SyncPolicy(*journalSync),
+		SyncInterval: *journalSyncInterval,
+		SegmentSize:  *segmentSize,
+	}
+
+	// Initialize Engine, or one per shard, and Handlers
+	var (
+		handler *apis.Handler
+		src     source
+		sweep   func(now int64) error
+		engines []*engine.Engine
+		// orderEntry takes the FIX gateway's orders
+		orderEntry   fix.Engine
+		localOrderID func(string) string
+	)
+	if *shards > 0 {
+		assign, err := parseAssignments(*shardAssign)
+		if err != nil {
+			log.Fatalf("invalid -shard-assign: %v", err)
+		}
+		set, err := shard.Open(shard.Options{
+			Shards:    *shards,
+			Assign:    assign,
+			Dir:       *journalDir,
+			Journal:   journalOpts,
+			Prune:     *pruneJournal,
+			Configure: configure,
+		})
+		if err != nil {
+			log.Fatalf("failed to open shards: %v", err)
+		}
+		defer set.Close()
+		for _, sh := range set.Shards() {
+			if *journalDir != "" {
+				log.Printf("Shard %d recovered from snapshot %d plus %d journaled commands (seq %d)",
+					sh.Index, sh.Recovered.SnapshotSeq, sh.Recovered.Records, sh.Engine.LastSeq())
+				if *snapshotInterval > 0 {
+					go sh.Snapshots.Run(*snapshotInterval, nil)
+				}
+			}
+		}
+
+		router := apis.NewShardRouter(set)
+		handler = apis.NewHandler(router)
+		handler.Shards = set
+		orderEntry, localOrderID = router, shard.LocalOrderID
+		if *journalDir != "" {
+			handler.Snapshots = set
+		}
+		src, sweep = set, set.Sweep
+		for _, sh := range set.Shards() {
+			engines = append(engines, sh.Engine)
+		}
+	} else {
+		eng := engine.NewEngine()
+		configure(eng)
+		handler = apis.NewHandler(eng)
+
+		// Recover state from the latest snapshot and journal, then keep journaling
+		if *journalDir != "" {
+			report, err := journal.Recover(*journalDir, eng)
+			if err != nil {
+				log.Fatalf("journal recovery failed: %v", err)
+			}
+			log.Printf("Recovered from snapshot %d plus %d journaled commands (seq %d)",
+				report.SnapshotSeq, report.Records, eng.LastSeq())
+
+			w, err := journal.Open(*journalDir, journalOpts)
+			if err != nil {
+				log.Fatalf("failed to open journal: %v", err)
+			}
+			defer w.Close()
+			eng.SetJournal(w)
+
+			snapshots := &journal.Snapshotter{Engine: eng, Writer: w, Prune: *pruneJournal}
+			handler.Snapshots = snapshots
+			if *snapshotInterval > 0 {
+				go snapshots.Run(*snapshotInterval, nil)
+			}
+		}
+		src = eng
+		engines = []*engine.Engine{eng}
+		orderEntry = eng
+		sweep = func(now int64) error {
+			if _, err := eng.ExpireOrders(now); err != nil {
+				return err
+			}
+			_, err := eng.ReopenHalts(now)
+			return err
+		}
+	}
+
+	// List configured instruments the recovered registry doesn't have yet;
+	// changes made through the admin API since take precedence
+	if *instrumentsFile != "" {
+		instruments, err := loadInstruments(*instrumentsFile)
+		if err != nil {
+			log.Fatalf("failed to load instruments: %v", err)
+		}
+		for _, inst := range instruments {
+			if _, err := handler.Engine.GetInstrument(inst.Symbol); err == nil {
+				continue
+			}
+			if err := handler.Engine.AddInstrument(inst); err != nil {
+				log.Fatalf("instrument %s: %v", inst.Symbol, err)
+			}
+		}
+		log.Printf("Trading %d instruments", len(handler.Engine.Instruments()))
+	}
+
+	// Trade history and candles; attached after recovery so replayed trades
+	// aren't counted twice
+	trades, err := tradestore.Open(tradestore.Options{
+		Capacity:    *tradesCapacity,
+		Dir:         *tradesDir,
+		SegmentSize: *tradesSegmentSize,
+	})
+	if err != nil {
+		log.Fatalf("failed to open trade history: %v", err)
+	}
+	defer trades.Close()
+	candleAgg := candles.NewAggregator(*candlesCapacity)
+	src.Subscribe(trades)
+	src.Subscribe(candleAgg)
+	handler.Trades = trades
+	handler.Candles = candleAgg
+
+	// Audit log of instructions, from the API, and of what the engine did
+	// with them, from its events
+	var auditLog *audit.Log
+	if *auditDir != "" {
+		opts := audit.DefaultOptions()
+		opts.MaxBytes, opts.MaxAge = *auditMaxBytes, *auditMaxAge
+		auditLog, err = audit.Open(*auditDir, opts)
+		if err != nil {
+			log.Fatalf("failed to open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		for _, eng := range engines {
+			for _, book := range eng.State().Books {
+				if err := auditLog.Track(book.Orders); err != nil {
+					log.Fatalf("failed to track orders in the audit log: %v", err)
+				}
+			}
+			eng.Events().Subscribe(auditLog)
+		}
+		handler.Audit = auditLog
+	}
+
+	if *apiKeys != "" {
+		keys, err := auth.LoadKeys(*apiKeys)
+		if err != nil {
+			log.Fatalf("failed to load API keys: %v", err)
+		}
+		handler.Keys = keys
+	}
+
+	// FIX order entry
+	if *fixAddr != "" {
+		opts := fix.DefaultOptions()
+		opts.CompID, opts.Audit, opts.Dir, opts.LocalOrderID = *fixCompID, auditLog, *fixDir, localOrderID
+		opts.Clients = strings.Split(*fixClients, ",")
+		accounts, err := parseAccounts(*fixAccounts)
+		if err != nil {
+			log.Fatalf("invalid -fix-accounts: %v", err)
+		}
+		opts.Accounts = accounts
+		gateway := fix.NewGateway(orderEntry, opts)
+		src.Subscribe(gateway)
+		defer gateway.Close()
+		go func() {
+			log.Printf("Starting FIX gateway on %s as %s", *fixAddr, opts.CompID)
+			if err := gateway.ListenAndServe(*fixAddr); err != nil && err != utils.ErrGatewayClosed {
+				log.Fatalf("FIX gateway: %v", err)
+			}
+		}()
+	}
+
+	// Market data feed and private execution streams
+	hub := marketdata.NewHub(src)
+	hub.StreamCandles(candleAgg)
+	handler.MarketData = hub
+	handler.Executions = executions.NewStream(src)
+
+	// Expire DAY/GTD orders and reopen books whose circuit breaker halt is over
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := sweep(now.UnixMilli()); err != nil {
+				log.Printf("sweep failed: %v", err)
+			}
+		}
+	}()
 
 	// Initialize Router
 	router := apis.NewRouter(handler)
@@ -32,3 +298,58 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// source is the engine, or set of shards, whose events the feeds and trade
+// history are built from.
+type source interface {
+	marketdata.Source
+	executions.Source
+}
+
+// parseAssignments reads SYMBOL=SHARD pairs separated by commas.
+func parseAssignments(s string) (map[string]int, error) {
+	assign := make(map[string]int)
+	if s == "" {
+		return assign, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		symbol, n, ok := strings.Cut(pair, "=")
+		if !ok || symbol == "" {
+			return nil, fmt.Errorf("%q is not SYMBOL=SHARD", pair)
+		}
+		index, err := strconv.Atoi(n)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not SYMBOL=SHARD", pair)
+		}
+		assign[symbol] = index
+	}
+	return assign, nil
+}
+
+// parseAccounts reads SENDERCOMPID=ACCOUNT pairs separated by commas.
+func parseAccounts(s string) (map[string][]string, error) {
+	accounts := make(map[string][]string)
+	if s == "" {
+		return accounts, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		compID, account, ok := strings.Cut(pair, "=")
+		if !ok || compID == "" || account == "" {
+			return nil, fmt.Errorf("%q is not SENDERCOMPID=ACCOUNT", pair)
+		}
+		accounts[compID] = append(accounts[compID], account)
+	}
+	return accounts, nil
+}
+
+func loadInstruments(path string) ([]engine.Instrument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var instruments []engine.Instrument
+	if err := json.Unmarshal(data, &instruments); err != nil {
+		return nil, err
+	}
+	return instruments, nil
+}
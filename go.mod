@@ -2,4 +2,6 @@ module github.com/Rishabhsingh78/orderMatchingEngine
 
 go 1.21
 
-require github.com/gorilla/mux v1.8.1 
+require github.com/gorilla/mux v1.8.1
+
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,63 @@
+package apis
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/audit"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+)
+
+// auditHealth fails once the audit log has stopped, so that no order
+// instruction is applied without being recorded.
+func (h *Handler) auditHealth() error {
+	if h.Audit == nil {
+		return nil
+	}
+	return h.Audit.Err()
+}
+
+// auditSubmit records an order submission and its outcome. order is nil if
+// the request couldn't be read.
+func (h *Handler) auditSubmit(r *http.Request, received time.Time, order *engine.Order, reason string) {
+	if h.Audit == nil {
+		return
+	}
+	// Once in the book the order is the book's; read a copy
+	if order != nil {
+		if current, err := h.Engine.GetOrder(order.ID); err == nil {
+			order = current
+		}
+	}
+	h.Audit.Write(audit.Submitted(received, auth.ClientID(r.Context()), order, reason))
+}
+
+// auditedOrder returns the order as it is before an instruction changes it,
+// if the audit log needs it.
+func (h *Handler) auditedOrder(orderID string) *engine.Order {
+	if h.Audit == nil {
+		return nil
+	}
+	order, err := h.Engine.GetOrder(orderID)
+	if err != nil {
+		return nil
+	}
+	return order
+}
+
+// auditChange records a cancel or amend of an order, and the order's state
+// after it unless it failed.
+func (h *Handler) auditChange(r *http.Request, received time.Time, action audit.Action, orderID string, before *engine.Order, err error) {
+	if h.Audit == nil {
+		return
+	}
+	var after *engine.Order
+	var reason string
+	if err != nil {
+		reason = err.Error()
+	} else {
+		after, _ = h.Engine.GetOrder(orderID)
+	}
+	h.Audit.Write(audit.Changed(received, action, auth.ClientID(r.Context()), orderID, before, after, reason))
+}
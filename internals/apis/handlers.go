@@ -2,81 +2,247 @@ package apis
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/audit"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/candles"
 	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/shard"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/tradestore"
 	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
 	"github.com/gorilla/mux"
 )
 
 type Handler struct {
-	Engine *engine.Engine
+	Engine Engine
+	// Shards is set when Engine is a ShardRouter, to serve shard placement.
+	Shards *shard.Set
+	// Snapshots is nil when the engine isn't journaling.
+	Snapshots Snapshotter
+	// MarketData serves the WebSocket market data feed, if enabled.
+	MarketData http.Handler
+	// Executions serves each client's execution report stream, if enabled.
+	Executions http.Handler
+	// Trades is the trade history, if kept.
+	Trades *tradestore.Store
+	// Candles are the OHLCV bars built from trades, if kept.
+	Candles *candles.Aggregator
+	// Audit records every order instruction, if kept.
+	Audit *audit.Log
+	// Keys authenticates clients by API key. Without it every request is
+	// anonymous, and nobody can stream execution reports.
+	Keys *auth.Keys
 }
 
-func NewHandler(e *engine.Engine) *Handler {
+const (
+	defaultTradesLimit = 100
+	maxTradesLimit     = 1000
+)
+
+type Snapshotter interface {
+	Snapshot() (uint64, error)
+}
+
+// Engine is what the handlers need from the engine: an *engine.Engine, or
+// a ShardRouter in front of several.
+type Engine interface {
+	SubmitOrder(order *engine.Order) ([]engine.Trade, error)
+	CancelOrder(orderID string) error
+	AmendOrder(orderID string, price, quantity int64) ([]engine.Trade, error)
+	GetOrder(orderID string) (*engine.Order, error)
+	GetOrderBook(symbol string) *engine.OrderBook
+	Tickers() []engine.Ticker
+
+	CreateAccount(id string, limits engine.RiskLimits) error
+	SetRiskLimits(id string, limits engine.RiskLimits) error
+	SetSelfTradePrevention(id string, mode engine.SelfTradePrevention) error
+	AdjustBalance(id, asset string, amount int64) error
+	GetAccount(id string) (engine.Account, error)
+
+	AddInstrument(inst engine.Instrument) error
+	UpdateInstrument(inst engine.Instrument) error
+	GetInstrument(symbol string) (engine.Instrument, error)
+	Instruments() []engine.Instrument
+
+	SetTradingState(symbol string, state engine.TradingState) ([]engine.Trade, error)
+	SetTradingConfig(symbol string, cfg engine.TradingConfig) error
+}
+
+// SymbolLedgers is implemented by engines that keep a ledger per shard of
+// symbols, where a deposit has to say which symbol's ledger it goes to.
+type SymbolLedgers interface {
+	AdjustBalanceFor(symbol, id, asset string, amount int64) error
+}
+
+func NewHandler(e Engine) *Handler {
 	return &Handler{Engine: e}
 }
 
 func (h *Handler) SubmitOrder(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Symbol   string           `json:"symbol"`
-		Side     engine.Side      `json:"side"`
-		Type     engine.OrderType `json:"type"`
-		Price    int64            `json:"price"`
-		Quantity int64            `json:"quantity"`
+		AccountID   string             `json:"account_id"`
+		Symbol      string             `json:"symbol"`
+		Side        engine.Side        `json:"side"`
+		Type        engine.OrderType   `json:"type"`
+		Price       int64              `json:"price"`
+		StopPrice   int64              `json:"stop_price"`
+		Quantity    int64              `json:"quantity"`
+		Display     int64              `json:"display_quantity"`
+		Hidden      bool               `json:"hidden"`
+		PostOnly    engine.PostOnly    `json:"post_only"`
+		TimeInForce engine.TimeInForce `json:"time_in_force"`
+		ExpireAt    int64              `json:"expire_at"`
+
+		SelfTradePrevention engine.SelfTradePrevention `json:"self_trade_prevention"`
+	}
+
+	// Every submission is audited, including those rejected here
+	received := time.Now()
+	var order *engine.Order
+	var reason string
+	reject := func(status int, message string) {
+		reason = message
+		writeError(w, status, message)
 	}
+	defer func() { h.auditSubmit(r, received, order, reason) }()
 
+	if err := h.auditHealth(); err != nil {
+		reject(http.StatusServiceUnavailable, err.Error())
+		return
+	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		reject(http.StatusBadRequest, "Malformed JSON")
 		return
 	}
 
+	order = &engine.Order{
+		ID:          utils.GenerateUUID(),
+		ClientID:    auth.ClientID(r.Context()),
+		AccountID:   req.AccountID,
+		Symbol:      req.Symbol,
+		Side:        req.Side,
+		Type:        req.Type,
+		Price:       req.Price,
+		StopPrice:   req.StopPrice,
+		Quantity:    req.Quantity,
+		Timestamp:   time.Now().UnixMilli(),
+		Status:      engine.OrderStatusAccepted,
+		TimeInForce: req.TimeInForce,
+		ExpireAt:    req.ExpireAt,
+
+		DisplayQuantity:     req.Display,
+		Hidden:              req.Hidden,
+		PostOnly:            req.PostOnly,
+		SelfTradePrevention: req.SelfTradePrevention,
+	}
+
+	if !req.Type.Valid() {
+		reject(http.StatusBadRequest, "Invalid order: unknown type")
+		return
+	}
 	if req.Quantity <= 0 {
-		writeError(w, http.StatusBadRequest, "Invalid order: quantity must be positive")
+		reject(http.StatusBadRequest, "Invalid order: quantity must be positive")
 		return
 	}
-	if req.Type == engine.OrderTypeLimit && req.Price <= 0 {
-		writeError(w, http.StatusBadRequest, "Invalid order: price must be positive")
+	if (req.Type == engine.OrderTypeLimit || req.Type == engine.OrderTypeStopLimit) && req.Price <= 0 {
+		reject(http.StatusBadRequest, "Invalid order: price must be positive")
 		return
 	}
-
-	order := &engine.Order{
-		ID:        utils.GenerateUUID(),
-		Symbol:    req.Symbol,
-		Side:      req.Side,
-		Type:      req.Type,
-		Price:     req.Price,
-		Quantity:  req.Quantity,
-		Timestamp: time.Now().UnixMilli(),
-		Status:    engine.OrderStatusAccepted,
+	if (req.Type == engine.OrderTypeStopMarket || req.Type == engine.OrderTypeStopLimit) && req.StopPrice <= 0 {
+		reject(http.StatusBadRequest, "Invalid order: stop orders need a positive stop_price")
+		return
+	}
+	if req.Display < 0 || req.Display > req.Quantity {
+		reject(http.StatusBadRequest, "Invalid order: display_quantity must be between 0 and quantity")
+		return
+	}
+	if req.Display > 0 && (req.Type == engine.OrderTypeMarket || req.Type == engine.OrderTypeStopMarket) {
+		reject(http.StatusBadRequest, "Invalid order: market orders cannot have a display_quantity")
+		return
+	}
+	if !req.PostOnly.Valid() {
+		reject(http.StatusBadRequest, "Invalid order: post_only must be REJECT or REPRICE")
+		return
+	}
+	if req.TimeInForce != "" && !req.TimeInForce.Valid() {
+		reject(http.StatusBadRequest, "Invalid order: unknown time_in_force")
+		return
+	}
+	if req.Type == engine.OrderTypeMarket && req.TimeInForce != "" &&
+		req.TimeInForce != engine.TimeInForceIOC && req.TimeInForce != engine.TimeInForceFOK {
+		reject(http.StatusBadRequest, "Invalid order: market orders must be IOC or FOK")
+		return
+	}
+	if req.TimeInForce == engine.TimeInForceGTD && req.ExpireAt <= time.Now().UnixMilli() {
+		reject(http.StatusBadRequest, "Invalid order: GTD orders need a future expire_at")
+		return
+	}
+	if !req.SelfTradePrevention.Valid() {
+		reject(http.StatusBadRequest, "Invalid order: unknown self_trade_prevention")
+		return
+	}
+	if !mayUse(r, req.AccountID) {
+		reject(http.StatusForbidden, "Order rejected: "+utils.ErrNotPermitted.Error())
+		return
 	}
 
 	trades, err := h.Engine.SubmitOrder(order)
 	if err != nil {
-		if err == utils.ErrInsufficientLiquidity {
-			writeError(w, http.StatusBadRequest, "Insufficient liquidity")
-			return
+		switch err {
+		case utils.ErrInsufficientLiquidity:
+			reject(http.StatusBadRequest, "Insufficient liquidity")
+		case utils.ErrPostOnlyWouldCross, utils.ErrMarketNotOpen, utils.ErrNotAuctionOrder:
+			reject(http.StatusConflict, "Order rejected: "+err.Error())
+		case utils.ErrUnknownSymbol:
+			reject(http.StatusNotFound, "Unknown symbol")
+		case utils.ErrInvalidSymbol, utils.ErrInvalidPrice, utils.ErrInvalidQuantity,
+			utils.ErrInvalidTimeInForce, utils.ErrInvalidExpireTime, utils.ErrInvalidSelfTradePrevention,
+			utils.ErrInvalidOrderType, utils.ErrInvalidStopPrice, utils.ErrInvalidDisplayQuantity,
+			utils.ErrInvalidPostOnly, utils.ErrInvalidHidden, utils.ErrOffTick, utils.ErrOffLot,
+			utils.ErrQuantityBelowMin, utils.ErrQuantityAboveMax, utils.ErrNotionalOverflow, utils.ErrPriceOutOfBand:
+			reject(http.StatusBadRequest, "Invalid order: "+err.Error())
+		case utils.ErrUnknownAccount, utils.ErrInsufficientFunds, utils.ErrInsufficientPosition,
+			utils.ErrMaxOrderSize, utils.ErrMaxNotional, utils.ErrMaxOpenOrders:
+			reject(http.StatusForbidden, "Order rejected: "+err.Error())
+		default:
+			reject(failureStatus(err), err.Error())
 		}
-		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	resp := OrderResponse{
 		OrderID:           order.ID,
 		Status:            order.Status,
+		Price:             order.Price,
 		FilledQuantity:    order.Filled,
 		RemainingQuantity: order.Quantity - order.Filled,
 		Trades:            trades,
+		SelfTradeCancels:  order.SelfTradeCancels,
 	}
 
-	if order.Status == engine.OrderStatusAccepted {
+	switch order.Status {
+	case engine.OrderStatusAccepted, engine.OrderStatusTriggered:
 		resp.Message = "Order added to book"
 		writeJSON(w, http.StatusCreated, resp)
-	} else if order.Status == engine.OrderStatusFilled {
+	case engine.OrderStatusPendingTrigger:
+		resp.Message = "Stop order waiting for trigger"
+		writeJSON(w, http.StatusCreated, resp)
+	case engine.OrderStatusQueued:
+		resp.Message = "Order queued until trading opens"
+		writeJSON(w, http.StatusAccepted, resp)
+	case engine.OrderStatusFilled:
 		writeJSON(w, http.StatusOK, resp)
-	} else {
+	case engine.OrderStatusCancelled, engine.OrderStatusPartialFillCancelled:
+		resp.Message = "Unfilled remainder cancelled"
+		if order.CancelReason != "" {
+			resp.Message += ": " + order.CancelReason
+		}
+		writeJSON(w, http.StatusOK, resp)
+	default:
 		writeJSON(w, http.StatusAccepted, resp)
 	}
 }
@@ -85,7 +251,18 @@ func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderID := vars["order_id"]
 
+	received := time.Now()
+	if err := h.auditHealth(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !h.owned(w, r, orderID) {
+		h.auditChange(r, received, audit.ActionCancel, orderID, nil, utils.ErrNotPermitted)
+		return
+	}
+	before := h.auditedOrder(orderID)
 	err := h.Engine.CancelOrder(orderID)
+	h.auditChange(r, received, audit.ActionCancel, orderID, before, err)
 	if err != nil {
 		if err == utils.ErrOrderNotFound {
 			writeError(w, http.StatusNotFound, "Order not found")
@@ -101,6 +278,80 @@ func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (h *Handler) AmendOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderID := vars["order_id"]
+	received := time.Now()
+	reject := func(status int, message string) {
+		h.auditChange(r, received, audit.ActionAmend, orderID, h.auditedOrder(orderID), errors.New(message))
+		writeError(w, status, message)
+	}
+
+	// Omitted fields are left unchanged
+	var req struct {
+		Price    int64 `json:"price"`
+		Quantity int64 `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reject(http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if req.Price < 0 || req.Quantity < 0 {
+		reject(http.StatusBadRequest, "Invalid amend: price and quantity must be positive")
+		return
+	}
+	if req.Price == 0 && req.Quantity == 0 {
+		reject(http.StatusBadRequest, "Invalid amend: price or quantity is required")
+		return
+	}
+	if err := h.auditHealth(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !h.owned(w, r, orderID) {
+		h.auditChange(r, received, audit.ActionAmend, orderID, nil, utils.ErrNotPermitted)
+		return
+	}
+
+	before := h.auditedOrder(orderID)
+	trades, err := h.Engine.AmendOrder(orderID, req.Price, req.Quantity)
+	h.auditChange(r, received, audit.ActionAmend, orderID, before, err)
+	if err != nil {
+		switch err {
+		case utils.ErrOrderNotFound:
+			writeError(w, http.StatusNotFound, "Order not found")
+		case utils.ErrOrderNotOpen:
+			writeError(w, http.StatusConflict, "Order is no longer open")
+		case utils.ErrPostOnlyWouldCross, utils.ErrMarketNotOpen:
+			writeError(w, http.StatusConflict, "Amend rejected: "+err.Error())
+		case utils.ErrInvalidPrice, utils.ErrInvalidQuantity, utils.ErrAmendUnchanged, utils.ErrAmendPendingStop,
+			utils.ErrOffTick, utils.ErrOffLot, utils.ErrQuantityBelowMin, utils.ErrQuantityAboveMax,
+			utils.ErrNotionalOverflow, utils.ErrPriceOutOfBand:
+			writeError(w, http.StatusBadRequest, "Invalid amend: "+err.Error())
+		case utils.ErrInsufficientFunds, utils.ErrInsufficientPosition, utils.ErrMaxOrderSize, utils.ErrMaxNotional:
+			writeError(w, http.StatusForbidden, "Amend rejected: "+err.Error())
+		default:
+			writeError(w, failureStatus(err), err.Error())
+		}
+		return
+	}
+
+	order, err := h.Engine.GetOrder(orderID)
+	if err != nil {
+		writeError(w, failureStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, OrderResponse{
+		OrderID:           order.ID,
+		Status:            order.Status,
+		Message:           "Order amended",
+		FilledQuantity:    order.Filled,
+		RemainingQuantity: order.Quantity - order.Filled,
+		Trades:            trades,
+		SelfTradeCancels:  order.SelfTradeCancels,
+	})
+}
+
 func (h *Handler) GetOrderBook(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	symbol := vars["symbol"]
@@ -113,14 +364,22 @@ func (h *Handler) GetOrderBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ob := h.Engine.GetOrderBook(symbol)
+	if ob == nil {
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+		return
+	}
 
 	snapshot := ob.GetSnapshot(depth)
 
 	resp := OrderBookResponse{
-		Symbol:    snapshot.Symbol,
-		Timestamp: snapshot.Timestamp,
-		Bids:      make([]PriceLevel, len(snapshot.Bids)),
-		Asks:      make([]PriceLevel, len(snapshot.Asks)),
+		Symbol:         snapshot.Symbol,
+		State:          snapshot.State,
+		Auction:        snapshot.Auction,
+		Seq:            snapshot.Seq,
+		Timestamp:      snapshot.Timestamp,
+		PricePrecision: snapshot.PricePrecision,
+		Bids:           make([]PriceLevel, len(snapshot.Bids)),
+		Asks:           make([]PriceLevel, len(snapshot.Asks)),
 	}
 	for i, b := range snapshot.Bids {
 		resp.Bids[i] = PriceLevel{Price: b.Price, Quantity: b.Quantity}
@@ -132,6 +391,115 @@ func (h *Handler) GetOrderBook(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (h *Handler) GetTicker(w http.ResponseWriter, r *http.Request) {
+	ob := h.Engine.GetOrderBook(mux.Vars(r)["symbol"])
+	if ob == nil {
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+		return
+	}
+	writeJSON(w, http.StatusOK, ob.Ticker())
+}
+
+func (h *Handler) ListTickers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Engine.Tickers())
+}
+
+func (h *Handler) GetTrades(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	if h.Engine.GetOrderBook(symbol) == nil {
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+		return
+	}
+
+	q := tradestore.Query{Symbol: symbol, Limit: defaultTradesLimit}
+	params := r.URL.Query()
+	var err error
+	if v := params.Get("from"); v != "" {
+		q.From, err = strconv.ParseInt(v, 10, 64)
+	}
+	if v := params.Get("to"); v != "" && err == nil {
+		q.To, err = strconv.ParseInt(v, 10, 64)
+	}
+	if v := params.Get("after"); v != "" && err == nil {
+		q.After, err = strconv.ParseUint(v, 10, 64)
+	}
+	if v := params.Get("limit"); v != "" && err == nil {
+		q.Limit, err = strconv.Atoi(v)
+	}
+	if err != nil || q.From < 0 || q.To < 0 || q.Limit <= 0 || q.Limit > maxTradesLimit {
+		writeError(w, http.StatusBadRequest, "Invalid query")
+		return
+	}
+
+	trades, err := h.Trades.Query(q)
+	if err != nil {
+		writeError(w, failureStatus(err), err.Error())
+		return
+	}
+	resp := TradesResponse{Symbol: symbol, Trades: trades}
+	if resp.Trades == nil {
+		resp.Trades = []tradestore.Record{}
+	}
+	if len(trades) == q.Limit {
+		resp.Next = trades[len(trades)-1].Seq
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) GetCandles(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	if h.Engine.GetOrderBook(symbol) == nil {
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+		return
+	}
+
+	params := r.URL.Query()
+	interval := candles.Interval1m
+	if v := params.Get("interval"); v != "" {
+		interval = candles.Interval(v)
+	}
+	var from, to int64
+	var err error
+	if v := params.Get("from"); v != "" {
+		from, err = strconv.ParseInt(v, 10, 64)
+	}
+	if v := params.Get("to"); v != "" && err == nil {
+		to, err = strconv.ParseInt(v, 10, 64)
+	}
+	if err != nil || !interval.Valid() || from < 0 || to < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid query")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CandlesResponse{
+		Symbol:   symbol,
+		Interval: interval,
+		Candles:  h.Candles.Bars(symbol, interval, from, to),
+	})
+}
+
+func (h *Handler) GetOrderFills(w http.ResponseWriter, r *http.Request) {
+	order, err := h.Engine.GetOrder(mux.Vars(r)["order_id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+	if !owns(r, order) {
+		writeError(w, http.StatusForbidden, utils.ErrNotPermitted.Error())
+		return
+	}
+
+	fills, err := h.Trades.Fills(order.Symbol, order.ID, order.Timestamp)
+	if err != nil {
+		writeError(w, failureStatus(err), err.Error())
+		return
+	}
+	if fills == nil {
+		fills = []tradestore.Record{}
+	}
+	writeJSON(w, http.StatusOK, FillsResponse{OrderID: order.ID, Fills: fills})
+}
+
 func (h *Handler) GetOrderStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	orderID := vars["order_id"]
@@ -141,21 +509,326 @@ func (h *Handler) GetOrderStatus(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "Order not found")
 		return
 	}
+	if !owns(r, order) {
+		writeError(w, http.StatusForbidden, utils.ErrNotPermitted.Error())
+		return
+	}
 
 	resp := OrderStatusResponse{
-		OrderID:        order.ID,
-		Symbol:         order.Symbol,
-		Side:           order.Side,
-		Type:           order.Type,
-		Price:          order.Price,
-		Quantity:       order.Quantity,
-		FilledQuantity: order.Filled,
-		Status:         order.Status,
-		Timestamp:      order.Timestamp,
+		OrderID:         order.ID,
+		ClientID:        order.ClientID,
+		AccountID:       order.AccountID,
+		Symbol:          order.Symbol,
+		Side:            order.Side,
+		Type:            order.Type,
+		Price:           order.Price,
+		StopPrice:       order.StopPrice,
+		Quantity:        order.Quantity,
+		FilledQuantity:  order.Filled,
+		DisplayQuantity: order.DisplayQuantity,
+		HiddenQuantity:  order.HiddenQuantity(),
+		Hidden:          order.Hidden,
+		PostOnly:        order.PostOnly,
+		Status:          order.Status,
+		TimeInForce:     order.TimeInForce,
+		ExpireAt:        order.ExpireAt,
+		CancelReason:    order.CancelReason,
+		TriggeredAt:     order.TriggeredAt,
+		Timestamp:       order.Timestamp,
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (h *Handler) CreateAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID string            `json:"account_id"`
+		Limits    engine.RiskLimits `json:"limits"`
+
+		SelfTradePrevention engine.SelfTradePrevention `json:"self_trade_prevention"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if req.AccountID == "" {
+		writeError(w, http.StatusBadRequest, "account_id is required")
+		return
+	}
+	if !req.SelfTradePrevention.Valid() {
+		writeError(w, http.StatusBadRequest, utils.ErrInvalidSelfTradePrevention.Error())
+		return
+	}
+
+	if err := h.Engine.CreateAccount(req.AccountID, req.Limits); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	if req.SelfTradePrevention != "" {
+		if err := h.Engine.SetSelfTradePrevention(req.AccountID, req.SelfTradePrevention); err != nil {
+			writeAccountError(w, err)
+			return
+		}
+	}
+	h.writeAccount(w, http.StatusCreated, req.AccountID)
+}
+
+func (h *Handler) SetRiskLimits(w http.ResponseWriter, r *http.Request) {
+	accountID := mux.Vars(r)["account_id"]
+
+	var limits engine.RiskLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if err := h.Engine.SetRiskLimits(accountID, limits); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	h.writeAccount(w, http.StatusOK, accountID)
+}
+
+func (h *Handler) SetSelfTradePrevention(w http.ResponseWriter, r *http.Request) {
+	accountID := mux.Vars(r)["account_id"]
+
+	var req struct {
+		Mode engine.SelfTradePrevention `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if err := h.Engine.SetSelfTradePrevention(accountID, req.Mode); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	h.writeAccount(w, http.StatusOK, accountID)
+}
+
+// AdjustBalance deposits into an account, or withdraws for a negative amount.
+func (h *Handler) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+	accountID := mux.Vars(r)["account_id"]
+
+	var req struct {
+		Asset  string `json:"asset"`
+		Amount int64  `json:"amount"`
+		// Symbol picks the ledger when the engine is sharded
+		Symbol string `json:"symbol"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if req.Amount == 0 {
+		writeError(w, http.StatusBadRequest, "amount must be non-zero")
+		return
+	}
+	var err error
+	if ledgers, ok := h.Engine.(SymbolLedgers); ok && req.Symbol != "" {
+		err = ledgers.AdjustBalanceFor(req.Symbol, accountID, req.Asset, req.Amount)
+	} else {
+		err = h.Engine.AdjustBalance(accountID, req.Asset, req.Amount)
+	}
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	h.writeAccount(w, http.StatusOK, accountID)
+}
+
+func (h *Handler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	accountID := mux.Vars(r)["account_id"]
+	if !mayUse(r, accountID) {
+		writeError(w, http.StatusForbidden, utils.ErrNotPermitted.Error())
+		return
+	}
+	h.writeAccount(w, http.StatusOK, accountID)
+}
+
+func (h *Handler) writeAccount(w http.ResponseWriter, status int, accountID string) {
+	acct, err := h.Engine.GetAccount(accountID)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	writeJSON(w, status, acct)
+}
+
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch err {
+	case utils.ErrUnknownAccount:
+		writeError(w, http.StatusNotFound, "Account not found")
+	case utils.ErrAccountExists:
+		writeError(w, http.StatusConflict, err.Error())
+	case utils.ErrInvalidAsset, utils.ErrInvalidRiskLimits, utils.ErrInsufficientFunds, utils.ErrInvalidSelfTradePrevention,
+		utils.ErrSymbolRequired:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, failureStatus(err), err.Error())
+	}
+}
+
+func (h *Handler) ListInstruments(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Engine.Instruments())
+}
+
+func (h *Handler) GetInstrument(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.Engine.GetInstrument(mux.Vars(r)["symbol"])
+	if err != nil {
+		writeInstrumentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inst)
+}
+
+func (h *Handler) AddInstrument(w http.ResponseWriter, r *http.Request) {
+	var inst engine.Instrument
+	if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if err := h.Engine.AddInstrument(inst); err != nil {
+		writeInstrumentError(w, err)
+		return
+	}
+	inst, _ = h.Engine.GetInstrument(inst.Symbol)
+	writeJSON(w, http.StatusCreated, inst)
+}
+
+func (h *Handler) UpdateInstrument(w http.ResponseWriter, r *http.Request) {
+	var inst engine.Instrument
+	if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	inst.Symbol = mux.Vars(r)["symbol"]
+	if err := h.Engine.UpdateInstrument(inst); err != nil {
+		writeInstrumentError(w, err)
+		return
+	}
+	inst, _ = h.Engine.GetInstrument(inst.Symbol)
+	writeJSON(w, http.StatusOK, inst)
+}
+
+func writeInstrumentError(w http.ResponseWriter, err error) {
+	switch err {
+	case utils.ErrUnknownSymbol:
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+	case utils.ErrInstrumentExists:
+		writeError(w, http.StatusConflict, err.Error())
+	case utils.ErrInvalidInstrument:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, failureStatus(err), err.Error())
+	}
+}
+
+func (h *Handler) SetTradingState(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+
+	var req struct {
+		State engine.TradingState `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	trades, err := h.Engine.SetTradingState(symbol, req.State)
+	if err != nil {
+		writeTradingError(w, err)
+		return
+	}
+	h.writeTradingStatus(w, symbol, trades)
+}
+
+func (h *Handler) SetTradingConfig(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+
+	var cfg engine.TradingConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if err := h.Engine.SetTradingConfig(symbol, cfg); err != nil {
+		writeTradingError(w, err)
+		return
+	}
+	h.writeTradingStatus(w, symbol, nil)
+}
+
+func (h *Handler) writeTradingStatus(w http.ResponseWriter, symbol string, trades []engine.Trade) {
+	ob := h.Engine.GetOrderBook(symbol)
+	if ob == nil {
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+		return
+	}
+	writeJSON(w, http.StatusOK, TradingStatusResponse{TradingStatus: ob.TradingStatus(), Trades: trades})
+}
+
+func writeTradingError(w http.ResponseWriter, err error) {
+	switch err {
+	case utils.ErrUnknownSymbol:
+		writeError(w, http.StatusNotFound, "Unknown symbol")
+	case utils.ErrInvalidTradingState, utils.ErrInvalidTradingConfig:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, failureStatus(err), err.Error())
+	}
+}
+
+func (h *Handler) TakeSnapshot(w http.ResponseWriter, r *http.Request) {
+	if h.Snapshots == nil {
+		writeError(w, http.StatusServiceUnavailable, "Snapshots are not enabled")
+		return
+	}
+
+	seq, err := h.Snapshots.Snapshot()
+	if err != nil {
+		writeError(w, failureStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, SnapshotResponse{Seq: seq})
+}
+
+// failureStatus is the status of an engine error the handler doesn't
+// expect: 503 once the journal has failed and the engine refuses commands.
+// mayUse reports whether the request's client may trade or read account.
+// Orders that name no account are left to the engine to accept or not.
+func mayUse(r *http.Request, account string) bool {
+	if account == "" {
+		return true
+	}
+	client, _ := auth.FromContext(r.Context())
+	return client.CanUse(account)
+}
+
+// owns reports whether the request's client may act on order: it may use
+// the order's account, or placed the order if it names none.
+func owns(r *http.Request, order *engine.Order) bool {
+	if order.AccountID != "" {
+		return mayUse(r, order.AccountID)
+	}
+	return order.ClientID == auth.ClientID(r.Context())
+}
+
+// owned answers 403 and returns false if the order exists and the
+// request's client may not act on it. A missing order is left to the
+// engine to report.
+func (h *Handler) owned(w http.ResponseWriter, r *http.Request, orderID string) bool {
+	order, err := h.Engine.GetOrder(orderID)
+	if err == nil && !owns(r, order) {
+		writeError(w, http.StatusForbidden, utils.ErrNotPermitted.Error())
+		return false
+	}
+	return true
+}
+
+func failureStatus(err error) int {
+	if errors.Is(err, utils.ErrJournalFailed) || errors.Is(err, utils.ErrAuditFailed) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 func writeError(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, ErrorResponse{Error: message})
 }
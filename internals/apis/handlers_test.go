@@ -2,11 +2,22 @@ package apis
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/audit"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/candles"
 	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/shard"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/tradestore"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
 	"github.com/gorilla/mux"
 )
 
@@ -16,7 +27,7 @@ func TestGetOrderBook(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc("/orderbook/{symbol}", h.GetOrderBook).Methods("GET")
 
-	e.GetOrderBook("BTCUSD")
+	e.AddInstrument(engine.Instrument{Symbol: "BTCUSD", PricePrecision: 2})
 	req, _ := http.NewRequest("GET", "/orderbook/BTCUSD", nil)
 	rr := httptest.NewRecorder()
 
@@ -40,4 +51,432 @@ func TestGetOrderBook(t *testing.T) {
 		t.Errorf("handler returned wrong symbol: got %v want %v",
 			resp.Symbol, "BTCUSD")
 	}
+	if resp.PricePrecision != 2 {
+		t.Errorf("handler returned wrong price precision: got %v want 2", resp.PricePrecision)
+	}
+
+	req, _ = http.NewRequest("GET", "/orderbook/BTCUSDD", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unlisted symbol, got %v", rr.Code)
+	}
+	if books := e.OrderBooks(); len(books) != 0 {
+		t.Errorf("expected reads to create no books, got %d", len(books))
+	}
+}
+
+func TestGetOrderBookUnknownSymbol(t *testing.T) {
+	e := engine.NewEngine()
+	e.AddInstrument(engine.Instrument{Symbol: "BTCUSD"})
+	router := NewRouter(NewHandler(e))
+
+	req, _ := http.NewRequest("GET", "/api/v1/orderbook/BTCUSDD", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unlisted symbol, got %v", rr.Code)
+	}
+	if len(e.OrderBooks()) != 0 {
+		t.Errorf("expected no book to be created")
+	}
+}
+
+// adminKeys authenticates "admin-key" as an admin client that may use
+// account "acct".
+func adminKeys() *auth.Keys {
+	return auth.NewKeys(map[string]auth.Client{"admin-key": {ID: "ops", Accounts: []string{"acct"}, Admin: true}})
+}
+
+func TestTradingStateEndpoint(t *testing.T) {
+	e := engine.NewEngine()
+	h := NewHandler(e)
+	h.Keys = adminKeys()
+	router := NewRouter(h)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("PUT", "/api/v1/admin/symbols/BTCUSD/trading-state", `{"state":"HALTED"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", rr.Code, rr.Body)
+	}
+	var status TradingStatusResponse
+	json.NewDecoder(rr.Body).Decode(&status)
+	if status.Symbol != "BTCUSD" || status.State != engine.TradingHalted {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	rr = send("POST", "/api/v1/orders", `{"symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":1}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 while halted, got %v: %s", rr.Code, rr.Body)
+	}
+
+	if rr := send("PUT", "/api/v1/admin/symbols/BTCUSD/trading-state", `{"state":"PAUSED"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown state, got %v", rr.Code)
+	}
+	rr = send("PUT", "/api/v1/admin/symbols/BTCUSD/trading-config", `{"halt_policy":"QUEUE"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", rr.Code, rr.Body)
+	}
+	rr = send("POST", "/api/v1/orders", `{"symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":1}`)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("expected 202 for a queued order, got %v: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestTradeHistoryEndpoints(t *testing.T) {
+	e := engine.NewEngine()
+	h := NewHandler(e)
+	h.Trades, _ = tradestore.Open(tradestore.Options{})
+	e.Subscribe(h.Trades)
+	router := NewRouter(h)
+
+	maker := &engine.Order{ID: "maker", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 5}
+	e.SubmitOrder(maker)
+	for i := 0; i < 2; i++ {
+		e.SubmitOrder(&engine.Order{ID: fmt.Sprintf("taker%d", i), Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 1})
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var page TradesResponse
+	rr := get("/api/v1/trades/BTCUSD?limit=1")
+	json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || len(page.Trades) != 1 || page.Trades[0].TakerOrderID != "taker0" || page.Next == 0 {
+		t.Fatalf("expected a full first page, got %v %+v", rr.Code, page)
+	}
+	rr = get(fmt.Sprintf("/api/v1/trades/BTCUSD?limit=1&after=%d", page.Next))
+	page = TradesResponse{}
+	json.NewDecoder(rr.Body).Decode(&page)
+	if len(page.Trades) != 1 || page.Trades[0].TakerOrderID != "taker1" {
+		t.Errorf("expected the second trade on the next page, got %+v", page)
+	}
+	if rr := get("/api/v1/trades/BTCUSD?limit=0"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad limit, got %v", rr.Code)
+	}
+
+	var fills FillsResponse
+	rr = get("/api/v1/orders/maker/fills")
+	json.NewDecoder(rr.Body).Decode(&fills)
+	if rr.Code != http.StatusOK || len(fills.Fills) != 2 || fills.Fills[0].Symbol != "BTCUSD" {
+		t.Errorf("expected the maker's two fills, got %v %+v", rr.Code, fills)
+	}
+}
+
+func TestCandlesEndpoint(t *testing.T) {
+	e := engine.NewEngine()
+	h := NewHandler(e)
+	h.Candles = candles.NewAggregator(0)
+	e.Subscribe(h.Candles)
+	router := NewRouter(h)
+	e.AddInstrument(engine.Instrument{Symbol: "BTCUSD"})
+	e.AddInstrument(engine.Instrument{Symbol: "ETHUSD"})
+
+	e.SubmitOrder(&engine.Order{ID: "maker", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 5})
+	e.SubmitOrder(&engine.Order{ID: "taker", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 2})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var resp CandlesResponse
+	rr := get("/api/v1/candles/BTCUSD?interval=5m")
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.Interval != candles.Interval5m || len(resp.Candles) != 1 || resp.Candles[0].Volume != 2 {
+		t.Fatalf("expected one 5m bar, got %v %+v", rr.Code, resp)
+	}
+	if rr := get("/api/v1/candles/BTCUSD?interval=2m"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown interval, got %v", rr.Code)
+	}
+	rr = get("/api/v1/candles/ETHUSD")
+	resp = CandlesResponse{}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusOK || resp.Interval != candles.Interval1m || resp.Candles == nil || len(resp.Candles) != 0 {
+		t.Errorf("expected no 1m bars, got %v %+v", rr.Code, resp)
+	}
+}
+
+func TestTickerEndpoints(t *testing.T) {
+	e := engine.NewEngine()
+	router := NewRouter(NewHandler(e))
+	e.SubmitOrder(&engine.Order{ID: "maker", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 5})
+	e.SubmitOrder(&engine.Order{ID: "taker", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 2})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var ticker engine.Ticker
+	rr := get("/api/v1/ticker/BTCUSD")
+	json.NewDecoder(rr.Body).Decode(&ticker)
+	if rr.Code != http.StatusOK || ticker.LastPrice != 100 || ticker.Volume != 2 || ticker.BestAsk != 100 || ticker.BestAskQuantity != 3 {
+		t.Fatalf("unexpected ticker %v %+v", rr.Code, ticker)
+	}
+
+	var tickers []engine.Ticker
+	rr = get("/api/v1/tickers")
+	json.NewDecoder(rr.Body).Decode(&tickers)
+	if rr.Code != http.StatusOK || len(tickers) != 1 || tickers[0].Symbol != "BTCUSD" {
+		t.Errorf("expected one ticker, got %v %+v", rr.Code, tickers)
+	}
+}
+
+func TestShardedEndpoints(t *testing.T) {
+	set, err := shard.Open(shard.Options{Shards: 2, Assign: map[string]int{"BTCUSD": 0, "ETHUSD": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+	h := NewHandler(NewShardRouter(set))
+	h.Shards = set
+	h.Keys = adminKeys()
+	router := NewRouter(h)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("POST", "/api/v1/admin/accounts", `{"account_id":"acct"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("create account: %v %s", rr.Code, rr.Body)
+	}
+	if rr := send("POST", "/api/v1/admin/accounts", `{"account_id":"acct"}`); rr.Code != http.StatusConflict {
+		t.Errorf("create an existing account: %v", rr.Code)
+	}
+	// An earlier attempt got as far as shard 1
+	if err := set.Shards()[1].Engine.CreateAccount("partial", engine.RiskLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	if rr := send("POST", "/api/v1/admin/accounts", `{"account_id":"partial","limits":{"max_open_orders":3}}`); rr.Code != http.StatusCreated {
+		t.Errorf("retry a partly created account: %v %s", rr.Code, rr.Body)
+	}
+	for _, sh := range set.Shards() {
+		if acct, err := sh.Engine.GetAccount("partial"); err != nil || acct.Limits.MaxOpenOrders != 3 {
+			t.Errorf("shard %d after the retry: %+v %v", sh.Index, acct, err)
+		}
+	}
+
+	if rr := send("POST", "/api/v1/admin/accounts/acct/balances", `{"asset":"USD","amount":1000}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("deposit without a symbol: %v", rr.Code)
+	}
+	if rr := send("POST", "/api/v1/admin/accounts/acct/balances", `{"asset":"ETH","amount":1000,"symbol":"BTCUSD"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("deposit of an asset the symbol doesn't trade: %v", rr.Code)
+	}
+	for _, symbol := range []string{"BTCUSD", "ETHUSD"} {
+		if rr := send("POST", "/api/v1/admin/accounts/acct/balances", `{"asset":"USD","amount":1000,"symbol":"`+symbol+`"}`); rr.Code != http.StatusOK {
+			t.Fatalf("deposit for %s: %v %s", symbol, rr.Code, rr.Body)
+		}
+	}
+
+	var order OrderResponse
+	rr := send("POST", "/api/v1/orders", `{"account_id":"acct","symbol":"ETHUSD","side":"BUY","type":"LIMIT","price":100,"quantity":5}`)
+	json.NewDecoder(rr.Body).Decode(&order)
+	if rr.Code != http.StatusCreated || !strings.HasPrefix(order.OrderID, "s1-") {
+		t.Fatalf("submit: %v %+v", rr.Code, order)
+	}
+
+	var acct engine.Account
+	json.NewDecoder(send("GET", "/api/v1/accounts/acct", "").Body).Decode(&acct)
+	if b := acct.Balances["USD"]; b == nil || b.Total != 2000 || b.Reserved != 500 || acct.OpenOrders != 1 {
+		t.Errorf("account across shards: %+v", acct)
+	}
+
+	if rr := send("PUT", "/api/v1/admin/shards/ETHUSD", `{"shard":0}`); rr.Code != http.StatusOK {
+		t.Fatalf("move: %v %s", rr.Code, rr.Body)
+	}
+	var shards ShardsResponse
+	json.NewDecoder(send("GET", "/api/v1/admin/shards", "").Body).Decode(&shards)
+	if shards.Shards != 2 || len(shards.Symbols) != 1 || shards.Symbols[0] != (SymbolShard{Symbol: "ETHUSD", Shard: 0}) {
+		t.Errorf("shards after the move: %+v", shards)
+	}
+	// Nothing left on shard 1 trades USD, so the free 500 went too
+	var parts []ShardAccount
+	json.NewDecoder(send("GET", "/api/v1/admin/accounts/acct/shards", "").Body).Decode(&parts)
+	if len(parts) != 2 || *parts[0].Balances["USD"] != (engine.Balance{Total: 2000, Reserved: 500}) || parts[0].OpenOrders != 1 ||
+		parts[1].Balances["USD"].Total != 0 {
+		t.Errorf("account by shard after the move: %+v", parts)
+	}
+	if rr := send("DELETE", "/api/v1/orders/"+order.OrderID, ""); rr.Code != http.StatusOK {
+		t.Errorf("cancel after the move: %v %s", rr.Code, rr.Body)
+	}
+	if rr := send("PUT", "/api/v1/admin/shards/ETHUSD", `{"shard":2}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("move to a missing shard: %v", rr.Code)
+	}
+}
+
+func TestAuditedInstructions(t *testing.T) {
+	dir := t.TempDir()
+	e := engine.NewEngine()
+	h := NewHandler(e)
+	h.Audit, _ = audit.Open(dir, audit.DefaultOptions())
+	h.Keys = auth.NewKeys(map[string]auth.Client{"desk-1-key": {ID: "desk-1"}})
+	e.Events().Subscribe(h.Audit)
+	router := NewRouter(h)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer desk-1-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := send("POST", "/api/v1/orders", `{"symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":0}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected the invalid order to be rejected, got %v", rr.Code)
+	}
+	rr := send("POST", "/api/v1/orders", `{"symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":2}`)
+	var resp OrderResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	send("DELETE", "/api/v1/orders/"+resp.OrderID, "")
+	h.Audit.Close()
+
+	var actions []audit.Action
+	var records []audit.Record
+	audit.Search(dir, audit.Query{ClientID: "desk-1"}, func(r audit.Record) error {
+		actions = append(actions, r.Action)
+		records = append(records, r)
+		return nil
+	})
+	// Each instruction follows what the engine did with it
+	want := []audit.Action{audit.ActionNew, audit.ActionAccepted, audit.ActionNew, audit.ActionCancelled, audit.ActionCancel}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("records %v, want %v", actions, want)
+	}
+	if r := records[0]; r.Reason == "" || r.After.Status != engine.OrderStatusRejected {
+		t.Errorf("rejected order recorded as %+v", r)
+	}
+	if r := records[4]; r.Before.Status != engine.OrderStatusAccepted || r.After.Status != engine.OrderStatusCancelled {
+		t.Errorf("cancel recorded as %+v -> %+v", r.Before, r.After)
+	}
+
+	var engineRecords int
+	audit.Search(dir, audit.Query{OrderID: resp.OrderID}, func(r audit.Record) error {
+		engineRecords++
+		return nil
+	})
+	if engineRecords != 4 {
+		t.Errorf("%d records of the order, want the instructions and ACCEPTED and CANCELLED", engineRecords)
+	}
+}
+
+func TestAuditFailureRefusesOrders(t *testing.T) {
+	dir := t.TempDir()
+	e := engine.NewEngine()
+	h := NewHandler(e)
+	opts := audit.DefaultOptions()
+	opts.MaxBytes = 1
+	h.Audit, _ = audit.Open(dir, opts)
+	defer h.Audit.Close()
+	router := NewRouter(h)
+
+	// The next file can't be started once the directory is gone
+	h.Audit.Write(audit.Record{Action: audit.ActionNew, OrderID: "a"})
+	os.RemoveAll(dir)
+	if err := h.Audit.Write(audit.Record{Action: audit.ActionNew, OrderID: "b"}); !errors.Is(err, utils.ErrAuditFailed) {
+		t.Fatalf("expected ErrAuditFailed, got %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/orders", strings.NewReader(`{"symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":2}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %v", rr.Code)
+	}
+	if books := e.OrderBooks(); len(books) != 0 {
+		t.Errorf("order applied without an audit record: %d books", len(books))
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	e := engine.NewEngine()
+	for _, id := range []string{"acct-1", "acct-2"} {
+		if err := e.CreateAccount(id, engine.RiskLimits{}); err != nil {
+			t.Fatal(err)
+		}
+		e.AdjustBalance(id, engine.DefaultQuoteAsset, 1000)
+	}
+	h := NewHandler(e)
+	h.Keys = auth.NewKeys(map[string]auth.Client{
+		"k1":    {ID: "desk-1", Accounts: []string{"acct-1"}},
+		"k2":    {ID: "desk-2", Accounts: []string{"acct-2"}},
+		"admin": {ID: "ops", Admin: true},
+	})
+	router := NewRouter(h)
+
+	send := func(key, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The admin API needs an admin client
+	deposit := `{"asset":"USD","amount":1000000}`
+	if rr := send("", "POST", "/api/v1/admin/accounts/acct-1/balances", deposit); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous deposit: %v", rr.Code)
+	}
+	if rr := send("k1", "POST", "/api/v1/admin/accounts/acct-1/balances", deposit); rr.Code != http.StatusForbidden {
+		t.Errorf("client deposit: %v", rr.Code)
+	}
+	if rr := send("admin", "POST", "/api/v1/admin/accounts/acct-1/balances", deposit); rr.Code != http.StatusOK {
+		t.Errorf("admin deposit: %v %s", rr.Code, rr.Body)
+	}
+
+	// Orders may only name the client's own accounts
+	order := `{"account_id":"acct-1","symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":1}`
+	for _, key := range []string{"", "k2"} {
+		if rr := send(key, "POST", "/api/v1/orders", order); rr.Code != http.StatusForbidden {
+			t.Errorf("order for another's account with key %q: %v", key, rr.Code)
+		}
+	}
+	rr := send("k1", "POST", "/api/v1/orders", order)
+	var resp OrderResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("own order: %v %s", rr.Code, rr.Body)
+	}
+
+	path := "/api/v1/orders/" + resp.OrderID
+	for _, c := range []struct{ method, path, body string }{
+		{"GET", path, ""},
+		{"PATCH", path, `{"quantity":2}`},
+		{"DELETE", path, ""},
+		{"GET", "/api/v1/accounts/acct-1", ""},
+	} {
+		if rr := send("k2", c.method, c.path, c.body); rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s by another client: %v", c.method, c.path, rr.Code)
+		}
+		if rr := send("k1", c.method, c.path, c.body); rr.Code != http.StatusOK {
+			t.Errorf("%s %s by its owner: %v %s", c.method, c.path, rr.Code, rr.Body)
+		}
+	}
+
+	// An order without an account is its client's
+	rr = send("k2", "POST", "/api/v1/orders", `{"symbol":"BTCUSD","side":"BUY","type":"LIMIT","price":100,"quantity":1}`)
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if rr := send("", "DELETE", "/api/v1/orders/"+resp.OrderID, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("anonymous cancel of a client's order: %v", rr.Code)
+	}
 }
@@ -1,14 +1,27 @@
 package apis
 
-import "github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+import (
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/candles"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/tradestore"
+)
 
 type OrderResponse struct {
 	OrderID           string             `json:"order_id"`
 	Status            engine.OrderStatus `json:"status"`
 	Message           string             `json:"message,omitempty"`
+	Price             int64              `json:"price,omitempty"` // After any post-only repricing
 	FilledQuantity    int64              `json:"filled_quantity,omitempty"`
 	RemainingQuantity int64              `json:"remaining_quantity,omitempty"`
 	Trades            []engine.Trade     `json:"trades,omitempty"`
+	// Resting orders of the same owner cancelled by self-trade prevention
+	SelfTradeCancels []string `json:"self_trade_cancels,omitempty"`
+}
+
+type TradingStatusResponse struct {
+	engine.TradingStatus
+	// Trades of queued orders released by opening the book
+	Trades []engine.Trade `json:"trades,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -16,10 +29,15 @@ type ErrorResponse struct {
 }
 
 type OrderBookResponse struct {
-	Symbol    string       `json:"symbol"`
-	Timestamp int64        `json:"timestamp"`
-	Bids      []PriceLevel `json:"bids"`
-	Asks      []PriceLevel `json:"asks"`
+	Symbol string              `json:"symbol"`
+	State  engine.TradingState `json:"state"`
+	// Indicative uncrossing price and volume during an auction
+	Auction        *engine.AuctionIndicative `json:"auction,omitempty"`
+	Seq            uint64                    `json:"seq"`
+	Timestamp      int64                     `json:"timestamp"`
+	PricePrecision int                       `json:"price_precision"`
+	Bids           []PriceLevel              `json:"bids"`
+	Asks           []PriceLevel              `json:"asks"`
 }
 
 type PriceLevel struct {
@@ -28,13 +46,63 @@ type PriceLevel struct {
 }
 
 type OrderStatusResponse struct {
-	OrderID        string             `json:"order_id"`
-	Symbol         string             `json:"symbol"`
-	Side           engine.Side        `json:"side"`
-	Type           engine.OrderType   `json:"type"`
-	Price          int64              `json:"price"`
-	Quantity       int64              `json:"quantity"`
-	FilledQuantity int64              `json:"filled_quantity"`
-	Status         engine.OrderStatus `json:"status"`
-	Timestamp      int64              `json:"timestamp"`
+	OrderID         string             `json:"order_id"`
+	ClientID        string             `json:"client_id,omitempty"`
+	AccountID       string             `json:"account_id,omitempty"`
+	Symbol          string             `json:"symbol"`
+	Side            engine.Side        `json:"side"`
+	Type            engine.OrderType   `json:"type"`
+	Price           int64              `json:"price"`
+	StopPrice       int64              `json:"stop_price,omitempty"`
+	Quantity        int64              `json:"quantity"`
+	FilledQuantity  int64              `json:"filled_quantity"`
+	DisplayQuantity int64              `json:"display_quantity,omitempty"`
+	HiddenQuantity  int64              `json:"hidden_quantity,omitempty"`
+	Hidden          bool               `json:"hidden,omitempty"`
+	PostOnly        engine.PostOnly    `json:"post_only,omitempty"`
+	Status          engine.OrderStatus `json:"status"`
+	TimeInForce     engine.TimeInForce `json:"time_in_force"`
+	ExpireAt        int64              `json:"expire_at,omitempty"`
+	CancelReason    string             `json:"cancel_reason,omitempty"`
+	TriggeredAt     int64              `json:"triggered_at,omitempty"`
+	Timestamp       int64              `json:"timestamp"`
+}
+
+type TradesResponse struct {
+	Symbol string              `json:"symbol"`
+	Trades []tradestore.Record `json:"trades"`
+	// Next is the after value for the next page, when this one is full
+	Next uint64 `json:"next,omitempty"`
+}
+
+type CandlesResponse struct {
+	Symbol   string           `json:"symbol"`
+	Interval candles.Interval `json:"interval"`
+	Candles  []candles.Bar    `json:"candles"`
+}
+
+type FillsResponse struct {
+	OrderID string              `json:"order_id"`
+	Fills   []tradestore.Record `json:"fills"`
+}
+
+type SnapshotResponse struct {
+	Seq uint64 `json:"seq"`
+}
+
+type SymbolShard struct {
+	Symbol string `json:"symbol"`
+	Shard  int    `json:"shard"`
+}
+
+// ShardAccount is the part of an account one shard's ledger holds.
+type ShardAccount struct {
+	Shard      int                        `json:"shard"`
+	Balances   map[string]*engine.Balance `json:"balances"`
+	OpenOrders int                        `json:"open_orders"`
+}
+
+type ShardsResponse struct {
+	Shards  int           `json:"shards"`
+	Symbols []SymbolShard `json:"symbols"`
 }
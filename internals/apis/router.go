@@ -3,17 +3,60 @@ package apis
 import (
 	"net/http"
 
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
 	"github.com/gorilla/mux"
 )
 
 func NewRouter(h *Handler) *mux.Router {
 	r := mux.NewRouter()
+	if h.Keys != nil {
+		r.Use(h.Keys.Middleware)
+	}
 	api := r.PathPrefix("/api/v1").Subrouter()
 
 	api.HandleFunc("/orders", h.SubmitOrder).Methods(http.MethodPost)
 	api.HandleFunc("/orders/{order_id}", h.CancelOrder).Methods(http.MethodDelete)
+	api.HandleFunc("/orders/{order_id}", h.AmendOrder).Methods(http.MethodPatch)
 	api.HandleFunc("/orders/{order_id}", h.GetOrderStatus).Methods(http.MethodGet)
 	api.HandleFunc("/orderbook/{symbol}", h.GetOrderBook).Methods(http.MethodGet)
+	api.HandleFunc("/ticker/{symbol}", h.GetTicker).Methods(http.MethodGet)
+	api.HandleFunc("/tickers", h.ListTickers).Methods(http.MethodGet)
+	api.HandleFunc("/accounts/{account_id}", h.GetAccount).Methods(http.MethodGet)
+	api.HandleFunc("/instruments", h.ListInstruments).Methods(http.MethodGet)
+	api.HandleFunc("/instruments/{symbol}", h.GetInstrument).Methods(http.MethodGet)
+	if h.Trades != nil {
+		api.HandleFunc("/trades/{symbol}", h.GetTrades).Methods(http.MethodGet)
+		api.HandleFunc("/orders/{order_id}/fills", h.GetOrderFills).Methods(http.MethodGet)
+	}
+	if h.Candles != nil {
+		api.HandleFunc("/candles/{symbol}", h.GetCandles).Methods(http.MethodGet)
+	}
+
+	// Streaming
+	if h.MarketData != nil {
+		api.Handle("/ws/marketdata", h.MarketData).Methods(http.MethodGet)
+	}
+	if h.Executions != nil {
+		api.Handle("/stream/executions", h.Executions).Methods(http.MethodGet)
+	}
+
+	// Admin, for admin clients only
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.AdminOnly)
+	admin.HandleFunc("/snapshots", h.TakeSnapshot).Methods(http.MethodPost)
+	admin.HandleFunc("/accounts", h.CreateAccount).Methods(http.MethodPost)
+	admin.HandleFunc("/accounts/{account_id}/limits", h.SetRiskLimits).Methods(http.MethodPut)
+	admin.HandleFunc("/accounts/{account_id}/balances", h.AdjustBalance).Methods(http.MethodPost)
+	admin.HandleFunc("/accounts/{account_id}/self-trade-prevention", h.SetSelfTradePrevention).Methods(http.MethodPut)
+	admin.HandleFunc("/instruments", h.AddInstrument).Methods(http.MethodPost)
+	admin.HandleFunc("/instruments/{symbol}", h.UpdateInstrument).Methods(http.MethodPut)
+	admin.HandleFunc("/symbols/{symbol}/trading-state", h.SetTradingState).Methods(http.MethodPut)
+	admin.HandleFunc("/symbols/{symbol}/trading-config", h.SetTradingConfig).Methods(http.MethodPut)
+	if h.Shards != nil {
+		admin.HandleFunc("/shards", h.ListShards).Methods(http.MethodGet)
+		admin.HandleFunc("/shards/{symbol}", h.MoveSymbol).Methods(http.MethodPut)
+		admin.HandleFunc("/accounts/{account_id}/shards", h.GetAccountShards).Methods(http.MethodGet)
+	}
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,232 @@
+package apis
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/shard"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+// ShardRouter is the Engine of a sharded server. Orders and symbol commands
+// go to the shard that trades the symbol; orders are found again by the
+// shard their ID names. Accounts and instruments are set on every shard.
+type ShardRouter struct {
+	Set *shard.Set
+}
+
+func NewShardRouter(s *shard.Set) *ShardRouter {
+	return &ShardRouter{Set: s}
+}
+
+// SubmitOrder prefixes the order's ID with the shard it goes to.
+func (r *ShardRouter) SubmitOrder(order *engine.Order) (trades []engine.Trade, err error) {
+	if order.Symbol == "" {
+		return nil, utils.ErrInvalidSymbol
+	}
+	r.Set.WithSymbol(order.Symbol, func(sh *shard.Shard) error {
+		order.ID = shard.EncodeOrderID(sh.Index, order.ID)
+		trades, err = sh.Engine.SubmitOrder(order)
+		return err
+	})
+	return trades, err
+}
+
+func (r *ShardRouter) CancelOrder(orderID string) error {
+	return r.Set.WithOrder(orderID, func(sh *shard.Shard) error {
+		return sh.Engine.CancelOrder(orderID)
+	})
+}
+
+func (r *ShardRouter) AmendOrder(orderID string, price, quantity int64) (trades []engine.Trade, err error) {
+	err = r.Set.WithOrder(orderID, func(sh *shard.Shard) error {
+		trades, err = sh.Engine.AmendOrder(orderID, price, quantity)
+		return err
+	})
+	return trades, err
+}
+
+func (r *ShardRouter) GetOrder(orderID string) (order *engine.Order, err error) {
+	err = r.Set.WithOrder(orderID, func(sh *shard.Shard) error {
+		order, err = sh.Engine.GetOrder(orderID)
+		return err
+	})
+	return order, err
+}
+
+func (r *ShardRouter) GetOrderBook(symbol string) *engine.OrderBook {
+	return r.Set.GetOrderBook(symbol)
+}
+
+func (r *ShardRouter) Tickers() []engine.Ticker {
+	return r.Set.Tickers()
+}
+
+// CreateAccount opens the account on every shard. Shards that already have
+// it, say from an earlier attempt that failed partway, take the new limits,
+// so retrying completes the account; only an account on every shard exists
+// already.
+func (r *ShardRouter) CreateAccount(id string, limits engine.RiskLimits) error {
+	created := false
+	err := r.Set.Each(func(sh *shard.Shard) error {
+		err := sh.Engine.CreateAccount(id, limits)
+		if err == utils.ErrAccountExists {
+			return nil
+		}
+		created = created || err == nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !created {
+		return utils.ErrAccountExists
+	}
+	return r.SetRiskLimits(id, limits)
+}
+
+func (r *ShardRouter) SetRiskLimits(id string, limits engine.RiskLimits) error {
+	return r.Set.Each(func(sh *shard.Shard) error {
+		return sh.Engine.SetRiskLimits(id, limits)
+	})
+}
+
+func (r *ShardRouter) SetSelfTradePrevention(id string, mode engine.SelfTradePrevention) error {
+	return r.Set.Each(func(sh *shard.Shard) error {
+		return sh.Engine.SetSelfTradePrevention(id, mode)
+	})
+}
+
+// AdjustBalance fails: each shard has a ledger of its own, so a deposit has
+// to name a symbol. See AdjustBalanceFor.
+func (r *ShardRouter) AdjustBalance(id, asset string, amount int64) error {
+	return utils.ErrSymbolRequired
+}
+
+// AdjustBalanceFor deposits into, or withdraws from, the ledger of the
+// shard that trades symbol. The asset has to be one symbol trades, so
+// funds never land on a shard where nothing can use them. When the symbol
+// moves they go with it, unless another symbol left behind trades the
+// asset too.
+func (r *ShardRouter) AdjustBalanceFor(symbol, id, asset string, amount int64) error {
+	return r.Set.WithSymbol(symbol, func(sh *shard.Shard) error {
+		if base, quote := sh.Engine.Assets(symbol); asset != base && asset != quote {
+			return utils.ErrInvalidAsset
+		}
+		return sh.Engine.AdjustBalance(id, asset, amount)
+	})
+}
+
+// GetAccount adds up the account's balances and open orders across shards.
+func (r *ShardRouter) GetAccount(id string) (engine.Account, error) {
+	var acct engine.Account
+	for i, sh := range r.Set.Shards() {
+		a, err := sh.Engine.GetAccount(id)
+		if err != nil {
+			return engine.Account{}, err
+		}
+		if i == 0 {
+			acct = a
+			continue
+		}
+		acct.OpenOrders += a.OpenOrders
+		for asset, b := range a.Balances {
+			if total, ok := acct.Balances[asset]; ok {
+				total.Total += b.Total
+				total.Reserved += b.Reserved
+			} else {
+				acct.Balances[asset] = b
+			}
+		}
+	}
+	return acct, nil
+}
+
+func (r *ShardRouter) AddInstrument(inst engine.Instrument) error {
+	return r.Set.Each(func(sh *shard.Shard) error {
+		return sh.Engine.AddInstrument(inst)
+	})
+}
+
+func (r *ShardRouter) UpdateInstrument(inst engine.Instrument) error {
+	return r.Set.Each(func(sh *shard.Shard) error {
+		return sh.Engine.UpdateInstrument(inst)
+	})
+}
+
+func (r *ShardRouter) GetInstrument(symbol string) (engine.Instrument, error) {
+	return r.Set.Shards()[0].Engine.GetInstrument(symbol)
+}
+
+func (r *ShardRouter) Instruments() []engine.Instrument {
+	return r.Set.Shards()[0].Engine.Instruments()
+}
+
+func (r *ShardRouter) SetTradingState(symbol string, state engine.TradingState) (trades []engine.Trade, err error) {
+	r.Set.WithSymbol(symbol, func(sh *shard.Shard) error {
+		trades, err = sh.Engine.SetTradingState(symbol, state)
+		return err
+	})
+	return trades, err
+}
+
+func (r *ShardRouter) SetTradingConfig(symbol string, cfg engine.TradingConfig) error {
+	return r.Set.WithSymbol(symbol, func(sh *shard.Shard) error {
+		return sh.Engine.SetTradingConfig(symbol, cfg)
+	})
+}
+
+func (h *Handler) ListShards(w http.ResponseWriter, r *http.Request) {
+	resp := ShardsResponse{Shards: len(h.Shards.Shards()), Symbols: []SymbolShard{}}
+	for symbol, n := range h.Shards.Symbols() {
+		resp.Symbols = append(resp.Symbols, SymbolShard{Symbol: symbol, Shard: n})
+	}
+	sort.Slice(resp.Symbols, func(i, j int) bool { return resp.Symbols[i].Symbol < resp.Symbols[j].Symbol })
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetAccountShards shows where an account's funds are.
+func (h *Handler) GetAccountShards(w http.ResponseWriter, r *http.Request) {
+	accountID := mux.Vars(r)["account_id"]
+
+	accounts := []ShardAccount{}
+	for _, sh := range h.Shards.Shards() {
+		acct, err := sh.Engine.GetAccount(accountID)
+		if err != nil {
+			writeAccountError(w, err)
+			return
+		}
+		accounts = append(accounts, ShardAccount{Shard: sh.Index, Balances: acct.Balances, OpenOrders: acct.OpenOrders})
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+// MoveSymbol hands a symbol's book over to another shard.
+func (h *Handler) MoveSymbol(w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+
+	var req struct {
+		Shard int `json:"shard"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed JSON")
+		return
+	}
+	if err := h.Shards.Move(symbol, req.Shard); err != nil {
+		switch err {
+		case utils.ErrUnknownShard:
+			writeError(w, http.StatusBadRequest, err.Error())
+		case utils.ErrUnknownSymbol:
+			writeError(w, http.StatusNotFound, "Unknown symbol")
+		case utils.ErrBookExists, utils.ErrUnknownAccount:
+			writeError(w, http.StatusConflict, "Move failed: "+err.Error())
+		default:
+			writeError(w, failureStatus(err), err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, SymbolShard{Symbol: symbol, Shard: h.Shards.Owner(symbol)})
+}
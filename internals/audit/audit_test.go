@@ -0,0 +1,236 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	r := Record{
+		Seq:       3,
+		Time:      time.Date(2024, 1, 5, 14, 30, 1, 123456789, time.UTC),
+		Action:    ActionFill,
+		ClientID:  "desk|1",
+		AccountID: "acct",
+		OrderID:   "s0-a",
+		Symbol:    "BTCUSD",
+		Before:    &State{Status: engine.OrderStatusAccepted, Side: engine.SideBuy, Price: 100, Quantity: 5},
+		After:     &State{Status: engine.OrderStatusPartialFill, Side: engine.SideBuy, Price: 100, Quantity: 5, Filled: 2},
+		TradeID:   "T1",
+		FillQty:   2,
+		FillPrice: 100,
+		Reason:    "50% done\nnext line",
+	}
+	line := r.Marshal()
+	got, err := Parse(line)
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", line, err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("round trip of %s gave %+v", line, got)
+	}
+
+	line[len(line)/2]++
+	if _, err := Parse(line); err != utils.ErrAuditCorrupt {
+		t.Errorf("damaged line: got %v", err)
+	}
+}
+
+func TestLogRecordsEventsAndRotates(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	clock := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	opts := Options{MaxBytes: 1, Now: func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(time.Nanosecond)
+		return clock
+	}}
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.NewEngine()
+	defer eng.Close()
+	eng.Events().Subscribe(l)
+	eng.CreateAccount("alice", engine.RiskLimits{})
+	eng.CreateAccount("bob", engine.RiskLimits{})
+	eng.AdjustBalance("alice", "BTCUSD", 5)
+	eng.AdjustBalance("bob", engine.DefaultQuoteAsset, 1000)
+	for _, o := range []*engine.Order{
+		{ID: "a", AccountID: "alice", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 5},
+		{ID: "b", AccountID: "bob", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 3},
+	} {
+		if _, err := eng.SubmitOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := eng.CancelOrder("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening carries on the numbering in a new file
+	l, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(Record{Action: ActionCancel, AccountID: "alice", OrderID: "a", Reason: utils.ErrOrderNotFound.Error()})
+	l.Close()
+
+	var alice []Record
+	err = Search(dir, Query{AccountID: "alice"}, func(r Record) error {
+		alice = append(alice, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []Action
+	for _, r := range alice {
+		actions = append(actions, r.Action)
+	}
+	if want := []Action{ActionAccepted, ActionFill, ActionCancelled, ActionCancel}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("alice's records %v, want %v", actions, want)
+	}
+	if fill := alice[1]; fill.Before.Filled != 0 || fill.After.Filled != 3 || fill.After.Status != engine.OrderStatusPartialFill || fill.TradeID == "" {
+		t.Errorf("fill recorded as %+v, %+v", fill.Before, fill.After)
+	}
+	if last := alice[3]; last.Seq != 6 {
+		t.Errorf("line after reopening numbered %d, want 6", last.Seq)
+	}
+
+	files, _ := listFiles(dir)
+	if len(files) != 6 {
+		t.Errorf("%d files, want one per line", len(files))
+	}
+	if info, _ := os.Stat(files[0].path); info.Mode().Perm()&0o222 != 0 {
+		t.Errorf("finished file is writable: %v", info.Mode())
+	}
+
+	var within int
+	Search(dir, Query{From: alice[1].Time, To: alice[3].Time}, func(Record) error {
+		within++
+		return nil
+	})
+	if within != 3 {
+		t.Errorf("%d records in the time range, want 3", within)
+	}
+
+	os.Remove(files[2].path)
+	if err := Search(dir, Query{}, func(Record) error { return nil }); !errors.Is(err, utils.ErrAuditCorrupt) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func TestOpenSkipsTornLine(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(Record{Action: ActionNew, OrderID: "a"})
+	// As left by a crash mid-write
+	f, _ := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString("34=2|60=2024")
+	f.Close()
+	l.f.Close()
+
+	l, err = Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write(Record{Action: ActionNew, OrderID: "b"})
+	l.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000002.log")); err != nil {
+		t.Errorf("no new file after the torn line: %v", err)
+	}
+	var n int
+	if err := Search(dir, Query{}, func(Record) error { n++; return nil }); err != nil || n != 2 {
+		t.Errorf("found %d records, %v", n, err)
+	}
+}
+
+func TestWriteFailureStopsLog(t *testing.T) {
+	l, err := Open(t.TempDir(), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The disk going away under the writer
+	l.f.Close()
+
+	err = l.Write(Record{Action: ActionNew, OrderID: "a"})
+	if !errors.Is(err, utils.ErrAuditFailed) {
+		t.Fatalf("expected ErrAuditFailed, got %v", err)
+	}
+	l.OnEvent(&engine.OrderAccepted{Order: engine.Order{ID: "b"}})
+	if l.Dropped() != 1 {
+		t.Errorf("expected the event dropped, got %d", l.Dropped())
+	}
+	if got := l.Write(Record{Action: ActionNew, OrderID: "c"}); got != err {
+		t.Errorf("write after the failure: got %v, want %v", got, err)
+	}
+	if l.Err() != err {
+		t.Errorf("Err() = %v, want %v", l.Err(), err)
+	}
+}
+
+func TestFullQueueHoldsCallers(t *testing.T) {
+	// The writer stalls on the clock when it checks the file's age
+	var stalled atomic.Bool
+	entered, stall := make(chan struct{}, 1), make(chan struct{})
+	opts := DefaultOptions()
+	opts.QueueSize, opts.MaxAge = 1, time.Hour
+	opts.Now = func() time.Time {
+		if stalled.Load() {
+			entered <- struct{}{}
+			<-stall
+		}
+		return time.Now()
+	}
+	l, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	unstall := sync.OnceFunc(func() { close(stall) })
+	defer unstall()
+
+	at := time.Now()
+	if err := l.Write(Record{Time: at, Action: ActionNew, OrderID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	stalled.Store(true)
+	go l.Write(Record{Time: at, Action: ActionNew, OrderID: "b"})
+	<-entered
+	stalled.Store(false)
+
+	// One entry fits in the queue, the next waits for room
+	if err := l.Track([]engine.Order{}); err != nil {
+		t.Fatal(err)
+	}
+	queued := make(chan error)
+	go func() { queued <- l.Track([]engine.Order{}) }()
+	select {
+	case <-queued:
+		t.Fatal("queued past the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unstall()
+	if err := <-queued; err != nil {
+		t.Fatal(err)
+	}
+}
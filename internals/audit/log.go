@@ -0,0 +1,485 @@
+package audit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+const fileExt = ".log"
+
+type Options struct {
+	// MaxBytes and MaxAge start a new file once the current one reaches
+	// that size or age. Zero disables either.
+	MaxBytes int64
+	MaxAge   time.Duration
+	// Sync fsyncs each batch of lines before Write returns. Records that
+	// arrive while a batch is written go in the next one.
+	Sync bool
+	// QueueSize bounds the entries waiting for the writer. Once it is full
+	// callers, books publishing events among them, wait for room, so a
+	// slow disk holds up trading rather than filling memory. Zero leaves
+	// the queue unbounded.
+	QueueSize int
+	// Now stamps records that don't carry a time. It defaults to time.Now,
+	// and must be safe to call from several goroutines.
+	Now func() time.Time
+}
+
+func DefaultOptions() Options {
+	return Options{MaxBytes: 64 << 20, MaxAge: 24 * time.Hour, Sync: true, QueueSize: 1 << 16}
+}
+
+// Log appends records to an audit log directory. Files are only ever
+// appended to: each run of the process starts a new one, and a file is made
+// read-only once it is done with.
+//
+// Log is an engine.EventSubscriber. Events are queued and written by the
+// log's own goroutine, so books only wait for the disk when the queue is
+// full. Events that can't be queued, once the log has failed or closed,
+// are counted and logged. It keeps the last
+// state of each open order it has seen, so that engine events record the
+// order's state before them as well as after.
+type Log struct {
+	dir  string
+	opts Options
+
+	// mu guards the queue, err and dropped; the writer goroutine owns the
+	// rest
+	mu      sync.Mutex
+	wake    *sync.Cond
+	room    *sync.Cond
+	queue   []entry
+	closing bool
+	done    chan struct{}
+	err     error
+	dropped int
+
+	f       *os.File
+	w       *bufio.Writer
+	path    string
+	size    int64
+	opened  time.Time
+	lastSeq uint64
+	orders  map[string]State
+	// stamped is the latest time the log stamped a record with
+	stamped time.Time
+}
+
+// entry is one thing for the writer to do: record an event or a record, or
+// track orders.
+type entry struct {
+	event engine.Event
+	// at is when the event, or a record without a time, arrived
+	at     time.Time
+	record Record
+	track  []engine.Order
+	// done receives the outcome of a Write once its batch is written
+	done chan error
+}
+
+// Open starts a new file in dir after the last line of the existing ones.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, opts: opts, done: make(chan struct{}), orders: make(map[string]State)}
+	l.wake = sync.NewCond(&l.mu)
+	l.room = sync.NewCond(&l.mu)
+	if len(files) > 0 {
+		// A file left behind by a crash may still be writable, and may
+		// end in a torn line; it is left as it is, unless it is empty
+		last := files[len(files)-1]
+		l.lastSeq = last.firstSeq - 1
+		err = readFile(last.path, func(r Record) error {
+			l.lastSeq = r.Seq
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(last.path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() == 0 {
+			err = os.Remove(last.path)
+		} else {
+			err = os.Chmod(last.path, 0o444)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *Log) openFile() error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.lastSeq+1, fileExt))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f, l.w, l.path, l.size, l.opened = f, bufio.NewWriter(f), path, 0, l.opts.Now()
+	return nil
+}
+
+// closeFile closes the current file and makes it read-only, or removes it
+// if nothing was written to it.
+func (l *Log) closeFile() error {
+	if err := l.flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.size == 0 {
+		return os.Remove(l.path)
+	}
+	return os.Chmod(l.path, 0o444)
+}
+
+// flush writes out the lines buffered so far and, with Options.Sync, waits
+// for them to reach the disk.
+func (l *Log) flush() error {
+	if l.w.Buffered() == 0 {
+		return nil
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if l.opts.Sync {
+		return l.f.Sync()
+	}
+	return nil
+}
+
+// Write appends r, numbering it and stamping it with the current time if it
+// has none, and returns once it is written. After a failure to write, the
+// log fails every later call with utils.ErrAuditFailed.
+func (l *Log) Write(r Record) error {
+	done := make(chan error, 1)
+	if err := l.enqueue(entry{record: r, done: done}); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// OnEvent queues the records for ev and returns without waiting for them
+// to be written.
+func (l *Log) OnEvent(ev engine.Event) {
+	if err := l.enqueue(entry{event: ev}); err != nil {
+		l.drop(err)
+	}
+}
+
+// drop counts an event that couldn't be queued, and logs the first.
+func (l *Log) drop(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dropped++
+	if l.dropped == 1 {
+		log.Printf("audit: dropping events: %v", err)
+	}
+}
+
+// Dropped returns how many events couldn't be queued.
+func (l *Log) Dropped() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// Track records the state of orders that were open before the log started,
+// such as those recovered from a journal.
+func (l *Log) Track(orders []engine.Order) error {
+	return l.enqueue(entry{track: orders})
+}
+
+// enqueue hands e to the writer, waiting while the queue is full. Events
+// arrive on every book's goroutine, so the lock is held for no more than
+// the append.
+func (l *Log) enqueue(e entry) error {
+	if e.event != nil || (e.track == nil && e.record.Time.IsZero()) {
+		e.at = l.opts.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.opts.QueueSize > 0 && len(l.queue) >= l.opts.QueueSize && l.err == nil && !l.closing {
+		l.room.Wait()
+	}
+	if l.err != nil {
+		return l.err
+	}
+	if l.closing {
+		return utils.ErrAuditClosed
+	}
+	l.queue = append(l.queue, e)
+	l.wake.Signal()
+	return nil
+}
+
+// run writes what is queued in batches, until the log is closed and the
+// queue is empty.
+func (l *Log) run() {
+	defer close(l.done)
+	for {
+		l.mu.Lock()
+		for len(l.queue) == 0 && !l.closing {
+			l.wake.Wait()
+		}
+		batch := l.queue
+		l.queue = nil
+		l.room.Broadcast()
+		l.mu.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+		l.commit(batch)
+	}
+}
+
+// commit writes a batch of entries with one flush, then tells the writers
+// waiting on it how it went.
+func (l *Log) commit(batch []entry) {
+	err := l.Err()
+	for _, e := range batch {
+		if err != nil {
+			break
+		}
+		switch {
+		case e.track != nil:
+			l.track(e.track)
+		case e.event != nil:
+			if r, ok := l.recordOf(e.event); ok {
+				r.Time = l.stamp(e.at)
+				err = l.append(r)
+			}
+		default:
+			r := e.record
+			if r.Time.IsZero() {
+				r.Time = l.stamp(e.at)
+			}
+			err = l.append(r)
+		}
+	}
+	if err == nil {
+		err = l.flush()
+	}
+	if err != nil {
+		l.fail(err)
+		err = l.Err()
+	}
+	for _, e := range batch {
+		if e.done != nil {
+			e.done <- err
+		}
+	}
+}
+
+// stamp returns at, or the last time stamped if at is before it: entries
+// are stamped before they are queued, possibly out of order, but times
+// never go back within a file.
+func (l *Log) stamp(at time.Time) time.Time {
+	if at.Before(l.stamped) {
+		return l.stamped
+	}
+	l.stamped = at
+	return at
+}
+
+// fail stops the log after a write error. Events have no one to return it
+// to, so it is logged as well as returned to every later Write.
+func (l *Log) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = fmt.Errorf("%w: %v", utils.ErrAuditFailed, err)
+		log.Printf("audit: writes stopped: %v", err)
+		l.room.Broadcast()
+	}
+}
+
+func (l *Log) append(r Record) error {
+	if l.size > 0 && ((l.opts.MaxBytes > 0 && l.size >= l.opts.MaxBytes) ||
+		(l.opts.MaxAge > 0 && l.opts.Now().Sub(l.opened) >= l.opts.MaxAge)) {
+		if err := l.closeFile(); err != nil {
+			return err
+		}
+		if err := l.openFile(); err != nil {
+			return err
+		}
+	}
+
+	r.Seq = l.lastSeq + 1
+	line := append(r.Marshal(), '\n')
+	if _, err := l.w.Write(line); err != nil {
+		return err
+	}
+	l.size += int64(len(line))
+	l.lastSeq = r.Seq
+	return nil
+}
+
+// Err returns the error that stopped the log, if any. Order entry checks it
+// and refuses instructions it couldn't record.
+func (l *Log) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Close writes out what is queued and closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	l.closing = true
+	l.wake.Signal()
+	l.room.Broadcast()
+	l.mu.Unlock()
+	<-l.done
+
+	if err := l.closeFile(); err != nil {
+		return err
+	}
+	return l.Err()
+}
+
+func (l *Log) track(orders []engine.Order) {
+	for i := range orders {
+		if o := &orders[i]; isOpen(o.Status) {
+			l.orders[o.ID] = *StateOf(o)
+		}
+	}
+}
+
+// recordOf returns the record of an order event, with the order's state
+// before it if the log has seen the order.
+func (l *Log) recordOf(ev engine.Event) (Record, bool) {
+	switch ev := ev.(type) {
+	case *engine.OrderAccepted:
+		return l.order(ActionAccepted, &ev.Order, ""), true
+	case *engine.OrderRejected:
+		return l.order(ActionRejected, &ev.Order, ev.Reason), true
+	case *engine.OrderCancelled:
+		return l.order(ActionCancelled, &ev.Order, ev.Reason), true
+	case *engine.OrderExpired:
+		return l.order(ActionExpired, &ev.Order, ""), true
+	case *engine.OrderAmended:
+		return l.order(ActionAmended, &ev.Order, ev.Reason), true
+	case *engine.OrderTriggered:
+		return l.order(ActionTriggered, &ev.Order, ""), true
+	case *engine.OrderFilled:
+		r := l.order(ActionFill, &ev.Order, "")
+		r.TradeID, r.FillQty, r.FillPrice = ev.Trade.ID, ev.Trade.Quantity, ev.Trade.Price
+		return r, true
+	}
+	return Record{}, false
+}
+
+func (l *Log) order(action Action, o *engine.Order, reason string) Record {
+	r := Record{
+		Action:    action,
+		ClientID:  o.ClientID,
+		AccountID: o.AccountID,
+		OrderID:   o.ID,
+		Symbol:    o.Symbol,
+		After:     StateOf(o),
+		Reason:    reason,
+	}
+	if before, ok := l.orders[o.ID]; ok {
+		r.Before = &before
+	}
+	if isOpen(o.Status) {
+		l.orders[o.ID] = *r.After
+	} else {
+		delete(l.orders, o.ID)
+	}
+	return r
+}
+
+func isOpen(status engine.OrderStatus) bool {
+	switch status {
+	case engine.OrderStatusFilled, engine.OrderStatusCancelled, engine.OrderStatusPartialFillCancelled,
+		engine.OrderStatusRejected, engine.OrderStatusExpired:
+		return false
+	}
+	return true
+}
+
+type file struct {
+	path     string
+	firstSeq uint64
+}
+
+func listFiles(dir string) ([]file, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []file
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		if seq, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 10, 64); err == nil {
+			files = append(files, file{path: filepath.Join(dir, name), firstSeq: seq})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].firstSeq < files[j].firstSeq })
+	return files, nil
+}
+
+// readFile calls fn for each record in the file at path, in order. A torn
+// final line, left by a crash mid-write, is skipped; any other damage fails.
+func readFile(path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r, err := Parse(line[:len(line)-1])
+		if err != nil {
+			return fmt.Errorf("%w: %s line %d", err, path, n)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
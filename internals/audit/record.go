@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// An audit log is a directory of files named after the sequence number of
+// their first line (00000000000000000001.log, ...), like the journal's
+// segments. Each line is one record of FIX-style tag=value fields, each
+// ending in '|', and a checksum:
+//
+//	34=7|60=20240105-14:30:01.123456789|5000=CANCEL|109=desk-1|1=acct-1|37=s0-9f2c...|55=BTCUSD|5001=ACCEPTED BUY 0/5@100|5002=CANCELLED BUY 0/5@100|10=142|
+//
+// 34 numbers the lines from 1 without gaps, 60 is the time in UTC to the
+// nanosecond, and 10 is the sum of the bytes before it modulo 256, as in FIX.
+// Order states (5001 before, 5002 after) read "STATUS SIDE filled/quantity@price".
+
+const (
+	tagSeq       = 34
+	tagTime      = 60
+	tagAction    = 5000
+	tagClientID  = 109
+	tagAccount   = 1
+	tagOrderID   = 37
+	tagSymbol    = 55
+	tagBefore    = 5001
+	tagAfter     = 5002
+	tagTradeID   = 17
+	tagLastQty   = 32
+	tagLastPx    = 31
+	tagText      = 58
+	tagCheckSum  = 10
+	timeLayout   = "20060102-15:04:05.000000000"
+	fieldEnd     = '|'
+	fieldEndText = "|"
+)
+
+type Action string
+
+const (
+	// Instructions, logged once the API has answered them. A rejected
+	// instruction has a Reason.
+	ActionNew    Action = "NEW"
+	ActionCancel Action = "CANCEL"
+	ActionAmend  Action = "AMEND"
+
+	// What the engine did, from its event bus
+	ActionAccepted  Action = "ACCEPTED"
+	ActionRejected  Action = "REJECTED"
+	ActionCancelled Action = "CANCELLED"
+	ActionExpired   Action = "EXPIRED"
+	ActionAmended   Action = "AMENDED"
+	ActionTriggered Action = "TRIGGERED"
+	ActionFill      Action = "FILL"
+)
+
+// State is an order as the audit log records it.
+type State struct {
+	Status   engine.OrderStatus
+	Side     engine.Side
+	Price    int64
+	Quantity int64
+	Filled   int64
+}
+
+func StateOf(o *engine.Order) *State {
+	return &State{Status: o.Status, Side: o.Side, Price: o.Price, Quantity: o.Quantity, Filled: o.Filled}
+}
+
+func (s State) String() string {
+	return fmt.Sprintf("%s %s %d/%d@%d", orDash(string(s.Status)), orDash(string(s.Side)), s.Filled, s.Quantity, s.Price)
+}
+
+// orDash keeps an empty word from collapsing the state's spacing, as a
+// rejected order's side may be.
+func orDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+func parseState(v string) (*State, error) {
+	var s State
+	var status, side string
+	if _, err := fmt.Sscanf(v, "%s %s %d/%d@%d", &status, &side, &s.Filled, &s.Quantity, &s.Price); err != nil {
+		return nil, err
+	}
+	s.Status, s.Side = engine.OrderStatus(strings.TrimPrefix(status, "-")), engine.Side(strings.TrimPrefix(side, "-"))
+	return &s, nil
+}
+
+type Record struct {
+	// Seq and Time are filled in by Log.Write if zero.
+	Seq       uint64
+	Time      time.Time
+	Action    Action
+	ClientID  string
+	AccountID string
+	OrderID   string
+	Symbol    string
+	// Before and After are nil when there was no order, or it didn't
+	// change.
+	Before *State
+	After  *State
+	// TradeID, FillQty and FillPrice are set on fills.
+	TradeID   string
+	FillQty   int64
+	FillPrice int64
+	Reason    string
+}
+
+// Values can't contain the field separator or end the line.
+var (
+	escaper   = strings.NewReplacer("%", "%25", fieldEndText, "%7C", "\n", "%0A", "\r", "%0D")
+	unescaper = strings.NewReplacer("%25", "%", "%7C", fieldEndText, "%0A", "\n", "%0D", "\r")
+)
+
+// Marshal returns the record's line, without the newline.
+func (r *Record) Marshal() []byte {
+	var b []byte
+	field := func(tag int, v string) {
+		if v == "" {
+			return
+		}
+		b = strconv.AppendInt(b, int64(tag), 10)
+		b = append(b, '=')
+		b = append(b, escaper.Replace(v)...)
+		b = append(b, fieldEnd)
+	}
+	field(tagSeq, strconv.FormatUint(r.Seq, 10))
+	field(tagTime, r.Time.UTC().Format(timeLayout))
+	field(tagAction, string(r.Action))
+	field(tagClientID, r.ClientID)
+	field(tagAccount, r.AccountID)
+	field(tagOrderID, r.OrderID)
+	field(tagSymbol, r.Symbol)
+	if r.Before != nil {
+		field(tagBefore, r.Before.String())
+	}
+	if r.After != nil {
+		field(tagAfter, r.After.String())
+	}
+	if r.TradeID != "" {
+		field(tagTradeID, r.TradeID)
+		field(tagLastQty, strconv.FormatInt(r.FillQty, 10))
+		field(tagLastPx, strconv.FormatInt(r.FillPrice, 10))
+	}
+	field(tagText, r.Reason)
+	return fmt.Appendf(b, "%d=%03d|", tagCheckSum, checksum(b))
+}
+
+func checksum(b []byte) int {
+	var sum int
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// Parse reads a line written by Marshal, failing with utils.ErrAuditCorrupt
+// if it is damaged.
+func Parse(line []byte) (Record, error) {
+	var r Record
+	sumAt := strings.LastIndex(string(line), fieldEndText+strconv.Itoa(tagCheckSum)+"=") + 1
+	if sumAt == 0 || line[len(line)-1] != fieldEnd {
+		return r, utils.ErrAuditCorrupt
+	}
+	sum, err := strconv.Atoi(string(line[sumAt+3 : len(line)-1]))
+	if err != nil || sum != checksum(line[:sumAt]) {
+		return r, utils.ErrAuditCorrupt
+	}
+
+	for _, f := range strings.Split(string(line[:sumAt-1]), fieldEndText) {
+		tag, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return r, utils.ErrAuditCorrupt
+		}
+		v = unescaper.Replace(v)
+		n, err := strconv.Atoi(tag)
+		if err != nil {
+			return r, utils.ErrAuditCorrupt
+		}
+		switch n {
+		case tagSeq:
+			r.Seq, err = strconv.ParseUint(v, 10, 64)
+		case tagTime:
+			r.Time, err = time.Parse(timeLayout, v)
+		case tagAction:
+			r.Action = Action(v)
+		case tagClientID:
+			r.ClientID = v
+		case tagAccount:
+			r.AccountID = v
+		case tagOrderID:
+			r.OrderID = v
+		case tagSymbol:
+			r.Symbol = v
+		case tagBefore:
+			r.Before, err = parseState(v)
+		case tagAfter:
+			r.After, err = parseState(v)
+		case tagTradeID:
+			r.TradeID = v
+		case tagLastQty:
+			r.FillQty, err = strconv.ParseInt(v, 10, 64)
+		case tagLastPx:
+			r.FillPrice, err = strconv.ParseInt(v, 10, 64)
+		case tagText:
+			r.Reason = v
+		}
+		if err != nil {
+			return r, utils.ErrAuditCorrupt
+		}
+	}
+	return r, nil
+}
+
+// Submitted is the record of an order submission, with reason set if it was
+// rejected. order is nil if the request couldn't be read.
+func Submitted(t time.Time, clientID string, order *engine.Order, reason string) Record {
+	r := Record{Time: t, Action: ActionNew, ClientID: clientID, Reason: reason}
+	if order != nil {
+		r.AccountID, r.OrderID, r.Symbol = order.AccountID, order.ID, order.Symbol
+		r.After = StateOf(order)
+		if reason != "" {
+			r.After.Status = engine.OrderStatusRejected
+		}
+	}
+	return r
+}
+
+// Changed is the record of a cancel or amend of an order. before is nil if
+// the order wasn't found, and after if the instruction failed.
+func Changed(t time.Time, action Action, clientID, orderID string, before, after *engine.Order, reason string) Record {
+	r := Record{Time: t, Action: action, ClientID: clientID, OrderID: orderID, Reason: reason}
+	if before != nil {
+		r.AccountID, r.Symbol = before.AccountID, before.Symbol
+		r.Before = StateOf(before)
+	}
+	if after != nil {
+		r.After = StateOf(after)
+	}
+	return r
+}
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// Query selects records; empty fields match everything.
+type Query struct {
+	OrderID   string
+	AccountID string
+	ClientID  string
+	// From is inclusive and To exclusive.
+	From time.Time
+	To   time.Time
+}
+
+func (q *Query) Match(r *Record) bool {
+	return (q.OrderID == "" || r.OrderID == q.OrderID) &&
+		(q.AccountID == "" || r.AccountID == q.AccountID) &&
+		(q.ClientID == "" || r.ClientID == q.ClientID) &&
+		(q.From.IsZero() || !r.Time.Before(q.From)) &&
+		(q.To.IsZero() || r.Time.Before(q.To))
+}
+
+// Search calls fn with the records in dir that match q, in order. It checks
+// every line on the way, failing with utils.ErrAuditCorrupt on a damaged
+// line or a gap in the sequence numbers; files older than the first one
+// present may have been archived.
+func Search(dir string, q Query, fn func(Record) error) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var last uint64
+	for i, f := range files {
+		if i > 0 && f.firstSeq != last+1 {
+			return fmt.Errorf("%w: %s does not follow line %d", utils.ErrAuditCorrupt, f.path, last)
+		}
+		last = f.firstSeq - 1
+		err := readFile(f.path, func(r Record) error {
+			if r.Seq != last+1 {
+				return fmt.Errorf("%w: %s has line %d after %d", utils.ErrAuditCorrupt, f.path, r.Seq, last)
+			}
+			last = r.Seq
+			if q.Match(&r) {
+				return fn(r)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
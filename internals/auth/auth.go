@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// Keys authenticates API requests by bearer token. Each API key belongs to
+// one client, whose ID is what orders, execution reports and the audit log
+// know it by. Keys are held as hashes, so a lookup compares no key bytes
+// directly.
+type Keys struct {
+	clients map[[sha256.Size]byte]Client
+}
+
+// Client is who an API key authenticates, and what it may do.
+type Client struct {
+	ID string `json:"client_id"`
+	// Accounts are the accounts the client may trade and read.
+	Accounts []string `json:"accounts,omitempty"`
+	// Admin allows the admin API.
+	Admin bool `json:"admin,omitempty"`
+}
+
+// CanUse reports whether the client may trade or read account.
+func (c Client) CanUse(account string) bool {
+	for _, a := range c.Accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+// NewKeys takes a map of API keys to their clients.
+func NewKeys(keys map[string]Client) *Keys {
+	k := &Keys{clients: make(map[[sha256.Size]byte]Client, len(keys))}
+	for key, client := range keys {
+		k.clients[sha256.Sum256([]byte(key))] = client
+	}
+	return k
+}
+
+// LoadKeys reads a JSON object of API keys to their clients: either a
+// client ID, for a client without accounts, or a Client.
+func LoadKeys(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]Client, len(raw))
+	for key, v := range raw {
+		var client Client
+		if err := json.Unmarshal(v, &client.ID); err != nil {
+			if err := json.Unmarshal(v, &client); err != nil {
+				return nil, err
+			}
+		}
+		if key == "" || client.ID == "" {
+			return nil, utils.ErrInvalidAPIKey
+		}
+		keys[key] = client
+	}
+	return NewKeys(keys), nil
+}
+
+// Authenticate returns the client the request's "Authorization: Bearer"
+// key belongs to, false if it has none, or utils.ErrInvalidAPIKey.
+func (k *Keys) Authenticate(r *http.Request) (Client, bool, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return Client{}, false, nil
+	}
+	key, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return Client{}, false, utils.ErrInvalidAPIKey
+	}
+	client, ok := k.clients[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Client{}, false, utils.ErrInvalidAPIKey
+	}
+	return client, true, nil
+}
+
+// Middleware puts the authenticated client in the request's context.
+// Requests without a key go through anonymously; a key that isn't known is
+// refused.
+func (k *Keys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok, err := k.Authenticate(r)
+		if err != nil {
+			unauthorized(w, err)
+			return
+		}
+		if ok {
+			r = r.WithContext(WithClient(r.Context(), client))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminOnly refuses requests that weren't authenticated as an admin
+// client, so without API keys the admin API can't be used at all.
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := FromContext(r.Context())
+		if !ok {
+			unauthorized(w, utils.ErrNotAuthenticated)
+			return
+		}
+		if !client.Admin {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"error":"`+utils.ErrNotPermitted.Error()+`"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusUnauthorized)
+}
+
+type contextKey struct{}
+
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, contextKey{}, client)
+}
+
+// WithClientID authenticates ctx as a client with no accounts.
+func WithClientID(ctx context.Context, clientID string) context.Context {
+	return WithClient(ctx, Client{ID: clientID})
+}
+
+// FromContext returns the authenticated client of a request's context.
+func FromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(contextKey{}).(Client)
+	return client, ok
+}
+
+// ClientID returns the authenticated client of a request's context, or "".
+func ClientID(ctx context.Context) string {
+	client, _ := FromContext(ctx)
+	return client.ID
+}
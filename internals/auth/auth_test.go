@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{"k1":"desk-1","k2":"desk-2"}`), 0o600)
+	keys, err := LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen string
+	h := keys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientID(r.Context())
+	}))
+	for _, tc := range []struct {
+		header string
+		code   int
+		client string
+	}{
+		{"Bearer k2", http.StatusOK, "desk-2"},
+		{"", http.StatusOK, ""},
+		{"Bearer desk-2", http.StatusUnauthorized, ""},
+		{"Basic k2", http.StatusUnauthorized, ""},
+	} {
+		seen = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.code || seen != tc.client {
+			t.Errorf("Authorization %q: got %d as %q, want %d as %q", tc.header, rr.Code, seen, tc.code, tc.client)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"k1":""}`), 0o600)
+	if _, err := LoadKeys(path); err != utils.ErrInvalidAPIKey {
+		t.Errorf("key without a client: got %v", err)
+	}
+}
+
+func TestClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{"k1":"desk-1","k2":{"client_id":"ops","accounts":["a1"],"admin":true}}`), 0o600)
+	keys, err := LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := keys.Middleware(AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client, _ := FromContext(r.Context()); !client.CanUse("a1") || client.CanUse("a2") {
+			t.Errorf("client %+v", client)
+		}
+	})))
+	for _, tc := range []struct {
+		header string
+		code   int
+	}{
+		{"Bearer k2", http.StatusOK},
+		{"Bearer k1", http.StatusForbidden},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.code {
+			t.Errorf("Authorization %q: got %d, want %d", tc.header, rr.Code, tc.code)
+		}
+	}
+}
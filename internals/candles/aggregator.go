@@ -0,0 +1,180 @@
+package candles
+
+import (
+	"sync"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+)
+
+type Interval string
+
+const (
+	Interval1s Interval = "1s"
+	Interval1m Interval = "1m"
+	Interval5m Interval = "5m"
+	Interval1h Interval = "1h"
+	Interval1d Interval = "1d"
+)
+
+// Intervals lists every interval bars are kept for, shortest first.
+var Intervals = []Interval{Interval1s, Interval1m, Interval5m, Interval1h, Interval1d}
+
+func (i Interval) Millis() int64 {
+	switch i {
+	case Interval1s:
+		return 1000
+	case Interval1m:
+		return 60 * 1000
+	case Interval5m:
+		return 5 * 60 * 1000
+	case Interval1h:
+		return 60 * 60 * 1000
+	case Interval1d:
+		return 24 * 60 * 60 * 1000
+	}
+	return 0
+}
+
+func (i Interval) Valid() bool {
+	return i.Millis() > 0
+}
+
+// Bar is one OHLCV candle. Start is the Unix millisecond the bar opens at,
+// a multiple of its interval, so daily bars run from UTC midnight.
+type Bar struct {
+	Symbol   string   `json:"symbol"`
+	Interval Interval `json:"interval"`
+	Start    int64    `json:"start"`
+	Open     int64    `json:"open"`
+	High     int64    `json:"high"`
+	Low      int64    `json:"low"`
+	Close    int64    `json:"close"`
+	Volume   int64    `json:"volume"`
+	Trades   int      `json:"trades"`
+}
+
+// Listener receives the bars each batch of trades changed, one per
+// interval. It is called synchronously and must not block.
+type Listener interface {
+	OnBars(bars []Bar)
+}
+
+const DefaultCapacity = 1000
+
+// Aggregator builds bars of every interval from the engine's trades, which
+// it subscribes to. Intervals without trades have no bar.
+type Aggregator struct {
+	mu       sync.RWMutex
+	capacity int
+	series   map[seriesKey][]Bar
+	listener Listener
+}
+
+type seriesKey struct {
+	symbol   string
+	interval Interval
+}
+
+// NewAggregator keeps the last capacity bars of each symbol and interval.
+func NewAggregator(capacity int) *Aggregator {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Aggregator{capacity: capacity, series: make(map[seriesKey][]Bar)}
+}
+
+func (a *Aggregator) SetListener(l Listener) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.listener = l
+}
+
+// OnEvent folds each trade into its symbol's bars.
+func (a *Aggregator) OnEvent(ev engine.Event) {
+	if ev, ok := ev.(*engine.TradeExecuted); ok {
+		a.OnTrades(ev.Symbol, []engine.Trade{ev.Trade})
+	}
+}
+
+func (a *Aggregator) OnTrades(symbol string, trades []engine.Trade) {
+	a.mu.Lock()
+	updated := make([]Bar, 0, len(Intervals))
+	for _, interval := range Intervals {
+		key := seriesKey{symbol, interval}
+		bars := a.series[key]
+		for _, t := range trades {
+			bars = a.add(bars, symbol, interval, t)
+		}
+		a.series[key] = bars
+		updated = append(updated, bars[len(bars)-1])
+	}
+	l := a.listener
+	a.mu.Unlock()
+
+	if l != nil {
+		l.OnBars(updated)
+	}
+}
+
+// add folds t into bars, opening a new bar if t is past the last one. A
+// trade stamped before the last bar, from the clock stepping back, counts
+// towards it.
+func (a *Aggregator) add(bars []Bar, symbol string, interval Interval, t engine.Trade) []Bar {
+	start := t.Timestamp - t.Timestamp%interval.Millis()
+	if n := len(bars); n > 0 && start <= bars[n-1].Start {
+		b := &bars[n-1]
+		b.High = max(b.High, t.Price)
+		b.Low = min(b.Low, t.Price)
+		b.Close = t.Price
+		b.Volume += t.Quantity
+		b.Trades++
+		return bars
+	}
+
+	bar := Bar{
+		Symbol:   symbol,
+		Interval: interval,
+		Start:    start,
+		Open:     t.Price,
+		High:     t.Price,
+		Low:      t.Price,
+		Close:    t.Price,
+		Volume:   t.Quantity,
+		Trades:   1,
+	}
+	if len(bars) == a.capacity {
+		copy(bars, bars[1:])
+		bars[len(bars)-1] = bar
+		return bars
+	}
+	return append(bars, bar)
+}
+
+// Bars returns symbol's bars of interval that start in [from, to), oldest
+// first. Zero leaves either end open.
+func (a *Aggregator) Bars(symbol string, interval Interval, from, to int64) []Bar {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	out := []Bar{}
+	for _, b := range a.series[seriesKey{symbol, interval}] {
+		if (from == 0 || b.Start >= from) && (to == 0 || b.Start < to) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// Latest returns symbol's current bar of each interval that has one.
+func (a *Aggregator) Latest(symbol string) []Bar {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var out []Bar
+	for _, interval := range Intervals {
+		if bars := a.series[seriesKey{symbol, interval}]; len(bars) > 0 {
+			out = append(out, bars[len(bars)-1])
+		}
+	}
+	return out
+}
@@ -0,0 +1,49 @@
+package candles
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+)
+
+type barRecorder [][]Bar
+
+func (r *barRecorder) OnBars(bars []Bar) { *r = append(*r, bars) }
+
+func TestAggregatorBars(t *testing.T) {
+	a := NewAggregator(2)
+	var rec barRecorder
+	a.SetListener(&rec)
+
+	a.OnTrades("BTCUSD", []engine.Trade{
+		{Price: 100, Quantity: 1, Timestamp: 60_000},
+		{Price: 105, Quantity: 2, Timestamp: 60_500},
+		{Price: 98, Quantity: 3, Timestamp: 61_000},
+	})
+	a.OnTrades("BTCUSD", []engine.Trade{{Price: 101, Quantity: 1, Timestamp: 119_999}})
+
+	bars := a.Bars("BTCUSD", Interval1m, 0, 0)
+	want := Bar{Symbol: "BTCUSD", Interval: Interval1m, Start: 60_000, Open: 100, High: 105, Low: 98, Close: 101, Volume: 7, Trades: 4}
+	if len(bars) != 1 || bars[0] != want {
+		t.Fatalf("expected %+v, got %+v", want, bars)
+	}
+
+	// Capacity keeps the last two one second bars
+	secs := a.Bars("BTCUSD", Interval1s, 0, 0)
+	if len(secs) != 2 || secs[0].Start != 61_000 || secs[1].Start != 119_000 {
+		t.Errorf("expected the 61s and 119s bars, got %+v", secs)
+	}
+	if got := a.Bars("BTCUSD", Interval1s, 100_000, 0); len(got) != 1 || got[0].Start != 119_000 {
+		t.Errorf("expected from to filter, got %+v", got)
+	}
+	if got := a.Bars("ETHUSD", Interval1m, 0, 0); got == nil || len(got) != 0 {
+		t.Errorf("expected no bars, got %+v", got)
+	}
+
+	if len(rec) != 2 || len(rec[1]) != len(Intervals) || rec[1][0].Close != 101 {
+		t.Errorf("expected the latest bar of each interval per batch, got %+v", rec)
+	}
+	if latest := a.Latest("BTCUSD"); len(latest) != len(Intervals) || latest[len(latest)-1].Start != 0 {
+		t.Errorf("expected a daily bar from midnight, got %+v", latest)
+	}
+}
@@ -0,0 +1,615 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// DefaultQuoteAsset is the cash asset every symbol is priced in. The base
+// asset of a symbol is the symbol itself.
+const DefaultQuoteAsset = "USD"
+
+// RiskLimits are an account's pre-trade limits. Zero disables a limit.
+type RiskLimits struct {
+	MaxOrderQuantity int64 `json:"max_order_quantity,omitempty"`
+	MaxOrderNotional int64 `json:"max_order_notional,omitempty"` // Price * quantity, in cents
+	MaxOpenOrders    int   `json:"max_open_orders,omitempty"`
+}
+
+func (l RiskLimits) Valid() bool {
+	return l.MaxOrderQuantity >= 0 && l.MaxOrderNotional >= 0 && l.MaxOpenOrders >= 0
+}
+
+type Balance struct {
+	Total    int64 `json:"total"`
+	Reserved int64 `json:"reserved"` // Held for open orders
+}
+
+func (b Balance) Available() int64 {
+	return b.Total - b.Reserved
+}
+
+// Account holds cash (in the quote asset) and positions (in base assets)
+// under one balance map keyed by asset.
+type Account struct {
+	ID         string              `json:"id"`
+	Balances   map[string]*Balance `json:"balances"`
+	Limits     RiskLimits          `json:"limits"`
+	OpenOrders int                 `json:"open_orders"`
+	// SelfTradePrevention applies to the account's orders that don't set one.
+	SelfTradePrevention SelfTradePrevention `json:"self_trade_prevention,omitempty"`
+}
+
+func (a *Account) balance(asset string) *Balance {
+	b, ok := a.Balances[asset]
+	if !ok {
+		b = &Balance{}
+		a.Balances[asset] = b
+	}
+	return b
+}
+
+func (a *Account) copy() Account {
+	c := *a
+	c.Balances = make(map[string]*Balance, len(a.Balances))
+	for asset, b := range a.Balances {
+		bc := *b
+		c.Balances[asset] = &bc
+	}
+	return c
+}
+
+// Accounts is the engine's ledger. Order books call into it from their own
+// goroutines, so it must never call back into a book.
+//
+// Each account has its own lock, so books trading for different accounts
+// don't wait for each other. mu is held shared while an account is used and
+// exclusively to add accounts or work on all of them.
+type Accounts struct {
+	mu       sync.RWMutex
+	accounts map[string]*lockedAccount
+	// required rejects orders that don't name an account.
+	required atomic.Bool
+}
+
+type lockedAccount struct {
+	mu sync.Mutex
+	*Account
+}
+
+func newAccounts() *Accounts {
+	return &Accounts{accounts: make(map[string]*lockedAccount)}
+}
+
+// lock returns account id locked, if it exists. unlock releases it.
+func (a *Accounts) lock(id string) (*lockedAccount, bool) {
+	a.mu.RLock()
+	acct, ok := a.accounts[id]
+	if !ok {
+		a.mu.RUnlock()
+		return nil, false
+	}
+	acct.mu.Lock()
+	return acct, true
+}
+
+func (a *Accounts) unlock(acct *lockedAccount) {
+	acct.mu.Unlock()
+	a.mu.RUnlock()
+}
+
+func (a *Accounts) create(id string, limits RiskLimits) error {
+	if id == "" {
+		return utils.ErrUnknownAccount
+	}
+	if !limits.Valid() {
+		return utils.ErrInvalidRiskLimits
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.accounts[id]; ok {
+		return utils.ErrAccountExists
+	}
+	a.accounts[id] = &lockedAccount{Account: &Account{ID: id, Balances: make(map[string]*Balance), Limits: limits}}
+	return nil
+}
+
+func (a *Accounts) setLimits(id string, limits RiskLimits) error {
+	if !limits.Valid() {
+		return utils.ErrInvalidRiskLimits
+	}
+
+	acct, ok := a.lock(id)
+	if !ok {
+		return utils.ErrUnknownAccount
+	}
+	defer a.unlock(acct)
+	acct.Limits = limits
+	return nil
+}
+
+func (a *Accounts) setSelfTradePrevention(id string, mode SelfTradePrevention) error {
+	if !mode.Valid() {
+		return utils.ErrInvalidSelfTradePrevention
+	}
+
+	acct, ok := a.lock(id)
+	if !ok {
+		return utils.ErrUnknownAccount
+	}
+	defer a.unlock(acct)
+	acct.SelfTradePrevention = mode
+	return nil
+}
+
+// adjust deposits amount of asset, or withdraws it if amount is negative.
+// Reserved funds can't be withdrawn.
+func (a *Accounts) adjust(id, asset string, amount int64) error {
+	if asset == "" {
+		return utils.ErrInvalidAsset
+	}
+
+	acct, ok := a.lock(id)
+	if !ok {
+		return utils.ErrUnknownAccount
+	}
+	defer a.unlock(acct)
+	b := acct.balance(asset)
+	if amount < 0 && b.Available() < -amount {
+		return utils.ErrInsufficientFunds
+	}
+	b.Total += amount
+	return nil
+}
+
+func (a *Accounts) get(id string) (Account, bool) {
+	acct, ok := a.lock(id)
+	if !ok {
+		return Account{}, false
+	}
+	defer a.unlock(acct)
+	return acct.copy(), true
+}
+
+func (a *Accounts) state() []Account {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	accounts := make([]Account, 0, len(a.accounts))
+	for _, acct := range a.accounts {
+		accounts = append(accounts, acct.copy())
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts
+}
+
+func (a *Accounts) restore(accounts []Account) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accounts = make(map[string]*lockedAccount, len(accounts))
+	for i := range accounts {
+		acct := accounts[i].copy()
+		a.accounts[acct.ID] = &lockedAccount{Account: &acct}
+	}
+}
+
+// riskTape carries the outcomes of the account checks a journaled book
+// command makes that depend on other books: whether the funds are free and
+// the open order limit. Books apply commands in parallel, so the journal
+// order needn't be the order those checks saw the balances in; replay takes
+// the recorded outcomes instead of checking again.
+type riskTape struct {
+	outcomes []string // "" for a check that passed
+	replay   bool
+	pos      int
+	short    bool
+}
+
+// outcome records err as a check's outcome, or on replay returns the
+// recorded one in its place. A nil tape leaves err as it is.
+func (t *riskTape) outcome(err error) error {
+	if t == nil {
+		return err
+	}
+	if !t.replay {
+		var msg string
+		if err != nil {
+			msg = err.Error()
+		}
+		t.outcomes = append(t.outcomes, msg)
+		return err
+	}
+	if t.pos >= len(t.outcomes) {
+		t.short = true
+		return err
+	}
+	msg := t.outcomes[t.pos]
+	t.pos++
+	if msg == "" {
+		return nil
+	}
+	for _, known := range []error{utils.ErrMaxOpenOrders, utils.ErrInsufficientFunds, utils.ErrInsufficientPosition} {
+		if msg == known.Error() {
+			return known
+		}
+	}
+	return errors.New(msg)
+}
+
+// replayTape replays outcomes. Journals from before outcomes were recorded
+// have none, and run the checks again.
+func replayTape(outcomes []string) *riskTape {
+	if outcomes == nil {
+		return nil
+	}
+	return &riskTape{outcomes: outcomes, replay: true}
+}
+
+func (t *riskTape) diverged() bool {
+	return t != nil && (t.short || t.pos != len(t.outcomes))
+}
+
+// notional returns price * quantity, or false if it doesn't fit in an
+// int64. Orders are checked with it on entry and amend, so the products
+// taken of their prices and quantities later can't overflow.
+func notional(price, quantity int64) (int64, bool) {
+	if quantity != 0 && price > math.MaxInt64/quantity {
+		return 0, false
+	}
+	return price * quantity, true
+}
+
+// reserveAmount is what order has to hold to cover its open quantity: cash
+// at its limit price for a buy, the base asset for a sell. notional is used
+// for market buys, which have no price of their own.
+func reserveAmount(order *Order, quantity, notional int64) int64 {
+	if order.Side == SideSell {
+		return quantity
+	}
+	if order.isMarket() {
+		return notional
+	}
+	return order.Price * quantity
+}
+
+// admit runs the pre-trade checks for a new order and reserves what it needs.
+// notional is the order's value: price * quantity for a limit order, or what
+// it would trade for against the book right now for a market order. The
+// checks that depend on other books go through tape.
+func (a *Accounts) admit(order *Order, notional int64, rests bool, base, quote string, tape *riskTape) error {
+	if a == nil {
+		return nil
+	}
+	if order.AccountID == "" {
+		if a.required.Load() {
+			return utils.ErrUnknownAccount
+		}
+		return nil
+	}
+
+	acct, ok := a.lock(order.AccountID)
+	if !ok {
+		return utils.ErrUnknownAccount
+	}
+	defer a.unlock(acct)
+	if err := acct.checkLimits(order.Quantity, notional); err != nil {
+		return err
+	}
+	amount := reserveAmount(order, order.Quantity, notional)
+	var err error
+	if rests && acct.Limits.MaxOpenOrders > 0 && acct.OpenOrders >= acct.Limits.MaxOpenOrders {
+		err = utils.ErrMaxOpenOrders
+	} else {
+		err = acct.reservable(order, amount, base, quote)
+	}
+	if err := tape.outcome(err); err != nil {
+		return err
+	}
+	acct.reserve(order, amount, base, quote)
+	return nil
+}
+
+// check runs the account checks that don't depend on the book, for stop
+// orders that will only be admitted once triggered.
+func (a *Accounts) check(order *Order, notional int64) error {
+	if a == nil {
+		return nil
+	}
+	if order.AccountID == "" {
+		if a.required.Load() {
+			return utils.ErrUnknownAccount
+		}
+		return nil
+	}
+
+	acct, ok := a.lock(order.AccountID)
+	if !ok {
+		return utils.ErrUnknownAccount
+	}
+	defer a.unlock(acct)
+	return acct.checkLimits(order.Quantity, notional)
+}
+
+// readmit re-runs the checks for an amended order, adjusting its reservation
+// to the new price and quantity.
+func (a *Accounts) readmit(order *Order, price, quantity int64, base, quote string, tape *riskTape) error {
+	if a == nil || order.AccountID == "" {
+		return nil
+	}
+
+	acct, ok := a.lock(order.AccountID)
+	if !ok {
+		return utils.ErrUnknownAccount
+	}
+	defer a.unlock(acct)
+	value, ok := notional(price, quantity)
+	if !ok {
+		return utils.ErrNotionalOverflow
+	}
+	if err := acct.checkLimits(quantity, value); err != nil {
+		return err
+	}
+	amended := *order
+	amended.Price = price
+	need := reserveAmount(&amended, quantity-order.Filled, 0) - order.Reserved
+	if need <= 0 {
+		acct.release(order, -need, base, quote)
+		return nil
+	}
+	if err := tape.outcome(acct.reservable(order, need, base, quote)); err != nil {
+		return err
+	}
+	acct.reserve(order, need, base, quote)
+	return nil
+}
+
+func (acct *Account) checkLimits(quantity, notional int64) error {
+	if acct.Limits.MaxOrderQuantity > 0 && quantity > acct.Limits.MaxOrderQuantity {
+		return utils.ErrMaxOrderSize
+	}
+	if acct.Limits.MaxOrderNotional > 0 && notional > acct.Limits.MaxOrderNotional {
+		return utils.ErrMaxNotional
+	}
+	return nil
+}
+
+// reservable checks that the account has amount free to hold for order.
+func (acct *Account) reservable(order *Order, amount int64, base, quote string) error {
+	if order.Side == SideSell {
+		if acct.balance(base).Available() < amount {
+			return utils.ErrInsufficientPosition
+		}
+	} else if acct.balance(quote).Available() < amount {
+		return utils.ErrInsufficientFunds
+	}
+	return nil
+}
+
+func (acct *Account) reserve(order *Order, amount int64, base, quote string) {
+	asset := quote
+	if order.Side == SideSell {
+		asset = base
+	}
+	acct.balance(asset).Reserved += amount
+	order.Reserved += amount
+}
+
+func (acct *Account) release(order *Order, amount int64, base, quote string) {
+	asset := quote
+	if order.Side == SideSell {
+		asset = base
+	}
+	acct.balance(asset).Reserved -= amount
+	order.Reserved -= amount
+}
+
+// releaseAll frees whatever order still holds once it can no longer trade.
+func (a *Accounts) releaseAll(order *Order, base, quote string) {
+	a.release(order, order.Reserved, base, quote)
+}
+
+func (a *Accounts) release(order *Order, amount int64, base, quote string) {
+	if a == nil || amount == 0 {
+		return
+	}
+
+	if acct, ok := a.lock(order.AccountID); ok {
+		acct.release(order, amount, base, quote)
+		a.unlock(acct)
+	}
+}
+
+// applyDefaults fills in order settings left to its account.
+func (a *Accounts) applyDefaults(order *Order) {
+	if a == nil || order.AccountID == "" || order.SelfTradePrevention != "" {
+		return
+	}
+
+	if acct, ok := a.lock(order.AccountID); ok {
+		order.SelfTradePrevention = acct.SelfTradePrevention
+		a.unlock(acct)
+	}
+}
+
+// settle moves cash and the base asset between the two sides of trade,
+// consuming their reservations.
+func (a *Accounts) settle(buy, sell *Order, trade *Trade, base, quote string) {
+	if a == nil || (buy.AccountID == "" && sell.AccountID == "") {
+		return
+	}
+
+	// Both accounts are locked, in order of ID so that two settlements
+	// can't each wait for the other
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	buyer, seller := a.accounts[buy.AccountID], a.accounts[sell.AccountID]
+	first, second := buyer, seller
+	if buy.AccountID > sell.AccountID {
+		first, second = second, first
+	}
+	if first != nil {
+		first.mu.Lock()
+		defer first.mu.Unlock()
+	}
+	if second != nil && second != first {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+	}
+
+	value := trade.Price * trade.Quantity
+	if acct := buyer; acct != nil {
+		// A limit buy reserved at its own price; any improvement is released
+		held := value
+		if !buy.isMarket() {
+			held = buy.Price * trade.Quantity
+		}
+		acct.release(buy, held, base, quote)
+		acct.balance(quote).Total -= value
+		acct.balance(base).Total += trade.Quantity
+	}
+	if acct := seller; acct != nil {
+		acct.release(sell, trade.Quantity, base, quote)
+		acct.balance(base).Total -= trade.Quantity
+		acct.balance(quote).Total += value
+	}
+}
+
+// trackOpen counts orders resting on the book against MaxOpenOrders.
+func (a *Accounts) trackOpen(order *Order, delta int) {
+	if a == nil || order.AccountID == "" {
+		return
+	}
+
+	if acct, ok := a.lock(order.AccountID); ok {
+		acct.OpenOrders += delta
+		a.unlock(acct)
+	}
+}
+
+// CreateAccount opens an empty account with the given limits.
+func (e *Engine) CreateAccount(id string, limits RiskLimits) error {
+	return e.journaled(&Command{Type: CommandCreateAccount, AccountID: id, Limits: &limits}, func() error {
+		return e.accounts.create(id, limits)
+	})
+}
+
+func (e *Engine) SetRiskLimits(id string, limits RiskLimits) error {
+	return e.journaled(&Command{Type: CommandSetLimits, AccountID: id, Limits: &limits}, func() error {
+		return e.accounts.setLimits(id, limits)
+	})
+}
+
+// SetSelfTradePrevention sets the mode used for the account's orders that
+// don't specify one.
+func (e *Engine) SetSelfTradePrevention(id string, mode SelfTradePrevention) error {
+	return e.journaled(&Command{Type: CommandSetSelfTradePrevention, AccountID: id, SelfTradePrevention: mode}, func() error {
+		return e.accounts.setSelfTradePrevention(id, mode)
+	})
+}
+
+// AdjustBalance deposits amount of asset into an account, or withdraws it if
+// amount is negative.
+func (e *Engine) AdjustBalance(id, asset string, amount int64) error {
+	return e.journaled(&Command{Type: CommandAdjustBalance, AccountID: id, Asset: asset, Amount: amount}, func() error {
+		return e.accounts.adjust(id, asset, amount)
+	})
+}
+
+// GetAccount returns a copy of the account.
+func (e *Engine) GetAccount(id string) (Account, error) {
+	acct, ok := e.accounts.get(id)
+	if !ok {
+		return Account{}, utils.ErrUnknownAccount
+	}
+	return acct, nil
+}
+
+// RequireAccounts makes the engine reject orders that don't name an account.
+func (e *Engine) RequireAccounts(required bool) {
+	e.accounts.required.Store(required)
+}
+
+// transfer moves the funds orders hold into the ledger, or out of it if
+// sign is -1, along with their reservations, for a book changing engines.
+// Resting orders count as open orders. Nothing changes if an account is
+// unknown.
+func (a *Accounts) transfer(orders []Order, resting map[string]bool, base, quote string, sign int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := range orders {
+		if id := orders[i].AccountID; id != "" {
+			if _, ok := a.accounts[id]; !ok {
+				return utils.ErrUnknownAccount
+			}
+		}
+	}
+	for i := range orders {
+		o := &orders[i]
+		acct, ok := a.accounts[o.AccountID]
+		if !ok {
+			continue
+		}
+		asset := quote
+		if o.Side == SideSell {
+			asset = base
+		}
+		b := acct.balance(asset)
+		b.Total += sign * o.Reserved
+		b.Reserved += sign * o.Reserved
+		if resting[o.ID] {
+			acct.OpenOrders += int(sign)
+		}
+	}
+	return nil
+}
+
+// free returns every account's free balance in the given assets, for a
+// handoff.
+func (a *Accounts) free(assets []string) []Funds {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var funds []Funds
+	for id, acct := range a.accounts {
+		for _, asset := range assets {
+			if b, ok := acct.Balances[asset]; ok && b.Available() > 0 {
+				funds = append(funds, Funds{AccountID: id, Asset: asset, Amount: b.Available()})
+			}
+		}
+	}
+	sort.Slice(funds, func(i, j int) bool {
+		if funds[i].AccountID != funds[j].AccountID {
+			return funds[i].AccountID < funds[j].AccountID
+		}
+		return funds[i].Asset < funds[j].Asset
+	})
+	return funds
+}
+
+// move deposits funds into the ledger, or withdraws them if sign is -1, for
+// a book changing engines. Nothing changes if an account is unknown or
+// doesn't have the funds to withdraw.
+func (a *Accounts) move(funds []Funds, sign int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, f := range funds {
+		acct, ok := a.accounts[f.AccountID]
+		if !ok {
+			return utils.ErrUnknownAccount
+		}
+		if b, ok := acct.Balances[f.Asset]; sign < 0 && (!ok || b.Available() < f.Amount) {
+			return utils.ErrInsufficientFunds
+		}
+	}
+	for _, f := range funds {
+		a.accounts[f.AccountID].balance(f.Asset).Total += sign * f.Amount
+	}
+	return nil
+}
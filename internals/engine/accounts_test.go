@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func fundedAccount(t *testing.T, eng *Engine, id string, cash, position int64, limits RiskLimits) {
+	t.Helper()
+	if err := eng.CreateAccount(id, limits); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.AdjustBalance(id, DefaultQuoteAsset, cash); err != nil {
+		t.Fatal(err)
+	}
+	if position > 0 {
+		if err := eng.AdjustBalance(id, "BTCUSD", position); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func balance(t *testing.T, eng *Engine, id, asset string) Balance {
+	t.Helper()
+	acct, err := eng.GetAccount(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := acct.Balances[asset]; ok {
+		return *b
+	}
+	return Balance{}
+}
+
+func accountOrder(account string, side Side, price, qty int64) *Order {
+	o := limitOrder(side, price, qty)
+	o.AccountID = account
+	return o
+}
+
+func TestAccounts_ReserveSettleRelease(t *testing.T) {
+	eng := NewEngine()
+	fundedAccount(t, eng, "buyer", 10000, 0, RiskLimits{})
+	fundedAccount(t, eng, "seller", 0, 10, RiskLimits{})
+
+	bid := accountOrder("buyer", SideBuy, 100, 5)
+	if _, err := eng.SubmitOrder(bid); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(t, eng, "buyer", DefaultQuoteAsset); b.Reserved != 500 || b.Available() != 9500 {
+		t.Fatalf("expected 500 reserved, got %+v", b)
+	}
+
+	if _, err := eng.SubmitOrder(accountOrder("seller", SideSell, 99, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(t, eng, "buyer", DefaultQuoteAsset); b.Total != 9700 || b.Reserved != 200 {
+		t.Errorf("buyer cash after fill: %+v", b)
+	}
+	if b := balance(t, eng, "buyer", "BTCUSD"); b.Total != 3 {
+		t.Errorf("buyer position after fill: %+v", b)
+	}
+	if b := balance(t, eng, "seller", DefaultQuoteAsset); b.Total != 300 {
+		t.Errorf("seller cash after fill: %+v", b)
+	}
+	if b := balance(t, eng, "seller", "BTCUSD"); b.Total != 7 || b.Reserved != 0 {
+		t.Errorf("seller position after fill: %+v", b)
+	}
+
+	eng.CancelOrder(bid.ID)
+	if b := balance(t, eng, "buyer", DefaultQuoteAsset); b.Reserved != 0 {
+		t.Errorf("expected cancel to release reservation, got %+v", b)
+	}
+	if acct, _ := eng.GetAccount("buyer"); acct.OpenOrders != 0 {
+		t.Errorf("expected no open orders, got %d", acct.OpenOrders)
+	}
+
+	// A buy that trades below its limit releases the difference
+	eng.SubmitOrder(accountOrder("seller", SideSell, 100, 2))
+	eng.SubmitOrder(accountOrder("buyer", SideBuy, 110, 2))
+	if b := balance(t, eng, "buyer", DefaultQuoteAsset); b.Total != 9500 || b.Reserved != 0 {
+		t.Errorf("expected price improvement released, got %+v", b)
+	}
+}
+
+func TestAccounts_PreTradeChecks(t *testing.T) {
+	eng := NewEngine()
+	fundedAccount(t, eng, "acct", 1000, 2, RiskLimits{MaxOrderQuantity: 8, MaxOrderNotional: 900, MaxOpenOrders: 1})
+
+	cases := []struct {
+		name  string
+		order *Order
+		err   error
+	}{
+		{"unknown account", accountOrder("nobody", SideBuy, 10, 1), utils.ErrUnknownAccount},
+		{"max order size", accountOrder("acct", SideBuy, 10, 9), utils.ErrMaxOrderSize},
+		{"max notional", accountOrder("acct", SideBuy, 200, 5), utils.ErrMaxNotional},
+		{"insufficient position", accountOrder("acct", SideSell, 10, 3), utils.ErrInsufficientPosition},
+	}
+	for _, c := range cases {
+		if _, err := eng.SubmitOrder(c.order); err != c.err {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+		if c.order.Status != OrderStatusRejected {
+			t.Errorf("%s: expected REJECTED, got %s", c.name, c.order.Status)
+		}
+	}
+
+	if _, err := eng.SubmitOrder(accountOrder("acct", SideBuy, 100, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.SubmitOrder(accountOrder("acct", SideBuy, 10, 1)); err != utils.ErrMaxOpenOrders {
+		t.Errorf("expected ErrMaxOpenOrders, got %v", err)
+	}
+
+	eng.SetRiskLimits("acct", RiskLimits{})
+	if _, err := eng.SubmitOrder(accountOrder("acct", SideBuy, 100, 3)); err != utils.ErrInsufficientFunds {
+		t.Errorf("expected ErrInsufficientFunds with 200 available, got %v", err)
+	}
+	if err := eng.AdjustBalance("acct", DefaultQuoteAsset, -300); err != utils.ErrInsufficientFunds {
+		t.Errorf("expected reserved cash to be withdraw-proof, got %v", err)
+	}
+
+	eng.RequireAccounts(true)
+	if _, err := eng.SubmitOrder(limitOrder(SideBuy, 10, 1)); err != utils.ErrUnknownAccount {
+		t.Errorf("expected orders without an account to be rejected, got %v", err)
+	}
+}
+
+func TestAccounts_AmendAdjustsReservation(t *testing.T) {
+	eng := NewEngine()
+	fundedAccount(t, eng, "acct", 1000, 0, RiskLimits{})
+
+	bid := accountOrder("acct", SideBuy, 100, 5)
+	eng.SubmitOrder(bid)
+	if _, err := eng.AmendOrder(bid.ID, 0, 11); err != utils.ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	if _, err := eng.AmendOrder(bid.ID, 50, 10); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(t, eng, "acct", DefaultQuoteAsset); b.Reserved != 500 || bid.Reserved != 500 {
+		t.Errorf("expected 500 reserved after amend, got %+v (order %d)", b, bid.Reserved)
+	}
+	eng.AmendOrder(bid.ID, 0, 4)
+	if b := balance(t, eng, "acct", DefaultQuoteAsset); b.Reserved != 200 {
+		t.Errorf("expected reduction to release funds, got %+v", b)
+	}
+}
+
+func TestAccounts_NotionalOverflow(t *testing.T) {
+	eng := NewEngine()
+	fundedAccount(t, eng, "buyer", 0, 0, RiskLimits{MaxOrderNotional: 1000})
+	fundedAccount(t, eng, "seller", 0, 10, RiskLimits{})
+
+	// 1<<62 * 4 wraps to 0, which would pass the notional limit and hold
+	// nothing
+	if _, err := eng.SubmitOrder(accountOrder("buyer", SideBuy, 1<<62, 4)); err != utils.ErrNotionalOverflow {
+		t.Fatalf("expected ErrNotionalOverflow, got %v", err)
+	}
+	stop := accountOrder("buyer", SideBuy, 0, 4)
+	stop.Type, stop.StopPrice = OrderTypeStopMarket, 1<<62
+	if _, err := eng.SubmitOrder(stop); err != utils.ErrNotionalOverflow {
+		t.Errorf("stop: expected ErrNotionalOverflow, got %v", err)
+	}
+
+	ask := accountOrder("seller", SideSell, 100, 4)
+	if _, err := eng.SubmitOrder(ask); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.AmendOrder(ask.ID, 1<<62, 0); err != utils.ErrNotionalOverflow {
+		t.Errorf("amend: expected ErrNotionalOverflow, got %v", err)
+	}
+
+	// Each resting order fits, but a market buy of all of them doesn't
+	for i := 0; i < 3; i++ {
+		if _, err := eng.SubmitOrder(limitOrder(SideSell, 1<<61, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	market := accountOrder("buyer", SideBuy, 0, 10)
+	market.Type = OrderTypeMarket
+	if _, err := eng.SubmitOrder(market); err != utils.ErrNotionalOverflow {
+		t.Errorf("market: expected ErrNotionalOverflow, got %v", err)
+	}
+	if b := balance(t, eng, "buyer", "BTCUSD"); b.Total != 0 {
+		t.Errorf("buyer got %d BTC for nothing", b.Total)
+	}
+	if b := balance(t, eng, "seller", DefaultQuoteAsset); b.Total != 0 {
+		t.Errorf("seller cash %+v", b)
+	}
+}
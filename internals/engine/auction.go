@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// A book in the AUCTION state collects orders without matching them, even
+// where they cross. When it opens or closes the book uncrosses: every order
+// that can trade executes at the single price that maximises the volume
+// traded.
+
+// AuctionIndicative is the result of uncrossing the book as it stands.
+type AuctionIndicative struct {
+	Price  int64 `json:"price"`
+	Volume int64 `json:"volume"`
+	// Imbalance is the quantity left over at Price: positive on the buy
+	// side, negative on the sell side.
+	Imbalance int64 `json:"imbalance"`
+}
+
+// collect accepts a validated order into the auction. Only orders that can
+// rest take part; stop orders wait for their trigger as usual.
+func (ob *OrderBook) collect(order *Order, now int64) error {
+	if order.isStop() {
+		return ob.addStop(order, now)
+	}
+	ob.Orders[order.ID] = order
+	if order.isMarket() || order.PostOnly != "" ||
+		order.TimeInForce == TimeInForceIOC || order.TimeInForce == TimeInForceFOK {
+		ob.reject(order, now, utils.ErrNotAuctionOrder)
+		return utils.ErrNotAuctionOrder
+	}
+	if err := ob.accounts.admit(order, order.Price*order.Quantity, true, ob.baseAsset, ob.quoteAsset, ob.risk); err != nil {
+		ob.reject(order, now, err)
+		return err
+	}
+
+	ob.setStatus(order, OrderStatusAccepted, ExecTypeNew, now, nil)
+	ob.addOrder(order)
+	return nil
+}
+
+// equilibrium finds the uncrossing price: the one that trades the most,
+// then leaves the smallest imbalance. Remaining ties go to the highest
+// price when every tied price leaves buyers over, the lowest when every
+// one leaves sellers over, and otherwise to the price closest to the last
+// trade (the lowest without one). It reports false if the book doesn't
+// cross.
+func (ob *OrderBook) equilibrium() (AuctionIndicative, bool) {
+	type level struct{ price, qty int64 }
+	levels := func(side *BookSide) []level {
+		var out []level
+		side.Each(func(o *Order) bool {
+			if n := len(out); n > 0 && out[n-1].price == o.Price {
+				out[n-1].qty += o.Quantity - o.Filled
+			} else {
+				out = append(out, level{o.Price, o.Quantity - o.Filled})
+			}
+			return true
+		})
+		return out
+	}
+	bids, asks := levels(ob.Bids), levels(ob.Asks)
+	if len(bids) == 0 || len(asks) == 0 || bids[0].price < asks[0].price {
+		return AuctionIndicative{}, false
+	}
+
+	// Only prices in the crossed range can trade
+	var prices []int64
+	for _, l := range asks {
+		if l.price <= bids[0].price {
+			prices = append(prices, l.price)
+		}
+	}
+	for i := len(bids) - 1; i >= 0; i-- {
+		if bids[i].price >= asks[0].price {
+			prices = append(prices, bids[i].price)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+
+	var tied []AuctionIndicative
+	for i, p := range prices {
+		if i > 0 && p == prices[i-1] {
+			continue
+		}
+		var buy, sell int64
+		for _, l := range bids {
+			if l.price < p {
+				break
+			}
+			buy += l.qty
+		}
+		for _, l := range asks {
+			if l.price > p {
+				break
+			}
+			sell += l.qty
+		}
+		c := AuctionIndicative{Price: p, Volume: min(buy, sell), Imbalance: buy - sell}
+		if len(tied) == 0 {
+			tied = append(tied, c)
+			continue
+		}
+		best := tied[0]
+		switch {
+		case c.Volume > best.Volume || (c.Volume == best.Volume && abs(c.Imbalance) < abs(best.Imbalance)):
+			tied = append(tied[:0], c)
+		case c.Volume == best.Volume && abs(c.Imbalance) == abs(best.Imbalance):
+			tied = append(tied, c)
+		}
+	}
+
+	buyers, sellers := true, true
+	for _, c := range tied {
+		buyers = buyers && c.Imbalance > 0
+		sellers = sellers && c.Imbalance < 0
+	}
+	switch {
+	case buyers:
+		return tied[len(tied)-1], true
+	case sellers || ob.LastTradePrice == 0:
+		return tied[0], true
+	}
+	pick := tied[0]
+	for _, c := range tied[1:] {
+		if abs(c.Price-ob.LastTradePrice) < abs(pick.Price-ob.LastTradePrice) {
+			pick = c
+		}
+	}
+	return pick, true
+}
+
+// uncross executes every order that crosses at the equilibrium price, in
+// price then time priority. The older order of each pair is the maker.
+// Self-trade prevention doesn't apply: neither order is the aggressor.
+func (ob *OrderBook) uncross(now int64, newTradeID func() string) []Trade {
+	ob.expireAll(now)
+	eq, ok := ob.equilibrium()
+	if !ok {
+		return nil
+	}
+
+	var trades []Trade
+	for volume := eq.Volume; volume > 0; {
+		bid, ask := ob.Bids.Best(), ob.Asks.Best()
+		qty := min(bid.available(), ask.available(), volume)
+		maker, taker := bid, ask
+		if ask.Sequence < bid.Sequence {
+			maker, taker = ask, bid
+		}
+		trade := Trade{
+			ID:           newTradeID(),
+			Price:        eq.Price,
+			Quantity:     qty,
+			Timestamp:    now,
+			MakerOrderID: maker.ID,
+			TakerOrderID: taker.ID,
+		}
+		trades = append(trades, trade)
+		volume -= qty
+
+		ob.fillResting(bid, qty)
+		ob.fillResting(ask, qty)
+		ob.accounts.settle(bid, ask, &trade, ob.baseAsset, ob.quoteAsset)
+		ob.reportFill(maker, &trade)
+		ob.reportFill(taker, &trade)
+		if ob.listening() {
+			ob.emit(EventTradeExecuted, now, &TradeExecuted{Trade: trade})
+		}
+	}
+	ob.LastTradePrice = eq.Price
+	return trades
+}
+
+// Indicative returns the price and volume the book would uncross at now, or
+// false if it isn't in an auction or doesn't cross.
+func (ob *OrderBook) Indicative() (eq AuctionIndicative, ok bool) {
+	ob.do(func() { eq, ok = ob.indicative() })
+	return eq, ok
+}
+
+func (ob *OrderBook) indicative() (AuctionIndicative, bool) {
+	if ob.TradingState != TradingAuction {
+		return AuctionIndicative{}, false
+	}
+	return ob.equilibrium()
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
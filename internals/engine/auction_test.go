@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestAuctionUncross(t *testing.T) {
+	eng := NewEngine()
+	if _, err := eng.SetTradingState("BTCUSD", TradingAuction); err != nil {
+		t.Fatal(err)
+	}
+
+	orders := []*Order{
+		limitOrder(SideBuy, 102, 10),
+		limitOrder(SideBuy, 101, 5),
+		limitOrder(SideBuy, 99, 5),
+		limitOrder(SideSell, 98, 8),
+		limitOrder(SideSell, 100, 6),
+		limitOrder(SideSell, 103, 10),
+	}
+	for _, o := range orders {
+		if trades, err := eng.SubmitOrder(o); err != nil || len(trades) != 0 {
+			t.Fatalf("expected order to join the auction without trading, got %v %v", trades, err)
+		}
+	}
+	if _, err := eng.SubmitOrder(marketOrder(SideBuy, 1)); err != utils.ErrNotAuctionOrder {
+		t.Errorf("expected market order to be refused, got %v", err)
+	}
+
+	// 100 and 101 both trade 14 leaving 1 over on the buy side, so the
+	// higher price wins
+	ob := eng.GetOrderBook("BTCUSD")
+	snap := ob.GetSnapshot(10)
+	want := AuctionIndicative{Price: 101, Volume: 14, Imbalance: 1}
+	if snap.Auction == nil || *snap.Auction != want {
+		t.Fatalf("expected indicative %+v, got %+v", want, snap.Auction)
+	}
+
+	trades, err := eng.SetTradingState("BTCUSD", TradingOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var volume int64
+	for _, tr := range trades {
+		if tr.Price != 101 {
+			t.Errorf("expected every trade at 101, got %+v", tr)
+		}
+		volume += tr.Quantity
+	}
+	if volume != 14 || ob.LastTradePrice != 101 {
+		t.Fatalf("expected 14 to trade at 101, got %d at %d", volume, ob.LastTradePrice)
+	}
+	if orders[1].Filled != 4 || orders[1].Status != OrderStatusPartialFill || orders[2].Filled != 0 {
+		t.Errorf("expected the 101 bid to keep 1 and the 99 bid to miss, got %d %d", orders[1].Filled, orders[2].Filled)
+	}
+	if bid, _ := ob.Bids.BestPrice(); bid != 101 {
+		t.Errorf("expected the book to be uncrossed, best bid %d", bid)
+	}
+	if ask, _ := ob.Asks.BestPrice(); ask != 103 {
+		t.Errorf("expected the book to be uncrossed, best ask %d", ask)
+	}
+}
+
+func TestAuctionReferencePriceTieBreak(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 101, 1))
+	eng.SubmitOrder(limitOrder(SideBuy, 101, 1))
+
+	eng.SetTradingState("BTCUSD", TradingAuction)
+	eng.SubmitOrder(limitOrder(SideBuy, 102, 5))
+	eng.SubmitOrder(limitOrder(SideSell, 98, 5))
+
+	// 98 and 102 both trade 5 with no imbalance; 102 is nearer the last trade
+	trades, _ := eng.SetTradingState("BTCUSD", TradingClosed)
+	if len(trades) != 1 || trades[0].Price != 102 || trades[0].Quantity != 5 {
+		t.Fatalf("expected the closing auction to trade 5 at 102, got %+v", trades)
+	}
+}
+
+func TestAuctionAfterCircuitBreaker(t *testing.T) {
+	eng := NewEngine()
+	eng.TradingConfig.CircuitBreaker = CircuitBreaker{MaxMoveBps: 1000, HaltMillis: 1000, AuctionMillis: 500}
+
+	eng.SubmitOrder(limitOrder(SideSell, 100, 1))
+	eng.SubmitOrder(limitOrder(SideBuy, 100, 1))
+	eng.SubmitOrder(limitOrder(SideSell, 120, 2))
+	eng.SubmitOrder(limitOrder(SideBuy, 120, 1))
+
+	ob := eng.GetOrderBook("BTCUSD")
+	if ob.TradingState != TradingHalted {
+		t.Fatalf("expected a halt, got %s", ob.TradingState)
+	}
+	eng.ReopenHalts(ob.HaltedUntil)
+	if ob.TradingState != TradingAuction || ob.AuctionUntil == 0 {
+		t.Fatalf("expected a timed reopening auction, got %s until %d", ob.TradingState, ob.AuctionUntil)
+	}
+
+	buy := limitOrder(SideBuy, 125, 1)
+	eng.SubmitOrder(buy)
+	trades, _ := eng.ReopenHalts(ob.AuctionUntil)
+	if len(trades) != 1 || trades[0].Price != 120 || buy.Status != OrderStatusFilled {
+		t.Fatalf("expected the auction to uncross at 120, got %+v", trades)
+	}
+	if ob.TradingState != TradingOpen {
+		t.Errorf("expected the book to open after the auction, got %s", ob.TradingState)
+	}
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// Clock tells an engine the time, in Unix milliseconds. It stamps orders'
+// status changes, trades and journaled commands.
+type Clock interface {
+	Now() int64
+}
+
+// IDGenerator names the trades an engine makes.
+type IDGenerator interface {
+	NewID() string
+}
+
+// SystemClock reads the wall clock. It is the default.
+type SystemClock struct{}
+
+func (SystemClock) Now() int64 {
+	return time.Now().UnixMilli()
+}
+
+// UUIDGenerator makes random UUIDs. It is the default.
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) NewID() string {
+	return utils.GenerateUUID()
+}
+
+// LogicalClock only moves when told to, so runs that read it in the same
+// order see the same times.
+type LogicalClock struct {
+	mu  sync.Mutex
+	now int64
+}
+
+func NewLogicalClock(start int64) *LogicalClock {
+	return &LogicalClock{now: start}
+}
+
+func (c *LogicalClock) Now() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now, which may be earlier.
+func (c *LogicalClock) Set(now int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *LogicalClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now += d.Milliseconds()
+}
+
+// SequenceIDs names IDs Prefix1, Prefix2 and so on.
+type SequenceIDs struct {
+	Prefix string
+	n      atomic.Uint64
+}
+
+func (g *SequenceIDs) NewID() string {
+	return g.Prefix + strconv.FormatUint(g.n.Add(1), 10)
+}
+
+// replayIDs hands out the trade IDs a journaled command recorded, noting
+// whether replay asked for more or fewer than there were.
+type replayIDs struct {
+	ids   []string
+	pos   int
+	short bool
+}
+
+func (r *replayIDs) NewID() string {
+	if r.pos >= len(r.ids) {
+		r.short = true
+		return utils.GenerateUUID()
+	}
+	id := r.ids[r.pos]
+	r.pos++
+	return id
+}
+
+// SetClock makes the engine and its books tell the time by c. It must be
+// called before the engine starts taking orders.
+func (e *Engine) SetClock(c Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.clock = c
+	for _, ob := range e.OrderBooks() {
+		ob.SetClock(c)
+	}
+}
+
+// SetIDGenerator makes the engine and its books name trades with g. It
+// must be called before the engine starts taking orders.
+func (e *Engine) SetIDGenerator(g IDGenerator) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.ids = g
+	for _, ob := range e.OrderBooks() {
+		ob.SetIDGenerator(g)
+	}
+}
+
+func (ob *OrderBook) SetClock(c Clock) {
+	ob.do(func() { ob.clock = c })
+}
+
+func (ob *OrderBook) SetIDGenerator(g IDGenerator) {
+	ob.do(func() { ob.ids = g })
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type reportLog struct {
+	reports []ExecutionReport
+}
+
+func (l *reportLog) OnEvent(ev Event) {
+	if r, ok := ExecutionReportOf(ev); ok {
+		l.reports = append(l.reports, r)
+	}
+}
+
+// deterministicRun plays a fixed order sequence into an engine with a
+// logical clock and sequence IDs.
+func deterministicRun(t *testing.T) ([]Trade, []ExecutionReport, []byte) {
+	t.Helper()
+	eng := NewEngine()
+	defer eng.Close()
+	clock := NewLogicalClock(1_700_000_000_000)
+	eng.SetClock(clock)
+	eng.SetIDGenerator(&SequenceIDs{Prefix: "T"})
+	log := &reportLog{}
+	eng.Subscribe(log)
+
+	order := func(id string, side Side, price, qty int64) *Order {
+		return &Order{ID: id, Symbol: "BTCUSD", Side: side, Type: OrderTypeLimit, Price: price, Quantity: qty}
+	}
+	var trades []Trade
+	for _, o := range []*Order{
+		order("a", SideSell, 101, 5),
+		order("b", SideSell, 100, 5),
+		order("c", SideBuy, 101, 7),
+		order("d", SideBuy, 99, 4),
+	} {
+		clock.Advance(time.Second)
+		ts, err := eng.SubmitOrder(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trades = append(trades, ts...)
+	}
+	clock.Advance(time.Second)
+	if err := eng.CancelOrder("d"); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := json.Marshal(eng.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trades, log.reports, state
+}
+
+func TestDeterministicClockAndIDs(t *testing.T) {
+	trades, reports, state := deterministicRun(t)
+	if len(trades) != 2 || trades[0].ID != "T1" || trades[1].ID != "T2" {
+		t.Fatalf("unexpected trades %+v", trades)
+	}
+	if trades[0].Timestamp != 1_700_000_003_000 {
+		t.Errorf("trade stamped %d, want the logical clock's time", trades[0].Timestamp)
+	}
+	if last := reports[len(reports)-1]; last.OrderID != "d" || last.Timestamp != 1_700_000_005_000 {
+		t.Errorf("cancel reported as %+v", last)
+	}
+
+	again, reportsAgain, stateAgain := deterministicRun(t)
+	if !reflect.DeepEqual(trades, again) || !reflect.DeepEqual(reports, reportsAgain) || string(state) != string(stateAgain) {
+		t.Error("the same orders produced different output")
+	}
+}
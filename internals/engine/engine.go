@@ -2,34 +2,85 @@ package engine
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
 )
 
-
 type Engine struct {
-	OrderBooks       map[string]*OrderBook
-	OrderSymbolIndex map[string]string 
-	mu               sync.RWMutex
+	// books maps symbols to order books, and orders order IDs to symbols.
+	// Neither needs e.mu to read.
+	books  atomic.Pointer[map[string]*OrderBook]
+	orders *orderIndex
+	// MarketConfig and TradingConfig are applied to order books as they are
+	// created.
+	MarketConfig  MarketOrderConfig
+	TradingConfig TradingConfig
+	// mu guards adding books and instruments
+	mu       sync.RWMutex
+	accounts *Accounts
+	// instruments lists the symbols that may trade; while it is empty any
+	// symbol may
+	instruments map[string]Instrument
+	// retired holds books handed to another engine, until Close
+	retired []*OrderBook
+	clock   Clock
+	ids     IDGenerator
+	events  *EventBus
+
+	// journalMu is held shared by a journaled command on one book, which
+	// is journaled from the book's goroutine, and exclusively by any other
+	// journaled command. So a book's commands are journaled in the order
+	// they were applied, books apply theirs in parallel, and a command
+	// that reaches several books or the ledger alone sees every command
+	// before it in the journal applied, and none after.
+	journalMu sync.RWMutex
+	journal   CommandLog
+	// seqMu orders appends, and guards seq and journalErr
+	seqMu sync.Mutex
+	seq   uint64
+	// journalErr is set once an append fails; memory may then be ahead of
+	// the journal, so no further command is applied.
+	journalErr error
 }
 
 func NewEngine() *Engine {
-	return &Engine{
-		OrderBooks:       make(map[string]*OrderBook),
-		OrderSymbolIndex: make(map[string]string),
+	e := &Engine{
+		orders:        newOrderIndex(),
+		MarketConfig:  DefaultMarketOrderConfig(),
+		TradingConfig: DefaultTradingConfig(),
+		accounts:      newAccounts(),
+		instruments:   make(map[string]Instrument),
+		clock:         SystemClock{},
+		ids:           UUIDGenerator{},
+		events:        newEventBus(),
 	}
+	e.books.Store(&map[string]*OrderBook{})
+	return e
 }
 
+// GetOrderBook returns symbol's order book, or nil if it has none and isn't
+// listed. Looking a symbol up never creates its book.
 func (e *Engine) GetOrderBook(symbol string) *OrderBook {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	ob, _ := e.lookup(symbol)
+	return ob
+}
 
-	ob, exists := e.OrderBooks[symbol]
-	if !exists {
-		ob = NewOrderBook(symbol)
-		e.OrderBooks[symbol] = ob
+// SetMarketOrderConfig changes how market orders are handled for symbol.
+func (e *Engine) SetMarketOrderConfig(symbol string, cfg MarketOrderConfig) error {
+	if !cfg.Policy.Valid() || cfg.ProtectionTicks < 0 || cfg.TickSize < 0 {
+		return utils.ErrInvalidMarketConfig
 	}
-	return ob
+	if cfg.TickSize == 0 {
+		cfg.TickSize = 1
+	}
+
+	ob, err := e.book(symbol)
+	if err != nil {
+		return err
+	}
+	ob.do(func() { ob.MarketConfig = cfg })
+	return nil
 }
 
 func (e *Engine) SubmitOrder(order *Order) ([]Trade, error) {
@@ -37,43 +88,161 @@ func (e *Engine) SubmitOrder(order *Order) ([]Trade, error) {
 		return nil, utils.ErrInvalidSymbol
 	}
 
-	e.mu.Lock()
-	e.OrderSymbolIndex[order.ID] = order.Symbol
-	e.mu.Unlock()
+	if e.journal == nil {
+		return e.submitAt(order, e.clock.Now(), e.ids.NewID, nil)
+	}
 
-	ob := e.GetOrderBook(order.Symbol)
-	return ob.ProcessOrder(order)
+	ob, err := e.book(order.Symbol)
+	if err != nil {
+		order.Status = OrderStatusRejected
+		return nil, err
+	}
+	var trades []Trade
+	cmd := &Command{Type: CommandSubmit, Order: copyOrder(order)}
+	// A rejected order is journaled too, as it may have emitted events.
+	// If only the journal fails, the order was applied all the same; its
+	// trades are returned so the caller can tell
+	err = e.journalBook(ob, cmd, true, func(now int64) (err error) {
+		e.orders.put(order.ID, order.Symbol)
+		trades, err = ob.submit(order, now, e.ids.NewID)
+		for _, t := range trades {
+			cmd.TradeIDs = append(cmd.TradeIDs, t.ID)
+		}
+		return err
+	})
+	return trades, err
+}
+
+func (e *Engine) submitAt(order *Order, now int64, newTradeID func() string, tape *riskTape) ([]Trade, error) {
+	ob, err := e.book(order.Symbol)
+	if err != nil {
+		order.Status = OrderStatusRejected
+		return nil, err
+	}
+
+	e.orders.put(order.ID, order.Symbol)
+	return ob.processOrderAt(order, now, newTradeID, tape)
 }
 
 func (e *Engine) CancelOrder(orderID string) error {
-	e.mu.RLock()
-	symbol, exists := e.OrderSymbolIndex[orderID]
-	e.mu.RUnlock()
+	if e.journal == nil {
+		return e.cancel(orderID, e.clock.Now())
+	}
+
+	symbol, exists := e.orders.get(orderID)
+	if !exists {
+		return utils.ErrOrderNotFound
+	}
+	ob := e.GetOrderBook(symbol)
+	return e.journalBook(ob, &Command{Type: CommandCancel, OrderID: orderID}, false, func(now int64) error {
+		return ob.cancel(orderID, now)
+	})
+}
 
+func (e *Engine) cancel(orderID string, now int64) error {
+	symbol, exists := e.orders.get(orderID)
 	if !exists {
 		return utils.ErrOrderNotFound
 	}
 
 	ob := e.GetOrderBook(symbol)
-	return ob.CancelOrder(orderID)
+	return ob.cancelOrderAt(orderID, now)
 }
 
-func (e *Engine) GetOrder(orderID string) (*Order, error) {
-	e.mu.RLock()
-	symbol, exists := e.OrderSymbolIndex[orderID]
-	e.mu.RUnlock()
+// AmendOrder changes an open order's price and/or total quantity; zero leaves
+// a field unchanged. See OrderBook.AmendOrder for how priority is affected.
+func (e *Engine) AmendOrder(orderID string, price, quantity int64) ([]Trade, error) {
+	if e.journal == nil {
+		return e.amendAt(orderID, price, quantity, e.clock.Now(), e.ids.NewID, nil)
+	}
+
+	symbol, exists := e.orders.get(orderID)
+	if !exists {
+		return nil, utils.ErrOrderNotFound
+	}
+	ob := e.GetOrderBook(symbol)
+	var trades []Trade
+	cmd := &Command{Type: CommandAmend, OrderID: orderID, Price: price, Quantity: quantity}
+	err := e.journalBook(ob, cmd, false, func(now int64) (err error) {
+		trades, err = ob.amend(orderID, price, quantity, now, e.ids.NewID)
+		for _, t := range trades {
+			cmd.TradeIDs = append(cmd.TradeIDs, t.ID)
+		}
+		return err
+	})
+	return trades, err
+}
 
+func (e *Engine) amendAt(orderID string, price, quantity int64, now int64, newTradeID func() string, tape *riskTape) ([]Trade, error) {
+	symbol, exists := e.orders.get(orderID)
 	if !exists {
 		return nil, utils.ErrOrderNotFound
 	}
 
 	ob := e.GetOrderBook(symbol)
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
+	return ob.amendOrderAt(orderID, price, quantity, now, newTradeID, tape)
+}
+
+// HasOrder reports whether the engine has the order, without asking its
+// book.
+func (e *Engine) HasOrder(orderID string) bool {
+	_, exists := e.orders.get(orderID)
+	return exists
+}
 
-	order, ok := ob.Orders[orderID]
-	if !ok {
+// GetOrder returns a copy of the order as it stands.
+func (e *Engine) GetOrder(orderID string) (*Order, error) {
+	symbol, exists := e.orders.get(orderID)
+	if !exists {
+		return nil, utils.ErrOrderNotFound
+	}
+
+	var order *Order
+	ob := e.GetOrderBook(symbol)
+	ob.do(func() {
+		if o, ok := ob.Orders[orderID]; ok {
+			order = copyOrder(o)
+		}
+	})
+	if order == nil {
 		return nil, utils.ErrOrderNotFound
 	}
 	return order, nil
 }
+
+// ExpireOrders sweeps every order book for DAY/GTD orders that have reached
+// their expiry and returns the orders that were expired.
+func (e *Engine) ExpireOrders(now int64) ([]*Order, error) {
+	if e.journal == nil {
+		return e.expireAt(now), nil
+	}
+
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	if err := e.journalHealth(); err != nil {
+		return nil, err
+	}
+
+	release := deferEvents(e.OrderBooks()...)
+	expired := e.expireAt(now)
+	cmd := &Command{Type: CommandExpire, Timestamp: now}
+	var err error
+	if len(expired) > 0 {
+		err = e.record(cmd)
+	}
+	release(cmd.Seq, err == nil)
+	return expired, err
+}
+
+func (e *Engine) expireAt(now int64) []*Order {
+	var expired []*Order
+	for _, ob := range e.OrderBooks() {
+		expired = append(expired, ob.ExpireOrders(now)...)
+	}
+	return expired
+}
+
+func copyOrder(order *Order) *Order {
+	c := *order
+	return &c
+}
@@ -1,9 +1,14 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"reflect"
+	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -57,6 +62,171 @@ func BenchmarkEngine_ProcessOrders(b *testing.B) {
 	}
 }
 
+// populateBook rests n orders per side spread over levels price levels.
+func populateBook(eng *Engine, symbol string, n, levels int) []string {
+	ids := make([]string, 0, 2*n)
+	for i := 0; i < n; i++ {
+		bid := &Order{
+			ID:       utils.GenerateUUID(),
+			Symbol:   symbol,
+			Side:     SideBuy,
+			Type:     OrderTypeLimit,
+			Price:    int64(50000 - i%levels),
+			Quantity: int64(1 + rand.Intn(10)),
+		}
+		ask := &Order{
+			ID:       utils.GenerateUUID(),
+			Symbol:   symbol,
+			Side:     SideSell,
+			Type:     OrderTypeLimit,
+			Price:    int64(51000 + i%levels),
+			Quantity: int64(1 + rand.Intn(10)),
+		}
+		eng.SubmitOrder(bid)
+		eng.SubmitOrder(ask)
+		ids = append(ids, bid.ID, ask.ID)
+	}
+	return ids
+}
+
+func BenchmarkOrderBook_GetSnapshot(b *testing.B) {
+	eng := NewEngine()
+	populateBook(eng, "BTCUSD", 10000, 500)
+	ob := eng.GetOrderBook("BTCUSD")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ob.GetSnapshot(10)
+	}
+}
+
+func BenchmarkOrderBook_CancelAndReplace(b *testing.B) {
+	eng := NewEngine()
+	ids := populateBook(eng, "BTCUSD", 10000, 500)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % len(ids)
+		order, _ := eng.GetOrder(ids[j])
+		eng.CancelOrder(ids[j])
+
+		replacement := &Order{
+			ID:       utils.GenerateUUID(),
+			Symbol:   "BTCUSD",
+			Side:     order.Side,
+			Type:     OrderTypeLimit,
+			Price:    order.Price,
+			Quantity: order.Quantity,
+		}
+		eng.SubmitOrder(replacement)
+		ids[j] = replacement.ID
+	}
+}
+
+func BenchmarkEngine_ProcessOrdersDeepBook(b *testing.B) {
+	eng := NewEngine()
+	populateBook(eng, "BTCUSD", 50000, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		side := SideBuy
+		price := int64(51000 + rand.Intn(5))
+		if i%2 == 0 {
+			side = SideSell
+			price = int64(50000 - rand.Intn(5))
+		}
+		eng.SubmitOrder(&Order{
+			ID:       utils.GenerateUUID(),
+			Symbol:   "BTCUSD",
+			Side:     side,
+			Type:     OrderTypeLimit,
+			Price:    price,
+			Quantity: 1,
+		})
+		// Keep the book from draining
+		other := SideSell
+		if side == SideSell {
+			other = SideBuy
+		}
+		eng.SubmitOrder(&Order{
+			ID:       utils.GenerateUUID(),
+			Symbol:   "BTCUSD",
+			Side:     other,
+			Type:     OrderTypeLimit,
+			Price:    price,
+			Quantity: 1,
+		})
+	}
+}
+
+// discardLog journals nowhere, to measure the engine's side of journaling.
+type discardLog struct{}
+
+func (discardLog) Append(*Command) error { return nil }
+
+// BenchmarkEngine_ParallelSymbols submits crossing orders from every
+// parallel goroutine, each to a symbol of its own, so books only share the
+// engine's routing, with accounts the ledger, and with a journal the
+// ordering of appends. Compare -cpu 1,2,4,8 to see throughput scale with
+// cores.
+func BenchmarkEngine_ParallelSymbols(b *testing.B) {
+	b.Run("unjournaled", func(b *testing.B) { benchmarkParallelSymbols(b, nil, false) })
+	b.Run("accounts", func(b *testing.B) { benchmarkParallelSymbols(b, nil, true) })
+	b.Run("journaled", func(b *testing.B) { benchmarkParallelSymbols(b, discardLog{}, false) })
+	b.Run("journaled-accounts", func(b *testing.B) { benchmarkParallelSymbols(b, discardLog{}, true) })
+}
+
+func benchmarkParallelSymbols(b *testing.B, log CommandLog, accounts bool) {
+	const symbols = 64
+	eng := NewEngine()
+	defer eng.Close()
+	for i := 0; i < symbols; i++ {
+		symbol := fmt.Sprintf("SYM%d", i)
+		populateBook(eng, symbol, 1000, 100)
+		if accounts {
+			// An account per symbol, funded for the whole run
+			id := "acct-" + symbol
+			eng.CreateAccount(id, RiskLimits{})
+			eng.AdjustBalance(id, DefaultQuoteAsset, 1<<50)
+			eng.AdjustBalance(id, symbol, 1<<40)
+		}
+	}
+	if log != nil {
+		eng.SetJournal(log)
+	}
+
+	var workers atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		worker := workers.Add(1) - 1
+		symbol := fmt.Sprintf("SYM%d", worker%symbols)
+		var account string
+		if accounts {
+			account = "acct-" + symbol
+		}
+		rng := rand.New(rand.NewSource(worker))
+		for i := 0; pb.Next(); i++ {
+			side, other, price := SideBuy, SideSell, int64(51000+rng.Intn(5))
+			if i%2 == 0 {
+				side, other, price = SideSell, SideBuy, int64(50000-rng.Intn(5))
+			}
+			// The first order trades and the second rests in its place
+			for j, s := range []Side{side, other} {
+				eng.SubmitOrder(&Order{
+					ID:        fmt.Sprintf("w%d-%d-%d", worker, i, j),
+					AccountID: account,
+					Symbol:    symbol,
+					Side:      s,
+					Type:      OrderTypeLimit,
+					Price:     price,
+					Quantity:  1,
+				})
+			}
+		}
+	})
+	b.ReportMetric(float64(2*b.N)/b.Elapsed().Seconds(), "orders/s")
+}
+
 func TestLatency(t *testing.T) {
 	eng := NewEngine()
 	symbol := "ETHUSD"
@@ -104,3 +274,636 @@ func TestLatency(t *testing.T) {
 	fmt.Printf("p99: %d us\n", p99)
 	fmt.Printf("p99.9: %d us\n", p999)
 }
+
+func limitOrder(side Side, price, qty int64) *Order {
+	return &Order{
+		ID:        utils.GenerateUUID(),
+		Symbol:    "BTCUSD",
+		Side:      side,
+		Type:      OrderTypeLimit,
+		Price:     price,
+		Quantity:  qty,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+func TestTimeInForce_IOCCancelsRemainder(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 100, 5))
+
+	ioc := limitOrder(SideBuy, 100, 8)
+	ioc.TimeInForce = TimeInForceIOC
+	trades, err := eng.SubmitOrder(ioc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 1 || ioc.Filled != 5 {
+		t.Fatalf("expected 5 filled in one trade, got %d in %d trades", ioc.Filled, len(trades))
+	}
+	if ioc.Status != OrderStatusPartialFillCancelled {
+		t.Errorf("expected IOC remainder cancelled after partial fill, got %s", ioc.Status)
+	}
+	if got, _ := eng.GetOrder(ioc.ID); got == nil || got.Status != OrderStatusPartialFillCancelled {
+		t.Errorf("expected IOC order to be queryable after cancellation")
+	}
+	if eng.GetOrderBook("BTCUSD").TotalBidLiquidity != 0 {
+		t.Errorf("IOC remainder must not rest on the book")
+	}
+}
+
+func TestTimeInForce_FOKRejectsWithoutTrading(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 100, 5))
+	eng.SubmitOrder(limitOrder(SideSell, 105, 5))
+
+	fok := limitOrder(SideBuy, 100, 8)
+	fok.TimeInForce = TimeInForceFOK
+	if _, err := eng.SubmitOrder(fok); err != utils.ErrInsufficientLiquidity {
+		t.Fatalf("expected ErrInsufficientLiquidity, got %v", err)
+	}
+	if fok.Status != OrderStatusRejected || fok.Filled != 0 {
+		t.Errorf("expected rejected FOK with no fills, got %s filled %d", fok.Status, fok.Filled)
+	}
+	if eng.GetOrderBook("BTCUSD").TotalAskLiquidity != 10 {
+		t.Errorf("FOK rejection must not consume liquidity")
+	}
+
+	fok = limitOrder(SideBuy, 105, 8)
+	fok.TimeInForce = TimeInForceFOK
+	trades, err := eng.SubmitOrder(fok)
+	if err != nil || len(trades) != 2 || fok.Status != OrderStatusFilled {
+		t.Fatalf("expected FOK to fill across two levels, got %v %d %s", err, len(trades), fok.Status)
+	}
+}
+
+func TestTimeInForce_GTDExpires(t *testing.T) {
+	eng := NewEngine()
+	now := time.Now().UnixMilli()
+
+	gtd := limitOrder(SideBuy, 100, 5)
+	gtd.TimeInForce = TimeInForceGTD
+	gtd.ExpireAt = now + 1000
+	if _, err := eng.SubmitOrder(gtd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expired, _ := eng.ExpireOrders(now); len(expired) != 0 {
+		t.Fatalf("order expired early")
+	}
+	expired, _ := eng.ExpireOrders(now + 1000)
+	if len(expired) != 1 || gtd.Status != OrderStatusExpired {
+		t.Fatalf("expected order to expire, got %d expired, status %s", len(expired), gtd.Status)
+	}
+	if err := eng.CancelOrder(gtd.ID); err != utils.ErrOrderNotOpen {
+		t.Errorf("expected ErrOrderNotOpen cancelling an expired order, got %v", err)
+	}
+
+	past := limitOrder(SideBuy, 100, 5)
+	past.TimeInForce = TimeInForceGTD
+	past.ExpireAt = now - 1
+	if _, err := eng.SubmitOrder(past); err != utils.ErrInvalidExpireTime {
+		t.Errorf("expected ErrInvalidExpireTime, got %v", err)
+	}
+}
+
+func TestTimeInForce_DayOrderGetsEndOfDayExpiry(t *testing.T) {
+	eng := NewEngine()
+	day := limitOrder(SideSell, 100, 1)
+	day.TimeInForce = TimeInForceDAY
+	eng.SubmitOrder(day)
+
+	end := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour).UnixMilli()
+	if day.ExpireAt != end-1 {
+		t.Errorf("expected DAY order to expire at %d, got %d", end-1, day.ExpireAt)
+	}
+}
+
+func marketOrder(side Side, qty int64) *Order {
+	o := limitOrder(side, 0, qty)
+	o.Type = OrderTypeMarket
+	return o
+}
+
+func TestMarketOrder_FillAndKillSweepsAvailableLiquidity(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 100, 3))
+	eng.SubmitOrder(limitOrder(SideSell, 101, 3))
+
+	mkt := marketOrder(SideBuy, 10)
+	trades, err := eng.SubmitOrder(mkt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 2 || mkt.Filled != 6 {
+		t.Fatalf("expected to sweep 6 across 2 trades, got %d in %d trades", mkt.Filled, len(trades))
+	}
+	if mkt.Status != OrderStatusPartialFillCancelled {
+		t.Errorf("expected %s, got %s", OrderStatusPartialFillCancelled, mkt.Status)
+	}
+
+	empty := marketOrder(SideBuy, 1)
+	if _, err := eng.SubmitOrder(empty); err != nil || empty.Status != OrderStatusCancelled {
+		t.Errorf("expected market order against empty book to be cancelled, got %v %s", err, empty.Status)
+	}
+}
+
+func TestMarketOrder_AllOrNoneRejects(t *testing.T) {
+	eng := NewEngine()
+	if err := eng.SetMarketOrderConfig("BTCUSD", MarketOrderConfig{Policy: MarketOrderAllOrNone}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eng.SubmitOrder(limitOrder(SideBuy, 100, 3))
+
+	mkt := marketOrder(SideSell, 5)
+	if _, err := eng.SubmitOrder(mkt); err != utils.ErrInsufficientLiquidity {
+		t.Fatalf("expected ErrInsufficientLiquidity, got %v", err)
+	}
+	if mkt.Status != OrderStatusRejected || eng.GetOrderBook("BTCUSD").TotalBidLiquidity != 3 {
+		t.Errorf("expected rejection without trading")
+	}
+}
+
+func TestMarketOrder_ProtectionBand(t *testing.T) {
+	eng := NewEngine()
+	eng.SetMarketOrderConfig("BTCUSD", MarketOrderConfig{
+		Policy:          MarketOrderFillAndKill,
+		ProtectionTicks: 2,
+		TickSize:        5,
+	})
+	eng.SubmitOrder(limitOrder(SideSell, 100, 1))
+	eng.SubmitOrder(limitOrder(SideSell, 110, 1))
+	eng.SubmitOrder(limitOrder(SideSell, 111, 1))
+
+	mkt := marketOrder(SideBuy, 3)
+	trades, _ := eng.SubmitOrder(mkt)
+	if len(trades) != 2 || trades[1].Price != 110 {
+		t.Fatalf("expected band to stop at 110, got %+v", trades)
+	}
+	if mkt.Status != OrderStatusPartialFillCancelled {
+		t.Errorf("expected remainder outside the band cancelled, got %s", mkt.Status)
+	}
+}
+
+type recordingReports struct {
+	reports []ExecutionReport
+}
+
+func (l *recordingReports) OnEvent(ev Event) {
+	if r, ok := ExecutionReportOf(ev); ok {
+		l.reports = append(l.reports, r)
+	}
+}
+
+func TestExecutionReports(t *testing.T) {
+	eng := NewEngine()
+	reports := &recordingReports{}
+	eng.Subscribe(reports)
+
+	maker := limitOrder(SideSell, 100, 5)
+	maker.ClientID = "maker"
+	eng.SubmitOrder(maker)
+
+	taker := limitOrder(SideBuy, 100, 3)
+	taker.ClientID = "taker"
+	eng.SubmitOrder(taker)
+	eng.CancelOrder(maker.ID)
+
+	want := []struct {
+		client string
+		exec   ExecType
+		status OrderStatus
+	}{
+		{"maker", ExecTypeNew, OrderStatusAccepted},
+		{"taker", ExecTypeNew, OrderStatusAccepted},
+		{"maker", ExecTypeTrade, OrderStatusPartialFill},
+		{"taker", ExecTypeTrade, OrderStatusFilled},
+		{"maker", ExecTypeCanceled, OrderStatusCancelled},
+	}
+	if len(reports.reports) != len(want) {
+		t.Fatalf("expected %d reports, got %d: %+v", len(want), len(reports.reports), reports.reports)
+	}
+	for i, w := range want {
+		r := reports.reports[i]
+		if r.ClientID != w.client || r.ExecType != w.exec || r.Status != w.status {
+			t.Errorf("report %d: got %s %s %s, want %s %s %s", i, r.ClientID, r.ExecType, r.Status, w.client, w.exec, w.status)
+		}
+	}
+	if fill := reports.reports[2]; fill.Trade == nil || fill.Trade.Quantity != 3 || fill.RemainingQuantity != 2 {
+		t.Errorf("expected maker fill report with trade detail, got %+v", fill)
+	}
+}
+
+func TestPriceTimePriority(t *testing.T) {
+	eng := NewEngine()
+	first := limitOrder(SideSell, 100, 2)
+	second := limitOrder(SideSell, 100, 2)
+	better := limitOrder(SideSell, 99, 1)
+	eng.SubmitOrder(first)
+	eng.SubmitOrder(second)
+	eng.SubmitOrder(better)
+	if !(first.Sequence < second.Sequence && second.Sequence < better.Sequence) {
+		t.Fatalf("expected increasing sequence numbers, got %d %d %d", first.Sequence, second.Sequence, better.Sequence)
+	}
+
+	// Cancelling from the middle of a level must keep the queue intact
+	middle := limitOrder(SideSell, 100, 4)
+	last := limitOrder(SideSell, 100, 1)
+	eng.SubmitOrder(middle)
+	eng.SubmitOrder(last)
+	eng.CancelOrder(middle.ID)
+
+	snap := eng.GetOrderBook("BTCUSD").GetSnapshot(10)
+	if len(snap.Asks) != 2 || snap.Asks[0].Price != 99 || snap.Asks[1].Quantity != 5 {
+		t.Fatalf("unexpected ask levels: %+v", snap.Asks)
+	}
+
+	trades, _ := eng.SubmitOrder(limitOrder(SideBuy, 100, 6))
+	want := []string{better.ID, first.ID, second.ID, last.ID}
+	if len(trades) != len(want) {
+		t.Fatalf("expected %d trades, got %d", len(want), len(trades))
+	}
+	for i, id := range want {
+		if trades[i].MakerOrderID != id {
+			t.Errorf("trade %d: maker %s, want %s", i, trades[i].MakerOrderID, id)
+		}
+	}
+	if ob := eng.GetOrderBook("BTCUSD"); ob.Asks.Len() != 0 || ob.TotalAskLiquidity != 0 {
+		t.Errorf("expected empty ask side, got %d orders, %d liquidity", ob.Asks.Len(), ob.TotalAskLiquidity)
+	}
+}
+
+func TestAmendOrder(t *testing.T) {
+	eng := NewEngine()
+	reports := &recordingReports{}
+	eng.Subscribe(reports)
+
+	first := limitOrder(SideSell, 100, 5)
+	second := limitOrder(SideSell, 100, 5)
+	eng.SubmitOrder(first)
+	eng.SubmitOrder(second)
+
+	// Reducing quantity keeps priority
+	seq := first.Sequence
+	if _, err := eng.AmendOrder(first.ID, 0, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Sequence != seq || first.Quantity != 3 {
+		t.Fatalf("expected reduction in place, got seq %d qty %d", first.Sequence, first.Quantity)
+	}
+	if last := reports.reports[len(reports.reports)-1]; last.ExecType != ExecTypeReplaced || last.Quantity != 3 {
+		t.Errorf("expected REPLACED report, got %+v", last)
+	}
+
+	// Increasing quantity loses it
+	if _, err := eng.AmendOrder(first.ID, 0, 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Sequence <= second.Sequence {
+		t.Errorf("expected quantity increase to requeue behind %d, got %d", second.Sequence, first.Sequence)
+	}
+	if ob := eng.GetOrderBook("BTCUSD"); ob.TotalAskLiquidity != 9 || ob.Asks.Quantity(100) != 9 {
+		t.Errorf("expected 9 resting at 100, got total %d level %d", ob.TotalAskLiquidity, ob.Asks.Quantity(100))
+	}
+
+	// A crossing price change trades immediately
+	bid := limitOrder(SideBuy, 98, 2)
+	eng.SubmitOrder(bid)
+	trades, err := eng.AmendOrder(second.ID, 98, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(trades) != 1 || trades[0].MakerOrderID != bid.ID || trades[0].Price != 98 {
+		t.Fatalf("expected amended order to take the bid at 98, got %+v", trades)
+	}
+	if second.Filled != 2 || second.Status != OrderStatusPartialFill {
+		t.Errorf("expected partial fill of amended order, got %s filled %d", second.Status, second.Filled)
+	}
+	if best := eng.GetOrderBook("BTCUSD").Asks.Best(); best != second {
+		t.Errorf("expected the amended remainder to be the best ask")
+	}
+
+	if _, err := eng.AmendOrder(second.ID, 0, 2); err != utils.ErrInvalidQuantity {
+		t.Errorf("expected ErrInvalidQuantity for quantity at filled, got %v", err)
+	}
+	if _, err := eng.AmendOrder(first.ID, 100, 4); err != utils.ErrAmendUnchanged {
+		t.Errorf("expected ErrAmendUnchanged, got %v", err)
+	}
+	if _, err := eng.AmendOrder(bid.ID, 0, 5); err != utils.ErrOrderNotOpen {
+		t.Errorf("expected ErrOrderNotOpen for filled order, got %v", err)
+	}
+	eng.CancelOrder(first.ID)
+	if _, err := eng.AmendOrder(first.ID, 0, 1); err != utils.ErrOrderNotOpen {
+		t.Errorf("expected ErrOrderNotOpen for cancelled order, got %v", err)
+	}
+	if _, err := eng.AmendOrder("missing", 0, 1); err != utils.ErrOrderNotFound {
+		t.Errorf("expected ErrOrderNotFound, got %v", err)
+	}
+}
+
+// brokenLog accepts appends until fail is set.
+type brokenLog struct {
+	fail    error
+	appends int
+}
+
+func (l *brokenLog) Append(cmd *Command) error {
+	if l.fail != nil {
+		return l.fail
+	}
+	l.appends++
+	return nil
+}
+
+func TestJournalFailureStopsCommands(t *testing.T) {
+	eng := NewEngine()
+	log := &brokenLog{}
+	eng.SetJournal(log)
+
+	ask := limitOrder(SideSell, 100, 5)
+	if _, err := eng.SubmitOrder(ask); err != nil {
+		t.Fatal(err)
+	}
+
+	// The crossing order has traded by the time the append fails; the
+	// caller is told, and gets the trades
+	log.fail = fmt.Errorf("disk full")
+	bid := limitOrder(SideBuy, 100, 2)
+	trades, err := eng.SubmitOrder(bid)
+	if !errors.Is(err, utils.ErrJournalFailed) || len(trades) != 1 {
+		t.Fatalf("expected ErrJournalFailed with the trade, got %v, %d trades", err, len(trades))
+	}
+
+	// Nothing more is applied, even once the journal would take it
+	log.fail = nil
+	if err := eng.CancelOrder(ask.ID); !errors.Is(err, utils.ErrJournalFailed) {
+		t.Errorf("expected cancel to be refused, got %v", err)
+	}
+	if ask.Status != OrderStatusPartialFill {
+		t.Errorf("expected the refused cancel to leave the order alone, got %s", ask.Status)
+	}
+	if _, err := eng.ExpireOrders(time.Now().UnixMilli()); !errors.Is(err, utils.ErrJournalFailed) {
+		t.Errorf("expected expiry to be refused, got %v", err)
+	}
+	if _, err := eng.ReopenHalts(time.Now().UnixMilli()); !errors.Is(err, utils.ErrJournalFailed) {
+		t.Errorf("expected reopening to be refused, got %v", err)
+	}
+	if log.appends != 1 {
+		t.Errorf("expected only the first order journaled, got %d appends", log.appends)
+	}
+}
+
+// watchedLog notes how many reports subscribers had at each append.
+type watchedLog struct {
+	brokenLog
+	reports *reportLog
+	seen    []int
+}
+
+func (l *watchedLog) Append(cmd *Command) error {
+	l.seen = append(l.seen, len(l.reports.reports))
+	return l.brokenLog.Append(cmd)
+}
+
+func TestEventsWaitForJournal(t *testing.T) {
+	eng := NewEngine()
+	defer eng.Close()
+	reports := &reportLog{}
+	eng.Subscribe(reports)
+	log := &watchedLog{reports: reports}
+	eng.SetJournal(log)
+
+	ask := limitOrder(SideSell, 100, 5)
+	if _, err := eng.SubmitOrder(ask); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.seen) != 1 || log.seen[0] != 0 || len(reports.reports) != 1 {
+		t.Fatalf("expected the acceptance reported after the append, seen %v with %d reports", log.seen, len(reports.reports))
+	}
+
+	// The crossing order trades, but its journal write fails, so neither
+	// its acceptance nor the fills are reported
+	log.fail = fmt.Errorf("disk full")
+	if _, err := eng.SubmitOrder(limitOrder(SideBuy, 100, 2)); !errors.Is(err, utils.ErrJournalFailed) {
+		t.Fatalf("expected ErrJournalFailed, got %v", err)
+	}
+	if len(reports.reports) != 1 {
+		t.Errorf("expected no reports for the unjournaled order, got %+v", reports.reports[1:])
+	}
+}
+
+// gatedLog makes commands durable in batches, when fsync is sent the
+// outcome.
+type gatedLog struct {
+	mu      sync.Mutex
+	written []uint64
+	fsync   chan error
+}
+
+func (l *gatedLog) Append(cmd *Command) error {
+	return nil
+}
+
+func (l *gatedLog) Write(cmd *Command) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.written = append(l.written, cmd.Seq)
+	return nil
+}
+
+func (l *gatedLog) Wait(seq uint64) error {
+	return <-l.fsync
+}
+
+func (l *gatedLog) writes() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.written)
+}
+
+func TestBookRunsOnWhileJournalSyncs(t *testing.T) {
+	eng := NewEngine()
+	defer eng.Close()
+	var mu sync.Mutex
+	var events []Event
+	eng.Subscribe(EventSubscriberFunc(func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}))
+	seen := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events)
+	}
+	log := &gatedLog{fsync: make(chan error)}
+	eng.SetJournal(log)
+
+	// Both orders are applied and written while the first waits for its
+	// fsync, and none of their events is published before it
+	done := make(chan error)
+	for _, price := range []int64{100, 101} {
+		go func(price int64) {
+			_, err := eng.SubmitOrder(limitOrder(SideSell, price, 5))
+			done <- err
+		}(price)
+	}
+	for deadline := time.Now().Add(5 * time.Second); log.writes() < 2; runtime.Gosched() {
+		if time.Now().After(deadline) {
+			t.Fatal("the second order waited for the first one's fsync")
+		}
+	}
+	if n := seen(); n != 0 {
+		t.Fatalf("%d events published before the fsync", n)
+	}
+	log.fsync <- nil
+	log.fsync <- nil
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	for i, ev := range events {
+		if ev.Header().Seq != uint64(i+1) {
+			t.Errorf("event %d has seq %d", i, ev.Header().Seq)
+		}
+	}
+	published := len(events)
+	mu.Unlock()
+	if published == 0 {
+		t.Fatal("no events published after the fsync")
+	}
+
+	// A failed fsync publishes nothing
+	go func() {
+		_, err := eng.SubmitOrder(limitOrder(SideBuy, 100, 2))
+		done <- err
+	}()
+	log.fsync <- fmt.Errorf("disk full")
+	if err := <-done; !errors.Is(err, utils.ErrJournalFailed) {
+		t.Fatalf("expected ErrJournalFailed, got %v", err)
+	}
+	if n := seen(); n != published {
+		t.Errorf("%d events published for the unjournaled order", n-published)
+	}
+}
+
+// memoryLog keeps what is appended to it.
+type memoryLog struct {
+	mu   sync.Mutex
+	cmds []Command
+}
+
+func (l *memoryLog) Append(cmd *Command) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cmds = append(l.cmds, *cmd)
+	return nil
+}
+
+func TestReplayTakesRecordedRiskOutcomes(t *testing.T) {
+	eng := NewEngine()
+	defer eng.Close()
+	log := &memoryLog{}
+	eng.SetJournal(log)
+	fundedAccount(t, eng, "acct", 1000, 0, RiskLimits{})
+
+	btc := accountOrder("acct", SideBuy, 100, 6)
+	if _, err := eng.SubmitOrder(btc); err != nil {
+		t.Fatal(err)
+	}
+	eth := accountOrder("acct", SideBuy, 100, 6)
+	eth.Symbol = "ETHUSD"
+	if _, err := eng.SubmitOrder(eth); err != utils.ErrInsufficientFunds {
+		t.Fatalf("second order: got %v", err)
+	}
+
+	// Books apply in parallel, so the journal may have the two the other
+	// way round from how their checks ran
+	cmds := log.cmds
+	cmds[2], cmds[3] = cmds[3], cmds[2]
+	replayed := NewEngine()
+	defer replayed.Close()
+	for i := range cmds {
+		cmds[i].Seq = uint64(i + 1)
+		if err := replayed.Apply(&cmds[i]); err != nil {
+			t.Fatalf("%s: %v", cmds[i].Type, err)
+		}
+	}
+	if o, err := replayed.GetOrder(btc.ID); err != nil || o.Status != OrderStatusAccepted {
+		t.Errorf("BTCUSD order replayed as %+v, %v", o, err)
+	}
+	if o, err := replayed.GetOrder(eth.ID); err != nil || o.Status != OrderStatusRejected {
+		t.Errorf("ETHUSD order replayed as %+v, %v", o, err)
+	}
+	if b := balance(t, replayed, "acct", DefaultQuoteAsset); b != (Balance{Total: 1000, Reserved: 600}) {
+		t.Errorf("replayed balance %+v", b)
+	}
+}
+
+func TestJournaledBooksReplayAfterParallelCommands(t *testing.T) {
+	eng := NewEngine()
+	defer eng.Close()
+	log := &memoryLog{}
+	eng.SetJournal(log)
+	fundedAccount(t, eng, "acct", 50000, 0, RiskLimits{MaxOpenOrders: 40})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		symbol := fmt.Sprintf("SYM%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				o := accountOrder("acct", SideBuy, int64(90+j), 5)
+				o.Symbol = symbol
+				eng.SubmitOrder(o)
+				if j%3 == 0 {
+					eng.CancelOrder(o.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	replayed := NewEngine()
+	defer replayed.Close()
+	for i := range log.cmds {
+		if err := replayed.Apply(&log.cmds[i]); err != nil {
+			t.Fatalf("command %d: %v", i+1, err)
+		}
+	}
+	want, _ := eng.GetAccount("acct")
+	got, _ := replayed.GetAccount("acct")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed account %+v, want %+v", got, want)
+	}
+	for _, ob := range eng.OrderBooks() {
+		if got := replayed.GetOrderBook(ob.Symbol).State(); !reflect.DeepEqual(got, ob.State()) {
+			t.Errorf("%s replayed differently", ob.Symbol)
+		}
+	}
+}
+
+func TestRestoreFailureStopsRestoredBooks(t *testing.T) {
+	eng := NewEngine()
+	for _, symbol := range []string{"AAA", "BBB", "CCC"} {
+		o := limitOrder(SideBuy, 100, 1)
+		o.Symbol = symbol
+		eng.SubmitOrder(o)
+	}
+	state := eng.State()
+	state.Books[len(state.Books)-1].TotalBidLiquidity++
+
+	before := runtime.NumGoroutine()
+	if err := NewEngine().Restore(state); err != utils.ErrJournalCorrupt {
+		t.Fatalf("expected a corrupt book to fail the restore, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected partially restored books to stop, %d goroutines left of %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
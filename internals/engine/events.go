@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// The event bus carries every change the engine makes to its books, as
+// typed events, to any number of subscribers. It is the engine's only
+// output: market data, execution reports, trade history, candles, audit and
+// FIX are all built on it.
+
+type EventType string
+
+const (
+	EventOrderAccepted    EventType = "ORDER_ACCEPTED"
+	EventOrderRejected    EventType = "ORDER_REJECTED"
+	EventOrderCancelled   EventType = "ORDER_CANCELLED"
+	EventOrderExpired     EventType = "ORDER_EXPIRED"
+	EventOrderAmended     EventType = "ORDER_AMENDED"
+	EventOrderTriggered   EventType = "ORDER_TRIGGERED"
+	EventOrderFilled      EventType = "ORDER_FILLED"
+	EventTradeExecuted    EventType = "TRADE_EXECUTED"
+	EventBookLevelChanged EventType = "BOOK_LEVEL_CHANGED"
+	EventBookUpdated      EventType = "BOOK_UPDATED"
+)
+
+// EventHeader is common to every event.
+type EventHeader struct {
+	Type   EventType `json:"type"`
+	Symbol string    `json:"symbol"`
+	// Seq numbers the events of one book, from 1 and without gaps, whether
+	// or not anyone is subscribed; it survives snapshots and carries on when
+	// the book moves to another engine
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+func (h *EventHeader) stamp(header EventHeader) {
+	*h = header
+}
+
+// Event is one of the pointer types below; subscribers switch on the type.
+type Event interface {
+	Header() EventHeader
+	stamp(header EventHeader)
+}
+
+// OrderAccepted is a new order taking effect: resting, parked as a stop,
+// queued until the book opens or collected into an auction. An order that
+// trades on arrival is accepted first.
+type OrderAccepted struct {
+	EventHeader
+	Order Order `json:"order"`
+}
+
+type OrderRejected struct {
+	EventHeader
+	Order  Order  `json:"order"`
+	Reason string `json:"reason"`
+}
+
+// OrderCancelled is an order, or the rest of one, being cancelled: on
+// request, for its time in force, by self-trade prevention or by a halt.
+type OrderCancelled struct {
+	EventHeader
+	Order  Order  `json:"order"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type OrderExpired struct {
+	EventHeader
+	Order Order `json:"order"`
+}
+
+// OrderAmended is a change to an open order's price or quantity, by request
+// or by self-trade prevention decrementing it.
+type OrderAmended struct {
+	EventHeader
+	Order  Order  `json:"order"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type OrderTriggered struct {
+	EventHeader
+	Order Order `json:"order"`
+}
+
+// OrderFilled is one side of a trade, with the order as it stands after it.
+type OrderFilled struct {
+	EventHeader
+	Order Order `json:"order"`
+	Trade Trade `json:"trade"`
+}
+
+// TradeExecuted follows the fills it made.
+type TradeExecuted struct {
+	EventHeader
+	Trade Trade `json:"trade"`
+}
+
+// BookLevelChanged is a price level's new visible quantity, 0 once it is
+// gone, after each command that changed it.
+type BookLevelChanged struct {
+	EventHeader
+	Level LevelUpdate `json:"level"`
+}
+
+// BookUpdated closes each command that changed what the book shows, with
+// all of its changes in one market data update.
+type BookUpdated struct {
+	EventHeader
+	Update BookUpdate `json:"update"`
+}
+
+// EventSubscriber receives events synchronously on the goroutine of the book
+// they happened in, in Seq order for each book. It must not block or call
+// back into the book.
+type EventSubscriber interface {
+	OnEvent(ev Event)
+}
+
+// EventSubscriberFunc lets a function subscribe.
+type EventSubscriberFunc func(ev Event)
+
+func (f EventSubscriberFunc) OnEvent(ev Event) {
+	f(ev)
+}
+
+// EventBus delivers an engine's events to its subscribers. Publishing reads
+// the subscriber list without locking.
+type EventBus struct {
+	mu     sync.Mutex
+	subs   atomic.Pointer[[]subscriber]
+	nextID int
+}
+
+type subscriber struct {
+	id  int
+	sub EventSubscriber
+}
+
+func newEventBus() *EventBus {
+	b := &EventBus{}
+	b.subs.Store(&[]subscriber{})
+	return b
+}
+
+// Subscribe adds s to the bus until the returned function is called.
+func (b *EventBus) Subscribe(s EventSubscriber) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+	subs := append(append([]subscriber(nil), *b.subs.Load()...), subscriber{id, s})
+	b.subs.Store(&subs)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		var subs []subscriber
+		for _, sub := range *b.subs.Load() {
+			if sub.id != id {
+				subs = append(subs, sub)
+			}
+		}
+		b.subs.Store(&subs)
+	}
+}
+
+// active reports whether anyone is listening; a nil bus, for a book outside
+// an engine, never is.
+func (b *EventBus) active() bool {
+	return b != nil && len(*b.subs.Load()) > 0
+}
+
+func (b *EventBus) publish(ev Event) {
+	for _, sub := range *b.subs.Load() {
+		sub.sub.OnEvent(ev)
+	}
+}
+
+// Events returns the engine's event bus.
+func (e *Engine) Events() *EventBus {
+	return e.events
+}
+
+// Subscribe adds sub to the engine's event bus.
+func (e *Engine) Subscribe(sub EventSubscriber) (unsubscribe func()) {
+	return e.events.Subscribe(sub)
+}
+
+// listening reports whether the book's next event is worth building. One
+// that isn't still takes its Seq, so every event is numbered.
+func (ob *OrderBook) listening() bool {
+	if ob.events.active() {
+		return true
+	}
+	ob.eventSeq++
+	return false
+}
+
+// emit stamps ev as the book's next event and publishes it. Callers check
+// listening first.
+func (ob *OrderBook) emit(typ EventType, now int64, ev Event) {
+	ob.eventSeq++
+	ev.stamp(EventHeader{Type: typ, Symbol: ob.Symbol, Seq: ob.eventSeq, Timestamp: now})
+	if ob.holding {
+		ob.held = append(ob.held, ev)
+		return
+	}
+	ob.events.publish(ev)
+}
+
+// heldEvents are the events of the journaled command seq.
+type heldEvents struct {
+	seq    uint64
+	events []Event
+}
+
+// holdEvents keeps the events of the command the book is about to apply
+// from subscribers until it is journaled.
+func (ob *OrderBook) holdEvents() {
+	ob.holding = true
+}
+
+// sealEvents ends the command holding events, which was journaled as seq,
+// and queues its events behind the book's earlier commands. A command that
+// wasn't journaled, seq 0, has its events published with the last one that
+// was, or at once if nothing is waiting.
+func (ob *OrderBook) sealEvents(seq uint64) {
+	held := ob.held
+	ob.holding, ob.held = false, nil
+	switch {
+	case len(held) == 0:
+	case seq != 0:
+		ob.pending = append(ob.pending, heldEvents{seq, held})
+	case len(ob.pending) > 0:
+		last := &ob.pending[len(ob.pending)-1]
+		last.events = append(last.events, held...)
+	default:
+		for _, ev := range held {
+			ob.events.publish(ev)
+		}
+	}
+}
+
+// releaseEvents publishes the events of the commands up to seq now that
+// they are durable, or if publish is false drops every event waiting: a
+// command couldn't be journaled, so the engine takes no more and reports
+// nothing it may not recover.
+func (ob *OrderBook) releaseEvents(seq uint64, publish bool) {
+	n := 0
+	for ; n < len(ob.pending) && (!publish || ob.pending[n].seq <= seq); n++ {
+		if publish {
+			for _, ev := range ob.pending[n].events {
+				ob.events.publish(ev)
+			}
+		}
+		ob.pending[n] = heldEvents{}
+	}
+	ob.pending = ob.pending[n:]
+}
+
+// emitOrder publishes the event for an order's execution report.
+func (ob *OrderBook) emitOrder(order *Order, exec ExecType, now int64, trade *Trade, reason string) {
+	if !ob.listening() {
+		return
+	}
+	o := order.detached()
+	switch exec {
+	case ExecTypeNew:
+		ob.emit(EventOrderAccepted, now, &OrderAccepted{Order: o})
+	case ExecTypeRejected:
+		ob.emit(EventOrderRejected, now, &OrderRejected{Order: o, Reason: reason})
+	case ExecTypeCanceled:
+		ob.emit(EventOrderCancelled, now, &OrderCancelled{Order: o, Reason: reason})
+	case ExecTypeExpired:
+		ob.emit(EventOrderExpired, now, &OrderExpired{Order: o})
+	case ExecTypeReplaced:
+		ob.emit(EventOrderAmended, now, &OrderAmended{Order: o, Reason: reason})
+	case ExecTypeTriggered:
+		ob.emit(EventOrderTriggered, now, &OrderTriggered{Order: o})
+	case ExecTypeTrade:
+		ob.emit(EventOrderFilled, now, &OrderFilled{Order: o, Trade: *trade})
+	}
+}
+
+// detached copies the order without its links into the book.
+func (o *Order) detached() Order {
+	c := *o
+	c.level, c.prev, c.next = nil, nil, nil
+	return c
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	eng := NewEngine()
+	defer eng.Close()
+
+	var events []Event
+	unsubscribe := eng.Events().Subscribe(EventSubscriberFunc(func(ev Event) {
+		events = append(events, ev)
+	}))
+
+	order := func(id string, side Side, price, qty int64) *Order {
+		return &Order{ID: id, Symbol: "BTCUSD", Side: side, Type: OrderTypeLimit, Price: price, Quantity: qty}
+	}
+	for _, o := range []*Order{order("a", SideSell, 100, 5), order("b", SideBuy, 100, 3)} {
+		if _, err := eng.SubmitOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := eng.CancelOrder("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []EventType
+	for i, ev := range events {
+		h := ev.Header()
+		types = append(types, h.Type)
+		if h.Seq != uint64(i+1) || h.Symbol != "BTCUSD" {
+			t.Errorf("event %d has header %+v", i, h)
+		}
+	}
+	want := []EventType{
+		EventOrderAccepted, EventBookLevelChanged, EventBookUpdated,
+		EventOrderAccepted, EventOrderFilled, EventOrderFilled, EventTradeExecuted, EventBookLevelChanged, EventBookUpdated,
+		EventOrderCancelled, EventBookLevelChanged, EventBookUpdated,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("events %v, want %v", types, want)
+	}
+	if fill := events[4].(*OrderFilled); fill.Order.ID != "a" || fill.Order.Filled != 3 || fill.Trade.Quantity != 3 {
+		t.Errorf("maker fill %+v", fill)
+	}
+	if tr := events[6].(*TradeExecuted).Trade; tr.Quantity != 3 || tr.MakerOrderID != "a" || tr.TakerOrderID != "b" {
+		t.Errorf("trade %+v", tr)
+	}
+	if l := events[7].(*BookLevelChanged).Level; l.Side != SideSell || l.Price != 100 || l.Quantity != 2 {
+		t.Errorf("level after the trade %+v", l)
+	}
+	if u := events[8].(*BookUpdated).Update; u.Seq != 2 || len(u.Trades) != 1 || len(u.Levels) != 1 || u.Ticker == nil {
+		t.Errorf("update after the trade %+v", u)
+	}
+	if l := events[10].(*BookLevelChanged).Level; l.Quantity != 0 {
+		t.Errorf("level after the cancel %+v", l)
+	}
+
+	unsubscribe()
+	if _, err := eng.SubmitOrder(order("c", SideBuy, 90, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(want) {
+		t.Error("events delivered after unsubscribing")
+	}
+}
+
+func TestEventSeqWithoutSubscribers(t *testing.T) {
+	eng := NewEngine()
+	defer eng.Close()
+	eng.SubmitOrder(&Order{ID: "a", Symbol: "BTCUSD", Side: SideSell, Type: OrderTypeLimit, Price: 100, Quantity: 5})
+
+	// The order's acceptance, its level and the book update were numbered
+	// with no one listening, and the numbering survives a snapshot
+	restored := NewEngine()
+	defer restored.Close()
+	if err := restored.Restore(eng.State()); err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	restored.Subscribe(EventSubscriberFunc(func(ev Event) {
+		seqs = append(seqs, ev.Header().Seq)
+	}))
+	restored.CancelOrder("a")
+	if !reflect.DeepEqual(seqs, []uint64{4, 5, 6}) {
+		t.Errorf("events after a restore numbered %v, want [4 5 6]", seqs)
+	}
+	if snap := restored.GetOrderBook("BTCUSD").GetSnapshot(1); snap.Seq != 2 {
+		t.Errorf("feed seq %d after a restore, want 2", snap.Seq)
+	}
+}
@@ -0,0 +1,95 @@
+package engine
+
+type ExecType string
+
+const (
+	ExecTypeNew       ExecType = "NEW"
+	ExecTypeTrade     ExecType = "TRADE"
+	ExecTypeCanceled  ExecType = "CANCELED"
+	ExecTypeExpired   ExecType = "EXPIRED"
+	ExecTypeRejected  ExecType = "REJECTED"
+	ExecTypeReplaced  ExecType = "REPLACED"
+	ExecTypeTriggered ExecType = "TRIGGERED"
+)
+
+// ExecutionReport describes one change to an order, as seen by its owner.
+type ExecutionReport struct {
+	ExecType          ExecType    `json:"exec_type"`
+	OrderID           string      `json:"order_id"`
+	ClientID          string      `json:"client_id,omitempty"`
+	Symbol            string      `json:"symbol"`
+	Side              Side        `json:"side"`
+	Type              OrderType   `json:"type"`
+	Price             int64       `json:"price"`
+	Quantity          int64       `json:"quantity"`
+	FilledQuantity    int64       `json:"filled_quantity"`
+	RemainingQuantity int64       `json:"remaining_quantity"`
+	Status            OrderStatus `json:"status"`
+	Trade             *Trade      `json:"trade,omitempty"`
+	Reason            string      `json:"reason,omitempty"`
+	Timestamp         int64       `json:"timestamp"`
+}
+
+// setStatus is how order books change an order's status: every transition
+// produces an event, and from it an execution report for the order's owner.
+func (ob *OrderBook) setStatus(order *Order, status OrderStatus, exec ExecType, now int64, trade *Trade) {
+	order.Status = status
+	if !order.isOpen() {
+		ob.accounts.releaseAll(order, ob.baseAsset, ob.quoteAsset)
+	}
+	var reason string
+	if exec == ExecTypeCanceled {
+		reason = order.CancelReason
+	}
+	ob.emitOrder(order, exec, now, trade, reason)
+}
+
+func (ob *OrderBook) reject(order *Order, now int64, reason error) {
+	order.Status = OrderStatusRejected
+	ob.emitOrder(order, ExecTypeRejected, now, nil, reason.Error())
+}
+
+// ExecutionReportOf returns the execution report an order event makes for
+// the order's owner. Other events make none.
+func ExecutionReportOf(ev Event) (ExecutionReport, bool) {
+	var (
+		order  *Order
+		exec   ExecType
+		trade  *Trade
+		reason string
+	)
+	switch ev := ev.(type) {
+	case *OrderAccepted:
+		order, exec = &ev.Order, ExecTypeNew
+	case *OrderRejected:
+		order, exec, reason = &ev.Order, ExecTypeRejected, ev.Reason
+	case *OrderCancelled:
+		order, exec, reason = &ev.Order, ExecTypeCanceled, ev.Reason
+	case *OrderExpired:
+		order, exec = &ev.Order, ExecTypeExpired
+	case *OrderAmended:
+		order, exec, reason = &ev.Order, ExecTypeReplaced, ev.Reason
+	case *OrderTriggered:
+		order, exec = &ev.Order, ExecTypeTriggered
+	case *OrderFilled:
+		order, exec, trade = &ev.Order, ExecTypeTrade, &ev.Trade
+	default:
+		return ExecutionReport{}, false
+	}
+	return ExecutionReport{
+		ExecType:          exec,
+		OrderID:           order.ID,
+		ClientID:          order.ClientID,
+		Symbol:            order.Symbol,
+		Side:              order.Side,
+		Type:              order.Type,
+		Price:             order.Price,
+		Quantity:          order.Quantity,
+		FilledQuantity:    order.Filled,
+		RemainingQuantity: order.Quantity - order.Filled,
+		Status:            order.Status,
+		Trade:             trade,
+		Reason:            reason,
+		Timestamp:         ev.Header().Timestamp,
+	}, true
+}
@@ -0,0 +1,179 @@
+package engine
+
+import "github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+
+// A book moves between engines, e.g. shards of one process, in three steps:
+// ExportBook on the engine that has it, AdoptBook on the one taking it over,
+// then ReleaseBook on the first, and ConfirmAdoption on the second once the
+// release is journaled. The funds resting orders hold move with them, as
+// do the free balances ExportBook is asked to take along. Nothing may reach
+// the book on either engine in between, so the export is exactly what both
+// engines journal.
+
+// BookHandoff is an order book on its way between engines.
+type BookHandoff struct {
+	// Book carries the book's market data and event sequence numbers, so
+	// subscribers see no gap when it moves.
+	Book BookState `json:"book"`
+	// Funds are the free balances that move with the book.
+	Funds []Funds `json:"funds,omitempty"`
+}
+
+// Funds are an amount of an account's free balance in one asset.
+type Funds struct {
+	AccountID string `json:"account_id"`
+	Asset     string `json:"asset"`
+	Amount    int64  `json:"amount"`
+}
+
+// resting returns the IDs of the orders on the book itself, which count
+// against their accounts' open orders.
+func (s *BookState) resting() map[string]bool {
+	ids := make(map[string]bool, len(s.Bids)+len(s.Asks))
+	for _, id := range s.Bids {
+		ids[id] = true
+	}
+	for _, id := range s.Asks {
+		ids[id] = true
+	}
+	return ids
+}
+
+// ExportBook captures symbol's book for AdoptBook on another engine, along
+// with every account's free balance in the given assets.
+func (e *Engine) ExportBook(symbol string, assets ...string) (*BookHandoff, error) {
+	ob, err := e.book(symbol)
+	if err != nil {
+		return nil, err
+	}
+	h := &BookHandoff{Book: ob.State(), Funds: e.accounts.free(assets)}
+	h.Book.Adopted = nil
+	return h, nil
+}
+
+// AdoptBook takes over a book exported from another engine. It fails if
+// this engine already has a book of the symbol with orders, or doesn't know
+// an account the book's orders belong to.
+func (e *Engine) AdoptBook(h *BookHandoff) error {
+	return e.journaled(&Command{Type: CommandAdoptBook, Symbol: h.Book.Symbol, Handoff: h}, func() error {
+		return e.adoptBook(h)
+	})
+}
+
+func (e *Engine) adoptBook(h *BookHandoff) error {
+	ob, err := restoreOrderBook(h.Book)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbol := h.Book.Symbol
+	current := *e.books.Load()
+	old, exists := current[symbol]
+	if exists {
+		var orders int
+		old.do(func() { orders = len(old.Orders) })
+		if orders > 0 {
+			ob.Close()
+			return utils.ErrBookExists
+		}
+	}
+	if inst, ok := e.instruments[symbol]; ok {
+		ob.setInstrument(inst)
+	}
+	if err := e.accounts.move(h.Funds, 1); err != nil {
+		ob.Close()
+		return err
+	}
+	if err := e.accounts.transfer(h.Book.Orders, h.Book.resting(), ob.baseAsset, ob.quoteAsset, 1); err != nil {
+		e.accounts.move(h.Funds, -1)
+		ob.Close()
+		return err
+	}
+	// Until the move is confirmed, recovery may have to give the funds back
+	ob.adopted = h.Funds
+	ob.accounts = e.accounts
+	ob.clock, ob.ids = e.clock, e.ids
+	ob.events = e.events
+
+	books := make(map[string]*OrderBook, len(current)+1)
+	for s, b := range current {
+		books[s] = b
+	}
+	books[symbol] = ob
+	e.books.Store(&books)
+	if exists {
+		e.retired = append(e.retired, old)
+	}
+	for _, o := range h.Book.Orders {
+		e.orders.put(o.ID, symbol)
+	}
+	return nil
+}
+
+// ReleaseBook drops symbol's book once another engine has adopted it,
+// along with the funds its orders hold and the free funds the handoff took.
+// Orders for the symbol are then unknown here, and a new order would start
+// an empty book.
+func (e *Engine) ReleaseBook(symbol string, funds []Funds) error {
+	return e.journaled(&Command{Type: CommandReleaseBook, Symbol: symbol, Funds: funds}, func() error {
+		return e.releaseBook(symbol, funds)
+	})
+}
+
+func (e *Engine) releaseBook(symbol string, funds []Funds) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := *e.books.Load()
+	ob, ok := current[symbol]
+	if !ok {
+		return utils.ErrUnknownSymbol
+	}
+	var state BookState
+	var base, quote string
+	ob.do(func() {
+		state = ob.state()
+		base, quote = ob.baseAsset, ob.quoteAsset
+	})
+	if err := e.accounts.move(funds, -1); err != nil {
+		return err
+	}
+	if err := e.accounts.transfer(state.Orders, state.resting(), base, quote, -1); err != nil {
+		e.accounts.move(funds, 1)
+		return err
+	}
+
+	books := make(map[string]*OrderBook, len(current))
+	for s, b := range current {
+		if s != symbol {
+			books[s] = b
+		}
+	}
+	e.books.Store(&books)
+	// Whoever still holds the book, e.g. a market data subscriber that
+	// looked it up mid-move, can keep reading it until the engine closes
+	e.retired = append(e.retired, ob)
+	e.orders.removeSymbol(symbol)
+	return nil
+}
+
+// ConfirmAdoption records that the engine symbol's book came from has
+// released it, so the funds the handoff brought are no longer needed to
+// undo the move.
+func (e *Engine) ConfirmAdoption(symbol string) error {
+	return e.journaled(&Command{Type: CommandConfirmAdoption, Symbol: symbol}, func() error {
+		return e.confirmAdoption(symbol)
+	})
+}
+
+func (e *Engine) confirmAdoption(symbol string) error {
+	ob, ok := (*e.books.Load())[symbol]
+	if !ok {
+		return utils.ErrUnknownSymbol
+	}
+	ob.do(func() { ob.adopted = nil })
+	return nil
+}
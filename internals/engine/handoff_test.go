@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestHandoff_MovesBookAndFunds(t *testing.T) {
+	src, dst := NewEngine(), NewEngine()
+	defer src.Close()
+	defer dst.Close()
+	fundedAccount(t, src, "buyer", 10000, 0, RiskLimits{})
+	fundedAccount(t, dst, "buyer", 0, 0, RiskLimits{})
+
+	bid := accountOrder("buyer", SideBuy, 100, 5)
+	if _, err := src.SubmitOrder(bid); err != nil {
+		t.Fatal(err)
+	}
+	before := src.GetOrderBook("BTCUSD").GetSnapshot(0).Seq
+
+	h, err := src.ExportBook("BTCUSD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.AdoptBook(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.ReleaseBook("BTCUSD", h.Funds); err != nil {
+		t.Fatal(err)
+	}
+
+	if b := balance(t, src, "buyer", DefaultQuoteAsset); b != (Balance{Total: 9500}) {
+		t.Errorf("source kept %+v, want the unreserved 9500", b)
+	}
+	if b := balance(t, dst, "buyer", DefaultQuoteAsset); b != (Balance{Total: 500, Reserved: 500}) {
+		t.Errorf("destination has %+v, want the 500 the bid holds", b)
+	}
+	if acct, _ := dst.GetAccount("buyer"); acct.OpenOrders != 1 {
+		t.Errorf("destination counts %d open orders, want 1", acct.OpenOrders)
+	}
+	if _, err := src.GetOrder(bid.ID); err != utils.ErrOrderNotFound {
+		t.Errorf("source still knows the order: %v", err)
+	}
+	if len(src.OrderBooks()) != 0 {
+		t.Errorf("source still has %d books", len(src.OrderBooks()))
+	}
+	if seq := dst.GetOrderBook("BTCUSD").GetSnapshot(0).Seq; seq != before {
+		t.Errorf("feed seq %d after the move, want %d", seq, before)
+	}
+
+	if err := dst.CancelOrder(bid.ID); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(t, dst, "buyer", DefaultQuoteAsset); b != (Balance{Total: 500}) {
+		t.Errorf("cancel left %+v", b)
+	}
+}
+
+func TestHandoff_RefusesBusyBookAndUnknownAccount(t *testing.T) {
+	src, dst := NewEngine(), NewEngine()
+	defer src.Close()
+	defer dst.Close()
+	fundedAccount(t, src, "buyer", 10000, 0, RiskLimits{})
+	if _, err := src.SubmitOrder(accountOrder("buyer", SideBuy, 100, 5)); err != nil {
+		t.Fatal(err)
+	}
+	h, err := src.ExportBook("BTCUSD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dst.AdoptBook(h); err != utils.ErrUnknownAccount {
+		t.Errorf("adopting orders of an unknown account: got %v", err)
+	}
+	if len(dst.OrderBooks()) != 0 {
+		t.Error("failed adoption left a book behind")
+	}
+
+	fundedAccount(t, dst, "buyer", 10000, 0, RiskLimits{})
+	if _, err := dst.SubmitOrder(accountOrder("buyer", SideBuy, 90, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.AdoptBook(h); err != utils.ErrBookExists {
+		t.Errorf("adopting over a book with orders: got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func icebergOrder(side Side, price, qty, display int64) *Order {
+	o := limitOrder(side, price, qty)
+	o.DisplayQuantity = display
+	return o
+}
+
+func TestIceberg_ShowsOnlyPeak(t *testing.T) {
+	eng := NewEngine()
+	ice := icebergOrder(SideSell, 100, 10, 3)
+	eng.SubmitOrder(ice)
+	eng.SubmitOrder(limitOrder(SideSell, 100, 2))
+
+	ob := eng.GetOrderBook("BTCUSD")
+	if snap := ob.GetSnapshot(5); len(snap.Asks) != 1 || snap.Asks[0].Quantity != 5 {
+		t.Fatalf("expected 3 shown plus 2, got %+v", snap.Asks)
+	}
+	if ice.HiddenQuantity() != 7 {
+		t.Errorf("expected 7 hidden, got %d", ice.HiddenQuantity())
+	}
+	if ob.TotalAskLiquidity != 12 {
+		t.Errorf("expected total liquidity to include the reserve, got %d", ob.TotalAskLiquidity)
+	}
+}
+
+func TestIceberg_ReplenishesWithFreshPriority(t *testing.T) {
+	eng := NewEngine()
+	ice := icebergOrder(SideSell, 100, 10, 3)
+	other := limitOrder(SideSell, 100, 2)
+	eng.SubmitOrder(ice)
+	eng.SubmitOrder(other)
+	seq := ice.Sequence
+
+	// Takes the peak, then the other order, which now has priority, then
+	// 1 of the new peak
+	trades, _ := eng.SubmitOrder(limitOrder(SideBuy, 100, 6))
+	if len(trades) != 3 {
+		t.Fatalf("expected 3 trades, got %+v", trades)
+	}
+	want := []struct {
+		maker string
+		qty   int64
+	}{{ice.ID, 3}, {other.ID, 2}, {ice.ID, 1}}
+	for i, w := range want {
+		if trades[i].MakerOrderID != w.maker || trades[i].Quantity != w.qty {
+			t.Errorf("trade %d: got %s x%d, want %s x%d", i, trades[i].MakerOrderID, trades[i].Quantity, w.maker, w.qty)
+		}
+	}
+	if ice.Sequence <= seq {
+		t.Errorf("expected replenished peak to get a new sequence")
+	}
+	if ice.Filled != 4 || ice.PeakRemaining != 2 || ice.HiddenQuantity() != 4 || ice.Status != OrderStatusPartialFill {
+		t.Errorf("expected 4 filled, 2 shown, 4 hidden; got filled %d peak %d hidden %d %s",
+			ice.Filled, ice.PeakRemaining, ice.HiddenQuantity(), ice.Status)
+	}
+	if got := eng.GetOrderBook("BTCUSD").Asks.Quantity(100); got != 2 {
+		t.Errorf("expected 2 shown at 100, got %d", got)
+	}
+
+	// An aggressive sweep takes the whole reserve, one peak at a time
+	trades, _ = eng.SubmitOrder(limitOrder(SideBuy, 100, 10))
+	var filled int64
+	for _, tr := range trades {
+		filled += tr.Quantity
+	}
+	if filled != 6 || ice.Status != OrderStatusFilled {
+		t.Errorf("expected the remaining 6 to fill, got %d (%s)", filled, ice.Status)
+	}
+	if ob := eng.GetOrderBook("BTCUSD"); ob.Asks.Len() != 0 || ob.TotalAskLiquidity != 0 {
+		t.Errorf("expected empty ask side")
+	}
+}
+
+func TestIceberg_Validation(t *testing.T) {
+	eng := NewEngine()
+	if _, err := eng.SubmitOrder(icebergOrder(SideBuy, 100, 5, 6)); err != utils.ErrInvalidDisplayQuantity {
+		t.Errorf("expected display above quantity to be rejected, got %v", err)
+	}
+	market := marketOrder(SideBuy, 5)
+	market.DisplayQuantity = 1
+	if _, err := eng.SubmitOrder(market); err != utils.ErrInvalidDisplayQuantity {
+		t.Errorf("expected market iceberg to be rejected, got %v", err)
+	}
+
+	// Reducing an iceberg takes from the reserve first
+	ice := icebergOrder(SideBuy, 100, 10, 4)
+	eng.SubmitOrder(ice)
+	eng.AmendOrder(ice.ID, 0, 6)
+	if ice.PeakRemaining != 4 || eng.GetOrderBook("BTCUSD").Bids.Quantity(100) != 4 {
+		t.Errorf("expected peak untouched by a reduction into the reserve")
+	}
+	eng.AmendOrder(ice.ID, 0, 3)
+	if ice.PeakRemaining != 3 || eng.GetOrderBook("BTCUSD").Bids.Quantity(100) != 3 {
+		t.Errorf("expected peak cut to the new quantity, got %d", ice.PeakRemaining)
+	}
+}
+
+type recordingFeed struct {
+	updates []BookUpdate
+}
+
+func (f *recordingFeed) OnEvent(ev Event) {
+	if ev, ok := ev.(*BookUpdated); ok {
+		f.updates = append(f.updates, ev.Update)
+	}
+}
+
+func TestHiddenOrders(t *testing.T) {
+	eng := NewEngine()
+	updates := &recordingFeed{}
+	eng.Subscribe(updates)
+
+	hidden := limitOrder(SideSell, 100, 5)
+	hidden.Hidden = true
+	eng.SubmitOrder(hidden)
+	ob := eng.GetOrderBook("BTCUSD")
+	if snap := ob.GetSnapshot(5); len(snap.Asks) != 0 {
+		t.Fatalf("expected hidden order to stay out of the snapshot, got %+v", snap.Asks)
+	}
+	if len(updates.updates) != 0 {
+		t.Errorf("expected no market data for a hidden order, got %+v", updates.updates)
+	}
+	if hidden.HiddenQuantity() != 5 {
+		t.Errorf("expected 5 hidden, got %d", hidden.HiddenQuantity())
+	}
+
+	// A displayed order at the same price arriving later still goes first
+	displayed := limitOrder(SideSell, 100, 3)
+	eng.SubmitOrder(displayed)
+	if snap := ob.GetSnapshot(5); len(snap.Asks) != 1 || snap.Asks[0].Quantity != 3 {
+		t.Fatalf("expected only the displayed 3, got %+v", snap.Asks)
+	}
+
+	trades, _ := eng.SubmitOrder(limitOrder(SideBuy, 100, 6))
+	if len(trades) != 2 || trades[0].MakerOrderID != displayed.ID || trades[1].MakerOrderID != hidden.ID {
+		t.Fatalf("expected displayed then hidden, got %+v", trades)
+	}
+	if hidden.Filled != 3 || hidden.HiddenQuantity() != 2 || ob.TotalAskLiquidity != 2 {
+		t.Errorf("expected hidden order to have 2 left, got filled %d", hidden.Filled)
+	}
+	if qty := ob.Asks.Quantity(100); qty != 0 {
+		t.Errorf("expected hidden fills to leave the level's shown quantity alone, got %d", qty)
+	}
+
+	iceberg := icebergOrder(SideBuy, 90, 10, 2)
+	iceberg.Hidden = true
+	if _, err := eng.SubmitOrder(iceberg); err != utils.ErrInvalidHidden {
+		t.Errorf("expected hidden iceberg to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// Instrument defines a tradable symbol and the prices and sizes its orders
+// may use. Once an engine has any instruments it only trades those.
+type Instrument struct {
+	Symbol     string `json:"symbol"`
+	BaseAsset  string `json:"base_asset"`
+	QuoteAsset string `json:"quote_asset"`
+	// PricePrecision is the number of decimal places integer prices carry,
+	// e.g. 2 for prices in cents.
+	PricePrecision int   `json:"price_precision"`
+	TickSize       int64 `json:"tick_size"`
+	LotSize        int64 `json:"lot_size"`
+	MinQuantity    int64 `json:"min_quantity"`
+	MaxQuantity    int64 `json:"max_quantity,omitempty"` // 0 for no maximum
+	// MinPrice and MaxPrice bound the limit and stop prices orders may use;
+	// 0 leaves that side open
+	MinPrice int64 `json:"min_price,omitempty"`
+	MaxPrice int64 `json:"max_price,omitempty"`
+}
+
+const maxPricePrecision = 18
+
+// withDefaults fills in the fields inst leaves out: assets from the symbol
+// and the default quote asset, and single-unit ticks and lots.
+func (inst Instrument) withDefaults() Instrument {
+	if inst.BaseAsset == "" {
+		inst.BaseAsset = inst.Symbol
+	}
+	if inst.QuoteAsset == "" {
+		inst.QuoteAsset = DefaultQuoteAsset
+	}
+	if inst.TickSize == 0 {
+		inst.TickSize = 1
+	}
+	if inst.LotSize == 0 {
+		inst.LotSize = 1
+	}
+	if inst.MinQuantity == 0 {
+		inst.MinQuantity = inst.LotSize
+	}
+	return inst
+}
+
+func (inst Instrument) Valid() bool {
+	return inst.Symbol != "" && inst.BaseAsset != inst.QuoteAsset &&
+		inst.PricePrecision >= 0 && inst.PricePrecision <= maxPricePrecision &&
+		inst.TickSize > 0 && inst.LotSize > 0 && inst.MinQuantity > 0 &&
+		(inst.MaxQuantity == 0 || inst.MaxQuantity >= inst.MinQuantity) &&
+		inst.MinPrice >= 0 && inst.MaxPrice >= 0 && (inst.MaxPrice == 0 || inst.MaxPrice >= inst.MinPrice)
+}
+
+// checkPrice checks a price an order sets; 0 is one it doesn't.
+func (inst *Instrument) checkPrice(price int64) error {
+	if price%inst.TickSize != 0 {
+		return utils.ErrOffTick
+	}
+	if price != 0 && (price < inst.MinPrice || (inst.MaxPrice > 0 && price > inst.MaxPrice)) {
+		return utils.ErrPriceOutOfBand
+	}
+	return nil
+}
+
+func (inst *Instrument) checkQuantity(qty int64) error {
+	switch {
+	case qty%inst.LotSize != 0:
+		return utils.ErrOffLot
+	case qty < inst.MinQuantity:
+		return utils.ErrQuantityBelowMin
+	case inst.MaxQuantity > 0 && qty > inst.MaxQuantity:
+		return utils.ErrQuantityAboveMax
+	}
+	return nil
+}
+
+// checkInstrument rejects orders whose prices are off the tick or outside
+// the instrument's band, or whose sizes are off the lot or outside its
+// limits.
+func (ob *OrderBook) checkInstrument(order *Order) error {
+	inst := ob.instrument
+	if inst == nil {
+		return nil
+	}
+	if err := inst.checkPrice(order.Price); err != nil {
+		return err
+	}
+	if err := inst.checkPrice(order.StopPrice); err != nil {
+		return err
+	}
+	if order.DisplayQuantity%inst.LotSize != 0 {
+		return utils.ErrOffLot
+	}
+	return inst.checkQuantity(order.Quantity)
+}
+
+// pricePrecision is the number of decimal places the book's prices carry.
+func (ob *OrderBook) pricePrecision() int {
+	if ob.instrument == nil {
+		return 0
+	}
+	return ob.instrument.PricePrecision
+}
+
+func (ob *OrderBook) setInstrument(inst Instrument) {
+	ob.instrument = &inst
+	ob.baseAsset = inst.BaseAsset
+	ob.quoteAsset = inst.QuoteAsset
+}
+
+// book returns symbol's order book for a command, creating it if the
+// symbol may trade. Reads use lookup instead.
+// Looking up an existing book takes no lock; the map of books is replaced
+// whenever one is added.
+func (e *Engine) book(symbol string) (*OrderBook, error) {
+	if ob, ok := (*e.books.Load())[symbol]; ok {
+		return ob, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := *e.books.Load()
+	if ob, ok := current[symbol]; ok {
+		return ob, nil
+	}
+	inst, listed := e.instruments[symbol]
+	if !listed && len(e.instruments) > 0 {
+		return nil, utils.ErrUnknownSymbol
+	}
+
+	ob := NewOrderBook(symbol)
+	e.configure(ob, inst, listed)
+	ob.accounts = e.accounts
+	ob.events = e.events
+	books := make(map[string]*OrderBook, len(current)+1)
+	for s, b := range current {
+		books[s] = b
+	}
+	books[symbol] = ob
+	e.books.Store(&books)
+	return ob, nil
+}
+
+// lookup returns symbol's order book without creating one. A listed symbol
+// that has no book yet gets a detached, empty one that isn't kept.
+func (e *Engine) lookup(symbol string) (*OrderBook, bool) {
+	if ob, ok := (*e.books.Load())[symbol]; ok {
+		return ob, true
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if ob, ok := (*e.books.Load())[symbol]; ok {
+		return ob, true
+	}
+	inst, listed := e.instruments[symbol]
+	if !listed {
+		return nil, false
+	}
+	ob := newOrderBook(symbol)
+	e.configure(ob, inst, true)
+	return ob, true
+}
+
+// configure gives a new book the engine's defaults and, if it is listed,
+// its instrument.
+func (e *Engine) configure(ob *OrderBook, inst Instrument, listed bool) {
+	ob.MarketConfig = e.MarketConfig
+	ob.TradingConfig = e.TradingConfig
+	ob.clock, ob.ids = e.clock, e.ids
+	if listed {
+		ob.setInstrument(inst)
+	}
+}
+
+func (e *Engine) addInstrument(inst Instrument) error {
+	inst = inst.withDefaults()
+	if !inst.Valid() {
+		return utils.ErrInvalidInstrument
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.instruments[inst.Symbol]; ok {
+		return utils.ErrInstrumentExists
+	}
+	if ob, ok := (*e.books.Load())[inst.Symbol]; ok {
+		// A book that traded before the registry existed
+		if ob.baseAsset != inst.BaseAsset || ob.quoteAsset != inst.QuoteAsset {
+			return utils.ErrInvalidInstrument
+		}
+		ob.do(func() { ob.setInstrument(inst) })
+	}
+	e.instruments[inst.Symbol] = inst
+	return nil
+}
+
+// updateInstrument changes an instrument's price and size rules. Its assets
+// are fixed: open orders hold reservations in them.
+func (e *Engine) updateInstrument(inst Instrument) error {
+	inst = inst.withDefaults()
+	if !inst.Valid() {
+		return utils.ErrInvalidInstrument
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old, ok := e.instruments[inst.Symbol]
+	if !ok {
+		return utils.ErrUnknownSymbol
+	}
+	if old.BaseAsset != inst.BaseAsset || old.QuoteAsset != inst.QuoteAsset {
+		return utils.ErrInvalidInstrument
+	}
+	if ob, ok := (*e.books.Load())[inst.Symbol]; ok {
+		ob.do(func() { ob.setInstrument(inst) })
+	}
+	e.instruments[inst.Symbol] = inst
+	return nil
+}
+
+// AddInstrument lists a new symbol. Fields left empty take defaults: the
+// symbol as base asset, DefaultQuoteAsset, and a tick, lot and minimum
+// quantity of 1.
+func (e *Engine) AddInstrument(inst Instrument) error {
+	return e.journaled(&Command{Type: CommandAddInstrument, Instrument: &inst}, func() error {
+		return e.addInstrument(inst)
+	})
+}
+
+// UpdateInstrument replaces a listed instrument's definition. New rules apply
+// to orders and amends from then on; resting orders are left as they are.
+func (e *Engine) UpdateInstrument(inst Instrument) error {
+	return e.journaled(&Command{Type: CommandUpdateInstrument, Instrument: &inst}, func() error {
+		return e.updateInstrument(inst)
+	})
+}
+
+func (e *Engine) GetInstrument(symbol string) (Instrument, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	inst, ok := e.instruments[symbol]
+	if !ok {
+		return Instrument{}, utils.ErrUnknownSymbol
+	}
+	return inst, nil
+}
+
+// Assets returns the base and quote asset of symbol, listed or not.
+func (e *Engine) Assets(symbol string) (base, quote string) {
+	if inst, err := e.GetInstrument(symbol); err == nil {
+		return inst.BaseAsset, inst.QuoteAsset
+	}
+	return symbol, DefaultQuoteAsset
+}
+
+// Instruments returns every listed instrument, sorted by symbol.
+func (e *Engine) Instruments() []Instrument {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]Instrument, 0, len(e.instruments))
+	for _, inst := range e.instruments {
+		list = append(list, inst)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+	return list
+}
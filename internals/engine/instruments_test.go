@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestInstruments_RejectUnlistedSymbols(t *testing.T) {
+	eng := NewEngine()
+	if err := eng.AddInstrument(Instrument{Symbol: "BTCUSD"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.AddInstrument(Instrument{Symbol: "BTCUSD"}); err != utils.ErrInstrumentExists {
+		t.Errorf("expected duplicate to be refused, got %v", err)
+	}
+
+	typo := limitOrder(SideBuy, 100, 1)
+	typo.Symbol = "BTCUSDD"
+	if _, err := eng.SubmitOrder(typo); err != utils.ErrUnknownSymbol {
+		t.Fatalf("expected unknown symbol, got %v", err)
+	}
+	if typo.Status != OrderStatusRejected {
+		t.Errorf("expected REJECTED, got %s", typo.Status)
+	}
+	if eng.GetOrderBook("BTCUSDD") != nil || len(eng.OrderBooks()) != 0 {
+		t.Errorf("expected no book for an unlisted symbol")
+	}
+	if _, err := eng.SubmitOrder(limitOrder(SideBuy, 100, 1)); err != nil {
+		t.Errorf("expected listed symbol to trade, got %v", err)
+	}
+}
+
+func TestInstruments_TickAndLot(t *testing.T) {
+	eng := NewEngine()
+	err := eng.AddInstrument(Instrument{Symbol: "BTCUSD", TickSize: 5, LotSize: 10, MinQuantity: 20, MaxQuantity: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		order *Order
+		want  error
+	}{
+		{"on tick and lot", limitOrder(SideBuy, 100, 20), nil},
+		{"off tick", limitOrder(SideBuy, 101, 20), utils.ErrOffTick},
+		{"off lot", limitOrder(SideBuy, 100, 25), utils.ErrOffLot},
+		{"below min", limitOrder(SideBuy, 100, 10), utils.ErrQuantityBelowMin},
+		{"above max", limitOrder(SideBuy, 100, 110), utils.ErrQuantityAboveMax},
+		{"stop off tick", stopOrder(OrderTypeStopLimit, SideBuy, 112, 115, 20), utils.ErrOffTick},
+		{"display off lot", icebergOrder(SideBuy, 100, 50, 15), utils.ErrOffLot},
+	}
+	for _, tt := range tests {
+		if _, err := eng.SubmitOrder(tt.order); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	resting := limitOrder(SideSell, 200, 30)
+	eng.SubmitOrder(resting)
+	if _, err := eng.AmendOrder(resting.ID, 202, 0); err != utils.ErrOffTick {
+		t.Errorf("expected off-tick amend to be refused, got %v", err)
+	}
+	if _, err := eng.AmendOrder(resting.ID, 0, 15); err != utils.ErrOffLot {
+		t.Errorf("expected off-lot amend to be refused, got %v", err)
+	}
+
+	// Tighter rules apply to new orders only
+	if err := eng.UpdateInstrument(Instrument{Symbol: "BTCUSD", TickSize: 50, LotSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eng.SubmitOrder(limitOrder(SideBuy, 105, 20)); err != utils.ErrOffTick {
+		t.Errorf("expected updated tick size to apply, got %v", err)
+	}
+	if !resting.isOpen() {
+		t.Errorf("expected resting order to survive the update")
+	}
+	if err := eng.UpdateInstrument(Instrument{Symbol: "BTCUSD", BaseAsset: "BTC"}); err != utils.ErrInvalidInstrument {
+		t.Errorf("expected changing assets to be refused, got %v", err)
+	}
+}
+
+func TestInstruments_PriceBand(t *testing.T) {
+	eng := NewEngine()
+	err := eng.AddInstrument(Instrument{Symbol: "BTCUSD", TickSize: 5, MinPrice: 50, MaxPrice: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		order *Order
+		want  error
+	}{
+		{"at min", limitOrder(SideBuy, 50, 1), nil},
+		{"at max", limitOrder(SideSell, 200, 1), nil},
+		{"below min", limitOrder(SideBuy, 45, 1), utils.ErrPriceOutOfBand},
+		{"above max", limitOrder(SideSell, 205, 1), utils.ErrPriceOutOfBand},
+		{"stop above max", stopOrder(OrderTypeStopLimit, SideBuy, 210, 150, 1), utils.ErrPriceOutOfBand},
+		{"market", marketOrder(SideBuy, 1), nil},
+	}
+	for _, tt := range tests {
+		if _, err := eng.SubmitOrder(tt.order); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	resting := limitOrder(SideBuy, 100, 1)
+	eng.SubmitOrder(resting)
+	if _, err := eng.AmendOrder(resting.ID, 40, 0); err != utils.ErrPriceOutOfBand {
+		t.Errorf("expected amend below the band to be refused, got %v", err)
+	}
+	if _, err := eng.AmendOrder(resting.ID, 150, 0); err != nil {
+		t.Errorf("expected amend within the band, got %v", err)
+	}
+
+	for _, inst := range []Instrument{
+		{Symbol: "BTCUSD", MinPrice: -1},
+		{Symbol: "BTCUSD", MinPrice: 100, MaxPrice: 50},
+	} {
+		if err := eng.UpdateInstrument(inst); err != utils.ErrInvalidInstrument {
+			t.Errorf("%+v: expected ErrInvalidInstrument, got %v", inst, err)
+		}
+	}
+}
+
+func TestInstruments_AssetsAndRecovery(t *testing.T) {
+	eng := NewEngine()
+	eng.AddInstrument(Instrument{Symbol: "BTCUSD", BaseAsset: "BTC", QuoteAsset: "USDT", TickSize: 10})
+	eng.CreateAccount("buyer", RiskLimits{})
+	eng.AdjustBalance("buyer", "USDT", 1000)
+	eng.CreateAccount("seller", RiskLimits{})
+	eng.AdjustBalance("seller", "BTC", 5)
+
+	eng.SubmitOrder(accountOrder("seller", SideSell, 100, 2))
+	if _, err := eng.SubmitOrder(accountOrder("buyer", SideBuy, 100, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(t, eng, "buyer", "BTC"); b.Total != 2 {
+		t.Errorf("expected buyer to receive 2 BTC, got %+v", b)
+	}
+	if b := balance(t, eng, "seller", "USDT"); b.Total != 200 {
+		t.Errorf("expected seller to receive 200 USDT, got %+v", b)
+	}
+
+	restored := NewEngine()
+	if err := restored.Restore(eng.State()); err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := restored.GetInstrument("BTCUSD"); err != nil || inst.TickSize != 10 {
+		t.Fatalf("expected instrument to survive a snapshot, got %+v %v", inst, err)
+	}
+	if _, err := restored.SubmitOrder(limitOrder(SideBuy, 105, 1)); err != utils.ErrOffTick {
+		t.Errorf("expected restored book to enforce the tick size, got %v", err)
+	}
+}
+
+func TestInstruments_LookupsCreateNoBooks(t *testing.T) {
+	eng := NewEngine()
+	if eng.GetOrderBook("anything") != nil {
+		t.Error("expected an unknown symbol to have no book")
+	}
+
+	eng.AddInstrument(Instrument{Symbol: "BTCUSD", PricePrecision: 2})
+	ob := eng.GetOrderBook("BTCUSD")
+	if ob == nil {
+		t.Fatal("expected a listed symbol to read as an empty book")
+	}
+	if snap := ob.GetSnapshot(10); snap.Symbol != "BTCUSD" || snap.PricePrecision != 2 || len(snap.Bids) != 0 {
+		t.Errorf("unexpected snapshot of a listed symbol: %+v", snap)
+	}
+	if eng.GetOrderBook("ETHUSD") != nil {
+		t.Error("expected an unlisted symbol to have no book")
+	}
+	if books := eng.OrderBooks(); len(books) != 0 {
+		t.Errorf("expected lookups to create no books, got %d", len(books))
+	}
+}
@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+type CommandType string
+
+const (
+	CommandSubmit CommandType = "SUBMIT"
+	CommandCancel CommandType = "CANCEL"
+	CommandExpire CommandType = "EXPIRE"
+	CommandAmend  CommandType = "AMEND"
+
+	CommandCreateAccount CommandType = "CREATE_ACCOUNT"
+	CommandSetLimits     CommandType = "SET_LIMITS"
+	CommandAdjustBalance CommandType = "ADJUST_BALANCE"
+
+	CommandSetSelfTradePrevention CommandType = "SET_STP"
+
+	CommandAddInstrument    CommandType = "ADD_INSTRUMENT"
+	CommandUpdateInstrument CommandType = "UPDATE_INSTRUMENT"
+
+	CommandSetTradingState  CommandType = "SET_TRADING_STATE"
+	CommandSetTradingConfig CommandType = "SET_TRADING_CONFIG"
+	// A book moving on from a timed halt or auction
+	CommandReopen CommandType = "REOPEN"
+
+	// A book moving to or from another engine
+	CommandAdoptBook   CommandType = "ADOPT_BOOK"
+	CommandReleaseBook CommandType = "RELEASE_BOOK"
+	// The engine a book came from has released it
+	CommandConfirmAdoption CommandType = "CONFIRM_ADOPTION"
+)
+
+// Command is a journaled engine instruction. It records everything the
+// engine read from the outside world while executing it (the clock and the
+// trade IDs it generated) so that replaying it reproduces the same result.
+type Command struct {
+	Seq       uint64      `json:"seq"`
+	Type      CommandType `json:"type"`
+	Timestamp int64       `json:"timestamp"` // Engine time in Unix milliseconds
+	Order     *Order      `json:"order,omitempty"`
+	OrderID   string      `json:"order_id,omitempty"`
+	Price     int64       `json:"price,omitempty"`    // AMEND only
+	Quantity  int64       `json:"quantity,omitempty"` // AMEND only
+	TradeIDs  []string    `json:"trade_ids,omitempty"`
+	AccountID string      `json:"account_id,omitempty"`
+	Asset     string      `json:"asset,omitempty"`
+	Amount    int64       `json:"amount,omitempty"`
+	Limits    *RiskLimits `json:"limits,omitempty"`
+
+	SelfTradePrevention SelfTradePrevention `json:"self_trade_prevention,omitempty"`
+	Instrument          *Instrument         `json:"instrument,omitempty"`
+	Symbol              string              `json:"symbol,omitempty"`
+	TradingState        TradingState        `json:"trading_state,omitempty"`
+	TradingConfig       *TradingConfig      `json:"trading_config,omitempty"`
+	Handoff             *BookHandoff        `json:"handoff,omitempty"`
+	Funds               []Funds             `json:"funds,omitempty"` // RELEASE_BOOK only
+	// Risk holds the outcomes of the account checks the command made that
+	// depend on other books, "" for a pass, in the order it made them
+	Risk []string `json:"risk,omitempty"`
+}
+
+// CommandLog receives every command that changes engine state, in the order
+// the engine applied them. Append must not return until the command is as
+// durable as the log's policy promises.
+type CommandLog interface {
+	Append(cmd *Command) error
+}
+
+// A CommandLog that can fail between appends, e.g. on a periodic fsync,
+// reports it through Err so the engine checks before applying a command.
+type failingLog interface {
+	Err() error
+}
+
+// A CommandLog that makes commands durable in batches has them written in
+// sequence with Write, and waits for them with Wait outside the engine's
+// ordering lock, so commands on different books share an fsync.
+type batchingLog interface {
+	Write(cmd *Command) error
+	Wait(seq uint64) error
+}
+
+// SetJournal attaches log to the engine. Subsequent commands are numbered
+// after the last sequence number the engine has applied. It must be called
+// before the engine starts taking orders.
+func (e *Engine) SetJournal(log CommandLog) {
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	e.journal = log
+}
+
+// LastSeq returns the sequence number of the last journaled or replayed command.
+func (e *Engine) LastSeq() uint64 {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+	return e.seq
+}
+
+// Apply replays a journaled command. It uses the command's recorded time and
+// trade IDs instead of the live clock and ID generator, and is not written
+// back to the journal.
+func (e *Engine) Apply(cmd *Command) error {
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+
+	if cmd.Seq != e.seq+1 {
+		return utils.ErrReplayDiverged
+	}
+
+	switch cmd.Type {
+	case CommandSubmit:
+		if cmd.Order == nil {
+			return utils.ErrJournalCorrupt
+		}
+		order := *cmd.Order
+		ids := &replayIDs{ids: cmd.TradeIDs}
+		tape := replayTape(cmd.Risk)
+		// Errors are part of the recorded outcome; replay only has to
+		// reproduce them, not surface them.
+		e.submitAt(&order, cmd.Timestamp, ids.NewID, tape)
+		if ids.pos != len(ids.ids) || ids.short || tape.diverged() {
+			return utils.ErrReplayDiverged
+		}
+	case CommandCancel:
+		if err := e.cancel(cmd.OrderID, cmd.Timestamp); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandAmend:
+		ids := &replayIDs{ids: cmd.TradeIDs}
+		tape := replayTape(cmd.Risk)
+		if _, err := e.amendAt(cmd.OrderID, cmd.Price, cmd.Quantity, cmd.Timestamp, ids.NewID, tape); err != nil {
+			return utils.ErrReplayDiverged
+		}
+		if ids.pos != len(ids.ids) || ids.short || tape.diverged() {
+			return utils.ErrReplayDiverged
+		}
+	case CommandExpire:
+		e.expireAt(cmd.Timestamp)
+	case CommandCreateAccount, CommandSetLimits:
+		if cmd.Limits == nil {
+			return utils.ErrJournalCorrupt
+		}
+		create := e.accounts.setLimits
+		if cmd.Type == CommandCreateAccount {
+			create = e.accounts.create
+		}
+		if err := create(cmd.AccountID, *cmd.Limits); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandSetSelfTradePrevention:
+		if err := e.accounts.setSelfTradePrevention(cmd.AccountID, cmd.SelfTradePrevention); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandAdjustBalance:
+		if err := e.accounts.adjust(cmd.AccountID, cmd.Asset, cmd.Amount); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandAddInstrument, CommandUpdateInstrument:
+		if cmd.Instrument == nil {
+			return utils.ErrJournalCorrupt
+		}
+		apply := e.updateInstrument
+		if cmd.Type == CommandAddInstrument {
+			apply = e.addInstrument
+		}
+		if err := apply(*cmd.Instrument); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandSetTradingState:
+		ids := &replayIDs{ids: cmd.TradeIDs}
+		tape := replayTape(cmd.Risk)
+		if _, err := e.tradingStateAt(cmd.Symbol, cmd.TradingState, cmd.Timestamp, ids.NewID, tape); err != nil {
+			return utils.ErrReplayDiverged
+		}
+		if ids.pos != len(ids.ids) || ids.short || tape.diverged() {
+			return utils.ErrReplayDiverged
+		}
+	case CommandReopen:
+		ob, err := e.book(cmd.Symbol)
+		if err != nil {
+			return utils.ErrReplayDiverged
+		}
+		ids := &replayIDs{ids: cmd.TradeIDs}
+		if _, reopened := ob.reopenIfDue(cmd.Timestamp, ids.NewID); !reopened {
+			return utils.ErrReplayDiverged
+		}
+		if ids.pos != len(ids.ids) || ids.short {
+			return utils.ErrReplayDiverged
+		}
+	case CommandSetTradingConfig:
+		if cmd.TradingConfig == nil {
+			return utils.ErrJournalCorrupt
+		}
+		if err := e.setTradingConfig(cmd.Symbol, *cmd.TradingConfig); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandAdoptBook:
+		if cmd.Handoff == nil {
+			return utils.ErrJournalCorrupt
+		}
+		if err := e.adoptBook(cmd.Handoff); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandReleaseBook:
+		if err := e.releaseBook(cmd.Symbol, cmd.Funds); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	case CommandConfirmAdoption:
+		if err := e.confirmAdoption(cmd.Symbol); err != nil {
+			return utils.ErrReplayDiverged
+		}
+	default:
+		return utils.ErrJournalCorrupt
+	}
+
+	e.seq = cmd.Seq
+	return nil
+}
+
+// journalHealth fails with utils.ErrJournalFailed once the journal can't
+// be written to, so that commands aren't applied without being recorded.
+// Callers must hold journalMu.
+func (e *Engine) journalHealth() error {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+	if e.journalErr != nil {
+		return e.journalErr
+	}
+	if l, ok := e.journal.(failingLog); ok {
+		if err := l.Err(); err != nil {
+			e.journalErr = fmt.Errorf("%w: %v", utils.ErrJournalFailed, err)
+			return e.journalErr
+		}
+	}
+	return nil
+}
+
+// record assigns the next sequence number to cmd, appends it to the
+// journal and waits for it to be durable. The command has already been
+// applied, so if the append fails the engine stops taking commands. Callers
+// must hold journalMu.
+func (e *Engine) record(cmd *Command) error {
+	if err := e.write(cmd); err != nil {
+		return err
+	}
+	return e.durable(cmd.Seq)
+}
+
+// write assigns the next sequence number to cmd and appends it to the
+// journal, which may not have it on disk yet if it makes commands durable
+// in batches. Callers must hold journalMu.
+func (e *Engine) write(cmd *Command) error {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+	cmd.Seq = e.seq + 1
+	var err error
+	if batching, ok := e.journal.(batchingLog); ok {
+		err = batching.Write(cmd)
+	} else {
+		err = e.journal.Append(cmd)
+	}
+	if err != nil {
+		e.journalErr = fmt.Errorf("%w: %v", utils.ErrJournalFailed, err)
+		return e.journalErr
+	}
+	e.seq = cmd.Seq
+	return nil
+}
+
+// durable waits for the command seq to be as durable as the journal
+// promises, which Append has already done for a journal that doesn't batch.
+func (e *Engine) durable(seq uint64) error {
+	batching, ok := e.journal.(batchingLog)
+	if !ok {
+		return nil
+	}
+	if err := batching.Wait(seq); err != nil {
+		e.seqMu.Lock()
+		defer e.seqMu.Unlock()
+		e.journalErr = fmt.Errorf("%w: %v", utils.ErrJournalFailed, err)
+		return e.journalErr
+	}
+	return nil
+}
+
+// journaled runs fn and, if it succeeds, records cmd. It is for commands
+// that either apply completely or fail without changing anything, and
+// runs them alone.
+func (e *Engine) journaled(cmd *Command, fn func() error) error {
+	if e.journal == nil {
+		return fn()
+	}
+
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	if err := e.journalHealth(); err != nil {
+		return err
+	}
+
+	cmd.Timestamp = e.clock.Now()
+	if err := fn(); err != nil {
+		return err
+	}
+	return e.record(cmd)
+}
+
+// journalBook runs apply, a command on ob alone, on ob's goroutine and
+// appends cmd to the journal there, so that the book's commands reach the
+// journal in the order they were applied while other books apply theirs in
+// parallel. cmd is recorded if apply succeeds, or regardless if always is
+// set, with the outcomes of the account checks apply made. A journal that
+// batches fsyncs is waited for off the book's goroutine, so the book goes
+// on to its next command meanwhile. The events apply makes are published
+// once cmd is durable. A journal failure is returned over apply's error.
+func (e *Engine) journalBook(ob *OrderBook, cmd *Command, always bool, apply func(now int64) error) error {
+	e.journalMu.RLock()
+	defer e.journalMu.RUnlock()
+	if err := e.journalHealth(); err != nil {
+		return err
+	}
+
+	var err, jerr error
+	_, batching := e.journal.(batchingLog)
+	tape := &riskTape{}
+	ob.withTape(tape, func() {
+		ob.holdEvents()
+		defer func() {
+			ob.sealEvents(cmd.Seq)
+			if !batching || jerr != nil {
+				ob.releaseEvents(cmd.Seq, jerr == nil)
+			}
+		}()
+		cmd.Timestamp = e.clock.Now()
+		err = apply(cmd.Timestamp)
+		cmd.Risk = tape.outcomes
+		if err == nil || always {
+			jerr = e.write(cmd)
+		}
+	})
+	if batching && cmd.Seq != 0 && jerr == nil {
+		jerr = e.durable(cmd.Seq)
+		ob.do(func() { ob.releaseEvents(cmd.Seq, jerr == nil) })
+	}
+	if jerr != nil {
+		return jerr
+	}
+	return err
+}
+
+// deferEvents holds back the events of books until the returned function
+// is called with the sequence number of the command that made them, 0 if
+// none was recorded, and whether it is durable. Callers must hold
+// journalMu exclusively, so that no other command's events are waiting.
+func deferEvents(books ...*OrderBook) (release func(seq uint64, publish bool)) {
+	for _, ob := range books {
+		ob.do(ob.holdEvents)
+	}
+	return func(seq uint64, publish bool) {
+		for _, ob := range books {
+			ob.do(func() {
+				ob.sealEvents(seq)
+				ob.releaseEvents(seq, publish)
+			})
+		}
+	}
+}
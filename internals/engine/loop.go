@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+)
+
+// Each order book is owned by one goroutine that applies commands to it in
+// turn from a bounded queue, LMAX style: a book needs no lock and books of
+// different symbols run in parallel. Callers hand a command to the book and
+// wait for it to finish; when the queue is full they wait for room.
+
+// QueueSize is how many commands may wait for a book.
+const QueueSize = 1024
+
+func (ob *OrderBook) run() {
+	for fn := range ob.cmds {
+		fn()
+	}
+}
+
+// do runs fn on the book's goroutine and waits for it. A panic in fn is
+// raised again in the caller, leaving the book's goroutine running. It must
+// not be called from the book's own goroutine, e.g. by a listener. A
+// detached book runs fn on the caller's goroutine.
+func (ob *OrderBook) do(fn func()) {
+	if ob.cmds == nil {
+		fn()
+		return
+	}
+	done := make(chan any, 1)
+	ob.cmds <- func() {
+		defer func() { done <- recover() }()
+		fn()
+	}
+	if p := <-done; p != nil {
+		panic(p)
+	}
+}
+
+// withTape runs fn on the book's goroutine with tape recording or replaying
+// its account checks.
+func (ob *OrderBook) withTape(tape *riskTape, fn func()) {
+	ob.do(func() {
+		ob.risk = tape
+		defer func() { ob.risk = nil }()
+		fn()
+	})
+}
+
+// Close stops the book's goroutine once the commands already queued have
+// run. The book must not be used afterwards.
+func (ob *OrderBook) Close() {
+	close(ob.cmds)
+}
+
+// OrderBooks returns every order book, by symbol.
+func (e *Engine) OrderBooks() []*OrderBook {
+	books := *e.books.Load()
+	list := make([]*OrderBook, 0, len(books))
+	for _, ob := range books {
+		list = append(list, ob)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+	return list
+}
+
+// Close stops every book's goroutine, including those of released books.
+// The engine must not be used afterwards.
+func (e *Engine) Close() {
+	for _, ob := range e.OrderBooks() {
+		ob.Close()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ob := range e.retired {
+		ob.Close()
+	}
+	e.retired = nil
+}
+
+const indexStripes = 64
+
+// orderIndex maps order IDs to their symbols. It is split into stripes so
+// that orders for different books rarely wait on the same lock.
+type orderIndex struct {
+	stripes [indexStripes]struct {
+		mu  sync.RWMutex
+		ids map[string]string
+	}
+}
+
+func newOrderIndex() *orderIndex {
+	idx := &orderIndex{}
+	for i := range idx.stripes {
+		idx.stripes[i].ids = make(map[string]string)
+	}
+	return idx
+}
+
+// stripe picks id's stripe by its FNV-1a hash.
+func (idx *orderIndex) stripe(id string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return int(h % indexStripes)
+}
+
+func (idx *orderIndex) get(id string) (string, bool) {
+	s := &idx.stripes[idx.stripe(id)]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	symbol, ok := s.ids[id]
+	return symbol, ok
+}
+
+func (idx *orderIndex) put(id, symbol string) {
+	s := &idx.stripes[idx.stripe(id)]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[id] = symbol
+}
+
+// removeSymbol forgets every order of symbol.
+func (idx *orderIndex) removeSymbol(symbol string) {
+	for i := range idx.stripes {
+		s := &idx.stripes[i]
+		s.mu.Lock()
+		for id, sym := range s.ids {
+			if sym == symbol {
+				delete(s.ids, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (idx *orderIndex) all() map[string]string {
+	all := make(map[string]string)
+	for i := range idx.stripes {
+		s := &idx.stripes[i]
+		s.mu.RLock()
+		for id, symbol := range s.ids {
+			all[id] = symbol
+		}
+		s.mu.RUnlock()
+	}
+	return all
+}
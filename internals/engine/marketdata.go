@@ -0,0 +1,133 @@
+package engine
+
+type LevelUpdate struct {
+	Side     Side  `json:"side"`
+	Price    int64 `json:"price"`
+	Quantity int64 `json:"quantity"` // New aggregate quantity; 0 removes the level
+}
+
+// BookUpdate describes everything one command changed in an order book.
+// Seq increases by one per update and matches OrderBookSnapshot.Seq, so a
+// consumer can apply updates on top of a snapshot and detect gaps.
+type BookUpdate struct {
+	Symbol    string        `json:"symbol"`
+	Seq       uint64        `json:"seq"`
+	Timestamp int64         `json:"timestamp"`
+	Levels    []LevelUpdate `json:"levels"`
+	Trades    []Trade       `json:"trades,omitempty"`
+	State     TradingState  `json:"state,omitempty"` // Set when the book changed phase
+	// Auction is the indicative uncrossing price and volume, on every update
+	// during an auction where the book crosses
+	Auction *AuctionIndicative `json:"auction,omitempty"`
+	// Ticker is set when the command traded or changed the best prices
+	Ticker *Ticker `json:"ticker,omitempty"`
+}
+
+type levelKey struct {
+	side  Side
+	price int64
+}
+
+// bookFeed collects the levels touched by the command in progress.
+type bookFeed struct {
+	seq     uint64
+	touched []levelKey
+	seen    map[levelKey]struct{}
+	// stateChanged records a trading state change to publish
+	stateChanged bool
+	// auction is the last indicative published, so changes to it alone
+	// (from hidden orders) are published too
+	auction *AuctionIndicative
+	// top is the best bid and ask last published
+	top [2]PriceLevel
+}
+
+// touchScan is how many touched levels are searched one by one before a
+// command's levels go into a map; most commands touch one or two.
+const touchScan = 8
+
+func (f *bookFeed) touch(side Side, price int64) {
+	key := levelKey{side: side, price: price}
+	if len(f.touched) <= touchScan {
+		for _, k := range f.touched {
+			if k == key {
+				return
+			}
+		}
+	} else {
+		if f.seen == nil {
+			f.seen = make(map[levelKey]struct{})
+		}
+		if len(f.seen) == 0 {
+			for _, k := range f.touched {
+				f.seen[k] = struct{}{}
+			}
+		}
+		if _, ok := f.seen[key]; ok {
+			return
+		}
+		f.seen[key] = struct{}{}
+	}
+	f.touched = append(f.touched, key)
+}
+
+// publish emits the levels and trades produced by the current command, if
+// any, as the command's BookUpdated event. It runs on the book's goroutine.
+func (ob *OrderBook) publish(now int64, trades []Trade) {
+	for i := range trades {
+		ob.stats.add(&trades[i])
+	}
+
+	f := &ob.feed
+	levels := make([]LevelUpdate, 0, len(f.touched))
+	for _, key := range f.touched {
+		level := LevelUpdate{Side: key.side, Price: key.price, Quantity: ob.side(key.side).Quantity(key.price)}
+		levels = append(levels, level)
+		if ob.listening() {
+			ob.emit(EventBookLevelChanged, now, &BookLevelChanged{Level: level})
+		}
+	}
+	f.touched = f.touched[:0]
+	clear(f.seen)
+	var auction *AuctionIndicative
+	if eq, ok := ob.indicative(); ok {
+		// Copied here so a book outside an auction allocates nothing
+		indicative := eq
+		auction = &indicative
+	}
+	auctionChanged := (auction == nil) != (f.auction == nil) || (auction != nil && *auction != *f.auction)
+	if len(levels) == 0 && len(trades) == 0 && !f.stateChanged && !auctionChanged {
+		return
+	}
+	f.auction = auction
+
+	update := BookUpdate{
+		Symbol:    ob.Symbol,
+		Timestamp: now,
+		Levels:    levels,
+		Trades:    trades,
+		Auction:   auction,
+	}
+	if f.stateChanged {
+		update.State = ob.TradingState
+		f.stateChanged = false
+	}
+
+	f.seq++
+	update.Seq = f.seq
+	top := ob.top()
+	tickerChanged := len(trades) > 0 || top != f.top
+	f.top = top
+	if !ob.listening() {
+		return
+	}
+	if tickerChanged {
+		ticker := ob.ticker(now)
+		update.Ticker = &ticker
+	}
+	ob.emit(EventBookUpdated, now, &BookUpdated{Update: update})
+}
+
+func (ob *OrderBook) top() [2]PriceLevel {
+	return [2]PriceLevel{ob.Bids.top(), ob.Asks.top()}
+}
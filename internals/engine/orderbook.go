@@ -1,159 +1,473 @@
 package engine
 
 import (
-	"container/heap"
-	"sort"
-	"sync"
+	"math"
 	"time"
 
 	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
 )
 
-type BidHeap []*Order
+type OrderBook struct {
+	Symbol            string
+	Bids              *BookSide
+	Asks              *BookSide
+	BuyStops          *BookSide
+	SellStops         *BookSide
+	LastTradePrice    int64
+	Orders            map[string]*Order
+	TotalBidLiquidity int64
+	TotalAskLiquidity int64
+	MarketConfig      MarketOrderConfig
+	TradingState      TradingState
+	TradingConfig     TradingConfig
+	// HaltedUntil and AuctionUntil are when a circuit breaker halt and the
+	// auction after it end, or 0
+	HaltedUntil  int64
+	AuctionUntil int64
+
+	// cmds feeds the goroutine that owns the book; nil if it is detached
+	cmds         chan func()
+	clock        Clock
+	ids          IDGenerator
+	lastSequence uint64
+	feed         bookFeed
+	stats        tradeStats
+
+	// events is nil for books outside an engine
+	events   *EventBus
+	eventSeq uint64
+	// While holding, a journaled command's events collect in held. They
+	// then wait in pending until the command is durable, behind those of
+	// the book's earlier commands
+	holding bool
+	held    []Event
+	pending []heldEvents
+	// accounts is nil for books outside an engine, which skips risk checks
+	accounts   *Accounts
+	baseAsset  string
+	quoteAsset string
+	// instrument is nil for books of an engine without an instrument registry
+	instrument *Instrument
+
+	// Orders held while the book isn't open, in arrival order
+	queued []*Order
+	// Circuit breaker reference price and when its window started
+	breakerRef   int64
+	breakerSince int64
+	// Free funds a handoff brought with the book, until the move is
+	// confirmed
+	adopted []Funds
+	// risk records or replays the account checks of the journaled command
+	// being applied; nil otherwise
+	risk *riskTape
+}
 
-func (h BidHeap) Len() int { return len(h) }
-func (h BidHeap) Less(i, j int) bool {
-	if h[i].Price == h[j].Price {
-		return h[i].Timestamp < h[j].Timestamp
-	}
-	return h[i].Price > h[j].Price
+func NewOrderBook(symbol string) *OrderBook {
+	ob := newOrderBook(symbol)
+	ob.cmds = make(chan func(), QueueSize)
+	go ob.run()
+	return ob
 }
-func (h BidHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-	h[i].HeapIndex = i
-	h[j].HeapIndex = j
+
+// newOrderBook returns a detached book: it has no goroutine, and reads of it
+// run on the caller's.
+func newOrderBook(symbol string) *OrderBook {
+	return &OrderBook{
+		Symbol:    symbol,
+		Bids:      newBookSide(SideBuy),
+		Asks:      newBookSide(SideSell),
+		BuyStops:  newStopSide(SideBuy),
+		SellStops: newStopSide(SideSell),
+		Orders:    make(map[string]*Order),
+
+		MarketConfig:  DefaultMarketOrderConfig(),
+		TradingState:  TradingOpen,
+		TradingConfig: DefaultTradingConfig(),
+		baseAsset:     symbol,
+		quoteAsset:    DefaultQuoteAsset,
+		clock:         SystemClock{},
+		ids:           UUIDGenerator{},
+	}
 }
-func (h *BidHeap) Push(x interface{}) {
-	n := len(*h)
-	item := x.(*Order)
-	item.HeapIndex = n
-	*h = append(*h, item)
+
+func (ob *OrderBook) ProcessOrder(order *Order) (trades []Trade, err error) {
+	ob.do(func() { trades, err = ob.submit(order, ob.clock.Now(), ob.ids.NewID) })
+	return trades, err
 }
-func (h *BidHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil // avoid memory leak
-	item.HeapIndex = -1
-	*h = old[0 : n-1]
-	return item
+
+// processOrderAt matches order as of engine time now, naming trades with
+// newTradeID. Both are explicit so journal replay can reproduce a command
+// exactly. The trades returned include those of any stop orders it triggers.
+func (ob *OrderBook) processOrderAt(order *Order, now int64, newTradeID func() string, tape *riskTape) (trades []Trade, err error) {
+	ob.withTape(tape, func() { trades, err = ob.submit(order, now, newTradeID) })
+	return trades, err
 }
 
-type AskHeap []*Order
+func (ob *OrderBook) submit(order *Order, now int64, newTradeID func() string) (trades []Trade, err error) {
+	defer func() { ob.publish(now, trades) }()
 
-func (h AskHeap) Len() int { return len(h) }
-func (h AskHeap) Less(i, j int) bool {
-	// Lower price first. If prices equal, earlier timestamp first
-	if h[i].Price == h[j].Price {
-		return h[i].Timestamp < h[j].Timestamp
+	if err := ob.validate(order, now); err != nil {
+		ob.reject(order, now, err)
+		return nil, err
 	}
-	return h[i].Price < h[j].Price
-}
-func (h AskHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-	h[i].HeapIndex = i
-	h[j].HeapIndex = j
-}
-func (h *AskHeap) Push(x interface{}) {
-	n := len(*h)
-	item := x.(*Order)
-	item.HeapIndex = n
-	*h = append(*h, item)
+	ob.accounts.applyDefaults(order)
+
+	switch ob.TradingState {
+	case TradingOpen, TradingAuction:
+		return ob.enter(order, now, newTradeID)
+	}
+	return nil, ob.hold(order, now)
 }
-func (h *AskHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil
-	item.HeapIndex = -1
-	*h = old[0 : n-1]
-	return item
+
+// enter runs a validated order in the book's current phase: collecting it
+// into an auction, or processing it while open.
+func (ob *OrderBook) enter(order *Order, now int64, newTradeID func() string) ([]Trade, error) {
+	if ob.TradingState == TradingAuction {
+		return nil, ob.collect(order, now)
+	}
+	return ob.process(order, now, newTradeID)
 }
 
-type OrderBook struct {
-	Symbol            string
-	Bids              BidHeap
-	Asks              AskHeap
-	Orders            map[string]*Order 
-	TotalBidLiquidity int64
-	TotalAskLiquidity int64
-	mu                sync.RWMutex
+// process runs a validated order while the book is open: parking or
+// triggering a stop, matching, and any stops its trades set off.
+func (ob *OrderBook) process(order *Order, now int64, newTradeID func() string) ([]Trade, error) {
+	if order.isStop() && !ob.triggered(order) {
+		return nil, ob.addStop(order, now)
+	}
+	if order.isStop() {
+		order.TriggeredAt = now
+	}
+
+	trades, err := ob.execute(order, now, newTradeID)
+	if err != nil {
+		return nil, err
+	}
+	return append(trades, ob.runTriggers(now, newTradeID)...), nil
 }
 
-func NewOrderBook(symbol string) *OrderBook {
-	ob := &OrderBook{
-		Symbol: symbol,
-		Bids:   make(BidHeap, 0),
-		Asks:   make(AskHeap, 0),
-		Orders: make(map[string]*Order),
-	}
-	heap.Init(&ob.Bids)
-	heap.Init(&ob.Asks)
-	return ob
+func (ob *OrderBook) validate(order *Order, now int64) error {
+	if !order.Type.Valid() {
+		return utils.ErrInvalidOrderType
+	}
+	if order.Quantity <= 0 {
+		return utils.ErrInvalidQuantity
+	}
+	if !order.isMarket() && order.Price <= 0 {
+		return utils.ErrInvalidPrice
+	}
+	if order.isStop() && order.StopPrice <= 0 {
+		return utils.ErrInvalidStopPrice
+	}
+	if _, ok := notional(order.Price, order.Quantity); !ok {
+		return utils.ErrNotionalOverflow
+	}
+	if _, ok := notional(order.StopPrice, order.Quantity); !ok {
+		return utils.ErrNotionalOverflow
+	}
+	if err := ob.checkInstrument(order); err != nil {
+		return err
+	}
+	if order.DisplayQuantity < 0 || order.DisplayQuantity > order.Quantity ||
+		(order.DisplayQuantity > 0 && order.isMarket()) {
+		return utils.ErrInvalidDisplayQuantity
+	}
+	if order.Hidden && (order.isMarket() || order.DisplayQuantity > 0) {
+		return utils.ErrInvalidHidden
+	}
+	if err := ob.applyTimeInForce(order, now); err != nil {
+		return err
+	}
+	if !order.PostOnly.Valid() || (order.PostOnly != "" && (order.Type != OrderTypeLimit ||
+		order.TimeInForce == TimeInForceIOC || order.TimeInForce == TimeInForceFOK)) {
+		return utils.ErrInvalidPostOnly
+	}
+	if !order.SelfTradePrevention.Valid() {
+		return utils.ErrInvalidSelfTradePrevention
+	}
+	return nil
 }
 
-func (ob *OrderBook) ProcessOrder(order *Order) ([]Trade, error) {
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
+// execute runs a live order against the book: the pre-trade checks,
+// matching, and then resting or cancelling whatever is left.
+func (ob *OrderBook) execute(order *Order, now int64, newTradeID func() string) (trades []Trade, err error) {
+	ob.dropExpired(now)
+	if order.PostOnly != "" {
+		price, err := ob.postOnlyPrice(order, order.Price)
+		if err != nil {
+			ob.Orders[order.ID] = order
+			ob.reject(order, now, err)
+			return nil, err
+		}
+		order.Price = price
+	}
+	limit := ob.priceLimit(order)
+
+	fillable, cost := ob.fillable(order, limit, now)
+	allOrNone := order.TimeInForce == TimeInForceFOK ||
+		(order.Type == OrderTypeStopMarket && ob.MarketConfig.Policy == MarketOrderAllOrNone)
+	if allOrNone && fillable < order.Quantity {
+		ob.Orders[order.ID] = order
+		ob.reject(order, now, utils.ErrInsufficientLiquidity)
+		return nil, utils.ErrInsufficientLiquidity
+	}
 
-	if order.Quantity <= 0 {
-		return nil, utils.ErrInvalidQuantity
+	value := order.Price * order.Quantity
+	if order.isMarket() {
+		if cost == math.MaxInt64 {
+			ob.Orders[order.ID] = order
+			ob.reject(order, now, utils.ErrNotionalOverflow)
+			return nil, utils.ErrNotionalOverflow
+		}
+		value = cost
 	}
-	if order.Type == OrderTypeLimit && order.Price <= 0 {
-		return nil, utils.ErrInvalidPrice
+	rests := !order.isMarket() && order.TimeInForce != TimeInForceIOC && order.TimeInForce != TimeInForceFOK
+	if err := ob.accounts.admit(order, value, rests, ob.baseAsset, ob.quoteAsset, ob.risk); err != nil {
+		ob.Orders[order.ID] = order
+		ob.reject(order, now, err)
+		return nil, err
 	}
 
-	var trades []Trade
-	var err error
+	if order.TriggeredAt > 0 {
+		ob.setStatus(order, OrderStatusTriggered, ExecTypeTriggered, now, nil)
+	} else {
+		ob.setStatus(order, OrderStatusAccepted, ExecTypeNew, now, nil)
+	}
 
 	if order.Side == SideBuy {
-		trades, err = ob.matchBuyOrder(order)
+		trades, err = ob.matchBuyOrder(order, limit, now, newTradeID)
 	} else {
-		trades, err = ob.matchSellOrder(order)
+		trades, err = ob.matchSellOrder(order, limit, now, newTradeID)
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	if len(trades) > 0 {
+		ob.LastTradePrice = trades[len(trades)-1].Price
+	}
 
-	if order.Type == OrderTypeLimit && order.Quantity > order.Filled {
-		order.Status = OrderStatusAccepted
-		if order.Filled > 0 {
-			order.Status = OrderStatusPartialFill
+	if order.Quantity > order.Filled && order.isOpen() {
+		if rests && ob.TradingState == TradingOpen {
+			ob.addOrder(order)
+			return trades, nil
 		}
-		ob.addOrder(order)
-	} else if order.Type == OrderTypeMarket && order.Quantity > order.Filled {
-		
+		// IOC remainder (limit or market) is cancelled rather than rested,
+		// as is anything left when a circuit breaker halts matching
+		ob.cancelRemainder(order, now)
 	}
+	ob.Orders[order.ID] = order
 
 	return trades, nil
 }
 
-func (ob *OrderBook) matchBuyOrder(order *Order) ([]Trade, error) {
-	trades := []Trade{}
-	if order.Type == OrderTypeMarket {
-		if !ob.hasLiquidity(SideSell, order.Quantity) {
-			return nil, utils.ErrInsufficientLiquidity
+// applyTimeInForce defaults and validates the order's time in force. Market
+// orders take their default from the book's MarketOrderConfig and can never
+// rest on the book. A stop market order's time in force covers how long it
+// waits for its trigger.
+func (ob *OrderBook) applyTimeInForce(order *Order, now int64) error {
+	if order.TimeInForce == "" {
+		order.TimeInForce = TimeInForceGTC
+		if order.Type == OrderTypeMarket {
+			order.TimeInForce = TimeInForceIOC
+			if ob.MarketConfig.Policy == MarketOrderAllOrNone {
+				order.TimeInForce = TimeInForceFOK
+			}
+		}
+	}
+	if !order.TimeInForce.Valid() {
+		return utils.ErrInvalidTimeInForce
+	}
+
+	switch order.TimeInForce {
+	case TimeInForceIOC, TimeInForceFOK:
+		order.ExpireAt = 0
+	case TimeInForceGTC:
+		if order.Type == OrderTypeMarket {
+			return utils.ErrInvalidTimeInForce
+		}
+		order.ExpireAt = 0
+	case TimeInForceDAY:
+		if order.Type == OrderTypeMarket {
+			return utils.ErrInvalidTimeInForce
+		}
+		order.ExpireAt = endOfDay(now)
+	case TimeInForceGTD:
+		if order.Type == OrderTypeMarket {
+			return utils.ErrInvalidTimeInForce
+		}
+		if order.ExpireAt <= now {
+			return utils.ErrInvalidExpireTime
+		}
+	}
+	return nil
+}
+
+// endOfDay returns the last millisecond of the UTC day containing now.
+func endOfDay(now int64) int64 {
+	const day = int64(24 * time.Hour / time.Millisecond)
+	return (now/day+1)*day - 1
+}
+
+// priceLimit returns the worst price order may trade at, or 0 if it may
+// trade at any price. Market orders are bounded by the book's protection
+// band around the best opposite price when one is configured.
+func (ob *OrderBook) priceLimit(order *Order) int64 {
+	if !order.isMarket() {
+		return order.Price
+	}
+
+	if ob.MarketConfig.ProtectionTicks <= 0 {
+		return 0
+	}
+	band := ob.MarketConfig.ProtectionTicks * ob.tickSize()
+
+	if order.Side == SideBuy {
+		best, ok := ob.Asks.BestPrice()
+		if !ok {
+			return 0
+		}
+		return best + band
+	}
+	best, ok := ob.Bids.BestPrice()
+	if !ok {
+		return 0
+	}
+	limit := best - band
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+func (ob *OrderBook) tickSize() int64 {
+	if ob.instrument != nil {
+		return ob.instrument.TickSize
+	}
+	if ob.MarketConfig.TickSize <= 0 {
+		return 1
+	}
+	return ob.MarketConfig.TickSize
+}
+
+// postOnlyPrice returns the price a post-only order may rest at instead of
+// price: price itself if it doesn't cross the book, otherwise one tick
+// behind the best opposite price if the order reprices.
+func (ob *OrderBook) postOnlyPrice(order *Order, price int64) (int64, error) {
+	if order.Side == SideBuy {
+		best, ok := ob.Asks.BestPrice()
+		if !ok || price < best {
+			return price, nil
+		}
+		if order.PostOnly == PostOnlyReprice && best-ob.tickSize() > 0 {
+			return best - ob.tickSize(), nil
 		}
+		return 0, utils.ErrPostOnlyWouldCross
 	}
 
-	for ob.Asks.Len() > 0 && order.Filled < order.Quantity {
-		bestAsk := ob.Asks[0]
+	best, ok := ob.Bids.BestPrice()
+	if !ok || price > best {
+		return price, nil
+	}
+	if order.PostOnly == PostOnlyReprice {
+		return best + ob.tickSize(), nil
+	}
+	return 0, utils.ErrPostOnlyWouldCross
+}
 
-		if order.Type == OrderTypeLimit && order.Price < bestAsk.Price {
+// dropExpired expires any orders sitting at the top of either side so the
+// best prices seen on arrival are live ones.
+func (ob *OrderBook) dropExpired(now int64) {
+	for best := ob.Bids.Best(); best != nil && best.isExpired(now); best = ob.Bids.Best() {
+		ob.expireOrder(best, now)
+	}
+	for best := ob.Asks.Best(); best != nil && best.isExpired(now); best = ob.Asks.Best() {
+		ob.expireOrder(best, now)
+	}
+}
+
+// fillable returns how much of order could execute against the opposite
+// side right now without trading through limit, and what it would trade
+// for, math.MaxInt64 if that overflows.
+func (ob *OrderBook) fillable(order *Order, limit int64, now int64) (quantity, cost int64) {
+	resting := ob.Bids
+	if order.Side == SideBuy {
+		resting = ob.Asks
+	}
+
+	resting.Each(func(o *Order) bool {
+		if limit > 0 {
+			if order.Side == SideBuy && o.Price > limit {
+				return false
+			}
+			if order.Side == SideSell && o.Price < limit {
+				return false
+			}
+		}
+		if ref, _ := ob.breakerReference(o.Price, now); ob.outsideBand(o.Price, ref) {
+			return false
+		}
+		if order.selfTrades(o) {
+			// Only cancelling the resting order lets matching carry on past it
+			return order.SelfTradePrevention == STPCancelOldest
+		}
+		if !o.isExpired(now) {
+			qty := o.Quantity - o.Filled
+			if qty > order.Quantity-quantity {
+				qty = order.Quantity - quantity
+			}
+			quantity += qty
+			// The resting order was checked on entry, so only the sum can
+			// overflow
+			if value := o.Price * qty; cost > math.MaxInt64-value {
+				cost = math.MaxInt64
+			} else {
+				cost += value
+			}
+		}
+		return quantity < order.Quantity
+	})
+	return quantity, cost
+}
+
+func (ob *OrderBook) matchBuyOrder(order *Order, limit int64, now int64, newTradeID func() string) ([]Trade, error) {
+	trades := []Trade{}
+
+	for order.Filled < order.Quantity {
+		bestAsk := ob.Asks.Best()
+		if bestAsk == nil {
+			break
+		}
+
+		if bestAsk.isExpired(now) {
+			ob.expireOrder(bestAsk, now)
+			continue
+		}
+
+		if limit > 0 && limit < bestAsk.Price {
+			break
+		}
+		if ob.breaches(bestAsk.Price, now) {
+			ob.trip(now)
 			break
 		}
 
+		if order.selfTrades(bestAsk) {
+			if ob.preventSelfTrade(order, bestAsk, now) {
+				break
+			}
+			continue
+		}
+
 		matchQty := order.Quantity - order.Filled
-		if matchQty > bestAsk.Quantity-bestAsk.Filled {
-			matchQty = bestAsk.Quantity - bestAsk.Filled
+		if matchQty > bestAsk.available() {
+			matchQty = bestAsk.available()
 		}
 
 		trade := Trade{
-			ID:           utils.GenerateUUID(), // We need a UUID generator
+			ID:           newTradeID(),
 			Price:        bestAsk.Price,
 			Quantity:     matchQty,
-			Timestamp:    time.Now().UnixMilli(),
+			Timestamp:    now,
 			MakerOrderID: bestAsk.ID,
 			TakerOrderID: order.ID,
 		}
@@ -161,176 +475,384 @@ func (ob *OrderBook) matchBuyOrder(order *Order) ([]Trade, error) {
 
 		// Update orders
 		order.Filled += matchQty
-		bestAsk.Filled += matchQty
-		ob.TotalAskLiquidity -= matchQty
-
-		// If bestAsk filled, remove it
-		if bestAsk.Filled >= bestAsk.Quantity {
-			bestAsk.Status = OrderStatusFilled
-			heap.Pop(&ob.Asks)
-			// delete(ob.Orders, bestAsk.ID) // Keep for history
-		} else {
-			bestAsk.Status = OrderStatusPartialFill
-		}
-	}
+		ob.fillResting(bestAsk, matchQty)
 
-	if order.Filled >= order.Quantity {
-		order.Status = OrderStatusFilled
+		ob.accounts.settle(order, bestAsk, &trade, ob.baseAsset, ob.quoteAsset)
+		ob.reportFill(bestAsk, &trade)
+		ob.reportFill(order, &trade)
+		if ob.listening() {
+			ob.emit(EventTradeExecuted, now, &TradeExecuted{Trade: trade})
+		}
 	}
 
 	return trades, nil
 }
 
-func (ob *OrderBook) matchSellOrder(order *Order) ([]Trade, error) {
+func (ob *OrderBook) matchSellOrder(order *Order, limit int64, now int64, newTradeID func() string) ([]Trade, error) {
 	trades := []Trade{}
 
-	// For Market Order, check liquidity first
-	if order.Type == OrderTypeMarket {
-		if !ob.hasLiquidity(SideBuy, order.Quantity) {
-			return nil, utils.ErrInsufficientLiquidity
+	for order.Filled < order.Quantity {
+		bestBid := ob.Bids.Best()
+		if bestBid == nil {
+			break
 		}
-	}
 
-	for ob.Bids.Len() > 0 && order.Filled < order.Quantity {
-		bestBid := ob.Bids[0]
+		if bestBid.isExpired(now) {
+			ob.expireOrder(bestBid, now)
+			continue
+		}
 
-		// Price check for Limit orders
-		if order.Type == OrderTypeLimit && order.Price > bestBid.Price {
+		// Price check for Limit orders and protected market orders
+		if limit > 0 && limit > bestBid.Price {
 			break
 		}
+		if ob.breaches(bestBid.Price, now) {
+			ob.trip(now)
+			break
+		}
+
+		if order.selfTrades(bestBid) {
+			if ob.preventSelfTrade(order, bestBid, now) {
+				break
+			}
+			continue
+		}
 
 		// Match
 		matchQty := order.Quantity - order.Filled
-		if matchQty > bestBid.Quantity-bestBid.Filled {
-			matchQty = bestBid.Quantity - bestBid.Filled
+		if matchQty > bestBid.available() {
+			matchQty = bestBid.available()
 		}
 
 		trade := Trade{
-			ID:           utils.GenerateUUID(),
+			ID:           newTradeID(),
 			Price:        bestBid.Price,
 			Quantity:     matchQty,
-			Timestamp:    time.Now().UnixMilli(),
+			Timestamp:    now,
 			MakerOrderID: bestBid.ID,
 			TakerOrderID: order.ID,
 		}
 		trades = append(trades, trade)
 
 		order.Filled += matchQty
-		bestBid.Filled += matchQty
-		ob.TotalBidLiquidity -= matchQty
+		ob.fillResting(bestBid, matchQty)
 
-		if bestBid.Filled >= bestBid.Quantity {
-			bestBid.Status = OrderStatusFilled
-			heap.Pop(&ob.Bids)
-		} else {
-			bestBid.Status = OrderStatusPartialFill
+		ob.accounts.settle(bestBid, order, &trade, ob.baseAsset, ob.quoteAsset)
+		ob.reportFill(bestBid, &trade)
+		ob.reportFill(order, &trade)
+		if ob.listening() {
+			ob.emit(EventTradeExecuted, now, &TradeExecuted{Trade: trade})
 		}
 	}
 
+	return trades, nil
+}
+
+// fillResting books qty traded by a resting order, taking it off the book
+// once filled. An iceberg whose peak is used up shows a new peak from its
+// reserve at the back of the queue.
+func (ob *OrderBook) fillResting(order *Order, qty int64) {
+	side := ob.side(order.Side)
+	visible := order.visible()
+	order.Filled += qty
+	if order.DisplayQuantity > 0 {
+		order.PeakRemaining -= qty
+	}
+	side.reduce(order, visible-order.visible())
+	ob.adjustLiquidity(order, -qty)
+
 	if order.Filled >= order.Quantity {
-		order.Status = OrderStatusFilled
+		ob.unlink(order)
+		// delete(ob.Orders, order.ID) // Keep for history
+		return
+	}
+	if order.DisplayQuantity > 0 && order.PeakRemaining == 0 {
+		side.remove(order)
+		ob.queue(order)
 	}
+}
 
-	return trades, nil
+// reportFill moves order to PARTIAL_FILL or FILLED after trade.
+func (ob *OrderBook) reportFill(order *Order, trade *Trade) {
+	status := OrderStatusPartialFill
+	if order.Filled >= order.Quantity {
+		status = OrderStatusFilled
+	}
+	ob.setStatus(order, status, ExecTypeTrade, trade.Timestamp, trade)
 }
 
-func (ob *OrderBook) addOrder(order *Order) {
-	ob.Orders[order.ID] = order
-	remaining := order.Quantity - order.Filled
-	if order.Side == SideBuy {
-		ob.TotalBidLiquidity += remaining
-		heap.Push(&ob.Bids, order)
+// cancelRemainder cancels the open quantity of an order that won't rest.
+func (ob *OrderBook) cancelRemainder(order *Order, now int64) {
+	if ob.TradingState == TradingHalted {
+		order.CancelReason = CircuitBreakerReason
+	}
+	if order.Filled > 0 {
+		ob.setStatus(order, OrderStatusPartialFillCancelled, ExecTypeCanceled, now, nil)
 	} else {
-		ob.TotalAskLiquidity += remaining
-		heap.Push(&ob.Asks, order)
+		ob.setStatus(order, OrderStatusCancelled, ExecTypeCanceled, now, nil)
 	}
 }
 
-func (ob *OrderBook) CancelOrder(orderID string) error {
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
+func (ob *OrderBook) addOrder(order *Order) {
+	ob.Orders[order.ID] = order
+	ob.queue(order)
+	ob.accounts.trackOpen(order, 1)
+	ob.adjustLiquidity(order, order.Quantity-order.Filled)
+}
 
-	order, ok := ob.Orders[orderID]
-	if !ok {
-		return utils.ErrOrderNotFound
+// queue puts order at the back of its price level with fresh time priority,
+// showing a new peak if it is an iceberg.
+func (ob *OrderBook) queue(order *Order) {
+	if order.DisplayQuantity > 0 {
+		order.PeakRemaining = min(order.DisplayQuantity, order.Quantity-order.Filled)
 	}
+	ob.lastSequence++
+	order.Sequence = ob.lastSequence
+	ob.side(order.Side).add(order)
+}
 
-	remaining := order.Quantity - order.Filled
-	if order.Side == SideBuy {
-		ob.TotalBidLiquidity -= remaining
-		heap.Remove(&ob.Bids, order.HeapIndex)
-	} else {
-		ob.TotalAskLiquidity -= remaining
-		heap.Remove(&ob.Asks, order.HeapIndex)
+// shrink takes qty off a resting order's quantity, from its hidden reserve
+// first if it is an iceberg.
+func (ob *OrderBook) shrink(order *Order, qty int64) {
+	visible := order.visible()
+	order.Quantity -= qty
+	if order.DisplayQuantity > 0 {
+		order.PeakRemaining = min(order.PeakRemaining, order.Quantity-order.Filled)
 	}
+	ob.side(order.Side).reduce(order, visible-order.visible())
+	ob.adjustLiquidity(order, -qty)
+}
 
-	order.Status = OrderStatusCancelled
-	return nil
+// unlink takes a resting order off its side of the book.
+func (ob *OrderBook) unlink(order *Order) {
+	ob.side(order.Side).remove(order)
+	ob.accounts.trackOpen(order, -1)
 }
 
-func (ob *OrderBook) hasLiquidity(side Side, quantity int64) bool {
+func (ob *OrderBook) side(side Side) *BookSide {
 	if side == SideBuy {
-		return ob.TotalBidLiquidity >= quantity
+		return ob.Bids
 	}
-	return ob.TotalAskLiquidity >= quantity
+	return ob.Asks
 }
 
-type PriceLevel struct {
-	Price    int64 `json:"price"`
-	Quantity int64 `json:"quantity"`
+func (ob *OrderBook) CancelOrder(orderID string) (err error) {
+	ob.do(func() { err = ob.cancel(orderID, ob.clock.Now()) })
+	return err
 }
 
-type OrderBookSnapshot struct {
-	Symbol    string       `json:"symbol"`
-	Timestamp int64        `json:"timestamp"`
-	Bids      []PriceLevel `json:"bids"`
-	Asks      []PriceLevel `json:"asks"`
+func (ob *OrderBook) cancelOrderAt(orderID string, now int64) (err error) {
+	ob.do(func() { err = ob.cancel(orderID, now) })
+	return err
+}
+
+func (ob *OrderBook) cancel(orderID string, now int64) error {
+	order, ok := ob.Orders[orderID]
+	if !ok {
+		return utils.ErrOrderNotFound
+	}
+	if !order.isOpen() {
+		return utils.ErrOrderNotOpen
+	}
+
+	ob.removeOrder(order)
+	ob.setStatus(order, OrderStatusCancelled, ExecTypeCanceled, now, nil)
+	ob.publish(now, nil)
+	return nil
 }
 
-func (ob *OrderBook) GetSnapshot(depth int) OrderBookSnapshot {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
+func (ob *OrderBook) AmendOrder(orderID string, price, quantity int64) (trades []Trade, err error) {
+	ob.do(func() { trades, err = ob.amend(orderID, price, quantity, ob.clock.Now(), ob.ids.NewID) })
+	return trades, err
+}
 
-	snapshot := OrderBookSnapshot{
-		Symbol:    ob.Symbol,
-		Timestamp: time.Now().UnixMilli(),
-		Bids:      make([]PriceLevel, 0),
-		Asks:      make([]PriceLevel, 0),
+// amendOrderAt changes a resting order's price and/or total quantity; zero
+// leaves a field as it is. A quantity reduction at the same price keeps the
+// order's place in the queue. Any other change requeues it as if it had just
+// arrived, matching it first if the new price crosses the book.
+func (ob *OrderBook) amendOrderAt(orderID string, price, quantity int64, now int64, newTradeID func() string, tape *riskTape) (trades []Trade, err error) {
+	ob.withTape(tape, func() { trades, err = ob.amend(orderID, price, quantity, now, newTradeID) })
+	return trades, err
+}
+
+func (ob *OrderBook) amend(orderID string, price, quantity int64, now int64, newTradeID func() string) (trades []Trade, err error) {
+	order, ok := ob.Orders[orderID]
+	if !ok {
+		return nil, utils.ErrOrderNotFound
+	}
+	if !order.isOpen() || order.isExpired(now) {
+		return nil, utils.ErrOrderNotOpen
+	}
+	if order.Status == OrderStatusPendingTrigger {
+		return nil, utils.ErrAmendPendingStop
+	}
+	if ob.TradingState != TradingOpen && ob.TradingState != TradingAuction {
+		return nil, utils.ErrMarketNotOpen
 	}
 
-	bidMap := make(map[int64]int64)
-	for _, order := range ob.Bids {
-		bidMap[order.Price] += (order.Quantity - order.Filled)
+	if price == 0 {
+		price = order.Price
+	}
+	if quantity == 0 {
+		quantity = order.Quantity
+	}
+	if price < 0 {
+		return nil, utils.ErrInvalidPrice
+	}
+	if quantity <= order.Filled {
+		return nil, utils.ErrInvalidQuantity
+	}
+	if inst := ob.instrument; inst != nil {
+		if err := inst.checkPrice(price); err != nil {
+			return nil, err
+		}
+		if err := inst.checkQuantity(quantity); err != nil {
+			return nil, err
+		}
+	}
+	if order.PostOnly != "" {
+		if price, err = ob.postOnlyPrice(order, price); err != nil {
+			return nil, err
+		}
+	}
+	if price == order.Price && quantity == order.Quantity {
+		return nil, utils.ErrAmendUnchanged
 	}
-	for price, qty := range bidMap {
-		snapshot.Bids = append(snapshot.Bids, PriceLevel{Price: price, Quantity: qty})
+	if _, ok := notional(price, quantity); !ok {
+		return nil, utils.ErrNotionalOverflow
+	}
+	if err := ob.accounts.readmit(order, price, quantity, ob.baseAsset, ob.quoteAsset, ob.risk); err != nil {
+		return nil, err
 	}
-	sortPriceLevels(snapshot.Bids, true)
 
-	askMap := make(map[int64]int64)
-	for _, order := range ob.Asks {
-		askMap[order.Price] += (order.Quantity - order.Filled)
+	defer func() { ob.publish(now, trades) }()
+
+	if price == order.Price && quantity < order.Quantity {
+		ob.shrink(order, order.Quantity-quantity)
+		ob.setStatus(order, order.Status, ExecTypeReplaced, now, nil)
+		return nil, nil
 	}
-	for price, qty := range askMap {
-		snapshot.Asks = append(snapshot.Asks, PriceLevel{Price: price, Quantity: qty})
+
+	ob.removeOrder(order)
+	order.Price = price
+	order.Quantity = quantity
+	order.SelfTradeCancels = nil
+	ob.setStatus(order, order.Status, ExecTypeReplaced, now, nil)
+	if ob.TradingState == TradingAuction {
+		ob.addOrder(order)
+		return nil, nil
 	}
-	sortPriceLevels(snapshot.Asks, false)
 
-	if len(snapshot.Bids) > depth {
-		snapshot.Bids = snapshot.Bids[:depth]
+	ob.dropExpired(now)
+	if order.Side == SideBuy {
+		trades, err = ob.matchBuyOrder(order, price, now, newTradeID)
+	} else {
+		trades, err = ob.matchSellOrder(order, price, now, newTradeID)
 	}
-	if len(snapshot.Asks) > depth {
-		snapshot.Asks = snapshot.Asks[:depth]
+	if err != nil {
+		return nil, err
 	}
+	if len(trades) > 0 {
+		ob.LastTradePrice = trades[len(trades)-1].Price
+	}
+	if order.Quantity > order.Filled && order.isOpen() {
+		if ob.TradingState == TradingOpen {
+			ob.addOrder(order)
+		} else {
+			ob.cancelRemainder(order, now)
+		}
+	}
+	return append(trades, ob.runTriggers(now, newTradeID)...), nil
+}
 
-	return snapshot
+// ExpireOrders removes every resting DAY/GTD order whose expiry is at or
+// before now and returns them.
+func (ob *OrderBook) ExpireOrders(now int64) (expired []*Order) {
+	ob.do(func() {
+		expired = ob.expireAll(now)
+		ob.publish(now, nil)
+	})
+	return expired
 }
 
-func sortPriceLevels(levels []PriceLevel, descending bool) {
-	sort.Slice(levels, func(i, j int) bool {
-		if descending {
-			return levels[i].Price > levels[j].Price
+func (ob *OrderBook) expireAll(now int64) []*Order {
+	var expired []*Order
+	for _, order := range ob.Orders {
+		if order.isOpen() && order.isExpired(now) {
+			expired = append(expired, order)
 		}
-		return levels[i].Price < levels[j].Price
-	})
+	}
+	for _, order := range expired {
+		ob.expireOrder(order, now)
+	}
+	return expired
+}
+
+func (ob *OrderBook) expireOrder(order *Order, now int64) {
+	ob.removeOrder(order)
+	ob.setStatus(order, OrderStatusExpired, ExecTypeExpired, now, nil)
+}
+
+func (ob *OrderBook) removeOrder(order *Order) {
+	switch order.Status {
+	case OrderStatusPendingTrigger:
+		ob.stops(order.Side).remove(order)
+		return
+	case OrderStatusQueued:
+		ob.dequeue(order)
+		return
+	}
+	ob.unlink(order)
+	ob.adjustLiquidity(order, -(order.Quantity - order.Filled))
+}
+
+// adjustLiquidity keeps the side totals in step with the levels and records
+// order's level as changed for market data, unless the order is hidden.
+func (ob *OrderBook) adjustLiquidity(order *Order, delta int64) {
+	if order.Side == SideBuy {
+		ob.TotalBidLiquidity += delta
+	} else {
+		ob.TotalAskLiquidity += delta
+	}
+	if !order.Hidden {
+		ob.feed.touch(order.Side, order.Price)
+	}
+}
+
+type PriceLevel struct {
+	Price    int64 `json:"price"`
+	Quantity int64 `json:"quantity"`
+}
+
+type OrderBookSnapshot struct {
+	Symbol string       `json:"symbol"`
+	State  TradingState `json:"state"`
+	// Auction is the indicative uncrossing price and volume during an auction
+	Auction        *AuctionIndicative `json:"auction,omitempty"`
+	Seq            uint64             `json:"seq"` // Market data sequence number the snapshot reflects
+	Timestamp      int64              `json:"timestamp"`
+	PricePrecision int                `json:"price_precision"`
+	Bids           []PriceLevel       `json:"bids"`
+	Asks           []PriceLevel       `json:"asks"`
+}
+
+func (ob *OrderBook) GetSnapshot(depth int) (snap OrderBookSnapshot) {
+	ob.do(func() { snap = ob.snapshot(depth) })
+	return snap
+}
+
+func (ob *OrderBook) snapshot(depth int) OrderBookSnapshot {
+	snap := OrderBookSnapshot{
+		Symbol:         ob.Symbol,
+		State:          ob.TradingState,
+		Seq:            ob.feed.seq,
+		Timestamp:      ob.clock.Now(),
+		PricePrecision: ob.pricePrecision(),
+		Bids:           ob.Bids.Depth(depth),
+		Asks:           ob.Asks.Depth(depth),
+	}
+	if eq, ok := ob.indicative(); ok {
+		snap.Auction = &eq
+	}
+	return snap
 }
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func postOnlyOrder(side Side, price, qty int64, mode PostOnly) *Order {
+	o := limitOrder(side, price, qty)
+	o.PostOnly = mode
+	return o
+}
+
+func TestPostOnly(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 101, 5))
+	eng.SubmitOrder(limitOrder(SideBuy, 99, 5))
+
+	crossing := postOnlyOrder(SideBuy, 101, 5, PostOnlyReject)
+	if _, err := eng.SubmitOrder(crossing); err != utils.ErrPostOnlyWouldCross {
+		t.Fatalf("expected crossing post-only order to be rejected, got %v", err)
+	}
+	if crossing.Status != OrderStatusRejected {
+		t.Errorf("expected REJECTED, got %s", crossing.Status)
+	}
+
+	passive := postOnlyOrder(SideBuy, 100, 5, PostOnlyReject)
+	if trades, err := eng.SubmitOrder(passive); err != nil || len(trades) != 0 || passive.Status != OrderStatusAccepted {
+		t.Fatalf("expected passive post-only order to rest, got %v %s", err, passive.Status)
+	}
+
+	buy := postOnlyOrder(SideBuy, 105, 5, PostOnlyReprice)
+	if trades, err := eng.SubmitOrder(buy); err != nil || len(trades) != 0 {
+		t.Fatalf("expected repriced buy to rest without trading, got %v %v", trades, err)
+	}
+	if buy.Price != 100 {
+		t.Errorf("expected buy repriced one tick below the best ask, got %d", buy.Price)
+	}
+	sell := postOnlyOrder(SideSell, 90, 5, PostOnlyReprice)
+	eng.SubmitOrder(sell)
+	if sell.Price != 101 {
+		t.Errorf("expected sell repriced one tick above the best bid, got %d", sell.Price)
+	}
+
+	if _, err := eng.AmendOrder(passive.ID, 102, 0); err != utils.ErrPostOnlyWouldCross {
+		t.Errorf("expected amend through the book to be rejected, got %v", err)
+	}
+	if passive.Price != 100 || passive.Status != OrderStatusAccepted {
+		t.Errorf("expected rejected amend to leave the order alone")
+	}
+
+	ioc := postOnlyOrder(SideBuy, 95, 5, PostOnlyReject)
+	ioc.TimeInForce = TimeInForceIOC
+	if _, err := eng.SubmitOrder(ioc); err != utils.ErrInvalidPostOnly {
+		t.Errorf("expected post-only IOC to be invalid, got %v", err)
+	}
+}
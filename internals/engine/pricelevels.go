@@ -0,0 +1,276 @@
+package engine
+
+// A BookSide holds one side of an order book as price levels kept in a skip
+// list ordered best price first. Each level is a FIFO queue of orders linked
+// through the orders themselves, with the level's aggregate open quantity
+// cached, so the best order is O(1), removing any order is O(1) plus
+// O(log n) when its level empties, and depth snapshots only visit the
+// levels they return. Hidden orders queue behind every displayed order at
+// their level.
+
+const (
+	maxSkipHeight = 16
+	skipBranching = 4 // 1 in skipBranching nodes is promoted a level
+)
+
+type priceLevel struct {
+	price    int64
+	quantity int64 // Sum of visible quantity of the orders in the queue
+	count    int
+	head     *Order
+	tail     *Order
+	hidden   *Order // First hidden order in the queue, if any
+
+	next []*priceLevel // Skip list forward pointers, one per height
+}
+
+type BookSide struct {
+	side Side
+	// stops keys orders by StopPrice instead of Price, for trigger books
+	stops   bool
+	header  priceLevel
+	height  int
+	byPrice map[int64]*priceLevel
+	orders  int
+	rng     uint64
+}
+
+func newBookSide(side Side) *BookSide {
+	return &BookSide{
+		side:    side,
+		header:  priceLevel{next: make([]*priceLevel, maxSkipHeight)},
+		height:  1,
+		byPrice: make(map[int64]*priceLevel),
+		rng:     0x9e3779b97f4a7c15,
+	}
+}
+
+// newStopSide returns a trigger book for stop orders on side, ordered by
+// which stop the last trade price reaches first: the lowest buy stop, the
+// highest sell stop.
+func newStopSide(side Side) *BookSide {
+	s := newBookSide(side)
+	s.stops = true
+	return s
+}
+
+func (s *BookSide) key(order *Order) int64 {
+	if s.stops {
+		return order.StopPrice
+	}
+	return order.Price
+}
+
+// better reports whether price a has priority over price b on this side.
+func (s *BookSide) better(a, b int64) bool {
+	if (s.side == SideBuy) != s.stops {
+		return a > b
+	}
+	return a < b
+}
+
+// Len returns the number of resting orders.
+func (s *BookSide) Len() int { return s.orders }
+
+// Levels returns the number of price levels.
+func (s *BookSide) Levels() int { return len(s.byPrice) }
+
+// Best returns the order with the highest priority, or nil if the side is empty.
+func (s *BookSide) Best() *Order {
+	if lvl := s.header.next[0]; lvl != nil {
+		return lvl.head
+	}
+	return nil
+}
+
+// BestPrice returns the best price and whether there is one.
+func (s *BookSide) BestPrice() (int64, bool) {
+	if lvl := s.header.next[0]; lvl != nil {
+		return lvl.price, true
+	}
+	return 0, false
+}
+
+// Quantity returns the aggregate open quantity at price.
+func (s *BookSide) Quantity(price int64) int64 {
+	if lvl, ok := s.byPrice[price]; ok {
+		return lvl.quantity
+	}
+	return 0
+}
+
+// top returns the best level with displayed quantity, or a zero level.
+func (s *BookSide) top() PriceLevel {
+	for lvl := s.header.next[0]; lvl != nil; lvl = lvl.next[0] {
+		if lvl.quantity > 0 {
+			return PriceLevel{Price: lvl.price, Quantity: lvl.quantity}
+		}
+	}
+	return PriceLevel{}
+}
+
+// Depth returns up to n levels with displayed quantity, best first. n <= 0
+// returns every level.
+func (s *BookSide) Depth(n int) []PriceLevel {
+	size := len(s.byPrice)
+	if n > 0 && n < size {
+		size = n
+	}
+	levels := make([]PriceLevel, 0, size)
+	for lvl := s.header.next[0]; lvl != nil && len(levels) < size; lvl = lvl.next[0] {
+		if lvl.quantity > 0 {
+			levels = append(levels, PriceLevel{Price: lvl.price, Quantity: lvl.quantity})
+		}
+	}
+	return levels
+}
+
+// Each calls fn for every resting order in priority order until fn returns false.
+func (s *BookSide) Each(fn func(*Order) bool) {
+	for lvl := s.header.next[0]; lvl != nil; lvl = lvl.next[0] {
+		for o := lvl.head; o != nil; o = o.next {
+			if !fn(o) {
+				return
+			}
+		}
+	}
+}
+
+// add queues order at the back of its price level, or for a displayed
+// order, ahead of the level's hidden orders.
+func (s *BookSide) add(order *Order) {
+	price := s.key(order)
+	lvl, ok := s.byPrice[price]
+	if !ok {
+		lvl = s.insertLevel(price)
+	}
+
+	order.level = lvl
+	if h := lvl.hidden; h != nil && !order.Hidden {
+		order.prev = h.prev
+		order.next = h
+		if h.prev != nil {
+			h.prev.next = order
+		} else {
+			lvl.head = order
+		}
+		h.prev = order
+	} else {
+		order.prev = lvl.tail
+		order.next = nil
+		if lvl.tail != nil {
+			lvl.tail.next = order
+		} else {
+			lvl.head = order
+		}
+		lvl.tail = order
+		if order.Hidden && lvl.hidden == nil {
+			lvl.hidden = order
+		}
+	}
+	lvl.count++
+	lvl.quantity += order.visible()
+	s.orders++
+}
+
+// remove unlinks order from its level, dropping the level if it empties.
+func (s *BookSide) remove(order *Order) {
+	lvl := order.level
+	if lvl == nil {
+		return
+	}
+	if lvl.hidden == order {
+		lvl.hidden = order.next
+	}
+
+	if order.prev != nil {
+		order.prev.next = order.next
+	} else {
+		lvl.head = order.next
+	}
+	if order.next != nil {
+		order.next.prev = order.prev
+	} else {
+		lvl.tail = order.prev
+	}
+	lvl.count--
+	lvl.quantity -= order.visible()
+	s.orders--
+	order.prev, order.next, order.level = nil, nil, nil
+
+	if lvl.count == 0 {
+		s.deleteLevel(lvl.price)
+	}
+}
+
+// reduce records that a resting order's visible quantity shrank by qty.
+func (s *BookSide) reduce(order *Order, qty int64) {
+	if order.level != nil {
+		order.level.quantity -= qty
+	}
+}
+
+func (s *BookSide) randomHeight() int {
+	// xorshift64: cheap, and the heights only affect performance
+	h := 1
+	for h < maxSkipHeight {
+		s.rng ^= s.rng << 13
+		s.rng ^= s.rng >> 7
+		s.rng ^= s.rng << 17
+		if s.rng%skipBranching != 0 {
+			break
+		}
+		h++
+	}
+	return h
+}
+
+func (s *BookSide) insertLevel(price int64) *priceLevel {
+	var update [maxSkipHeight]*priceLevel
+	x := &s.header
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.better(x.next[i].price, price) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	h := s.randomHeight()
+	if h > s.height {
+		for i := s.height; i < h; i++ {
+			update[i] = &s.header
+		}
+		s.height = h
+	}
+
+	lvl := &priceLevel{price: price, next: make([]*priceLevel, h)}
+	for i := 0; i < h; i++ {
+		lvl.next[i] = update[i].next[i]
+		update[i].next[i] = lvl
+	}
+	s.byPrice[price] = lvl
+	return lvl
+}
+
+func (s *BookSide) deleteLevel(price int64) {
+	var update [maxSkipHeight]*priceLevel
+	x := &s.header
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && s.better(x.next[i].price, price) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	lvl := x.next[0]
+	if lvl == nil || lvl.price != price {
+		return
+	}
+	for i := 0; i < len(lvl.next); i++ {
+		update[i].next[i] = lvl.next[i]
+	}
+	for s.height > 1 && s.header.next[s.height-1] == nil {
+		s.height--
+	}
+	delete(s.byPrice, price)
+}
@@ -0,0 +1,112 @@
+package engine
+
+// SelfTradePrevention decides what happens when an incoming order would
+// trade against a resting order with the same owner. The incoming order's
+// mode applies; an order without one takes its account's default.
+type SelfTradePrevention string
+
+const (
+	STPNone SelfTradePrevention = "NONE"
+	// Cancel the incoming order's remaining quantity.
+	STPCancelNewest SelfTradePrevention = "CANCEL_NEWEST"
+	// Cancel the resting order and keep matching.
+	STPCancelOldest SelfTradePrevention = "CANCEL_OLDEST"
+	STPCancelBoth   SelfTradePrevention = "CANCEL_BOTH"
+	// Reduce both orders by the smaller open quantity without trading,
+	// cancelling whichever (or both) reaches zero.
+	STPDecrementAndCancel SelfTradePrevention = "DECREMENT_AND_CANCEL"
+)
+
+// SelfTradeReason is the execution report reason for STP cancellations.
+const SelfTradeReason = "self-trade prevention"
+
+func (m SelfTradePrevention) Valid() bool {
+	switch m {
+	case "", STPNone, STPCancelNewest, STPCancelOldest, STPCancelBoth, STPDecrementAndCancel:
+		return true
+	}
+	return false
+}
+
+// owner identifies who an order belongs to for self-trade prevention.
+func (o *Order) owner() string {
+	if o.AccountID != "" {
+		return o.AccountID
+	}
+	return o.ClientID
+}
+
+func (o *Order) selfTrades(resting *Order) bool {
+	if o.SelfTradePrevention == "" || o.SelfTradePrevention == STPNone {
+		return false
+	}
+	owner := o.owner()
+	return owner != "" && owner == resting.owner()
+}
+
+// preventSelfTrade applies taker's mode against maker, a resting order with
+// the same owner, and reports whether the taker is finished. Resting orders
+// it cancels are listed in the taker's SelfTradeCancels.
+func (ob *OrderBook) preventSelfTrade(taker, maker *Order, now int64) bool {
+	cancelMaker := func() {
+		ob.removeOrder(maker)
+		ob.cancelSelfTrade(maker, OrderStatusCancelled, now)
+		taker.SelfTradeCancels = append(taker.SelfTradeCancels, maker.ID)
+	}
+	cancelTaker := func() {
+		status := OrderStatusCancelled
+		if taker.Filled > 0 {
+			status = OrderStatusPartialFillCancelled
+		}
+		ob.cancelSelfTrade(taker, status, now)
+	}
+
+	switch taker.SelfTradePrevention {
+	case STPCancelNewest:
+		cancelTaker()
+		return true
+	case STPCancelOldest:
+		cancelMaker()
+		return false
+	case STPCancelBoth:
+		cancelMaker()
+		cancelTaker()
+		return true
+	}
+
+	// Decrement and cancel
+	takerOpen := taker.Quantity - taker.Filled
+	makerOpen := maker.Quantity - maker.Filled
+	switch {
+	case takerOpen > makerOpen:
+		cancelMaker()
+		ob.decrement(taker, makerOpen, now)
+		return false
+	case takerOpen < makerOpen:
+		ob.decrement(maker, takerOpen, now)
+		cancelTaker()
+		return true
+	default:
+		cancelMaker()
+		cancelTaker()
+		return true
+	}
+}
+
+func (ob *OrderBook) cancelSelfTrade(order *Order, status OrderStatus, now int64) {
+	order.Status = status
+	order.CancelReason = SelfTradeReason
+	ob.accounts.releaseAll(order, ob.baseAsset, ob.quoteAsset)
+	ob.emitOrder(order, ExecTypeCanceled, now, nil, SelfTradeReason)
+}
+
+// decrement reduces order's quantity by qty without trading.
+func (ob *OrderBook) decrement(order *Order, qty int64, now int64) {
+	if order.level != nil {
+		ob.shrink(order, qty)
+	} else {
+		order.Quantity -= qty
+	}
+	ob.accounts.release(order, reserveAmount(order, qty, 0), ob.baseAsset, ob.quoteAsset)
+	ob.emitOrder(order, ExecTypeReplaced, now, nil, SelfTradeReason)
+}
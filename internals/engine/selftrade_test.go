@@ -0,0 +1,117 @@
+package engine
+
+import "testing"
+
+func ownedOrder(owner string, side Side, price, qty int64, mode SelfTradePrevention) *Order {
+	o := limitOrder(side, price, qty)
+	o.ClientID = owner
+	o.SelfTradePrevention = mode
+	return o
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	cases := []struct {
+		mode                     SelfTradePrevention
+		takerQty                 int64
+		takerStatus, makerStatus OrderStatus
+		takerQtyAfter            int64
+		makerQtyAfter            int64
+		trades                   int
+	}{
+		// The resting book is: own ask 100x3, then another owner's ask 101x5
+		{STPNone, 4, OrderStatusFilled, OrderStatusFilled, 4, 3, 2},
+		{STPCancelNewest, 4, OrderStatusCancelled, OrderStatusAccepted, 4, 3, 0},
+		{STPCancelOldest, 4, OrderStatusFilled, OrderStatusCancelled, 4, 3, 1},
+		{STPCancelBoth, 4, OrderStatusCancelled, OrderStatusCancelled, 4, 3, 0},
+		// Taker larger: the resting order is cancelled and the taker decremented
+		{STPDecrementAndCancel, 4, OrderStatusFilled, OrderStatusCancelled, 1, 3, 1},
+		// Taker smaller: the taker is cancelled and the resting order decremented
+		{STPDecrementAndCancel, 2, OrderStatusCancelled, OrderStatusAccepted, 2, 1, 0},
+	}
+
+	for _, c := range cases {
+		eng := NewEngine()
+		reports := &recordingReports{}
+		eng.Subscribe(reports)
+
+		maker := ownedOrder("mm", SideSell, 100, 3, "")
+		other := ownedOrder("other", SideSell, 101, 5, "")
+		eng.SubmitOrder(maker)
+		eng.SubmitOrder(other)
+
+		taker := ownedOrder("mm", SideBuy, 101, c.takerQty, c.mode)
+		taker.TimeInForce = TimeInForceIOC
+		trades, err := eng.SubmitOrder(taker)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.mode, err)
+		}
+		if len(trades) != c.trades {
+			t.Errorf("%s: expected %d trades, got %d", c.mode, c.trades, len(trades))
+		}
+		for _, tr := range trades {
+			if tr.MakerOrderID == maker.ID && c.mode != STPNone {
+				t.Errorf("%s: traded against own order", c.mode)
+			}
+		}
+		if taker.Status != c.takerStatus || taker.Quantity != c.takerQtyAfter {
+			t.Errorf("%s: taker %s qty %d, want %s qty %d", c.mode, taker.Status, taker.Quantity, c.takerStatus, c.takerQtyAfter)
+		}
+		if maker.Status != c.makerStatus || maker.Quantity != c.makerQtyAfter {
+			t.Errorf("%s: maker %s qty %d, want %s qty %d", c.mode, maker.Status, maker.Quantity, c.makerStatus, c.makerQtyAfter)
+		}
+
+		makerCancelled := maker.Status == OrderStatusCancelled
+		if makerCancelled != (len(taker.SelfTradeCancels) == 1) {
+			t.Errorf("%s: expected cancelled resting orders to be listed, got %v", c.mode, taker.SelfTradeCancels)
+		}
+		var resting int64
+		if maker.isOpen() {
+			resting = maker.Quantity - maker.Filled
+		}
+		if got := eng.GetOrderBook("BTCUSD").Asks.Quantity(100); got != resting {
+			t.Errorf("%s: expected %d resting at 100, got %d", c.mode, resting, got)
+		}
+
+		if c.mode == STPNone {
+			continue
+		}
+		var stpReports int
+		for _, r := range reports.reports {
+			if r.Reason == SelfTradeReason {
+				stpReports++
+			}
+		}
+		if stpReports == 0 {
+			t.Errorf("%s: expected execution reports citing self-trade prevention", c.mode)
+		}
+	}
+}
+
+func TestSelfTradePrevention_AccountDefault(t *testing.T) {
+	eng := NewEngine()
+	fundedAccount(t, eng, "mm", 100000, 10, RiskLimits{})
+	if err := eng.SetSelfTradePrevention("mm", STPCancelOldest); err != nil {
+		t.Fatal(err)
+	}
+
+	ask := accountOrder("mm", SideSell, 100, 5)
+	eng.SubmitOrder(ask)
+	bid := accountOrder("mm", SideBuy, 100, 5)
+	trades, _ := eng.SubmitOrder(bid)
+	if len(trades) != 0 || ask.Status != OrderStatusCancelled || bid.Status != OrderStatusAccepted {
+		t.Fatalf("expected account default to cancel the resting order, got %d trades, ask %s, bid %s", len(trades), ask.Status, bid.Status)
+	}
+	if b := balance(t, eng, "mm", "BTCUSD"); b.Reserved != 0 {
+		t.Errorf("expected cancelled ask to release its position, got %+v", b)
+	}
+
+	// FOK can't count on liquidity hidden behind its own order
+	eng.SubmitOrder(accountOrder("mm", SideSell, 101, 2))
+	eng.SubmitOrder(ownedOrder("other", SideSell, 102, 5, ""))
+	fok := accountOrder("mm", SideBuy, 102, 5)
+	fok.SelfTradePrevention = STPCancelNewest
+	fok.TimeInForce = TimeInForceFOK
+	if _, err := eng.SubmitOrder(fok); err == nil || fok.Status != OrderStatusRejected {
+		t.Errorf("expected FOK to be rejected, got %s (%v)", fok.Status, err)
+	}
+}
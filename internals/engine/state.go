@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// StateVersion is bumped whenever EngineState changes incompatibly.
+const StateVersion = 2
+
+// EngineState is a point-in-time copy of everything the engine needs to
+// resume: it reflects exactly the commands up to and including Seq.
+type EngineState struct {
+	Version          int               `json:"version"`
+	Seq              uint64            `json:"seq"`
+	TakenAt          int64             `json:"taken_at"`
+	Books            []BookState       `json:"books"`
+	OrderSymbolIndex map[string]string `json:"order_symbol_index"`
+	Accounts         []Account         `json:"accounts,omitempty"`
+	Instruments      []Instrument      `json:"instruments,omitempty"`
+}
+
+// BookState is the serialisable form of an OrderBook. Bids, Asks and the
+// stop lists hold order IDs in priority order, so a restored book queues
+// them exactly as before.
+type BookState struct {
+	Symbol            string            `json:"symbol"`
+	MarketConfig      MarketOrderConfig `json:"market_config"`
+	TotalBidLiquidity int64             `json:"total_bid_liquidity"`
+	TotalAskLiquidity int64             `json:"total_ask_liquidity"`
+	Orders            []Order           `json:"orders"`
+	Bids              []string          `json:"bids"`
+	Asks              []string          `json:"asks"`
+	BuyStops          []string          `json:"buy_stops,omitempty"`
+	SellStops         []string          `json:"sell_stops,omitempty"`
+	LastTradePrice    int64             `json:"last_trade_price,omitempty"`
+	// Books from before trading states were recorded are open
+	TradingState  TradingState  `json:"trading_state,omitempty"`
+	TradingConfig TradingConfig `json:"trading_config"`
+	HaltedUntil   int64         `json:"halted_until,omitempty"`
+	AuctionUntil  int64         `json:"auction_until,omitempty"`
+	Queued        []string      `json:"queued,omitempty"`
+	BreakerRef    int64         `json:"breaker_ref,omitempty"`
+	BreakerSince  int64         `json:"breaker_since,omitempty"`
+	// Stats are the trade statistics of the last 24 hours
+	Stats []StatsBucket `json:"stats,omitempty"`
+	// FeedSeq and EventSeq are the book's last market data update and last
+	// event, which carry on from here
+	FeedSeq  uint64 `json:"feed_seq,omitempty"`
+	EventSeq uint64 `json:"event_seq,omitempty"`
+	// Adopted are the free funds a handoff brought with the book, until
+	// the move is confirmed
+	Adopted []Funds `json:"adopted,omitempty"`
+}
+
+func (ob *OrderBook) State() (state BookState) {
+	ob.do(func() { state = ob.state() })
+	return state
+}
+
+func (ob *OrderBook) state() BookState {
+	state := BookState{
+		Symbol:            ob.Symbol,
+		MarketConfig:      ob.MarketConfig,
+		TotalBidLiquidity: ob.TotalBidLiquidity,
+		TotalAskLiquidity: ob.TotalAskLiquidity,
+		LastTradePrice:    ob.LastTradePrice,
+		TradingState:      ob.TradingState,
+		TradingConfig:     ob.TradingConfig,
+		HaltedUntil:       ob.HaltedUntil,
+		AuctionUntil:      ob.AuctionUntil,
+		BreakerRef:        ob.breakerRef,
+		BreakerSince:      ob.breakerSince,
+		Stats:             append([]StatsBucket(nil), ob.stats.buckets...),
+		FeedSeq:           ob.feed.seq,
+		EventSeq:          ob.eventSeq,
+		Adopted:           append([]Funds(nil), ob.adopted...),
+		Orders:            make([]Order, 0, len(ob.Orders)),
+		Bids:              make([]string, 0, ob.Bids.Len()),
+		Asks:              make([]string, 0, ob.Asks.Len()),
+	}
+	for _, o := range ob.Orders {
+		state.Orders = append(state.Orders, o.detached())
+	}
+	sort.Slice(state.Orders, func(i, j int) bool { return state.Orders[i].ID < state.Orders[j].ID })
+	ob.Bids.Each(func(o *Order) bool {
+		state.Bids = append(state.Bids, o.ID)
+		return true
+	})
+	ob.Asks.Each(func(o *Order) bool {
+		state.Asks = append(state.Asks, o.ID)
+		return true
+	})
+	ob.BuyStops.Each(func(o *Order) bool {
+		state.BuyStops = append(state.BuyStops, o.ID)
+		return true
+	})
+	ob.SellStops.Each(func(o *Order) bool {
+		state.SellStops = append(state.SellStops, o.ID)
+		return true
+	})
+	for _, o := range ob.queued {
+		state.Queued = append(state.Queued, o.ID)
+	}
+	return state
+}
+
+// restoreOrderBook starts a book from state. A book that can't be restored
+// is closed again.
+func restoreOrderBook(state BookState) (*OrderBook, error) {
+	ob := NewOrderBook(state.Symbol)
+	if err := ob.restore(state); err != nil {
+		ob.Close()
+		return nil, err
+	}
+	return ob, nil
+}
+
+func (ob *OrderBook) restore(state BookState) error {
+	ob.MarketConfig = state.MarketConfig
+	ob.LastTradePrice = state.LastTradePrice
+	if state.TradingState != "" {
+		ob.TradingState = state.TradingState
+		ob.TradingConfig = state.TradingConfig
+	}
+	ob.HaltedUntil = state.HaltedUntil
+	ob.AuctionUntil = state.AuctionUntil
+	ob.breakerRef = state.BreakerRef
+	ob.breakerSince = state.BreakerSince
+	ob.stats.restore(state.Stats)
+	ob.feed.seq = state.FeedSeq
+	ob.eventSeq = state.EventSeq
+	ob.adopted = append([]Funds(nil), state.Adopted...)
+
+	for i := range state.Orders {
+		o := state.Orders[i]
+		ob.Orders[o.ID] = &o
+		if o.Sequence > ob.lastSequence {
+			ob.lastSequence = o.Sequence
+		}
+	}
+	for _, id := range state.Bids {
+		o, ok := ob.Orders[id]
+		if !ok || o.Side != SideBuy || o.level != nil {
+			return utils.ErrJournalCorrupt
+		}
+		ob.Bids.add(o)
+		ob.adjustLiquidity(o, o.Quantity-o.Filled)
+	}
+	for _, id := range state.Asks {
+		o, ok := ob.Orders[id]
+		if !ok || o.Side != SideSell || o.level != nil {
+			return utils.ErrJournalCorrupt
+		}
+		ob.Asks.add(o)
+		ob.adjustLiquidity(o, o.Quantity-o.Filled)
+	}
+	for _, ids := range [][]string{state.BuyStops, state.SellStops} {
+		for _, id := range ids {
+			o, ok := ob.Orders[id]
+			if !ok || o.Status != OrderStatusPendingTrigger || o.level != nil {
+				return utils.ErrJournalCorrupt
+			}
+			ob.stops(o.Side).add(o)
+		}
+	}
+	for _, id := range state.Queued {
+		o, ok := ob.Orders[id]
+		if !ok || o.Status != OrderStatusQueued {
+			return utils.ErrJournalCorrupt
+		}
+		ob.queued = append(ob.queued, o)
+	}
+	if ob.TotalBidLiquidity != state.TotalBidLiquidity || ob.TotalAskLiquidity != state.TotalAskLiquidity {
+		return utils.ErrJournalCorrupt
+	}
+	ob.feed.top = ob.top()
+	return nil
+}
+
+// State captures the engine. While a journal is attached it blocks commands
+// for the duration, so the result corresponds exactly to Seq.
+func (e *Engine) State() *EngineState {
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+
+	books := e.OrderBooks()
+	state := &EngineState{
+		Version:          StateVersion,
+		Seq:              e.seq,
+		TakenAt:          e.clock.Now(),
+		Books:            make([]BookState, 0, len(books)),
+		OrderSymbolIndex: e.orders.all(),
+		Accounts:         e.accounts.state(),
+		Instruments:      e.Instruments(),
+	}
+	for _, ob := range books {
+		state.Books = append(state.Books, ob.State())
+	}
+	return state
+}
+
+// Restore loads state into a freshly created engine. Journal replay then
+// continues from state.Seq.
+func (e *Engine) Restore(state *EngineState) error {
+	if state.Version != StateVersion {
+		return utils.ErrUnsupportedStateVersion
+	}
+
+	e.journalMu.Lock()
+	defer e.journalMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.seq != 0 || len(*e.books.Load()) != 0 || len(e.instruments) != 0 {
+		return utils.ErrEngineNotEmpty
+	}
+
+	instruments := make(map[string]Instrument, len(state.Instruments))
+	for _, inst := range state.Instruments {
+		instruments[inst.Symbol] = inst
+	}
+	books := make(map[string]*OrderBook, len(state.Books))
+	for _, bs := range state.Books {
+		ob, err := restoreOrderBook(bs)
+		if err != nil {
+			for _, restored := range books {
+				restored.Close()
+			}
+			return err
+		}
+		if inst, ok := instruments[bs.Symbol]; ok {
+			ob.setInstrument(inst)
+		}
+		ob.accounts = e.accounts
+		ob.clock, ob.ids = e.clock, e.ids
+		ob.events = e.events
+		books[bs.Symbol] = ob
+	}
+
+	e.books.Store(&books)
+	e.instruments = instruments
+	for id, symbol := range state.OrderSymbolIndex {
+		e.orders.put(id, symbol)
+	}
+	e.accounts.restore(state.Accounts)
+	e.seq = state.Seq
+	return nil
+}
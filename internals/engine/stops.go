@@ -0,0 +1,75 @@
+package engine
+
+// Stop orders wait in the book's trigger books, which are ordered by how soon
+// the last trade price reaches them. A buy stop triggers once the last trade
+// is at or above its stop price, a sell stop once it is at or below.
+
+func (ob *OrderBook) stops(side Side) *BookSide {
+	if side == SideBuy {
+		return ob.BuyStops
+	}
+	return ob.SellStops
+}
+
+// triggered reports whether the last trade price has reached order's stop.
+func (ob *OrderBook) triggered(order *Order) bool {
+	if ob.LastTradePrice == 0 {
+		return false
+	}
+	if order.Side == SideBuy {
+		return ob.LastTradePrice >= order.StopPrice
+	}
+	return ob.LastTradePrice <= order.StopPrice
+}
+
+// addStop parks a stop order until it triggers. Only the account checks that
+// don't depend on the book run now; funds are reserved on triggering.
+func (ob *OrderBook) addStop(order *Order, now int64) error {
+	notional := order.Price * order.Quantity
+	if order.Type == OrderTypeStopMarket {
+		notional = order.StopPrice * order.Quantity
+	}
+	ob.Orders[order.ID] = order
+	if err := ob.accounts.check(order, notional); err != nil {
+		ob.reject(order, now, err)
+		return err
+	}
+
+	ob.stops(order.Side).add(order)
+	ob.setStatus(order, OrderStatusPendingTrigger, ExecTypeNew, now, nil)
+	return nil
+}
+
+// runTriggers releases every stop the last trade price has reached into
+// matching, in trigger order. Their trades move the last price in turn, so
+// one trade can set off a cascade of stops.
+func (ob *OrderBook) runTriggers(now int64, newTradeID func() string) []Trade {
+	var trades []Trade
+	for ob.TradingState == TradingOpen {
+		stop := ob.nextTriggered()
+		if stop == nil {
+			return trades
+		}
+		if stop.isExpired(now) {
+			ob.expireOrder(stop, now)
+			continue
+		}
+
+		ob.stops(stop.Side).remove(stop)
+		stop.TriggeredAt = now
+		// A stop that fails its checks now is rejected and reported as such
+		t, _ := ob.execute(stop, now, newTradeID)
+		trades = append(trades, t...)
+	}
+	return trades
+}
+
+func (ob *OrderBook) nextTriggered() *Order {
+	if stop := ob.BuyStops.Best(); stop != nil && ob.triggered(stop) {
+		return stop
+	}
+	if stop := ob.SellStops.Best(); stop != nil && ob.triggered(stop) {
+		return stop
+	}
+	return nil
+}
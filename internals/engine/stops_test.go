@@ -0,0 +1,102 @@
+package engine
+
+import "testing"
+
+func stopOrder(typ OrderType, side Side, stop, price, qty int64) *Order {
+	o := limitOrder(side, price, qty)
+	o.Type = typ
+	o.StopPrice = stop
+	return o
+}
+
+func TestStopOrders_TriggerOnLastTrade(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 100, 1))
+	eng.SubmitOrder(limitOrder(SideSell, 105, 5))
+
+	stop := stopOrder(OrderTypeStopMarket, SideBuy, 100, 0, 2)
+	if _, err := eng.SubmitOrder(stop); err != nil {
+		t.Fatal(err)
+	}
+	if stop.Status != OrderStatusPendingTrigger {
+		t.Fatalf("expected PENDING_TRIGGER with no trades yet, got %s", stop.Status)
+	}
+	if ob := eng.GetOrderBook("BTCUSD"); ob.TotalBidLiquidity != 0 {
+		t.Errorf("pending stops must not show as liquidity")
+	}
+
+	trades, err := eng.SubmitOrder(limitOrder(SideBuy, 100, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[1].TakerOrderID != stop.ID || trades[1].Price != 105 {
+		t.Fatalf("expected the trade at 100 to set off the stop against 105, got %+v", trades)
+	}
+	if stop.Status != OrderStatusFilled || stop.TriggeredAt == 0 {
+		t.Errorf("expected triggered stop to fill, got %s (triggered at %d)", stop.Status, stop.TriggeredAt)
+	}
+	if got := eng.GetOrderBook("BTCUSD").LastTradePrice; got != 105 {
+		t.Errorf("expected last trade price 105, got %d", got)
+	}
+}
+
+func TestStopOrders_Cascade(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideBuy, 100, 1))
+	eng.SubmitOrder(limitOrder(SideBuy, 95, 1))
+	eng.SubmitOrder(limitOrder(SideBuy, 90, 1))
+	eng.SubmitOrder(limitOrder(SideBuy, 89, 1))
+
+	// Each stop's fill reaches the next one's trigger
+	first := stopOrder(OrderTypeStopMarket, SideSell, 100, 0, 1)
+	second := stopOrder(OrderTypeStopMarket, SideSell, 95, 0, 1)
+	limit := stopOrder(OrderTypeStopLimit, SideSell, 90, 89, 3)
+	untouched := stopOrder(OrderTypeStopMarket, SideSell, 80, 0, 1)
+	for _, o := range []*Order{first, second, limit, untouched} {
+		eng.SubmitOrder(o)
+	}
+
+	trades, _ := eng.SubmitOrder(limitOrder(SideSell, 100, 1))
+	if len(trades) != 4 {
+		t.Fatalf("expected the cascade to trade 4 times in one call, got %+v", trades)
+	}
+	// 100 triggers the first stop into 95, which triggers the second into 90,
+	// which triggers the stop limit
+	if first.Status != OrderStatusFilled || second.Status != OrderStatusFilled {
+		t.Errorf("expected both stop market orders filled, got %s and %s", first.Status, second.Status)
+	}
+	if limit.Status != OrderStatusPartialFill || limit.Filled != 1 {
+		t.Errorf("expected stop limit to trade once and rest, got %s filled %d", limit.Status, limit.Filled)
+	}
+	if untouched.Status != OrderStatusPendingTrigger {
+		t.Errorf("expected the 80 stop to stay pending, got %s", untouched.Status)
+	}
+	if got := eng.GetOrderBook("BTCUSD").Asks.Quantity(89); got != 2 {
+		t.Errorf("expected the stop limit remainder resting at 89, got %d", got)
+	}
+}
+
+func TestStopOrders_CancelAndStatus(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 100, 1))
+	eng.SubmitOrder(limitOrder(SideBuy, 100, 1))
+
+	// Already through its stop: triggers on arrival and rests as TRIGGERED
+	live := stopOrder(OrderTypeStopLimit, SideBuy, 99, 98, 2)
+	eng.SubmitOrder(live)
+	if live.Status != OrderStatusTriggered || eng.GetOrderBook("BTCUSD").Bids.Quantity(98) != 2 {
+		t.Fatalf("expected TRIGGERED order resting at 98, got %s", live.Status)
+	}
+
+	pending := stopOrder(OrderTypeStopLimit, SideBuy, 110, 110, 2)
+	eng.SubmitOrder(pending)
+	if _, err := eng.AmendOrder(pending.ID, 111, 0); err == nil {
+		t.Errorf("expected amending a pending stop to fail")
+	}
+	if err := eng.CancelOrder(pending.ID); err != nil {
+		t.Fatal(err)
+	}
+	if pending.Status != OrderStatusCancelled || eng.GetOrderBook("BTCUSD").BuyStops.Len() != 0 {
+		t.Errorf("expected cancelled stop to leave the trigger book, got %s", pending.Status)
+	}
+}
@@ -0,0 +1,206 @@
+package engine
+
+// Statistics over the last 24 hours are kept in one minute buckets: a
+// ticker covers the current minute and the 1439 before it. Totals are
+// updated as trades happen and the highs and lows are kept in monotonic
+// queues, so neither a trade nor a ticker has to walk the window.
+
+const (
+	statsBucketMillis = 60 * 1000
+	statsWindowMillis = 24 * 60 * 60 * 1000
+)
+
+// Ticker is a book's last price, top of book and statistics over the last
+// 24 hours. Open, High, Low, VWAP and the change are 0 without trades in
+// that window.
+type Ticker struct {
+	Symbol    string       `json:"symbol"`
+	State     TradingState `json:"state"`
+	Timestamp int64        `json:"timestamp"`
+	// Seq is the last book update the ticker reflects, as in
+	// OrderBookSnapshot
+	Seq             uint64 `json:"seq"`
+	PricePrecision  int    `json:"price_precision"`
+	LastPrice       int64  `json:"last_price"`
+	BestBid         int64  `json:"best_bid"`
+	BestBidQuantity int64  `json:"best_bid_quantity"`
+	BestAsk         int64  `json:"best_ask"`
+	BestAskQuantity int64  `json:"best_ask_quantity"`
+	Open            int64  `json:"open"`
+	High            int64  `json:"high"`
+	Low             int64  `json:"low"`
+	Volume          int64  `json:"volume"`
+	Notional        int64  `json:"notional"`
+	VWAP            int64  `json:"vwap"`
+	Trades          int64  `json:"trades"`
+	PriceChange     int64  `json:"price_change"`
+	// PriceChangeBps is PriceChange in basis points of Open
+	PriceChangeBps int64 `json:"price_change_bps"`
+}
+
+// StatsBucket totals one minute of trades.
+type StatsBucket struct {
+	Start    int64 `json:"start"`
+	Open     int64 `json:"open"`
+	High     int64 `json:"high"`
+	Low      int64 `json:"low"`
+	Volume   int64 `json:"volume"`
+	Notional int64 `json:"notional"`
+	Trades   int64 `json:"trades"`
+}
+
+type extreme struct {
+	start, price int64
+}
+
+type tradeStats struct {
+	// Minutes with trades, oldest first
+	buckets []StatsBucket
+	// Totals of buckets, including any that have since left the window
+	volume, notional, trades int64
+	// Bucket highs in decreasing and lows in increasing order of price, so
+	// the first one still in the window is the extreme
+	highs, lows []extreme
+}
+
+func windowStart(now int64) int64 {
+	return now - now%statsBucketMillis - statsWindowMillis + statsBucketMillis
+}
+
+func (s *tradeStats) add(t *Trade) {
+	s.evict(windowStart(t.Timestamp))
+
+	start := t.Timestamp - t.Timestamp%statsBucketMillis
+	n := len(s.buckets)
+	// A trade stamped before the last bucket, from the clock stepping
+	// back, counts towards it
+	if n == 0 || start > s.buckets[n-1].Start {
+		s.buckets = append(s.buckets, StatsBucket{Start: start, Open: t.Price, High: t.Price, Low: t.Price})
+		n++
+		s.pushHigh(start, t.Price)
+		s.pushLow(start, t.Price)
+	}
+	b := &s.buckets[n-1]
+	if t.Price > b.High {
+		b.High = t.Price
+		s.pushHigh(b.Start, t.Price)
+	}
+	if t.Price < b.Low {
+		b.Low = t.Price
+		s.pushLow(b.Start, t.Price)
+	}
+	notional := t.Price * t.Quantity
+	b.Volume += t.Quantity
+	b.Notional += notional
+	b.Trades++
+	s.volume += t.Quantity
+	s.notional += notional
+	s.trades++
+}
+
+func (s *tradeStats) pushHigh(start, price int64) {
+	for len(s.highs) > 0 && s.highs[len(s.highs)-1].price <= price {
+		s.highs = s.highs[:len(s.highs)-1]
+	}
+	s.highs = append(s.highs, extreme{start, price})
+}
+
+func (s *tradeStats) pushLow(start, price int64) {
+	for len(s.lows) > 0 && s.lows[len(s.lows)-1].price >= price {
+		s.lows = s.lows[:len(s.lows)-1]
+	}
+	s.lows = append(s.lows, extreme{start, price})
+}
+
+// evict drops the buckets before from.
+func (s *tradeStats) evict(from int64) {
+	for len(s.buckets) > 0 && s.buckets[0].Start < from {
+		b := s.buckets[0]
+		s.volume -= b.Volume
+		s.notional -= b.Notional
+		s.trades -= b.Trades
+		s.buckets = s.buckets[1:]
+	}
+	for len(s.highs) > 0 && s.highs[0].start < from {
+		s.highs = s.highs[1:]
+	}
+	for len(s.lows) > 0 && s.lows[0].start < from {
+		s.lows = s.lows[1:]
+	}
+}
+
+// fill sets t's statistics as of now without changing s, leaving out the
+// buckets that have left the window since the last trade.
+func (s *tradeStats) fill(t *Ticker, now int64) {
+	from := windowStart(now)
+	t.Volume, t.Notional, t.Trades = s.volume, s.notional, s.trades
+	i := 0
+	for ; i < len(s.buckets) && s.buckets[i].Start < from; i++ {
+		t.Volume -= s.buckets[i].Volume
+		t.Notional -= s.buckets[i].Notional
+		t.Trades -= s.buckets[i].Trades
+	}
+	if i == len(s.buckets) {
+		return
+	}
+	t.Open = s.buckets[i].Open
+	for _, e := range s.highs {
+		if e.start >= from {
+			t.High = e.price
+			break
+		}
+	}
+	for _, e := range s.lows {
+		if e.start >= from {
+			t.Low = e.price
+			break
+		}
+	}
+	t.VWAP = (t.Notional + t.Volume/2) / t.Volume
+	t.PriceChange = t.LastPrice - t.Open
+	t.PriceChangeBps = t.PriceChange * 10000 / t.Open
+}
+
+// restore rebuilds s from buckets in the form State saves them.
+func (s *tradeStats) restore(buckets []StatsBucket) {
+	*s = tradeStats{buckets: buckets}
+	for _, b := range buckets {
+		s.pushHigh(b.Start, b.High)
+		s.pushLow(b.Start, b.Low)
+		s.volume += b.Volume
+		s.notional += b.Notional
+		s.trades += b.Trades
+	}
+}
+
+// Ticker returns the book's ticker as of now.
+func (ob *OrderBook) Ticker() (t Ticker) {
+	ob.do(func() { t = ob.ticker(ob.clock.Now()) })
+	return t
+}
+
+func (ob *OrderBook) ticker(now int64) Ticker {
+	t := Ticker{
+		Symbol:         ob.Symbol,
+		State:          ob.TradingState,
+		Timestamp:      now,
+		Seq:            ob.feed.seq,
+		PricePrecision: ob.pricePrecision(),
+		LastPrice:      ob.LastTradePrice,
+	}
+	top := ob.top()
+	t.BestBid, t.BestBidQuantity = top[0].Price, top[0].Quantity
+	t.BestAsk, t.BestAskQuantity = top[1].Price, top[1].Quantity
+	ob.stats.fill(&t, now)
+	return t
+}
+
+// Tickers returns the ticker of every book, by symbol.
+func (e *Engine) Tickers() []Ticker {
+	books := e.OrderBooks()
+	tickers := make([]Ticker, 0, len(books))
+	for _, ob := range books {
+		tickers = append(tickers, ob.Ticker())
+	}
+	return tickers
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTradeStatsWindow(t *testing.T) {
+	const base = 10 * statsWindowMillis
+	var s tradeStats
+	s.add(&Trade{Price: 100, Quantity: 2, Timestamp: base})
+	s.add(&Trade{Price: 120, Quantity: 1, Timestamp: base + 30_000})
+	s.add(&Trade{Price: 90, Quantity: 1, Timestamp: base + 2*60*60*1000})
+
+	tk := Ticker{LastPrice: 90}
+	s.fill(&tk, base+2*60*60*1000)
+	want := Ticker{LastPrice: 90, Open: 100, High: 120, Low: 90, Volume: 4, Notional: 410, VWAP: 103, Trades: 3, PriceChange: -10, PriceChangeBps: -1000}
+	if tk != want {
+		t.Fatalf("expected %+v, got %+v", want, tk)
+	}
+
+	// The first minute leaves the window 24 hours on
+	tk = Ticker{LastPrice: 90}
+	s.fill(&tk, base+statsWindowMillis)
+	if tk.Open != 90 || tk.High != 90 || tk.Low != 90 || tk.Volume != 1 || tk.Trades != 1 {
+		t.Errorf("expected only the last trade, got %+v", tk)
+	}
+	tk = Ticker{LastPrice: 90}
+	s.fill(&tk, base+2*statsWindowMillis)
+	if tk != (Ticker{LastPrice: 90}) {
+		t.Errorf("expected empty statistics, got %+v", tk)
+	}
+
+	s.add(&Trade{Price: 95, Quantity: 1, Timestamp: base + 2*statsWindowMillis})
+	if len(s.buckets) != 1 || len(s.highs) != 1 || len(s.lows) != 1 || s.volume != 1 || s.notional != 95 {
+		t.Errorf("expected older buckets evicted, got %+v", s)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	eng := NewEngine()
+	eng.SubmitOrder(limitOrder(SideSell, 101, 5))
+	eng.SubmitOrder(limitOrder(SideBuy, 101, 2))
+	eng.SubmitOrder(limitOrder(SideBuy, 99, 3))
+
+	tickers := eng.Tickers()
+	if len(tickers) != 1 {
+		t.Fatalf("expected one ticker, got %+v", tickers)
+	}
+	tk := tickers[0]
+	if tk.Symbol != "BTCUSD" || tk.LastPrice != 101 || tk.BestBid != 99 || tk.BestBidQuantity != 3 ||
+		tk.BestAsk != 101 || tk.BestAskQuantity != 3 || tk.Volume != 2 || tk.VWAP != 101 || tk.Trades != 1 {
+		t.Errorf("unexpected ticker %+v", tk)
+	}
+
+	data, _ := json.Marshal(eng.State())
+	var state EngineState
+	json.Unmarshal(data, &state)
+	restored := NewEngine()
+	if err := restored.Restore(&state); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.GetOrderBook("BTCUSD").Ticker(); got.Volume != 2 || got.High != 101 || got.Trades != 1 {
+		t.Errorf("expected statistics to survive a snapshot, got %+v", got)
+	}
+}
@@ -0,0 +1,364 @@
+package engine
+
+import (
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// TradingState is the market phase of an order book. Orders only match while
+// it is OPEN, or all at once when an AUCTION ends.
+type TradingState string
+
+const (
+	TradingPreOpen TradingState = "PRE_OPEN"
+	TradingAuction TradingState = "AUCTION"
+	TradingOpen    TradingState = "OPEN"
+	TradingHalted  TradingState = "HALTED"
+	TradingClosed  TradingState = "CLOSED"
+)
+
+func (s TradingState) Valid() bool {
+	switch s {
+	case TradingPreOpen, TradingAuction, TradingOpen, TradingHalted, TradingClosed:
+		return true
+	}
+	return false
+}
+
+// HaltPolicy decides what happens to orders submitted while a book is
+// halted or pre-open. Closed books reject every order.
+type HaltPolicy string
+
+const (
+	HaltReject HaltPolicy = "REJECT"
+	// Hold orders, unchecked and off the book, and process them in arrival
+	// order when the book opens.
+	HaltQueue HaltPolicy = "QUEUE"
+)
+
+func (p HaltPolicy) Valid() bool {
+	return p == HaltReject || p == HaltQueue
+}
+
+// CircuitBreaker halts a book instead of letting it trade more than
+// MaxMoveBps away from a reference price: the last trade price when the
+// current window started.
+type CircuitBreaker struct {
+	MaxMoveBps   int64 `json:"max_move_bps"` // 0 disables the breaker
+	WindowMillis int64 `json:"window_ms"`    // 0 keeps one reference until the book reopens
+	HaltMillis   int64 `json:"halt_ms"`      // 0 halts until reopened by hand
+	// AuctionMillis is how long the auction that reopens the book after a
+	// halt lasts; 0 reopens straight into continuous trading.
+	AuctionMillis int64 `json:"auction_ms,omitempty"`
+}
+
+type TradingConfig struct {
+	HaltPolicy     HaltPolicy     `json:"halt_policy"`
+	CircuitBreaker CircuitBreaker `json:"circuit_breaker"`
+}
+
+func DefaultTradingConfig() TradingConfig {
+	return TradingConfig{HaltPolicy: HaltReject}
+}
+
+func (c TradingConfig) Valid() bool {
+	cb := c.CircuitBreaker
+	return c.HaltPolicy.Valid() && cb.MaxMoveBps >= 0 && cb.WindowMillis >= 0 && cb.HaltMillis >= 0 &&
+		cb.AuctionMillis >= 0
+}
+
+// Reasons given in execution reports for orders cancelled by a phase change
+const (
+	CircuitBreakerReason = "circuit breaker halt"
+	MarketClosedReason   = "market closed"
+)
+
+type TradingStatus struct {
+	Symbol       string             `json:"symbol"`
+	State        TradingState       `json:"state"`
+	HaltedUntil  int64              `json:"halted_until,omitempty"` // 0 while not halted or halted indefinitely
+	AuctionUntil int64              `json:"auction_until,omitempty"`
+	Auction      *AuctionIndicative `json:"auction,omitempty"`
+	Config       TradingConfig      `json:"config"`
+	Queued       int                `json:"queued"`
+}
+
+func (ob *OrderBook) TradingStatus() (status TradingStatus) {
+	ob.do(func() { status = ob.tradingStatus() })
+	return status
+}
+
+func (ob *OrderBook) tradingStatus() TradingStatus {
+	status := TradingStatus{
+		Symbol:       ob.Symbol,
+		State:        ob.TradingState,
+		HaltedUntil:  ob.HaltedUntil,
+		AuctionUntil: ob.AuctionUntil,
+		Config:       ob.TradingConfig,
+		Queued:       len(ob.queued),
+	}
+	if eq, ok := ob.indicative(); ok {
+		status.Auction = &eq
+	}
+	return status
+}
+
+// hold handles an order that arrives while the book isn't open.
+func (ob *OrderBook) hold(order *Order, now int64) error {
+	if ob.TradingState == TradingClosed || ob.TradingConfig.HaltPolicy != HaltQueue {
+		ob.Orders[order.ID] = order
+		ob.reject(order, now, utils.ErrMarketNotOpen)
+		return utils.ErrMarketNotOpen
+	}
+	ob.Orders[order.ID] = order
+	ob.queued = append(ob.queued, order)
+	ob.setStatus(order, OrderStatusQueued, ExecTypeNew, now, nil)
+	return nil
+}
+
+func (ob *OrderBook) dequeue(order *Order) {
+	for i, o := range ob.queued {
+		if o == order {
+			ob.queued = append(ob.queued[:i], ob.queued[i+1:]...)
+			return
+		}
+	}
+}
+
+// setTradingStateAt moves the book to state. Opening or closing uncrosses
+// the book first; opening then processes any queued orders, and closing
+// cancels them. Starting an auction moves queued orders into it.
+func (ob *OrderBook) setTradingStateAt(state TradingState, now int64, newTradeID func() string, tape *riskTape) (trades []Trade, err error) {
+	if !state.Valid() {
+		return nil, utils.ErrInvalidTradingState
+	}
+
+	ob.withTape(tape, func() { trades = ob.setTradingState(state, now, newTradeID) })
+	return trades, nil
+}
+
+func (ob *OrderBook) setTradingState(state TradingState, now int64, newTradeID func() string) (trades []Trade) {
+	defer func() { ob.publish(now, trades) }()
+
+	switch state {
+	case TradingOpen:
+		return ob.open(now, newTradeID)
+	case TradingClosed:
+		trades = ob.uncross(now, newTradeID)
+		ob.changeState(state, 0)
+		for _, order := range ob.queued {
+			order.CancelReason = MarketClosedReason
+			ob.setStatus(order, OrderStatusCancelled, ExecTypeCanceled, now, nil)
+		}
+		ob.queued = nil
+	case TradingAuction:
+		ob.changeState(state, 0)
+		ob.releaseQueued(now, newTradeID)
+	default:
+		ob.changeState(state, 0)
+	}
+	return trades
+}
+
+// open uncrosses the book, then opens it for continuous trading.
+func (ob *OrderBook) open(now int64, newTradeID func() string) []Trade {
+	trades := ob.uncross(now, newTradeID)
+	ob.changeState(TradingOpen, 0)
+	trades = append(trades, ob.releaseQueued(now, newTradeID)...)
+	return append(trades, ob.runTriggers(now, newTradeID)...)
+}
+
+// changeState moves the book to state until the given time, which is when
+// a halt or auction ends and is ignored for other states.
+func (ob *OrderBook) changeState(state TradingState, until int64) {
+	if state == TradingOpen {
+		// A new window starts with the first trade after reopening
+		ob.breakerRef = 0
+	}
+	ob.TradingState = state
+	ob.HaltedUntil, ob.AuctionUntil = 0, 0
+	switch state {
+	case TradingHalted:
+		ob.HaltedUntil = until
+	case TradingAuction:
+		ob.AuctionUntil = until
+	}
+	ob.feed.stateChanged = true
+}
+
+// releaseQueued enters queued orders in arrival order for as long as the
+// book stays open or in an auction.
+func (ob *OrderBook) releaseQueued(now int64, newTradeID func() string) []Trade {
+	var trades []Trade
+	for (ob.TradingState == TradingOpen || ob.TradingState == TradingAuction) && len(ob.queued) > 0 {
+		order := ob.queued[0]
+		ob.queued = ob.queued[1:]
+		if order.isExpired(now) {
+			ob.setStatus(order, OrderStatusExpired, ExecTypeExpired, now, nil)
+			continue
+		}
+		// Orders that fail their checks now are rejected and reported as such
+		t, _ := ob.enter(order, now, newTradeID)
+		trades = append(trades, t...)
+	}
+	return trades
+}
+
+// breaches reports whether a trade at price would move the book further
+// than its circuit breaker allows, starting a new reference window first
+// if the last one has run out.
+func (ob *OrderBook) breaches(price, now int64) bool {
+	ref, fresh := ob.breakerReference(price, now)
+	if fresh {
+		ob.breakerRef, ob.breakerSince = ref, now
+	}
+	return ob.outsideBand(price, ref)
+}
+
+// breakerReference returns the price a trade at price would be measured
+// against, and whether it starts a new window.
+func (ob *OrderBook) breakerReference(price, now int64) (ref int64, fresh bool) {
+	window := ob.TradingConfig.CircuitBreaker.WindowMillis
+	if ob.breakerRef != 0 && (window == 0 || now-ob.breakerSince < window) {
+		return ob.breakerRef, false
+	}
+	if ob.LastTradePrice != 0 {
+		return ob.LastTradePrice, true
+	}
+	return price, true
+}
+
+func (ob *OrderBook) outsideBand(price, ref int64) bool {
+	maxMove := ob.TradingConfig.CircuitBreaker.MaxMoveBps
+	if maxMove <= 0 {
+		return false
+	}
+	move := price - ref
+	if move < 0 {
+		move = -move
+	}
+	return move*10000 > ref*maxMove
+}
+
+// trip halts the book for its circuit breaker's halt time.
+func (ob *OrderBook) trip(now int64) {
+	var until int64
+	if ms := ob.TradingConfig.CircuitBreaker.HaltMillis; ms > 0 {
+		until = now + ms
+	}
+	ob.changeState(TradingHalted, until)
+}
+
+// reopenIfDue moves the book on if a circuit breaker halt, or the auction
+// that follows it, has run its course.
+func (ob *OrderBook) reopenIfDue(now int64, newTradeID func() string) (trades []Trade, reopened bool) {
+	ob.do(func() { trades, reopened = ob.reopen(now, newTradeID) })
+	return trades, reopened
+}
+
+func (ob *OrderBook) reopen(now int64, newTradeID func() string) (trades []Trade, reopened bool) {
+	halted := ob.TradingState == TradingHalted && ob.HaltedUntil != 0 && ob.HaltedUntil <= now
+	auctionOver := ob.TradingState == TradingAuction && ob.AuctionUntil != 0 && ob.AuctionUntil <= now
+	if !halted && !auctionOver {
+		return nil, false
+	}
+	defer func() { ob.publish(now, trades) }()
+
+	if ms := ob.TradingConfig.CircuitBreaker.AuctionMillis; halted && ms > 0 {
+		ob.changeState(TradingAuction, now+ms)
+		ob.releaseQueued(now, newTradeID)
+		return nil, true
+	}
+	return ob.open(now, newTradeID), true
+}
+
+// SetTradingState moves symbol to a new market phase and returns the trades
+// of any queued orders that opening it processed.
+func (e *Engine) SetTradingState(symbol string, state TradingState) ([]Trade, error) {
+	if e.journal == nil {
+		return e.tradingStateAt(symbol, state, e.clock.Now(), e.ids.NewID, nil)
+	}
+
+	if !state.Valid() {
+		return nil, utils.ErrInvalidTradingState
+	}
+	ob, err := e.book(symbol)
+	if err != nil {
+		return nil, err
+	}
+	var trades []Trade
+	cmd := &Command{Type: CommandSetTradingState, Symbol: symbol, TradingState: state}
+	err = e.journalBook(ob, cmd, false, func(now int64) error {
+		trades = ob.setTradingState(state, now, e.ids.NewID)
+		for _, t := range trades {
+			cmd.TradeIDs = append(cmd.TradeIDs, t.ID)
+		}
+		return nil
+	})
+	return trades, err
+}
+
+func (e *Engine) tradingStateAt(symbol string, state TradingState, now int64, newTradeID func() string, tape *riskTape) ([]Trade, error) {
+	ob, err := e.book(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return ob.setTradingStateAt(state, now, newTradeID, tape)
+}
+
+// ReopenHalts moves on every book whose circuit breaker halt or reopening
+// auction ended at or before now, and returns the trades of uncrossing and
+// of the queued orders that released.
+func (e *Engine) ReopenHalts(now int64) ([]Trade, error) {
+	if e.journal != nil {
+		e.journalMu.Lock()
+		defer e.journalMu.Unlock()
+		if err := e.journalHealth(); err != nil {
+			return nil, err
+		}
+	}
+
+	var all []Trade
+	for _, ob := range e.OrderBooks() {
+		if e.journal == nil {
+			trades, _ := ob.reopenIfDue(now, e.ids.NewID)
+			all = append(all, trades...)
+			continue
+		}
+
+		release := deferEvents(ob)
+		trades, reopened := ob.reopenIfDue(now, e.ids.NewID)
+		all = append(all, trades...)
+		cmd := &Command{Type: CommandReopen, Timestamp: now, Symbol: ob.Symbol}
+		var err error
+		if reopened {
+			for _, t := range trades {
+				cmd.TradeIDs = append(cmd.TradeIDs, t.ID)
+			}
+			err = e.record(cmd)
+		}
+		release(cmd.Seq, err == nil)
+		if err != nil {
+			return all, err
+		}
+	}
+	return all, nil
+}
+
+// SetTradingConfig changes symbol's halt policy and circuit breaker.
+func (e *Engine) SetTradingConfig(symbol string, cfg TradingConfig) error {
+	return e.journaled(&Command{Type: CommandSetTradingConfig, Symbol: symbol, TradingConfig: &cfg}, func() error {
+		return e.setTradingConfig(symbol, cfg)
+	})
+}
+
+func (e *Engine) setTradingConfig(symbol string, cfg TradingConfig) error {
+	if !cfg.Valid() {
+		return utils.ErrInvalidTradingConfig
+	}
+	ob, err := e.book(symbol)
+	if err != nil {
+		return err
+	}
+	ob.do(func() { ob.TradingConfig = cfg })
+	return nil
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestTradingStates(t *testing.T) {
+	eng := NewEngine()
+	resting := limitOrder(SideSell, 100, 5)
+	eng.SubmitOrder(resting)
+
+	if _, err := eng.SetTradingState("BTCUSD", TradingHalted); err != nil {
+		t.Fatal(err)
+	}
+	halted := limitOrder(SideBuy, 100, 5)
+	if _, err := eng.SubmitOrder(halted); err != utils.ErrMarketNotOpen || halted.Status != OrderStatusRejected {
+		t.Fatalf("expected rejection while halted, got %v %s", err, halted.Status)
+	}
+	if _, err := eng.AmendOrder(resting.ID, 0, 3); err != utils.ErrMarketNotOpen {
+		t.Errorf("expected amend to be refused while halted, got %v", err)
+	}
+
+	// Queue instead, then open
+	cfg := DefaultTradingConfig()
+	cfg.HaltPolicy = HaltQueue
+	if err := eng.SetTradingConfig("BTCUSD", cfg); err != nil {
+		t.Fatal(err)
+	}
+	first := limitOrder(SideBuy, 100, 3)
+	second := limitOrder(SideBuy, 100, 3)
+	cancelled := limitOrder(SideBuy, 100, 1)
+	for _, o := range []*Order{first, second, cancelled} {
+		if trades, err := eng.SubmitOrder(o); err != nil || len(trades) != 0 || o.Status != OrderStatusQueued {
+			t.Fatalf("expected order to queue, got %v %v %s", trades, err, o.Status)
+		}
+	}
+	if err := eng.CancelOrder(cancelled.ID); err != nil {
+		t.Fatalf("expected queued order to cancel, got %v", err)
+	}
+
+	trades, err := eng.SetTradingState("BTCUSD", TradingOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 2 || trades[0].TakerOrderID != first.ID || trades[1].TakerOrderID != second.ID {
+		t.Fatalf("expected queued orders to trade in arrival order, got %+v", trades)
+	}
+	if second.Status != OrderStatusPartialFill || cancelled.Status != OrderStatusCancelled {
+		t.Errorf("unexpected statuses %s %s", second.Status, cancelled.Status)
+	}
+
+	// Closing cancels the queue and rejects everything
+	eng.SetTradingState("BTCUSD", TradingPreOpen)
+	queued := limitOrder(SideSell, 110, 1)
+	eng.SubmitOrder(queued)
+	eng.SetTradingState("BTCUSD", TradingClosed)
+	if queued.Status != OrderStatusCancelled || queued.CancelReason != MarketClosedReason {
+		t.Errorf("expected queued order cancelled on close, got %s %q", queued.Status, queued.CancelReason)
+	}
+	if _, err := eng.SubmitOrder(limitOrder(SideSell, 110, 1)); err != utils.ErrMarketNotOpen {
+		t.Errorf("expected rejection while closed, got %v", err)
+	}
+	if _, err := eng.SetTradingState("BTCUSD", "LUNCH"); err != utils.ErrInvalidTradingState {
+		t.Errorf("expected invalid state to be refused, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	eng := NewEngine()
+	eng.TradingConfig.CircuitBreaker = CircuitBreaker{MaxMoveBps: 1000, WindowMillis: 60000, HaltMillis: 30000}
+
+	eng.SubmitOrder(limitOrder(SideSell, 100, 1))
+	eng.SubmitOrder(limitOrder(SideSell, 105, 1))
+	eng.SubmitOrder(limitOrder(SideSell, 120, 5))
+	eng.SubmitOrder(limitOrder(SideBuy, 100, 1)) // Reference trade at 100
+
+	sweep := limitOrder(SideBuy, 120, 4)
+	trades, _ := eng.SubmitOrder(sweep)
+	if len(trades) != 1 || trades[0].Price != 105 {
+		t.Fatalf("expected to trade at 105 and halt before 120, got %+v", trades)
+	}
+	ob := eng.GetOrderBook("BTCUSD")
+	if ob.TradingState != TradingHalted || ob.HaltedUntil == 0 {
+		t.Fatalf("expected a timed halt, got %s until %d", ob.TradingState, ob.HaltedUntil)
+	}
+	if sweep.Status != OrderStatusPartialFillCancelled || sweep.CancelReason != CircuitBreakerReason {
+		t.Errorf("expected remainder cancelled by the breaker, got %s %q", sweep.Status, sweep.CancelReason)
+	}
+	if bids := ob.GetSnapshot(5).Bids; len(bids) != 0 {
+		t.Errorf("expected nothing to rest through the band, got %+v", bids)
+	}
+
+	// FOK orders that would run into the band are rejected up front
+	fok := limitOrder(SideBuy, 120, 2)
+	fok.TimeInForce = TimeInForceFOK
+	if eng.ReopenHalts(ob.HaltedUntil - 1); ob.TradingState != TradingHalted {
+		t.Fatalf("expected the halt to last until HaltedUntil")
+	}
+	eng.ReopenHalts(ob.HaltedUntil)
+	if ob.TradingState != TradingOpen {
+		t.Fatalf("expected the book to reopen, got %s", ob.TradingState)
+	}
+	if _, err := eng.SubmitOrder(fok); err != utils.ErrInsufficientLiquidity {
+		t.Errorf("expected FOK through the band to be rejected, got %v", err)
+	}
+}
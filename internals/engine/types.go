@@ -1,6 +1,5 @@
 package engine
 
-
 type Side string
 
 const (
@@ -13,8 +12,20 @@ type OrderType string
 const (
 	OrderTypeLimit  OrderType = "LIMIT"
 	OrderTypeMarket OrderType = "MARKET"
+	// Stop orders wait in the book's trigger book until the last trade
+	// price reaches StopPrice, then enter matching as a market or limit order.
+	OrderTypeStopMarket OrderType = "STOP_MARKET"
+	OrderTypeStopLimit  OrderType = "STOP_LIMIT"
 )
 
+func (t OrderType) Valid() bool {
+	switch t {
+	case OrderTypeLimit, OrderTypeMarket, OrderTypeStopMarket, OrderTypeStopLimit:
+		return true
+	}
+	return false
+}
+
 type OrderStatus string
 
 const (
@@ -23,19 +34,147 @@ const (
 	OrderStatusFilled      OrderStatus = "FILLED"
 	OrderStatusCancelled   OrderStatus = "CANCELLED"
 	OrderStatusRejected    OrderStatus = "REJECTED"
+	OrderStatusExpired     OrderStatus = "EXPIRED"
+	// The order traded part of its quantity and the rest was cancelled
+	// instead of resting, e.g. an IOC or market order that ran out of liquidity.
+	OrderStatusPartialFillCancelled OrderStatus = "PARTIAL_FILL_CANCELLED"
+	// A stop order waiting for its trigger
+	OrderStatusPendingTrigger OrderStatus = "PENDING_TRIGGER"
+	// A stop order that has been triggered and is working but hasn't traded
+	OrderStatusTriggered OrderStatus = "TRIGGERED"
+	// Held while the book is halted or pre-open, until it opens
+	OrderStatusQueued OrderStatus = "QUEUED"
 )
 
+type TimeInForce string
+
+const (
+	TimeInForceGTC TimeInForce = "GTC" // Good till cancelled
+	TimeInForceIOC TimeInForce = "IOC" // Immediate or cancel
+	TimeInForceFOK TimeInForce = "FOK" // Fill or kill
+	TimeInForceDAY TimeInForce = "DAY" // Expires at the end of the UTC trading day
+	TimeInForceGTD TimeInForce = "GTD" // Good till ExpireAt
+)
+
+func (tif TimeInForce) Valid() bool {
+	switch tif {
+	case TimeInForceGTC, TimeInForceIOC, TimeInForceFOK, TimeInForceDAY, TimeInForceGTD:
+		return true
+	}
+	return false
+}
+
+// PostOnly makes a limit order add liquidity only. An order that would
+// cross the book on entry is rejected, or repriced one tick behind the best
+// opposite price.
+type PostOnly string
+
+const (
+	PostOnlyReject  PostOnly = "REJECT"
+	PostOnlyReprice PostOnly = "REPRICE"
+)
+
+func (p PostOnly) Valid() bool {
+	return p == "" || p == PostOnlyReject || p == PostOnlyReprice
+}
+
 type Order struct {
-	ID        string      `json:"id"`
-	Symbol    string      `json:"symbol"`
-	Side      Side        `json:"side"`
-	Type      OrderType   `json:"type"`
-	Price     int64       `json:"price"` // Price in cents
-	Quantity  int64       `json:"quantity"`
-	Timestamp int64       `json:"timestamp"` // Unix milliseconds
-	Filled    int64       `json:"filled_quantity"`
-	Status    OrderStatus `json:"status"`
-	HeapIndex int `json:"-"`
+	ID        string    `json:"id"`
+	ClientID  string    `json:"client_id,omitempty"` // Owner, for execution reports
+	AccountID string    `json:"account_id,omitempty"`
+	Symbol    string    `json:"symbol"`
+	Side      Side      `json:"side"`
+	Type      OrderType `json:"type"`
+	Price     int64     `json:"price"` // Price in cents
+	StopPrice int64     `json:"stop_price,omitempty"`
+	// DisplayQuantity makes the order an iceberg: the book shows at most
+	// this much of it at a time.
+	DisplayQuantity int64 `json:"display_quantity,omitempty"`
+	// PeakRemaining is what is left of an iceberg's displayed peak.
+	PeakRemaining int64 `json:"peak_remaining,omitempty"`
+	// Hidden orders never show in the book and queue behind every displayed
+	// order at their price.
+	Hidden      bool        `json:"hidden,omitempty"`
+	PostOnly    PostOnly    `json:"post_only,omitempty"`
+	Quantity    int64       `json:"quantity"`
+	Timestamp   int64       `json:"timestamp"` // Unix milliseconds
+	Filled      int64       `json:"filled_quantity"`
+	Status      OrderStatus `json:"status"`
+	TimeInForce TimeInForce `json:"time_in_force"`
+	ExpireAt    int64       `json:"expire_at,omitempty"` // Unix milliseconds, GTD and DAY only
+
+	SelfTradePrevention SelfTradePrevention `json:"self_trade_prevention,omitempty"`
+	CancelReason        string              `json:"cancel_reason,omitempty"`
+	TriggeredAt         int64               `json:"triggered_at,omitempty"` // Unix milliseconds, stop orders only
+	// SelfTradeCancels lists the resting orders self-trade prevention
+	// cancelled while this order was matching.
+	SelfTradeCancels []string `json:"-"`
+	// Sequence is assigned by the book when the order starts resting and
+	// orders time priority within a price level; it is strictly increasing
+	// per book.
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Reserved is the cash (buys) or base asset (sells) still held from the
+	// account for the order's open quantity.
+	Reserved int64 `json:"reserved,omitempty"`
+
+	// Position in the order's price level queue while resting
+	level      *priceLevel
+	prev, next *Order
+}
+
+func (o *Order) isOpen() bool {
+	switch o.Status {
+	case OrderStatusAccepted, OrderStatusPartialFill, OrderStatusPendingTrigger, OrderStatusTriggered,
+		OrderStatusQueued:
+		return true
+	}
+	return false
+}
+
+// available is how much a resting order can trade before it loses its
+// place in the queue: an iceberg's peak, otherwise its open quantity.
+func (o *Order) available() int64 {
+	if o.DisplayQuantity > 0 {
+		return o.PeakRemaining
+	}
+	return o.Quantity - o.Filled
+}
+
+// visible is how much of a resting order the book shows.
+func (o *Order) visible() int64 {
+	if o.Hidden {
+		return 0
+	}
+	return o.available()
+}
+
+// HiddenQuantity is the part of an order's open quantity not shown in the
+// book: an iceberg's reserve, or all of a hidden order.
+func (o *Order) HiddenQuantity() int64 {
+	if !o.isOpen() {
+		return 0
+	}
+	if o.Hidden {
+		return o.Quantity - o.Filled
+	}
+	if o.DisplayQuantity == 0 {
+		return 0
+	}
+	return o.Quantity - o.Filled - o.PeakRemaining
+}
+
+func (o *Order) isStop() bool {
+	return o.Type == OrderTypeStopMarket || o.Type == OrderTypeStopLimit
+}
+
+// isMarket reports whether the order trades without a limit price once it
+// is live.
+func (o *Order) isMarket() bool {
+	return o.Type == OrderTypeMarket || o.Type == OrderTypeStopMarket
+}
+
+func (o *Order) isExpired(now int64) bool {
+	return o.ExpireAt > 0 && o.ExpireAt <= now
 }
 
 type Trade struct {
@@ -46,3 +185,30 @@ type Trade struct {
 	MakerOrderID string `json:"maker_order_id"`
 	TakerOrderID string `json:"taker_order_id"`
 }
+
+type MarketOrderPolicy string
+
+const (
+	// Sweep whatever liquidity is available and cancel the remainder.
+	MarketOrderFillAndKill MarketOrderPolicy = "FILL_AND_KILL"
+	// Reject the order before trading unless it can fill completely.
+	MarketOrderAllOrNone MarketOrderPolicy = "ALL_OR_NONE"
+)
+
+func (p MarketOrderPolicy) Valid() bool {
+	return p == MarketOrderFillAndKill || p == MarketOrderAllOrNone
+}
+
+// MarketOrderConfig controls how a symbol handles market orders that don't
+// specify their own time in force.
+type MarketOrderConfig struct {
+	Policy MarketOrderPolicy `json:"policy"`
+	// ProtectionTicks caps how far from the best opposite price, measured
+	// at arrival, a market order may trade. Zero disables the band.
+	ProtectionTicks int64 `json:"protection_ticks"`
+	TickSize        int64 `json:"tick_size"`
+}
+
+func DefaultMarketOrderConfig() MarketOrderConfig {
+	return MarketOrderConfig{Policy: MarketOrderFillAndKill, TickSize: 1}
+}
@@ -0,0 +1,175 @@
+package executions
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+)
+
+const (
+	sendBuffer        = 1024
+	keepAliveInterval = 15 * time.Second
+)
+
+// Event is one execution report as delivered to a client. Seq increases by
+// one per event for the client, across all of its connections, so a gap
+// means reports were missed and the client should re-query its orders.
+type Event struct {
+	Seq uint64 `json:"seq"`
+	engine.ExecutionReport
+}
+
+// Stream pushes execution reports for each client's own orders over
+// server-sent events. It subscribes to the engine's events.
+type Stream struct {
+	// clients maps client IDs to their *client. Reports come from every
+	// book's goroutine, so each client has a lock of its own rather than
+	// books waiting on each other.
+	clients sync.Map
+}
+
+type client struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+	seq  uint64
+}
+
+type subscriber struct {
+	events    chan Event
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// Source is the engine, or set of sharded engines, whose orders are
+// reported.
+type Source interface {
+	Subscribe(sub engine.EventSubscriber) (unsubscribe func())
+}
+
+func NewStream(e Source) *Stream {
+	s := &Stream{}
+	e.Subscribe(s)
+	return s
+}
+
+// OnEvent reports what happens to each order to its client.
+func (s *Stream) OnEvent(e engine.Event) {
+	r, ok := engine.ExecutionReportOf(e)
+	if !ok || r.ClientID == "" {
+		return
+	}
+
+	c := s.client(r.ClientID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	ev := Event{Seq: c.seq, ExecutionReport: r}
+	for sub := range c.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			// Too slow to keep up; the client reconnects and catches up
+			// by querying its orders.
+			log.Printf("executions: dropping slow subscriber for client %s", r.ClientID)
+			delete(c.subs, sub)
+			sub.close()
+		}
+	}
+}
+
+// client returns the client with the given ID, adding it if it's new.
+// Clients stay once added, so their seq carries on across connections.
+func (s *Stream) client(id string) *client {
+	if v, ok := s.clients.Load(id); ok {
+		return v.(*client)
+	}
+	v, _ := s.clients.LoadOrStore(id, &client{subs: make(map[*subscriber]struct{})})
+	return v.(*client)
+}
+
+func (s *Stream) subscribe(clientID string) *subscriber {
+	sub := &subscriber{events: make(chan Event, sendBuffer), closed: make(chan struct{})}
+
+	c := s.client(clientID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *Stream) unsubscribe(clientID string, sub *subscriber) {
+	c := s.client(clientID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, sub)
+	sub.close()
+}
+
+// ServeHTTP streams the authenticated client's execution reports as
+// server-sent events until the client disconnects:
+//
+//	id: 7
+//	event: execution
+//	data: {"seq":7,"exec_type":"TRADE",...}
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	clientID := auth.ClientID(r.Context())
+	if clientID == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, `{"error":"an API key is required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	sub := s.subscribe(clientID)
+	defer s.unsubscribe(clientID, sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev := <-sub.events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("executions: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: execution\ndata: %s\n\n", ev.Seq, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-sub.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
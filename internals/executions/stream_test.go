@@ -0,0 +1,89 @@
+package executions
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/auth"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func TestStream_DeliversOnlyOwnReports(t *testing.T) {
+	e := engine.NewEngine()
+	keys := auth.NewKeys(map[string]auth.Client{"alice-key": {ID: "alice"}})
+	srv := httptest.NewServer(keys.Middleware(NewStream(e)))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer alice-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	events := make(chan Event)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var ev Event
+				json.Unmarshal([]byte(data), &ev)
+				events <- ev
+			}
+		}
+	}()
+
+	e.SubmitOrder(&engine.Order{ID: "bob-1", ClientID: "bob", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 2})
+	e.SubmitOrder(&engine.Order{ID: utils.GenerateUUID(), ClientID: "alice", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 2})
+
+	var got []Event
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("timed out after %d events", len(got))
+		}
+	}
+
+	if got[0].ExecType != engine.ExecTypeNew || got[0].Seq != 1 {
+		t.Errorf("expected NEW with seq 1, got %+v", got[0])
+	}
+	if got[1].ExecType != engine.ExecTypeTrade || got[1].Status != engine.OrderStatusFilled || got[1].Seq != 2 {
+		t.Errorf("expected FILLED trade with seq 2, got %+v", got[1])
+	}
+	for _, ev := range got {
+		if ev.ClientID != "alice" {
+			t.Errorf("received another client's report: %+v", ev)
+		}
+	}
+}
+
+func TestStream_RequiresAPIKey(t *testing.T) {
+	keys := auth.NewKeys(map[string]auth.Client{"alice-key": {ID: "alice"}})
+	stream := keys.Middleware(NewStream(engine.NewEngine()))
+	for _, header := range []string{"", "Bearer bob-key", "alice-key"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		// Naming a client isn't enough
+		req.Header.Set("X-Client-ID", "alice")
+		rr := httptest.NewRecorder()
+		stream.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", header, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/apis"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/shard"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// testOptions lets sessions A and B log on, and A use account acct-a.
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.Clients = []string{"A", "B"}
+	opts.Accounts = map[string][]string{"A": {"acct-a"}}
+	return opts
+}
+
+func startGateway(t *testing.T, opts Options) (*Gateway, string) {
+	t.Helper()
+	eng := engine.NewEngine()
+	t.Cleanup(eng.Close)
+	return serveGateway(t, eng, eng.Events().Subscribe, opts)
+}
+
+// serveGateway starts a gateway on e, subscribed to its events with
+// subscribe.
+func serveGateway(t *testing.T, e Engine, subscribe func(engine.EventSubscriber) func(), opts Options) (*Gateway, string) {
+	t.Helper()
+	g := NewGateway(e, opts)
+	subscribe(g)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(l)
+	t.Cleanup(func() { g.Close() })
+	return g, l.Addr().String()
+}
+
+func logon(t *testing.T, addr, compID string, heartbeat time.Duration) *Initiator {
+	t.Helper()
+	i, err := Dial(addr, compID, "ENGINE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { i.Close() })
+	if _, err := i.Logon(heartbeat, true); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// expect returns the next message that isn't a Heartbeat, failing unless it
+// has the type and fields given.
+func expect(t *testing.T, i *Initiator, msgType string, fields ...Field) *Message {
+	t.Helper()
+	for {
+		m, err := i.Receive(5 * time.Second)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		if m.Type() == MsgHeartbeat && msgType != MsgHeartbeat {
+			continue
+		}
+		if m.Type() != msgType {
+			t.Fatalf("want %s, got %s", msgType, m)
+		}
+		for _, f := range fields {
+			if got := m.Get(f.Tag); got != f.Value {
+				t.Fatalf("tag %d: want %q, got %q in %s", f.Tag, f.Value, got, m)
+			}
+		}
+		return m
+	}
+}
+
+func newOrder(clOrdID, side string, price, quantity int64) *Message {
+	return NewMessage(MsgNewOrderSingle).
+		Set(TagClOrdID, clOrdID).
+		Set(TagSymbol, "BTCUSD").
+		Set(TagSide, side).
+		Set(TagOrdType, "2").
+		SetInt(TagPrice, price).
+		SetInt(TagOrderQty, quantity)
+}
+
+func TestOrderEntry(t *testing.T) {
+	g, addr := startGateway(t, testOptions())
+	a := logon(t, addr, "A", 30*time.Second)
+	b := logon(t, addr, "B", 30*time.Second)
+
+	a.Send(newOrder("a1", "2", 100, 5))
+	ack := expect(t, a, MsgExecutionReport, Field{TagClOrdID, "a1"}, Field{TagExecType, "0"}, Field{TagOrdStatus, "0"}, Field{TagLeavesQty, "5"})
+	orderID := ack.Get(TagOrderID)
+
+	// Both sides hear of the trade
+	b.Send(newOrder("b1", "1", 100, 3))
+	expect(t, b, MsgExecutionReport, Field{TagClOrdID, "b1"}, Field{TagExecType, "0"})
+	expect(t, b, MsgExecutionReport, Field{TagClOrdID, "b1"}, Field{TagExecType, "F"}, Field{TagOrdStatus, "2"},
+		Field{TagLastQty, "3"}, Field{TagLastPx, "100"}, Field{TagLeavesQty, "0"}, Field{TagCumQty, "3"})
+	expect(t, a, MsgExecutionReport, Field{TagOrderID, orderID}, Field{TagExecType, "F"}, Field{TagOrdStatus, "1"},
+		Field{TagLeavesQty, "2"}, Field{TagCumQty, "3"}, Field{TagAvgPx, "100"})
+
+	a.Send(NewMessage(MsgOrderStatusRequest).Set(TagClOrdID, "a1").Set(TagOrdStatusReqID, "s1"))
+	expect(t, a, MsgExecutionReport, Field{TagExecType, "I"}, Field{TagOrdStatus, "1"}, Field{TagOrdStatusReqID, "s1"})
+
+	a.Send(NewMessage(MsgOrderCancelReplaceRequest).
+		Set(TagClOrdID, "a2").Set(TagOrigClOrdID, "a1").Set(TagSymbol, "BTCUSD").Set(TagSide, "2").
+		SetInt(TagOrderQty, 4).SetInt(TagPrice, 101))
+	expect(t, a, MsgExecutionReport, Field{TagClOrdID, "a2"}, Field{TagOrigClOrdID, "a1"}, Field{TagExecType, "5"},
+		Field{TagOrderQty, "4"}, Field{TagPrice, "101"}, Field{TagLeavesQty, "1"})
+
+	a.Send(NewMessage(MsgOrderCancelRequest).Set(TagClOrdID, "a3").Set(TagOrigClOrdID, "a2"))
+	expect(t, a, MsgExecutionReport, Field{TagClOrdID, "a3"}, Field{TagOrigClOrdID, "a2"}, Field{TagExecType, "4"},
+		Field{TagOrdStatus, "4"}, Field{TagLeavesQty, "0"})
+
+	// A finished order is forgotten
+	a.Send(NewMessage(MsgOrderCancelRequest).Set(TagClOrdID, "a4").Set(TagOrigClOrdID, "a3"))
+	expect(t, a, MsgOrderCancelReject, Field{TagOrderID, "NONE"}, Field{TagCxlRejResponseTo, "1"}, Field{TagCxlRejReason, "1"})
+	g.mu.Lock()
+	if n := len(g.orders) + len(g.sessions["A"].orders) + len(g.sessions["B"].orders); n != 0 {
+		t.Errorf("%d finished orders kept", n)
+	}
+	g.mu.Unlock()
+
+	a.Send(newOrder("a6", "3", 100, 1))
+	expect(t, a, MsgExecutionReport, Field{TagOrderID, "NONE"}, Field{TagExecType, "8"}, Field{TagOrdStatus, "8"})
+
+	a.Send(NewMessage("B"))
+	expect(t, a, MsgReject, Field{TagSessionRejectReason, "11"})
+
+	if err := a.Logout(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResendAfterReconnect(t *testing.T) {
+	g, addr := startGateway(t, testOptions())
+	a := logon(t, addr, "A", 30*time.Second)
+	b := logon(t, addr, "B", 30*time.Second)
+
+	a.Send(newOrder("a1", "2", 100, 5))
+	ack := expect(t, a, MsgExecutionReport, Field{TagExecType, "0"})
+	a.Close()
+	waitDisconnected(t, g, "A")
+
+	// The fill happens while A is away
+	b.Send(newOrder("b1", "1", 100, 5))
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "0"})
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "F"})
+
+	a2, err := Dial(addr, "A", "ENGINE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a2.Close()
+	a2.SetNextSeqNum(a.NextSeqNum())
+	reply, err := a2.Logon(30*time.Second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missed := ack.SeqNum() + 1
+	if reply.SeqNum() != missed+1 {
+		t.Fatalf("logon reply is %d, want %d", reply.SeqNum(), missed+1)
+	}
+
+	a2.Send(NewMessage(MsgResendRequest).Set(TagBeginSeqNo, strconv.FormatUint(missed, 10)).Set(TagEndSeqNo, "0"))
+	expect(t, a2, MsgExecutionReport, Field{TagMsgSeqNum, strconv.FormatUint(missed, 10)}, Field{TagPossDupFlag, "Y"},
+		Field{TagExecType, "F"}, Field{TagOrdStatus, "2"}, Field{TagClOrdID, "a1"})
+	expect(t, a2, MsgSequenceReset, Field{TagMsgSeqNum, strconv.FormatUint(missed+1, 10)}, Field{TagGapFillFlag, "Y"},
+		Field{TagNewSeqNo, strconv.FormatUint(missed+2, 10)})
+}
+
+func TestResendLimit(t *testing.T) {
+	opts := testOptions()
+	opts.ResendLimit = 2
+	_, addr := startGateway(t, opts)
+	a := logon(t, addr, "A", 30*time.Second)
+
+	for _, id := range []string{"a1", "a2", "a3"} {
+		a.Send(newOrder(id, "2", 100, 5))
+		expect(t, a, MsgExecutionReport, Field{TagClOrdID, id}, Field{TagExecType, "0"})
+	}
+
+	// Only the last two reports are kept; the logon and a1 are gap filled
+	a.Send(NewMessage(MsgResendRequest).Set(TagBeginSeqNo, "1").Set(TagEndSeqNo, "0"))
+	expect(t, a, MsgSequenceReset, Field{TagMsgSeqNum, "1"}, Field{TagGapFillFlag, "Y"}, Field{TagNewSeqNo, "3"})
+	expect(t, a, MsgExecutionReport, Field{TagMsgSeqNum, "3"}, Field{TagPossDupFlag, "Y"}, Field{TagClOrdID, "a2"})
+	expect(t, a, MsgExecutionReport, Field{TagMsgSeqNum, "4"}, Field{TagPossDupFlag, "Y"}, Field{TagClOrdID, "a3"})
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	opts := testOptions()
+	opts.Dir = t.TempDir()
+	g, addr := startGateway(t, opts)
+	a := logon(t, addr, "A", 30*time.Second)
+	a.Send(newOrder("a1", "2", 100, 5))
+	ack := expect(t, a, MsgExecutionReport, Field{TagExecType, "0"})
+	g.Close()
+	// The gateway logged A out on the way down
+	logout := expect(t, a, MsgLogout)
+
+	_, addr = startGateway(t, opts)
+	a2, err := Dial(addr, "A", "ENGINE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a2.Close()
+	a2.SetNextSeqNum(a.NextSeqNum())
+	reply, err := a2.Logon(30*time.Second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.SeqNum() != logout.SeqNum()+1 {
+		t.Fatalf("logon reply is %d, want %d", reply.SeqNum(), logout.SeqNum()+1)
+	}
+
+	a2.Send(NewMessage(MsgResendRequest).Set(TagBeginSeqNo, ack.Get(TagMsgSeqNum)).Set(TagEndSeqNo, "0"))
+	expect(t, a2, MsgExecutionReport, Field{TagMsgSeqNum, ack.Get(TagMsgSeqNum)}, Field{TagPossDupFlag, "Y"},
+		Field{TagClOrdID, "a1"}, Field{TagOrderID, ack.Get(TagOrderID)})
+	expect(t, a2, MsgSequenceReset, Field{TagGapFillFlag, "Y"}, Field{TagNewSeqNo, strconv.FormatUint(reply.SeqNum()+1, 10)})
+}
+
+func TestDecimalPrices(t *testing.T) {
+	eng := engine.NewEngine()
+	t.Cleanup(eng.Close)
+	inst := engine.Instrument{Symbol: "BTCUSD", PricePrecision: 2, TickSize: 5, LotSize: 1, MinQuantity: 1}
+	if err := eng.AddInstrument(inst); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := serveGateway(t, eng, eng.Events().Subscribe, testOptions())
+	a := logon(t, addr, "A", 30*time.Second)
+	b := logon(t, addr, "B", 30*time.Second)
+
+	a.Send(newOrder("a1", "2", 0, 5).Set(TagPrice, "101.25"))
+	ack := expect(t, a, MsgExecutionReport, Field{TagExecType, "0"}, Field{TagPrice, "101.25"})
+	if o, err := eng.GetOrder(ack.Get(TagOrderID)); err != nil || o.Price != 10125 {
+		t.Fatalf("engine order %+v, %v", o, err)
+	}
+
+	b.Send(newOrder("b1", "1", 0, 2).Set(TagPrice, "101.3"))
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "0"}, Field{TagPrice, "101.3"})
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "F"}, Field{TagLastPx, "101.25"}, Field{TagAvgPx, "101.25"})
+
+	a.Send(NewMessage(MsgOrderCancelReplaceRequest).
+		Set(TagClOrdID, "a2").Set(TagOrigClOrdID, "a1").SetInt(TagOrderQty, 5).Set(TagPrice, "102"))
+	expect(t, a, MsgExecutionReport, Field{TagExecType, "F"})
+	expect(t, a, MsgExecutionReport, Field{TagExecType, "5"}, Field{TagPrice, "102"})
+
+	b.Send(newOrder("b2", "1", 0, 1).Set(TagPrice, "101.255"))
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "8"}, Field{TagText, `tag 44: "101.255" has more than 2 decimal places`})
+}
+
+func TestShardedOrderEntry(t *testing.T) {
+	set, err := shard.Open(shard.Options{Shards: 2, Assign: map[string]int{"BTCUSD": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { set.Close() })
+	opts := testOptions()
+	opts.LocalOrderID = shard.LocalOrderID
+	_, addr := serveGateway(t, apis.NewShardRouter(set), set.Subscribe, opts)
+	a := logon(t, addr, "A", 30*time.Second)
+	b := logon(t, addr, "B", 30*time.Second)
+
+	a.Send(newOrder("a1", "2", 100, 5))
+	ack := expect(t, a, MsgExecutionReport, Field{TagExecType, "0"})
+	orderID := ack.Get(TagOrderID)
+	if n, ok := shard.DecodeOrderID(orderID); !ok || n != 1 {
+		t.Fatalf("order ID %s doesn't name shard 1", orderID)
+	}
+
+	b.Send(newOrder("b1", "1", 100, 2))
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "0"})
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "F"}, Field{TagOrdStatus, "2"})
+	expect(t, a, MsgExecutionReport, Field{TagOrderID, orderID}, Field{TagExecType, "F"}, Field{TagLeavesQty, "3"})
+
+	a.Send(NewMessage(MsgOrderCancelRequest).Set(TagClOrdID, "a2").Set(TagOrigClOrdID, "a1"))
+	expect(t, a, MsgExecutionReport, Field{TagOrderID, orderID}, Field{TagClOrdID, "a2"}, Field{TagExecType, "4"})
+}
+
+func waitDisconnected(t *testing.T, g *Gateway, compID string) {
+	t.Helper()
+	g.mu.Lock()
+	s := g.sessions[compID]
+	g.mu.Unlock()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		c := s.conn
+		s.mu.Unlock()
+		if c == nil {
+			return
+		}
+	}
+	t.Fatalf("session %s is still connected", compID)
+}
+
+func TestIncomingGap(t *testing.T) {
+	_, addr := startGateway(t, testOptions())
+	a := logon(t, addr, "A", 30*time.Second)
+
+	// Message 2 goes missing; 3 is dropped until the gap is filled
+	a.SetNextSeqNum(3)
+	a.Send(newOrder("a1", "2", 100, 5))
+	expect(t, a, MsgResendRequest, Field{TagBeginSeqNo, "2"}, Field{TagEndSeqNo, "0"})
+
+	// The initiator gap filled past a1, so only a2 is acknowledged
+	a.Send(newOrder("a2", "2", 100, 5))
+	expect(t, a, MsgExecutionReport, Field{TagClOrdID, "a2"}, Field{TagExecType, "0"})
+}
+
+func TestHeartbeats(t *testing.T) {
+	_, addr := startGateway(t, testOptions())
+	a := logon(t, addr, "A", time.Second)
+
+	seen := map[string]bool{}
+	for !seen[MsgHeartbeat] || !seen[MsgTestRequest] {
+		m, err := a.Receive(5 * time.Second)
+		if err != nil {
+			t.Fatalf("heartbeats %v: %v", seen, err)
+		}
+		seen[m.Type()] = true
+	}
+
+	// The TestRequest was answered, so the session is still up
+	a.Send(NewMessage(MsgOrderStatusRequest).Set(TagClOrdID, "x"))
+	expect(t, a, MsgExecutionReport, Field{TagExecType, "I"}, Field{TagOrderID, "NONE"})
+}
+
+func TestLogonRefused(t *testing.T) {
+	opts := testOptions()
+	opts.Clients = []string{"A"}
+	_, addr := startGateway(t, opts)
+
+	i, err := Dial(addr, "X", "ENGINE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	if _, err := i.Logon(30*time.Second, true); err == nil {
+		t.Fatal("unknown SenderCompID logged on")
+	}
+	logon(t, addr, "A", 30*time.Second)
+
+	// Without a list of clients nobody can log on
+	_, addr = startGateway(t, DefaultOptions())
+	i, err = Dial(addr, "A", "ENGINE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.Close()
+	if _, err := i.Logon(30*time.Second, true); err == nil {
+		t.Fatal("logged on to a gateway without clients")
+	}
+}
+
+func TestSessionAccounts(t *testing.T) {
+	eng := engine.NewEngine()
+	t.Cleanup(eng.Close)
+	if err := eng.CreateAccount("acct-a", engine.RiskLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	eng.AdjustBalance("acct-a", engine.DefaultQuoteAsset, 1000)
+	_, addr := serveGateway(t, eng, eng.Events().Subscribe, testOptions())
+	a := logon(t, addr, "A", 30*time.Second)
+	b := logon(t, addr, "B", 30*time.Second)
+
+	a.Send(newOrder("a1", "1", 100, 5).Set(TagAccount, "acct-a"))
+	expect(t, a, MsgExecutionReport, Field{TagExecType, "0"}, Field{TagAccount, "acct-a"})
+	a.Send(newOrder("a2", "1", 100, 5).Set(TagAccount, "acct-b"))
+	expect(t, a, MsgExecutionReport, Field{TagExecType, "8"}, Field{TagText, "Account not permitted for this client"})
+	b.Send(newOrder("b1", "1", 100, 5).Set(TagAccount, "acct-a"))
+	expect(t, b, MsgExecutionReport, Field{TagExecType, "8"}, Field{TagText, "Account not permitted for this client"})
+}
+
+func TestReadMessage(t *testing.T) {
+	m := newOrder("a1", "1", 100, 5).Set(TagMsgSeqNum, "7")
+	b := m.Bytes()
+	got, err := ReadMessage(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != m.String() {
+		t.Errorf("read %s, want %s", got, m)
+	}
+
+	b[bytes.Index(b, []byte("55="))+3]++
+	if _, err := ReadMessage(bufio.NewReader(bytes.NewReader(b))); err != utils.ErrFIXGarbled {
+		t.Errorf("bad checksum: got %v", err)
+	}
+}
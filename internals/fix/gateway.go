@@ -0,0 +1,807 @@
+package fix
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/audit"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// Engine is what the gateway needs from the engine.
+type Engine interface {
+	SubmitOrder(order *engine.Order) ([]engine.Trade, error)
+	CancelOrder(orderID string) error
+	AmendOrder(orderID string, price, quantity int64) ([]engine.Trade, error)
+	GetOrder(orderID string) (*engine.Order, error)
+	GetInstrument(symbol string) (engine.Instrument, error)
+}
+
+type Options struct {
+	// CompID is the gateway's own, the TargetCompID of incoming messages.
+	CompID string
+	// Clients lists the SenderCompIDs allowed to log on; no others can.
+	Clients []string
+	// Accounts lists, by SenderCompID, the accounts a session's orders may
+	// name. Orders naming any other account are rejected.
+	Accounts map[string][]string
+	// LogonTimeout is how long a new connection has to send its Logon.
+	LogonTimeout time.Duration
+	// Audit, if set, records every instruction.
+	Audit *audit.Log
+	// Dir, if set, is where each session's sequence numbers and sent
+	// messages are kept, so that sessions carry on across restarts.
+	Dir string
+	// ResendLimit is how many of its latest application messages a session
+	// keeps to resend; older ones are gap filled.
+	ResendLimit int
+	// LocalOrderID, if set, turns the ID the engine gives an order back
+	// into the one the gateway submitted it with, for an engine that
+	// renames orders on entry as a sharded one does.
+	LocalOrderID func(orderID string) string
+}
+
+func DefaultOptions() Options {
+	return Options{CompID: "ENGINE", LogonTimeout: 10 * time.Second, ResendLimit: 10000}
+}
+
+// A Gateway accepts FIX 4.4 sessions over TCP and turns NewOrderSingle,
+// OrderCancelRequest, OrderCancelReplaceRequest and OrderStatusRequest into
+// engine commands. ExecutionReports come from the engine's events, so fills
+// of a resting order reach its session whenever they happen; the Gateway
+// has to be subscribed to the engine's event bus.
+//
+// Prices are decimals, scaled to the engine's integers by the instrument's
+// PricePrecision; an unlisted symbol's prices are whole numbers. Quantities
+// are the engine's integers. An order's ClientID is its session's
+// SenderCompID.
+type Gateway struct {
+	engine Engine
+	opts   Options
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	// orders are the open orders entered through the gateway, by the ID
+	// the gateway submitted them with
+	orders    map[string]*order
+	listeners []net.Listener
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// order is what the gateway knows of one of its orders: enough to fill in
+// ExecutionReports.
+type order struct {
+	session     *session
+	id          string
+	clOrdID     string
+	origClOrdID string
+	// pending is the ClOrdID of a cancel or replace the engine is working
+	// on; the report that answers it carries it.
+	pending string
+
+	account  string
+	symbol   string
+	side     engine.Side
+	typ      engine.OrderType
+	price    int64
+	stopPx   int64
+	quantity int64
+	filled   int64
+	notional int64
+	status   engine.OrderStatus
+	// precision is the number of decimal places of the symbol's prices
+	precision int
+}
+
+func NewGateway(e Engine, opts Options) *Gateway {
+	if opts.LogonTimeout <= 0 {
+		opts.LogonTimeout = DefaultOptions().LogonTimeout
+	}
+	if opts.ResendLimit <= 0 {
+		opts.ResendLimit = DefaultOptions().ResendLimit
+	}
+	return &Gateway{
+		engine:   e,
+		opts:     opts,
+		sessions: make(map[string]*session),
+		orders:   make(map[string]*order),
+	}
+}
+
+func (g *Gateway) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
+
+// Serve accepts connections on l until the gateway is closed.
+func (g *Gateway) Serve(l net.Listener) error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		l.Close()
+		return utils.ErrGatewayClosed
+	}
+	g.listeners = append(g.listeners, l)
+	g.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			g.mu.Lock()
+			closed := g.closed
+			g.mu.Unlock()
+			if closed {
+				return utils.ErrGatewayClosed
+			}
+			return err
+		}
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			g.serveConn(nc)
+		}()
+	}
+}
+
+// Close stops accepting connections and logs every session out.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	for _, l := range g.listeners {
+		l.Close()
+	}
+	sessions := make([]*session, 0, len(g.sessions))
+	for _, s := range g.sessions {
+		sessions = append(sessions, s)
+	}
+	g.mu.Unlock()
+
+	for _, s := range sessions {
+		s.mu.Lock()
+		c := s.conn
+		if c != nil {
+			s.sendLocked(NewMessage(MsgLogout).Set(TagText, "gateway shutting down"))
+		}
+		s.mu.Unlock()
+		if c != nil {
+			c.close()
+		}
+	}
+	g.wg.Wait()
+
+	var err error
+	for _, s := range sessions {
+		s.mu.Lock()
+		if s.store != nil {
+			if cerr := s.store.close(); err == nil {
+				err = cerr
+			}
+			s.store = nil
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (g *Gateway) serveConn(nc net.Conn) {
+	r := bufio.NewReader(nc)
+	nc.SetReadDeadline(time.Now().Add(g.opts.LogonTimeout))
+	m, err := ReadMessage(r)
+	if err != nil || m.Type() != MsgLogon {
+		nc.Close()
+		return
+	}
+
+	s, c, reason := g.logon(nc, m)
+	if reason != "" {
+		log.Printf("FIX logon from %s (%s) refused: %s", m.Get(TagSenderCompID), nc.RemoteAddr(), reason)
+		nc.Close()
+		return
+	}
+	s.serve(c, r, m)
+}
+
+// logon finds or creates the session a Logon is for and connects it.
+func (g *Gateway) logon(nc net.Conn, m *Message) (*session, *conn, string) {
+	compID := m.Get(TagSenderCompID)
+	if compID == "" || m.Get(TagTargetCompID) != g.opts.CompID {
+		return nil, nil, "CompID problem"
+	}
+	if !contains(g.opts.Clients, compID) {
+		return nil, nil, "unknown SenderCompID"
+	}
+	if m.Get(TagEncryptMethod) != "0" {
+		return nil, nil, "EncryptMethod must be 0"
+	}
+	hb, err := m.Int(TagHeartBtInt)
+	if err != nil || hb <= 0 {
+		return nil, nil, "HeartBtInt must be positive"
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil, nil, "gateway shutting down"
+	}
+	s, ok := g.sessions[compID]
+	if !ok {
+		if s, err = newSession(g, compID); err != nil {
+			g.mu.Unlock()
+			log.Printf("FIX session %s: %v", compID, err)
+			return nil, nil, "session store unavailable"
+		}
+		g.sessions[compID] = s
+	}
+	g.mu.Unlock()
+
+	c := newConn(nc, time.Duration(hb)*time.Second)
+	go c.writeLoop()
+	if reason := s.logon(c, m); reason != "" {
+		c.close()
+		return nil, nil, reason
+	}
+	return s, c, ""
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// application handles an order entry message on the session's reading
+// goroutine. The engine is never called with the gateway's lock held: its
+// events take it.
+func (g *Gateway) application(s *session, m *Message) {
+	switch m.Type() {
+	case MsgNewOrderSingle:
+		g.newOrder(s, m)
+	case MsgOrderCancelRequest:
+		g.cancelOrder(s, m)
+	case MsgOrderCancelReplaceRequest:
+		g.replaceOrder(s, m)
+	case MsgOrderStatusRequest:
+		g.orderStatus(s, m)
+	}
+}
+
+func (g *Gateway) newOrder(s *session, m *Message) {
+	received := time.Now()
+	precision := g.precision(m.Get(TagSymbol))
+	o, reason := parseNewOrder(m, precision)
+	o.ID = utils.GenerateUUID()
+	o.ClientID = s.compID
+	o.Timestamp = received.UnixMilli()
+	o.Status = engine.OrderStatusAccepted
+	fo := &order{
+		session:   s,
+		id:        o.ID,
+		clOrdID:   m.Get(TagClOrdID),
+		account:   o.AccountID,
+		symbol:    o.Symbol,
+		side:      o.Side,
+		typ:       o.Type,
+		price:     o.Price,
+		stopPx:    o.StopPrice,
+		quantity:  o.Quantity,
+		status:    o.Status,
+		precision: precision,
+	}
+
+	g.mu.Lock()
+	if reason == "" && fo.clOrdID == "" {
+		reason = "ClOrdID is required"
+	}
+	if reason == "" && o.AccountID != "" && !contains(g.opts.Accounts[s.compID], o.AccountID) {
+		reason = "Account " + utils.ErrNotPermitted.Error()
+	}
+	if err := g.auditHealth(); reason == "" && err != nil {
+		reason = err.Error()
+	}
+	if prev, ok := s.orders[fo.clOrdID]; reason == "" && ok && isOpen(prev.status) {
+		reason = "duplicate ClOrdID"
+	}
+	if reason != "" {
+		fo.id = "NONE"
+		fo.status = engine.OrderStatusRejected
+		s.send(fo.report(execRejected, received).Set(TagText, reason).Set(TagOrdRejReason, "99"))
+		g.mu.Unlock()
+		g.audit(audit.Submitted(received, s.compID, o, reason))
+		return
+	}
+	s.orders[fo.clOrdID] = fo
+	g.orders[fo.id] = fo
+	g.mu.Unlock()
+
+	_, err := g.engine.SubmitOrder(o)
+	g.mu.Lock()
+	// The engine may have renamed the order
+	fo.id = o.ID
+	if err != nil {
+		// A reject made by the book has been reported from its event
+		if isOpen(fo.status) {
+			fo.status = engine.OrderStatusRejected
+			s.send(fo.report(execRejected, time.Now()).Set(TagText, err.Error()).Set(TagOrdRejReason, ordRejReason(err)))
+		}
+		g.forget(fo)
+		reason = err.Error()
+	}
+	g.mu.Unlock()
+	if g.opts.Audit != nil {
+		// Once in the book the order is the book's; read a copy
+		if current, err := g.engine.GetOrder(o.ID); err == nil {
+			o = current
+		}
+		g.audit(audit.Submitted(received, s.compID, o, reason))
+	}
+}
+
+func (g *Gateway) cancelOrder(s *session, m *Message) {
+	g.change(s, m, cxlRejResponseToCancel, audit.ActionCancel, func(id string, _ int) error {
+		return g.engine.CancelOrder(id)
+	})
+}
+
+func (g *Gateway) replaceOrder(s *session, m *Message) {
+	quantity, err := m.Int(TagOrderQty)
+	if err != nil {
+		g.cancelReject(s, m, nil, cxlRejResponseToReplace, "99", err.Error())
+		return
+	}
+	g.change(s, m, cxlRejResponseToReplace, audit.ActionAmend, func(id string, precision int) error {
+		var price int64
+		if _, ok := m.Lookup(TagPrice); ok {
+			if price, err = m.Price(TagPrice, precision); err != nil {
+				return err
+			}
+		}
+		_, err := g.engine.AmendOrder(id, price, quantity)
+		return err
+	})
+}
+
+const (
+	cxlRejResponseToCancel  = "1"
+	cxlRejResponseToReplace = "2"
+)
+
+// change applies a cancel or replace to the order OrigClOrdID (or OrderID)
+// names, given its ID and price precision. Success is reported from the
+// engine's event.
+func (g *Gateway) change(s *session, m *Message, responseTo string, action audit.Action, apply func(id string, precision int) error) {
+	received := time.Now()
+	clOrdID := m.Get(TagClOrdID)
+
+	g.mu.Lock()
+	fo := g.find(s, m.Get(TagOrigClOrdID), m.Get(TagOrderID))
+	if fo == nil || clOrdID == "" {
+		g.mu.Unlock()
+		reason := "unknown order"
+		if clOrdID == "" {
+			reason = "ClOrdID is required"
+		}
+		g.cancelReject(s, m, nil, responseTo, "1", reason)
+		g.audit(audit.Changed(received, action, s.compID, m.Get(TagOrderID), nil, nil, reason))
+		return
+	}
+	fo.pending = clOrdID
+	id := fo.id
+	g.mu.Unlock()
+
+	before, _ := g.engine.GetOrder(id)
+	err := g.auditHealth()
+	if err == nil {
+		err = apply(id, fo.precision)
+	}
+
+	g.mu.Lock()
+	fo.pending = ""
+	g.mu.Unlock()
+	if err != nil {
+		g.cancelReject(s, m, fo, responseTo, cxlRejReason(err), err.Error())
+		g.audit(audit.Changed(received, action, s.compID, id, before, nil, err.Error()))
+		return
+	}
+	if g.opts.Audit != nil {
+		after, _ := g.engine.GetOrder(id)
+		g.audit(audit.Changed(received, action, s.compID, id, before, after, ""))
+	}
+}
+
+// find looks an order up by ClOrdID, or else by OrderID. It is called with
+// g.mu held.
+func (g *Gateway) find(s *session, clOrdID, orderID string) *order {
+	if fo, ok := s.orders[clOrdID]; ok && clOrdID != "" {
+		return fo
+	}
+	for _, fo := range s.orders {
+		if fo.id == orderID {
+			return fo
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) cancelReject(s *session, m *Message, fo *order, responseTo, reason, text string) {
+	r := NewMessage(MsgOrderCancelReject).
+		Set(TagOrderID, "NONE").
+		Set(TagClOrdID, m.Get(TagClOrdID)).
+		Set(TagOrigClOrdID, m.Get(TagOrigClOrdID)).
+		Set(TagOrdStatus, ordStatusRejected).
+		Set(TagCxlRejResponseTo, responseTo).
+		Set(TagCxlRejReason, reason).
+		Set(TagText, text)
+	if fo != nil {
+		g.mu.Lock()
+		r.Set(TagOrderID, fo.id).Set(TagOrdStatus, ordStatus(fo.status))
+		g.mu.Unlock()
+	}
+	s.send(r)
+}
+
+func (g *Gateway) orderStatus(s *session, m *Message) {
+	g.mu.Lock()
+	fo := g.find(s, m.Get(TagClOrdID), m.Get(TagOrderID))
+	var id string
+	if fo != nil {
+		id = fo.id
+	}
+	g.mu.Unlock()
+
+	var current *engine.Order
+	if fo != nil {
+		current, _ = g.engine.GetOrder(id)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var r *Message
+	if fo == nil {
+		unknown := &order{id: "NONE", clOrdID: m.Get(TagClOrdID), status: engine.OrderStatusRejected}
+		r = unknown.report(execOrderStatus, time.Now()).Set(TagText, "unknown order")
+	} else {
+		if current != nil {
+			fo.update(current)
+		}
+		r = fo.report(execOrderStatus, time.Now())
+	}
+	if id := m.Get(TagOrdStatusReqID); id != "" {
+		r.Set(TagOrdStatusReqID, id)
+	}
+	s.send(r)
+	if fo != nil && !isOpen(fo.status) {
+		g.forget(fo)
+	}
+}
+
+// precision is the number of decimal places of symbol's prices.
+func (g *Gateway) precision(symbol string) int {
+	inst, err := g.engine.GetInstrument(symbol)
+	if err != nil {
+		return 0
+	}
+	return inst.PricePrecision
+}
+
+// key is the ID the gateway submitted the order the engine calls orderID
+// with.
+func (g *Gateway) key(orderID string) string {
+	if g.opts.LocalOrderID != nil {
+		return g.opts.LocalOrderID(orderID)
+	}
+	return orderID
+}
+
+// forget drops an order that has finished; its ClOrdID may be used again.
+// It is called with g.mu held.
+func (g *Gateway) forget(fo *order) {
+	delete(g.orders, g.key(fo.id))
+	if fo.session.orders[fo.clOrdID] == fo {
+		delete(fo.session.orders, fo.clOrdID)
+	}
+}
+
+// auditHealth fails once the audit log has stopped, so that no order
+// instruction is applied without being recorded.
+func (g *Gateway) auditHealth() error {
+	if g.opts.Audit == nil {
+		return nil
+	}
+	return g.opts.Audit.Err()
+}
+
+func (g *Gateway) audit(r audit.Record) {
+	if g.opts.Audit != nil {
+		g.opts.Audit.Write(r)
+	}
+}
+
+// OnEvent reports what happens to the gateway's orders to their sessions.
+func (g *Gateway) OnEvent(ev engine.Event) {
+	switch ev := ev.(type) {
+	case *engine.OrderAccepted:
+		g.report(&ev.Order, execNew, ev.Timestamp, "")
+	case *engine.OrderRejected:
+		g.report(&ev.Order, execRejected, ev.Timestamp, ev.Reason)
+	case *engine.OrderCancelled:
+		g.report(&ev.Order, execCanceled, ev.Timestamp, ev.Reason)
+	case *engine.OrderExpired:
+		g.report(&ev.Order, execExpired, ev.Timestamp, "")
+	case *engine.OrderAmended:
+		g.report(&ev.Order, execReplaced, ev.Timestamp, ev.Reason)
+	case *engine.OrderTriggered:
+		g.report(&ev.Order, execTriggered, ev.Timestamp, "")
+	case *engine.TradeExecuted:
+		g.fill(ev.Trade.MakerOrderID, &ev.Trade)
+		g.fill(ev.Trade.TakerOrderID, &ev.Trade)
+	}
+}
+
+func (g *Gateway) report(o *engine.Order, execType string, ts int64, text string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fo, ok := g.orders[g.key(o.ID)]
+	if !ok {
+		return
+	}
+	fo.update(o)
+	// A requested cancel or replace is answered with the request's ClOrdID,
+	// which a replaced order goes by from then on
+	if fo.pending != "" && (execType == execCanceled || execType == execReplaced) {
+		if fo.session.orders[fo.clOrdID] == fo {
+			delete(fo.session.orders, fo.clOrdID)
+		}
+		fo.origClOrdID, fo.clOrdID = fo.clOrdID, fo.pending
+		fo.session.orders[fo.clOrdID] = fo
+		fo.pending = ""
+	}
+	r := fo.report(execType, time.UnixMilli(ts))
+	if text != "" {
+		r.Set(TagText, text)
+	}
+	fo.session.send(r)
+	if !isOpen(fo.status) {
+		g.forget(fo)
+	}
+}
+
+func (g *Gateway) fill(orderID string, trade *engine.Trade) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fo, ok := g.orders[g.key(orderID)]
+	if !ok {
+		return
+	}
+	fo.id = orderID
+	fo.filled += trade.Quantity
+	fo.notional += trade.Quantity * trade.Price
+	fo.status = engine.OrderStatusPartialFill
+	if fo.filled >= fo.quantity {
+		fo.status = engine.OrderStatusFilled
+	}
+	fo.session.send(fo.report(execTrade, time.UnixMilli(trade.Timestamp)).
+		SetInt(TagLastQty, trade.Quantity).
+		SetPrice(TagLastPx, trade.Price, fo.precision).
+		Set(TagTrdMatchID, trade.ID))
+	if !isOpen(fo.status) {
+		g.forget(fo)
+	}
+}
+
+func (fo *order) update(o *engine.Order) {
+	fo.id, fo.price, fo.quantity, fo.filled, fo.status = o.ID, o.Price, o.Quantity, o.Filled, o.Status
+}
+
+// ExecType values
+const (
+	execNew         = "0"
+	execCanceled    = "4"
+	execReplaced    = "5"
+	execRejected    = "8"
+	execExpired     = "C"
+	execTrade       = "F"
+	execOrderStatus = "I"
+	execTriggered   = "L"
+)
+
+const ordStatusRejected = "8"
+
+func (fo *order) report(execType string, at time.Time) *Message {
+	m := NewMessage(MsgExecutionReport).
+		Set(TagOrderID, fo.id).
+		Set(TagClOrdID, fo.clOrdID).
+		Set(TagExecID, utils.GenerateUUID()).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, ordStatus(fo.status)).
+		Set(TagSymbol, fo.symbol).
+		Set(TagSide, sideCode(fo.side)).
+		Set(TagOrdType, ordTypeCode(fo.typ)).
+		SetInt(TagOrderQty, fo.quantity).
+		SetInt(TagLeavesQty, fo.leaves()).
+		SetInt(TagCumQty, fo.filled).
+		Set(TagAvgPx, fo.avgPx()).
+		SetTime(TagTransactTime, at)
+	if fo.origClOrdID != "" {
+		m.Set(TagOrigClOrdID, fo.origClOrdID)
+	}
+	if fo.account != "" {
+		m.Set(TagAccount, fo.account)
+	}
+	if fo.price > 0 {
+		m.SetPrice(TagPrice, fo.price, fo.precision)
+	}
+	if fo.stopPx > 0 {
+		m.SetPrice(TagStopPx, fo.stopPx, fo.precision)
+	}
+	return m
+}
+
+func (fo *order) leaves() int64 {
+	if !isOpen(fo.status) {
+		return 0
+	}
+	return fo.quantity - fo.filled
+}
+
+func (fo *order) avgPx() string {
+	if fo.filled == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(fo.notional)/(float64(fo.filled)*math.Pow10(fo.precision)), 'f', -1, 64)
+}
+
+func isOpen(status engine.OrderStatus) bool {
+	switch status {
+	case engine.OrderStatusFilled, engine.OrderStatusCancelled, engine.OrderStatusPartialFillCancelled,
+		engine.OrderStatusRejected, engine.OrderStatusExpired:
+		return false
+	}
+	return true
+}
+
+func ordStatus(status engine.OrderStatus) string {
+	switch status {
+	case engine.OrderStatusPartialFill:
+		return "1"
+	case engine.OrderStatusFilled:
+		return "2"
+	case engine.OrderStatusCancelled, engine.OrderStatusPartialFillCancelled:
+		return "4"
+	case engine.OrderStatusRejected:
+		return ordStatusRejected
+	case engine.OrderStatusExpired:
+		return "C"
+	case engine.OrderStatusQueued:
+		return "A" // Pending New
+	}
+	return "0"
+}
+
+func sideCode(side engine.Side) string {
+	if side == engine.SideSell {
+		return "2"
+	}
+	return "1"
+}
+
+func ordTypeCode(typ engine.OrderType) string {
+	switch typ {
+	case engine.OrderTypeMarket:
+		return "1"
+	case engine.OrderTypeStopMarket:
+		return "3"
+	case engine.OrderTypeStopLimit:
+		return "4"
+	}
+	return "2"
+}
+
+func ordRejReason(err error) string {
+	switch err {
+	case utils.ErrUnknownSymbol:
+		return "1" // Unknown symbol
+	case utils.ErrMarketNotOpen:
+		return "2" // Exchange closed
+	case utils.ErrMaxOrderSize, utils.ErrMaxNotional, utils.ErrMaxOpenOrders:
+		return "3" // Order exceeds limit
+	}
+	return "99"
+}
+
+func cxlRejReason(err error) string {
+	switch err {
+	case utils.ErrOrderNotFound:
+		return "1" // Unknown order
+	case utils.ErrOrderNotOpen:
+		return "0" // Too late to cancel
+	}
+	return "99"
+}
+
+// parseNewOrder reads a NewOrderSingle, with prices of precision decimal
+// places, into an order, or says why it can't.
+func parseNewOrder(m *Message, precision int) (*engine.Order, string) {
+	o := &engine.Order{AccountID: m.Get(TagAccount), Symbol: m.Get(TagSymbol)}
+
+	switch m.Get(TagSide) {
+	case "1":
+		o.Side = engine.SideBuy
+	case "2":
+		o.Side = engine.SideSell
+	default:
+		return o, "unsupported Side"
+	}
+	switch m.Get(TagOrdType) {
+	case "1":
+		o.Type = engine.OrderTypeMarket
+	case "2":
+		o.Type = engine.OrderTypeLimit
+	case "3":
+		o.Type = engine.OrderTypeStopMarket
+	case "4":
+		o.Type = engine.OrderTypeStopLimit
+	default:
+		return o, "unsupported OrdType"
+	}
+	switch m.Get(TagTimeInForce) {
+	case "":
+	case "0":
+		o.TimeInForce = engine.TimeInForceDAY
+	case "1":
+		o.TimeInForce = engine.TimeInForceGTC
+	case "3":
+		o.TimeInForce = engine.TimeInForceIOC
+	case "4":
+		o.TimeInForce = engine.TimeInForceFOK
+	case "6":
+		o.TimeInForce = engine.TimeInForceGTD
+		t, err := time.Parse(timeLayout, m.Get(TagExpireTime))
+		if err != nil {
+			return o, "GTD orders need an ExpireTime"
+		}
+		o.ExpireAt = t.UnixMilli()
+	default:
+		return o, "unsupported TimeInForce"
+	}
+
+	var err error
+	if o.Quantity, err = m.Int(TagOrderQty); err != nil {
+		return o, err.Error()
+	}
+	optional := []struct {
+		tag  int
+		v    *int64
+		read func(tag int) (int64, error)
+	}{
+		{TagPrice, &o.Price, func(tag int) (int64, error) { return m.Price(tag, precision) }},
+		{TagStopPx, &o.StopPrice, func(tag int) (int64, error) { return m.Price(tag, precision) }},
+		{TagMaxFloor, &o.DisplayQuantity, m.Int},
+	}
+	for _, f := range optional {
+		if _, ok := m.Lookup(f.tag); !ok {
+			continue
+		}
+		if *f.v, err = f.read(f.tag); err != nil {
+			return o, err.Error()
+		}
+	}
+	// ExecInst 6, Participate don't initiate
+	if strings.Contains(m.Get(TagExecInst), "6") {
+		o.PostOnly = engine.PostOnlyReject
+	}
+	return o, ""
+}
@@ -0,0 +1,146 @@
+package fix
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Initiator is the client end of a FIX session, as simple as will do for
+// tests and tools: it numbers what it sends, answers TestRequests, and
+// answers ResendRequests with a gap fill, since it keeps nothing to resend.
+type Initiator struct {
+	SenderCompID string
+	TargetCompID string
+
+	nc net.Conn
+	r  *bufio.Reader
+
+	mu      sync.Mutex
+	nextOut uint64
+}
+
+func Dial(addr, senderCompID, targetCompID string) (*Initiator, error) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Initiator{
+		SenderCompID: senderCompID,
+		TargetCompID: targetCompID,
+		nc:           nc,
+		r:            bufio.NewReader(nc),
+		nextOut:      1,
+	}, nil
+}
+
+// Logon logs on and waits for the acceptor's Logon. A reset starts both
+// sides' sequence numbers over at 1.
+func (i *Initiator) Logon(heartbeat time.Duration, reset bool) (*Message, error) {
+	m := NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, int64(heartbeat/time.Second))
+	if reset {
+		i.SetNextSeqNum(1)
+		m.Set(TagResetSeqNumFlag, "Y")
+	}
+	if err := i.Send(m); err != nil {
+		return nil, err
+	}
+	reply, err := i.Receive(5 * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type() != MsgLogon {
+		return reply, fmt.Errorf("logon answered with %s", reply)
+	}
+	return reply, nil
+}
+
+// Send numbers m as the next message and sends it.
+func (i *Initiator) Send(m *Message) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	seq := i.nextOut
+	i.nextOut++
+	return i.write(m, seq)
+}
+
+// SendSeq sends m numbered seq, leaving the sequence alone: for resends and
+// for making gaps.
+func (i *Initiator) SendSeq(m *Message, seq uint64) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.write(m, seq)
+}
+
+func (i *Initiator) write(m *Message, seq uint64) error {
+	m.Set(TagSenderCompID, i.SenderCompID)
+	m.Set(TagTargetCompID, i.TargetCompID)
+	m.Set(TagMsgSeqNum, strconv.FormatUint(seq, 10))
+	m.SetTime(TagSendingTime, time.Now())
+	i.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := i.nc.Write(m.Bytes())
+	return err
+}
+
+// NextSeqNum is the number the next message sent will have.
+func (i *Initiator) NextSeqNum() uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.nextOut
+}
+
+func (i *Initiator) SetNextSeqNum(seq uint64) {
+	i.mu.Lock()
+	i.nextOut = seq
+	i.mu.Unlock()
+}
+
+// Receive waits up to timeout for the next message. TestRequests and
+// ResendRequests are answered before they are returned.
+func (i *Initiator) Receive(timeout time.Duration) (*Message, error) {
+	i.nc.SetReadDeadline(time.Now().Add(timeout))
+	m, err := ReadMessage(i.r)
+	if err != nil {
+		return nil, err
+	}
+	switch m.Type() {
+	case MsgTestRequest:
+		err = i.Send(NewMessage(MsgHeartbeat).Set(TagTestReqID, m.Get(TagTestReqID)))
+	case MsgResendRequest:
+		begin, _ := m.Int(TagBeginSeqNo)
+		i.mu.Lock()
+		reset := NewMessage(MsgSequenceReset).
+			Set(TagGapFillFlag, "Y").
+			Set(TagNewSeqNo, strconv.FormatUint(i.nextOut, 10)).
+			Set(TagPossDupFlag, "Y")
+		err = i.write(reset, uint64(begin))
+		i.mu.Unlock()
+	}
+	return m, err
+}
+
+// Logout logs out, waits for the acceptor's Logout and closes the
+// connection.
+func (i *Initiator) Logout() error {
+	if err := i.Send(NewMessage(MsgLogout)); err != nil {
+		i.Close()
+		return err
+	}
+	defer i.Close()
+	for {
+		m, err := i.Receive(5 * time.Second)
+		if err != nil {
+			return err
+		}
+		if m.Type() == MsgLogout {
+			return nil
+		}
+	}
+}
+
+func (i *Initiator) Close() error {
+	return i.nc.Close()
+}
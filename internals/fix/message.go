@@ -0,0 +1,342 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// BeginString is the only FIX version spoken.
+const BeginString = "FIX.4.4"
+
+const soh = '\x01'
+
+// Tags used by the session and application layers
+const (
+	TagAccount             = 1
+	TagAvgPx               = 6
+	TagBeginSeqNo          = 7
+	TagBeginString         = 8
+	TagBodyLength          = 9
+	TagCheckSum            = 10
+	TagClOrdID             = 11
+	TagCumQty              = 14
+	TagEndSeqNo            = 16
+	TagExecID              = 17
+	TagExecInst            = 18
+	TagLastPx              = 31
+	TagLastQty             = 32
+	TagMsgSeqNum           = 34
+	TagMsgType             = 35
+	TagNewSeqNo            = 36
+	TagOrderID             = 37
+	TagOrderQty            = 38
+	TagOrdStatus           = 39
+	TagOrdType             = 40
+	TagOrigClOrdID         = 41
+	TagPossDupFlag         = 43
+	TagPrice               = 44
+	TagRefSeqNum           = 45
+	TagSenderCompID        = 49
+	TagSendingTime         = 52
+	TagSide                = 54
+	TagSymbol              = 55
+	TagTargetCompID        = 56
+	TagText                = 58
+	TagTimeInForce         = 59
+	TagTransactTime        = 60
+	TagEncryptMethod       = 98
+	TagStopPx              = 99
+	TagCxlRejReason        = 102
+	TagOrdRejReason        = 103
+	TagHeartBtInt          = 108
+	TagMaxFloor            = 111
+	TagTestReqID           = 112
+	TagOrigSendingTime     = 122
+	TagGapFillFlag         = 123
+	TagExpireTime          = 126
+	TagResetSeqNumFlag     = 141
+	TagExecType            = 150
+	TagLeavesQty           = 151
+	TagRefMsgType          = 372
+	TagSessionRejectReason = 373
+	TagCxlRejResponseTo    = 434
+	TagOrdStatusReqID      = 790
+	TagTrdMatchID          = 880
+)
+
+// Message types
+const (
+	MsgHeartbeat                 = "0"
+	MsgTestRequest               = "1"
+	MsgResendRequest             = "2"
+	MsgReject                    = "3"
+	MsgSequenceReset             = "4"
+	MsgLogout                    = "5"
+	MsgExecutionReport           = "8"
+	MsgOrderCancelReject         = "9"
+	MsgLogon                     = "A"
+	MsgNewOrderSingle            = "D"
+	MsgOrderCancelRequest        = "F"
+	MsgOrderCancelReplaceRequest = "G"
+	MsgOrderStatusRequest        = "H"
+)
+
+// timeLayout is FIX's UTCTimestamp with milliseconds.
+const timeLayout = "20060102-15:04:05.000"
+
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message without the BeginString, BodyLength and CheckSum
+// that frame it on the wire. Fields keep the order they were added in.
+type Message struct {
+	Fields []Field
+}
+
+func NewMessage(msgType string) *Message {
+	return &Message{Fields: []Field{{TagMsgType, msgType}}}
+}
+
+func (m *Message) Type() string {
+	return m.Get(TagMsgType)
+}
+
+// Get returns the value of the first field with the tag, or "".
+func (m *Message) Get(tag int) string {
+	v, _ := m.Lookup(tag)
+	return v
+}
+
+func (m *Message) Lookup(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the field as an integer; a missing field is an error.
+func (m *Message) Int(tag int) (int64, error) {
+	v, ok := m.Lookup(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: %q is not an integer", tag, v)
+	}
+	return n, nil
+}
+
+// Price returns the field as a decimal price in units of precision decimal
+// places, e.g. 10125 for 101.25 at precision 2. A missing field, or one with
+// more decimal places than precision, is an error.
+func (m *Message) Price(tag, precision int) (int64, error) {
+	v, ok := m.Lookup(tag)
+	if !ok {
+		return 0, fmt.Errorf("missing tag %d", tag)
+	}
+	digits, neg := strings.CutPrefix(v, "-")
+	whole, frac, _ := strings.Cut(digits, ".")
+	frac = strings.TrimRight(frac, "0")
+	if whole+frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("tag %d: %q is not a price", tag, v)
+	}
+	if len(frac) > precision {
+		return 0, fmt.Errorf("tag %d: %q has more than %d decimal places", tag, v, precision)
+	}
+	n, err := strconv.ParseInt(whole+frac+strings.Repeat("0", precision-len(frac)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: %q is out of range", tag, v)
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func (m *Message) SeqNum() uint64 {
+	n, _ := strconv.ParseUint(m.Get(TagMsgSeqNum), 10, 64)
+	return n
+}
+
+// Set replaces the tag's value, or adds the field if the message has none.
+func (m *Message) Set(tag int, v string) *Message {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = v
+			return m
+		}
+	}
+	m.Fields = append(m.Fields, Field{tag, v})
+	return m
+}
+
+func (m *Message) SetInt(tag int, v int64) *Message {
+	return m.Set(tag, strconv.FormatInt(v, 10))
+}
+
+// SetPrice sets a price in units of precision decimal places as a decimal.
+func (m *Message) SetPrice(tag int, v int64, precision int) *Message {
+	if precision == 0 {
+		return m.SetInt(tag, v)
+	}
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	digits := strconv.FormatInt(v, 10)
+	if len(digits) <= precision {
+		digits = strings.Repeat("0", precision-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-precision], strings.TrimRight(digits[len(digits)-precision:], "0")
+	if frac != "" {
+		whole += "." + frac
+	}
+	return m.Set(tag, sign+whole)
+}
+
+func (m *Message) SetTime(tag int, t time.Time) *Message {
+	return m.Set(tag, t.UTC().Format(timeLayout))
+}
+
+// headerTags are written straight after MsgType, in this order, as the
+// standard header requires.
+var headerTags = []int{TagSenderCompID, TagTargetCompID, TagMsgSeqNum, TagPossDupFlag, TagSendingTime, TagOrigSendingTime}
+
+// Bytes frames the message for the wire.
+func (m *Message) Bytes() []byte {
+	var body bytes.Buffer
+	put := func(f Field) {
+		body.WriteString(strconv.Itoa(f.Tag))
+		body.WriteByte('=')
+		body.WriteString(f.Value)
+		body.WriteByte(soh)
+	}
+	put(Field{TagMsgType, m.Type()})
+	for _, tag := range headerTags {
+		if v, ok := m.Lookup(tag); ok {
+			put(Field{tag, v})
+		}
+	}
+	for _, f := range m.Fields {
+		if f.Tag != TagMsgType && !isHeader(f.Tag) {
+			put(f)
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "8=%s\x019=%d\x01", BeginString, body.Len())
+	b.Write(body.Bytes())
+	fmt.Fprintf(&b, "10=%03d\x01", checksum(b.Bytes()))
+	return b.Bytes()
+}
+
+func isHeader(tag int) bool {
+	for _, t := range headerTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func checksum(b []byte) int {
+	var sum int
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// String shows the message with '|' for SOH, for logs and errors.
+func (m *Message) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{soh}, []byte{'|'}))
+}
+
+// ReadMessage reads one framed message, failing with utils.ErrFIXGarbled if
+// its framing, BodyLength or CheckSum is wrong.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	begin, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	if begin.Tag != TagBeginString || begin.Value != BeginString {
+		return nil, utils.ErrFIXGarbled
+	}
+	length, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(length.Value)
+	if length.Tag != TagBodyLength || err != nil || n <= 0 || n > maxBodyLength {
+		return nil, utils.ErrFIXGarbled
+	}
+	// CheckSum covers everything before it
+	framed := fmt.Sprintf("8=%s\x019=%s\x01", begin.Value, length.Value)
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	sum, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	want, err := strconv.Atoi(sum.Value)
+	if sum.Tag != TagCheckSum || err != nil || want != checksum(append([]byte(framed), body...)) {
+		return nil, utils.ErrFIXGarbled
+	}
+
+	m := &Message{}
+	for len(body) > 0 {
+		end := bytes.IndexByte(body, soh)
+		if end < 0 {
+			return nil, utils.ErrFIXGarbled
+		}
+		f, err := parseField(body[:end])
+		if err != nil {
+			return nil, err
+		}
+		m.Fields = append(m.Fields, f)
+		body = body[end+1:]
+	}
+	if len(m.Fields) == 0 || m.Fields[0].Tag != TagMsgType {
+		return nil, utils.ErrFIXGarbled
+	}
+	return m, nil
+}
+
+const maxBodyLength = 1 << 20
+
+func readField(r *bufio.Reader) (Field, error) {
+	b, err := r.ReadSlice(soh)
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return Field{}, utils.ErrFIXGarbled
+		}
+		return Field{}, err
+	}
+	return parseField(b[:len(b)-1])
+}
+
+func parseField(b []byte) (Field, error) {
+	eq := bytes.IndexByte(b, '=')
+	if eq <= 0 {
+		return Field{}, utils.ErrFIXGarbled
+	}
+	tag, err := strconv.Atoi(string(b[:eq]))
+	if err != nil || tag <= 0 {
+		return Field{}, utils.ErrFIXGarbled
+	}
+	return Field{tag, string(b[eq+1:])}, nil
+}
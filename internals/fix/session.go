@@ -0,0 +1,430 @@
+package fix
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// session is the state of one counterparty's FIX session, keyed by its
+// SenderCompID. It outlives connections: sequence numbers carry on across a
+// reconnect, and the application messages sent while the counterparty was
+// away are there for it to ask to be resent.
+type session struct {
+	g      *Gateway
+	compID string
+
+	mu      sync.Mutex
+	nextOut uint64
+	nextIn  uint64
+	// sent keeps the last Options.ResendLimit application messages by
+	// sequence number for resends; session messages, and application
+	// messages too old to be kept, are gap filled instead. sentFrom is the
+	// oldest sequence number that may still be in it.
+	sent     map[uint64]*Message
+	sentFrom uint64
+	// store, if the gateway has a Dir, keeps the sequence numbers and sent
+	// messages across restarts
+	store *store
+	conn  *conn
+
+	// orders are this session's orders by ClOrdID; guarded by g.mu
+	orders map[string]*order
+}
+
+func newSession(g *Gateway, compID string) (*session, error) {
+	s := &session{
+		g:        g,
+		compID:   compID,
+		nextOut:  1,
+		nextIn:   1,
+		sent:     make(map[uint64]*Message),
+		sentFrom: 1,
+		orders:   make(map[string]*order),
+	}
+	if g.opts.Dir != "" {
+		st, err := openStore(g.opts.Dir, s, g.opts.ResendLimit)
+		if err != nil {
+			return nil, err
+		}
+		s.store = st
+	}
+	return s, nil
+}
+
+// trimSent forgets the oldest sent messages beyond limit.
+func (s *session) trimSent(limit int) {
+	for len(s.sent) > limit {
+		delete(s.sent, s.sentFrom)
+		s.sentFrom++
+	}
+}
+
+// saveSeqs stores the sequence numbers, if the session has a store. It is
+// called with s.mu held.
+func (s *session) saveSeqs() {
+	if s.store != nil {
+		s.stored(s.store.setSeqs(s.nextOut, s.nextIn))
+	}
+}
+
+// stored logs a failure to store the session; it carries on in memory.
+func (s *session) stored(err error) {
+	if err != nil {
+		log.Printf("FIX session %s: store: %v", s.compID, err)
+	}
+}
+
+// conn is one TCP connection of a logged on session.
+type conn struct {
+	nc        net.Conn
+	heartbeat time.Duration
+	// out is drained by the writer goroutine, so sending never blocks on
+	// the network
+	out  chan []byte
+	done chan struct{}
+	once sync.Once
+
+	lastSent     atomic.Int64
+	lastReceived atomic.Int64
+	// gapFrom is the sequence number last asked to be resent from; only
+	// the reading goroutine uses it.
+	gapFrom uint64
+}
+
+const outQueue = 1024
+
+func newConn(nc net.Conn, heartbeat time.Duration) *conn {
+	c := &conn{nc: nc, heartbeat: heartbeat, out: make(chan []byte, outQueue), done: make(chan struct{})}
+	now := time.Now().UnixNano()
+	c.lastSent.Store(now)
+	c.lastReceived.Store(now)
+	return c
+}
+
+// close ends the connection once what is already queued has been written,
+// such as a Logout.
+func (c *conn) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// write queues b, dropping the connection if the counterparty has fallen
+// so far behind that the queue is full. What it missed can be resent.
+func (c *conn) write(b []byte) {
+	select {
+	case c.out <- b:
+		c.lastSent.Store(time.Now().UnixNano())
+	default:
+		c.close()
+	}
+}
+
+func (c *conn) writeLoop() {
+	defer c.nc.Close()
+	for {
+		select {
+		case b := <-c.out:
+			c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := c.nc.Write(b); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			c.nc.SetWriteDeadline(time.Now().Add(drainTimeout))
+			for {
+				select {
+				case b := <-c.out:
+					if _, err := c.nc.Write(b); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+const (
+	writeTimeout = 10 * time.Second
+	drainTimeout = time.Second
+)
+
+// heartbeatLoop sends a Heartbeat when nothing else has gone out for an
+// interval, a TestRequest when nothing has come in for a little longer, and
+// gives up on the connection if that goes unanswered.
+func (s *session) heartbeatLoop(c *conn) {
+	ticker := time.NewTicker(c.heartbeat / 4)
+	defer ticker.Stop()
+	var testSent bool
+	for {
+		select {
+		case now := <-ticker.C:
+			idleIn := time.Duration(now.UnixNano() - c.lastReceived.Load())
+			switch {
+			case idleIn >= 2*c.heartbeat+c.heartbeat/5:
+				c.close()
+				return
+			case idleIn >= c.heartbeat+c.heartbeat/5 && !testSent:
+				s.send(NewMessage(MsgTestRequest).Set(TagTestReqID, strconv.FormatInt(now.UnixNano(), 10)))
+				testSent = true
+			case idleIn < c.heartbeat:
+				testSent = false
+			}
+			if time.Duration(now.UnixNano()-c.lastSent.Load()) >= c.heartbeat {
+				s.send(NewMessage(MsgHeartbeat))
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// send numbers m as the session's next message and sends it, or keeps it
+// for a resend if the counterparty isn't connected.
+func (s *session) send(m *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(m)
+}
+
+func (s *session) sendLocked(m *Message) {
+	seq := s.nextOut
+	s.nextOut++
+	s.stamp(m, seq)
+	if !isSessionMessage(m.Type()) {
+		s.sent[seq] = m
+		s.trimSent(s.g.opts.ResendLimit)
+		if s.store != nil {
+			s.stored(s.store.add(m, s.sent))
+		}
+	}
+	s.saveSeqs()
+	if s.conn != nil {
+		s.conn.write(m.Bytes())
+	}
+}
+
+func (s *session) stamp(m *Message, seq uint64) {
+	m.Set(TagSenderCompID, s.g.opts.CompID)
+	m.Set(TagTargetCompID, s.compID)
+	m.Set(TagMsgSeqNum, strconv.FormatUint(seq, 10))
+	m.SetTime(TagSendingTime, time.Now())
+}
+
+func isSessionMessage(msgType string) bool {
+	switch msgType {
+	case MsgHeartbeat, MsgTestRequest, MsgResendRequest, MsgReject, MsgSequenceReset, MsgLogout, MsgLogon:
+		return true
+	}
+	return false
+}
+
+// resend sends the application messages numbered begin to end again, as
+// possible duplicates, and gap fills over the session messages between
+// them. An end of 0 means everything sent so far.
+func (s *session) resend(begin, end uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	if end == 0 || end >= s.nextOut {
+		end = s.nextOut - 1
+	}
+
+	var gapFrom uint64
+	fill := func(next uint64) {
+		if gapFrom == 0 {
+			return
+		}
+		m := NewMessage(MsgSequenceReset).Set(TagGapFillFlag, "Y").Set(TagNewSeqNo, strconv.FormatUint(next, 10))
+		s.stamp(m, gapFrom)
+		m.Set(TagPossDupFlag, "Y")
+		s.conn.write(m.Bytes())
+		gapFrom = 0
+	}
+	for seq := begin; seq <= end; seq++ {
+		sent, ok := s.sent[seq]
+		if !ok {
+			if gapFrom == 0 {
+				gapFrom = seq
+			}
+			continue
+		}
+		fill(seq)
+		m := &Message{Fields: append([]Field(nil), sent.Fields...)}
+		m.Set(TagPossDupFlag, "Y")
+		m.Set(TagOrigSendingTime, sent.Get(TagSendingTime))
+		m.SetTime(TagSendingTime, time.Now())
+		s.conn.write(m.Bytes())
+	}
+	fill(end + 1)
+}
+
+// logon binds c to the session for the Logon m and answers it, or returns
+// why it can't.
+func (s *session) logon(c *conn, m *Message) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		return "session already logged on"
+	}
+	reset := m.Get(TagResetSeqNumFlag) == "Y"
+	if reset {
+		s.nextOut, s.nextIn = 1, 1
+		s.sent, s.sentFrom = make(map[uint64]*Message), 1
+		if s.store != nil {
+			s.stored(s.store.rewrite(s.sent))
+		}
+	}
+	if seq := m.SeqNum(); seq < s.nextIn {
+		return "MsgSeqNum too low, expecting " + strconv.FormatUint(s.nextIn, 10)
+	}
+
+	s.conn = c
+	reply := NewMessage(MsgLogon).Set(TagEncryptMethod, "0").SetInt(TagHeartBtInt, int64(c.heartbeat/time.Second))
+	if reset {
+		reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	s.sendLocked(reply)
+	return ""
+}
+
+func (s *session) disconnect(c *conn) {
+	s.mu.Lock()
+	if s.conn == c {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+	c.close()
+}
+
+// serve reads messages from a logged on connection until it ends.
+func (s *session) serve(c *conn, r *bufio.Reader, logon *Message) {
+	defer s.disconnect(c)
+	go s.heartbeatLoop(c)
+
+	if !s.handle(c, logon) {
+		return
+	}
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(3 * c.heartbeat))
+		m, err := ReadMessage(r)
+		if err != nil {
+			return
+		}
+		c.lastReceived.Store(time.Now().UnixNano())
+		if !s.handle(c, m) {
+			return
+		}
+	}
+}
+
+// handle checks an incoming message's sequence number and acts on it. It
+// returns false to end the connection.
+func (s *session) handle(c *conn, m *Message) bool {
+	if m.Get(TagSenderCompID) != s.compID || m.Get(TagTargetCompID) != s.g.opts.CompID {
+		s.logout("CompID problem")
+		return false
+	}
+	seq := m.SeqNum()
+	if seq == 0 {
+		s.logout("MsgSeqNum missing")
+		return false
+	}
+
+	// A SequenceReset that isn't a gap fill moves the sequence whatever its
+	// own number
+	if m.Type() == MsgSequenceReset && m.Get(TagGapFillFlag) != "Y" {
+		if next, err := m.Int(TagNewSeqNo); err == nil {
+			s.mu.Lock()
+			if uint64(next) > s.nextIn {
+				s.nextIn = uint64(next)
+				s.saveSeqs()
+			}
+			s.mu.Unlock()
+		}
+		return true
+	}
+
+	s.mu.Lock()
+	expected := s.nextIn
+	if seq == expected {
+		s.nextIn++
+		s.saveSeqs()
+	}
+	s.mu.Unlock()
+
+	switch {
+	case seq < expected:
+		if m.Get(TagPossDupFlag) == "Y" {
+			return true
+		}
+		s.logout("MsgSeqNum too low, expecting " + strconv.FormatUint(expected, 10))
+		return false
+	case seq > expected:
+		// What comes after a gap is dropped, to be resent in order. A
+		// ResendRequest is answered at once, though, so that two sides
+		// missing messages don't wait on each other.
+		if m.Type() == MsgResendRequest {
+			s.answerResend(m)
+		}
+		if c.gapFrom != expected {
+			c.gapFrom = expected
+			s.send(NewMessage(MsgResendRequest).Set(TagBeginSeqNo, strconv.FormatUint(expected, 10)).Set(TagEndSeqNo, "0"))
+		}
+		return true
+	}
+
+	switch m.Type() {
+	case MsgLogon:
+		// Only the first message of a connection, which has been handled
+		return true
+	case MsgHeartbeat, MsgReject:
+	case MsgTestRequest:
+		s.send(NewMessage(MsgHeartbeat).Set(TagTestReqID, m.Get(TagTestReqID)))
+	case MsgResendRequest:
+		s.answerResend(m)
+	case MsgSequenceReset:
+		if next, err := m.Int(TagNewSeqNo); err == nil {
+			s.mu.Lock()
+			if uint64(next) > s.nextIn {
+				s.nextIn = uint64(next)
+				s.saveSeqs()
+			}
+			s.mu.Unlock()
+		}
+	case MsgLogout:
+		s.send(NewMessage(MsgLogout))
+		return false
+	case MsgNewOrderSingle, MsgOrderCancelRequest, MsgOrderCancelReplaceRequest, MsgOrderStatusRequest:
+		s.g.application(s, m)
+	default:
+		s.send(NewMessage(MsgReject).
+			Set(TagRefSeqNum, strconv.FormatUint(seq, 10)).
+			Set(TagRefMsgType, m.Type()).
+			Set(TagSessionRejectReason, "11"). // Invalid MsgType
+			Set(TagText, "unsupported message type"))
+	}
+	return true
+}
+
+func (s *session) answerResend(m *Message) {
+	begin, err := m.Int(TagBeginSeqNo)
+	if err != nil || begin <= 0 {
+		return
+	}
+	end, _ := m.Int(TagEndSeqNo)
+	s.resend(uint64(begin), uint64(end))
+}
+
+func (s *session) logout(text string) {
+	log.Printf("FIX session %s: logging out: %s", s.compID, text)
+	s.send(NewMessage(MsgLogout).Set(TagText, text))
+}
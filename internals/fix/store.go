@@ -0,0 +1,175 @@
+package fix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// store keeps a session's sequence numbers and the application messages it
+// has sent in files, so that a restarted gateway carries the session on
+// where it was and can still resend. Writes go to the operating system
+// without an fsync: they survive the process, not the machine.
+//
+// <CompID>.seqnums holds the next outgoing and incoming sequence numbers,
+// rewritten in place. <CompID>.messages has the sent messages appended as
+// they went out; it is rewritten with only the ones kept for resends when
+// it has grown to twice that many.
+type store struct {
+	seqs *os.File
+	msgs *os.File
+	path string
+	// written counts the messages in the messages file
+	written int
+	limit   int
+}
+
+// openStore opens compID's store in dir, creating it if need be, and loads
+// what it holds into s.
+func openStore(dir string, s *session, limit int) (*store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, url.PathEscape(s.compID))
+	st := &store{path: base + ".messages", limit: limit}
+
+	var err error
+	if st.seqs, err = os.OpenFile(base+".seqnums", os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(st.seqs)
+	if err != nil {
+		st.seqs.Close()
+		return nil, err
+	}
+	if fields := strings.Fields(string(b)); len(fields) == 2 {
+		out, err1 := strconv.ParseUint(fields[0], 10, 64)
+		in, err2 := strconv.ParseUint(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			st.seqs.Close()
+			return nil, fmt.Errorf("%s: corrupt sequence numbers %q", st.seqs.Name(), b)
+		}
+		s.nextOut, s.nextIn = out, in
+	}
+
+	sent, err := readMessages(st.path)
+	if err != nil {
+		st.seqs.Close()
+		return nil, err
+	}
+	for _, m := range sent {
+		seq := m.SeqNum()
+		s.sent[seq] = m
+		// The message may have been written without the numbers after it
+		if seq >= s.nextOut {
+			s.nextOut = seq + 1
+		}
+	}
+	s.trimSent(limit)
+	// Starting from what is kept also drops a message cut short by a crash
+	if err := st.rewrite(s.sent); err != nil {
+		st.seqs.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// readMessages reads a messages file up to its end or the first message
+// that was only partly written.
+func readMessages(path string) ([]*Message, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []*Message
+	r := bufio.NewReader(f)
+	for {
+		m, err := ReadMessage(r)
+		if err != nil {
+			return list, nil
+		}
+		list = append(list, m)
+	}
+}
+
+// setSeqs records the session's next sequence numbers.
+func (st *store) setSeqs(out, in uint64) error {
+	_, err := st.seqs.WriteAt([]byte(fmt.Sprintf("%020d %020d\n", out, in)), 0)
+	return err
+}
+
+// add appends a sent application message. sent is what the session keeps
+// for resends, written out in its place once the file has grown too long.
+func (st *store) add(m *Message, sent map[uint64]*Message) error {
+	if st.written >= 2*st.limit {
+		return st.rewrite(sent)
+	}
+	if _, err := st.msgs.Write(m.Bytes()); err != nil {
+		return err
+	}
+	st.written++
+	return nil
+}
+
+// rewrite replaces the messages file with sent.
+func (st *store) rewrite(sent map[uint64]*Message) error {
+	seqs := make([]uint64, 0, len(sent))
+	for seq := range sent {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	tmp := st.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, seq := range seqs {
+		w.Write(sent[seq].Bytes())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		return err
+	}
+
+	if st.msgs != nil {
+		st.msgs.Close()
+	}
+	if st.msgs, err = os.OpenFile(st.path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return err
+	}
+	st.written = len(seqs)
+	return nil
+}
+
+func (st *store) close() error {
+	err := st.seqs.Close()
+	if st.msgs != nil {
+		if cerr := st.msgs.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
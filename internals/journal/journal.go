@@ -0,0 +1,553 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// A journal is a directory of segment files named after the sequence number
+// of their first record (00000000000000000001.log, ...). Each segment is a
+// sequence of newline-terminated records: the CRC-32 (IEEE) of a JSON value
+// in hex, a space, and the JSON itself:
+//
+//	3b8a1f0c {"seq":1,"type":"SUBMIT",...}
+//
+// Snapshots (see snapshot.go) live in the same directory.
+
+const segmentExt = ".log"
+
+type SyncPolicy string
+
+const (
+	// Fsync after every record; an acknowledged command survives power loss.
+	SyncAlways SyncPolicy = "always"
+	// Fsync on a timer; a crash of the machine can lose the last interval.
+	SyncInterval SyncPolicy = "interval"
+	// Leave flushing to the OS; only survives a crash of the process.
+	SyncNone SyncPolicy = "none"
+)
+
+func (p SyncPolicy) Valid() bool {
+	return p == SyncAlways || p == SyncInterval || p == SyncNone
+}
+
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// SegmentSize is the size in bytes after which a new segment is
+	// started. Zero means segments only roll on Rotate.
+	SegmentSize int64
+}
+
+func DefaultOptions() Options {
+	return Options{Sync: SyncAlways, SyncInterval: 100 * time.Millisecond, SegmentSize: 64 << 20}
+}
+
+// Writer appends commands to a journal directory. It implements
+// engine.CommandLog.
+type Writer struct {
+	mu       sync.Mutex
+	dir      string
+	f        *os.File
+	size     int64
+	firstSeq uint64
+	opts     Options
+	lastSeq  uint64
+	dirty    bool
+	err      error
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// syncMu is held by the one Wait that fsyncs for every record written
+	// before it started, up to synced
+	syncMu sync.Mutex
+	synced uint64
+}
+
+// Open opens or creates the journal in dir for appending. A torn record left
+// at the end of the newest segment by a crash is truncated away.
+func Open(dir string, opts Options) (*Writer, error) {
+	if !opts.Sync.Valid() {
+		return nil, fmt.Errorf("journal: unknown sync policy %q", opts.Sync)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{dir: dir, opts: opts, done: make(chan struct{})}
+	if len(segments) == 0 {
+		base, err := latestSnapshotSeq(dir)
+		if err != nil {
+			return nil, err
+		}
+		w.lastSeq = base
+		if err := w.openSegment(base + 1); err != nil {
+			return nil, err
+		}
+	} else {
+		last := segments[len(segments)-1]
+		report, err := ReadFile(last.path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.OpenFile(last.path, os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		if report.TornTail {
+			if err := f.Truncate(report.ValidBytes); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		if _, err := f.Seek(report.ValidBytes, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w.f = f
+		w.size = report.ValidBytes
+		w.firstSeq = last.firstSeq
+		w.lastSeq = last.firstSeq - 1
+		if report.Records > 0 {
+			w.lastSeq = report.LastSeq
+		}
+	}
+
+	if opts.Sync == SyncInterval {
+		interval := opts.SyncInterval
+		if interval <= 0 {
+			interval = DefaultOptions().SyncInterval
+		}
+		w.wg.Add(1)
+		go w.syncLoop(interval)
+	}
+	return w, nil
+}
+
+func (w *Writer) openSegment(firstSeq uint64) error {
+	f, err := os.OpenFile(segmentPath(w.dir, firstSeq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = 0
+	w.firstSeq = firstSeq
+	return nil
+}
+
+// LastSeq returns the sequence number of the last record in the journal.
+func (w *Writer) LastSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastSeq
+}
+
+// Err returns the error that stopped the journal, e.g. a failed fsync, if
+// there was one. Every append after it fails with the same error.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Append writes cmd and, under SyncAlways, waits until it is on disk.
+func (w *Writer) Append(cmd *engine.Command) error {
+	if err := w.Write(cmd); err != nil {
+		return err
+	}
+	return w.Wait(cmd.Seq)
+}
+
+// Write writes cmd without waiting for an fsync, which Wait does.
+func (w *Writer) Write(cmd *engine.Command) error {
+	line, err := encode(cmd)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if cmd.Seq != w.lastSeq+1 {
+		return fmt.Errorf("journal: sequence %d does not follow %d", cmd.Seq, w.lastSeq)
+	}
+	if w.opts.SegmentSize > 0 && w.size >= w.opts.SegmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.f.Write(line); err != nil {
+		w.err = err
+		return err
+	}
+	w.size += int64(len(line))
+	w.dirty = true
+	w.lastSeq = cmd.Seq
+	return nil
+}
+
+// Wait returns once the record seq is on disk under SyncAlways, and at
+// once otherwise. Records written by other goroutines while an fsync is
+// under way share the next one, so concurrent writers don't each wait for
+// an fsync of their own.
+func (w *Writer) Wait(seq uint64) error {
+	if w.opts.Sync != SyncAlways {
+		return nil
+	}
+
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	if w.synced >= seq {
+		return nil
+	}
+
+	w.mu.Lock()
+	f, last, err := w.f, w.lastSeq, w.err
+	w.dirty = false
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		// A rotation synced and closed the segment in the meantime
+		if w.f != f && w.err == nil {
+			w.synced = last
+			return nil
+		}
+		w.err = err
+		return err
+	}
+	w.synced = last
+	return nil
+}
+
+// Rotate closes the current segment and starts a new one, so everything
+// written so far becomes prunable once a snapshot covers it.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if w.size == 0 && w.firstSeq == w.lastSeq+1 {
+		return nil
+	}
+	w.dirty = true
+	if err := w.sync(); err != nil {
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		w.err = err
+		return err
+	}
+	if err := w.openSegment(w.lastSeq + 1); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+func (w *Writer) sync() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.dirty {
+		return nil
+	}
+	if err := w.f.Sync(); err != nil {
+		w.err = err
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *Writer) syncLoop(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Sync()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Writer) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+	syncErr := w.sync()
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+func encode(v interface{}) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, 0, len(payload)+10)
+	line = fmt.Appendf(line, "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decode(line []byte, v interface{}) error {
+	sep := bytes.IndexByte(line, ' ')
+	if sep != 8 {
+		return utils.ErrJournalCorrupt
+	}
+	sum, err := strconv.ParseUint(string(line[:sep]), 16, 32)
+	if err != nil {
+		return utils.ErrJournalCorrupt
+	}
+	payload := line[sep+1:]
+	if crc32.ChecksumIEEE(payload) != uint32(sum) {
+		return utils.ErrJournalCorrupt
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return utils.ErrJournalCorrupt
+	}
+	return nil
+}
+
+// Report describes the contents of a journal segment or directory.
+type Report struct {
+	Segments int
+	Records  int
+	FirstSeq uint64
+	LastSeq  uint64
+	// SnapshotSeq is the sequence number of the snapshot recovery started
+	// from, or 0 if it replayed from the beginning.
+	SnapshotSeq uint64
+	// ValidBytes is the length of the well-formed prefix of the last segment.
+	ValidBytes int64
+	// TornTail is set when the last segment ends in a partial record, as
+	// left by a crash mid-write. It is not an error: the command was never
+	// acknowledged.
+	TornTail bool
+}
+
+func (r *Report) add(cmd *engine.Command) {
+	if r.Records == 0 {
+		r.FirstSeq = cmd.Seq
+	}
+	r.Records++
+	r.LastSeq = cmd.Seq
+}
+
+// ReadFile calls fn for each command in the segment file at path, in order.
+// It stops at a torn final record and fails on any other damage, including
+// gaps in the sequence numbers.
+func ReadFile(path string, fn func(*engine.Command) error) (Report, error) {
+	report := Report{Segments: 1}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	want, named := parseSegmentName(filepath.Base(path))
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			report.TornTail = len(line) > 0
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+
+		var cmd engine.Command
+		if err := decode(line[:len(line)-1], &cmd); err != nil {
+			return report, fmt.Errorf("%w: %s record %d at offset %d", err, path, report.Records+1, report.ValidBytes)
+		}
+		if report.Records == 0 && named && cmd.Seq != want {
+			return report, fmt.Errorf("%w: %s starts at sequence %d", utils.ErrJournalCorrupt, path, cmd.Seq)
+		}
+		if report.Records > 0 && cmd.Seq != report.LastSeq+1 {
+			return report, fmt.Errorf("%w: %s sequence %d follows %d", utils.ErrJournalCorrupt, path, cmd.Seq, report.LastSeq)
+		}
+		if fn != nil {
+			if err := fn(&cmd); err != nil {
+				return report, fmt.Errorf("seq %d: %w", cmd.Seq, err)
+			}
+		}
+
+		report.add(&cmd)
+		report.ValidBytes += int64(len(line))
+	}
+}
+
+// ReadDir calls fn for each command in the journal directory with a sequence
+// number greater than after, in order across segments. Only the newest
+// segment may end in a torn record.
+func ReadDir(dir string, after uint64, fn func(*engine.Command) error) (Report, error) {
+	var report Report
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return report, err
+	}
+
+	for i, seg := range segments {
+		if i+1 < len(segments) && segments[i+1].firstSeq <= after+1 {
+			continue // entirely covered by the snapshot
+		}
+		if report.Records > 0 && seg.firstSeq != report.LastSeq+1 {
+			return report, fmt.Errorf("%w: segment %s does not follow sequence %d", utils.ErrJournalCorrupt, seg.path, report.LastSeq)
+		}
+
+		segReport, err := ReadFile(seg.path, func(cmd *engine.Command) error {
+			if cmd.Seq <= after {
+				return nil
+			}
+			report.add(cmd)
+			if fn != nil {
+				return fn(cmd)
+			}
+			return nil
+		})
+		report.Segments++
+		if err != nil {
+			return report, err
+		}
+		if segReport.TornTail && i+1 < len(segments) {
+			return report, fmt.Errorf("%w: torn record in %s before newer segments", utils.ErrJournalCorrupt, seg.path)
+		}
+		report.ValidBytes = segReport.ValidBytes
+		report.TornTail = segReport.TornTail
+	}
+	return report, nil
+}
+
+// Verify checks a journal directory, or a single segment file, without
+// applying it.
+func Verify(path string) (Report, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Report{}, err
+	}
+	if info.IsDir() {
+		return ReadDir(path, 0, nil)
+	}
+	return ReadFile(path, nil)
+}
+
+// Recover rebuilds e, which must be freshly created, from the journal in
+// dir: it loads the newest readable snapshot and replays the commands after
+// it. A missing or empty directory is not an error.
+func Recover(dir string, e *engine.Engine) (Report, error) {
+	state, err := LatestSnapshot(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return Report{}, nil
+	}
+	if err != nil {
+		return Report{}, err
+	}
+
+	var after uint64
+	if state != nil {
+		if err := e.Restore(state); err != nil {
+			return Report{}, err
+		}
+		after = state.Seq
+	}
+
+	report, err := ReadDir(dir, after, e.Apply)
+	report.SnapshotSeq = after
+	return report, err
+}
+
+type segment struct {
+	path     string
+	firstSeq uint64
+}
+
+func segmentPath(dir string, firstSeq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", firstSeq, segmentExt))
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	return seq, err == nil
+}
+
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []segment
+	for _, entry := range entries {
+		if seq, ok := parseSegmentName(entry.Name()); ok && !entry.IsDir() {
+			segments = append(segments, segment{path: filepath.Join(dir, entry.Name()), firstSeq: seq})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].firstSeq < segments[j].firstSeq })
+	return segments, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
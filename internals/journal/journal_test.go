@@ -0,0 +1,426 @@
+package journal
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func submit(t *testing.T, e *engine.Engine, side engine.Side, price, qty int64) *engine.Order {
+	t.Helper()
+	o := &engine.Order{
+		ID:        utils.GenerateUUID(),
+		Symbol:    "BTCUSD",
+		Side:      side,
+		Type:      engine.OrderTypeLimit,
+		Price:     price,
+		Quantity:  qty,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	e.SubmitOrder(o)
+	return o
+}
+
+func TestRecoverReproducesEngineState(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	submit(t, live, engine.SideSell, 101, 5)
+	submit(t, live, engine.SideSell, 102, 5)
+	cancelled := submit(t, live, engine.SideBuy, 90, 3)
+	submit(t, live, engine.SideBuy, 102, 7)
+	if err := live.CancelOrder(cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	amended := submit(t, live, engine.SideBuy, 95, 4)
+	if _, err := live.AmendOrder(amended.ID, 101, 6); err != nil {
+		t.Fatal(err)
+	}
+	live.SubmitOrder(&engine.Order{
+		ID:          utils.GenerateUUID(),
+		Symbol:      "BTCUSD",
+		Side:        engine.SideBuy,
+		Type:        engine.OrderTypeLimit,
+		Price:       102,
+		Quantity:    100,
+		TimeInForce: engine.TimeInForceFOK,
+	})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := engine.NewEngine()
+	report, err := Recover(dir, recovered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 8 || recovered.LastSeq() != 8 {
+		t.Fatalf("expected 8 records, got %d (engine at seq %d)", report.Records, recovered.LastSeq())
+	}
+
+	want := live.GetOrderBook("BTCUSD")
+	got := recovered.GetOrderBook("BTCUSD")
+	if !reflect.DeepEqual(want.GetSnapshot(10).Bids, got.GetSnapshot(10).Bids) ||
+		!reflect.DeepEqual(want.GetSnapshot(10).Asks, got.GetSnapshot(10).Asks) {
+		t.Errorf("recovered book differs")
+	}
+	if want.TotalBidLiquidity != got.TotalBidLiquidity || want.TotalAskLiquidity != got.TotalAskLiquidity {
+		t.Errorf("recovered liquidity differs")
+	}
+	for id, o := range want.Orders {
+		r, err := recovered.GetOrder(id)
+		if err != nil {
+			t.Fatalf("order %s missing after recovery", id)
+		}
+		if r.Status != o.Status || r.Filled != o.Filled {
+			t.Errorf("order %s: got %s/%d want %s/%d", id, r.Status, r.Filled, o.Status, o.Filled)
+		}
+	}
+}
+
+func TestRecoverReproducesTradeIDs(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, Options{Sync: SyncNone})
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	submit(t, live, engine.SideSell, 100, 1)
+	taker := &engine.Order{ID: "taker", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 1}
+	trades, _ := live.SubmitOrder(taker)
+	w.Close()
+
+	var replayed []string
+	ReadDir(dir, 0, func(cmd *engine.Command) error {
+		replayed = append(replayed, cmd.TradeIDs...)
+		return nil
+	})
+	if len(trades) != 1 || !reflect.DeepEqual(replayed, []string{trades[0].ID}) {
+		t.Errorf("journal trade IDs %v do not match live trades %+v", replayed, trades)
+	}
+	if _, err := Recover(dir, engine.NewEngine()); err != nil {
+		t.Errorf("replay failed: %v", err)
+	}
+}
+
+func TestOpenTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, DefaultOptions())
+	live := engine.NewEngine()
+	live.SetJournal(w)
+	submit(t, live, engine.SideSell, 100, 1)
+	w.Close()
+
+	f, _ := os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`0badf00d {"seq":2,"ty`)
+	f.Close()
+
+	report, err := Verify(dir)
+	if err != nil || !report.TornTail || report.Records != 1 {
+		t.Fatalf("expected one record and a torn tail, got %+v, %v", report, err)
+	}
+
+	w, err = Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.LastSeq() != 1 {
+		t.Errorf("expected last seq 1, got %d", w.LastSeq())
+	}
+	if report, _ := Verify(dir); report.TornTail {
+		t.Errorf("torn tail was not truncated")
+	}
+}
+
+func TestRecoverAfterParallelBooks(t *testing.T) {
+	dir := t.TempDir()
+	// Small segments, so rotations happen while other books wait on fsyncs
+	opts := DefaultOptions()
+	opts.SegmentSize = 4 << 10
+	w, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	var wg sync.WaitGroup
+	for _, symbol := range []string{"AAA", "BBB", "CCC", "DDD"} {
+		wg.Add(1)
+		go func(symbol string) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				o := &engine.Order{ID: utils.GenerateUUID(), Symbol: symbol, Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: int64(100 + i), Quantity: 1}
+				if _, err := live.SubmitOrder(o); err != nil {
+					t.Error(err)
+				}
+			}
+		}(symbol)
+	}
+	wg.Wait()
+	w.Close()
+
+	recovered := engine.NewEngine()
+	report, err := Recover(dir, recovered)
+	if err != nil || report.Records != 100 {
+		t.Fatalf("recovered %+v, %v", report, err)
+	}
+	for _, ob := range live.OrderBooks() {
+		if got := recovered.GetOrderBook(ob.Symbol).State(); !reflect.DeepEqual(got, ob.State()) {
+			t.Errorf("%s recovered differently", ob.Symbol)
+		}
+	}
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, DefaultOptions())
+	live := engine.NewEngine()
+	live.SetJournal(w)
+	submit(t, live, engine.SideSell, 100, 1)
+	submit(t, live, engine.SideSell, 101, 1)
+	w.Close()
+
+	path := segmentPath(dir, 1)
+	data, _ := os.ReadFile(path)
+	data[20] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	if _, err := Verify(path); err == nil {
+		t.Errorf("expected corruption to be detected")
+	}
+}
+
+func TestRecoverFromSnapshotReplaysOnlyLaterCommands(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, DefaultOptions())
+	live := engine.NewEngine()
+	live.SetJournal(w)
+	snapshots := &Snapshotter{Engine: live, Writer: w, Prune: true}
+
+	// Equal prices and timestamps: only queue position decides priority
+	first := &engine.Order{ID: "a", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 1, Timestamp: 1}
+	second := &engine.Order{ID: "b", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 1, Timestamp: 1}
+	live.SubmitOrder(first)
+	live.SubmitOrder(second)
+	submit(t, live, engine.SideBuy, 90, 4)
+
+	seq, err := snapshots.Snapshot()
+	if err != nil || seq != 3 {
+		t.Fatalf("snapshot at seq %d: %v", seq, err)
+	}
+	if _, err := os.Stat(segmentPath(dir, 1)); !os.IsNotExist(err) {
+		t.Errorf("expected segment covered by the snapshot to be pruned")
+	}
+
+	taker := &engine.Order{ID: "taker", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 1}
+	trades, _ := live.SubmitOrder(taker)
+	w.Close()
+
+	recovered := engine.NewEngine()
+	report, err := Recover(dir, recovered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SnapshotSeq != 3 || report.Records != 1 || recovered.LastSeq() != 4 {
+		t.Fatalf("expected snapshot 3 plus one replayed command, got %+v", report)
+	}
+
+	maker := trades[0].MakerOrderID
+	got, _ := recovered.GetOrder(maker)
+	if got.Status != engine.OrderStatusFilled {
+		t.Errorf("expected maker %s filled after recovery, got %s", maker, got.Status)
+	}
+	other := "a"
+	if maker == "a" {
+		other = "b"
+	}
+	if got, _ := recovered.GetOrder(other); got.Status != engine.OrderStatusAccepted {
+		t.Errorf("expected %s still resting, got %s", other, got.Status)
+	}
+	want := live.GetOrderBook("BTCUSD")
+	if ob := recovered.GetOrderBook("BTCUSD"); ob.TotalBidLiquidity != want.TotalBidLiquidity || ob.TotalAskLiquidity != want.TotalAskLiquidity {
+		t.Errorf("recovered liquidity differs")
+	}
+
+	w, err = Open(dir, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.LastSeq() != 4 {
+		t.Errorf("expected reopened journal at seq 4, got %d", w.LastSeq())
+	}
+}
+
+func TestRecoverReproducesAccounts(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, Options{Sync: SyncNone})
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	live.CreateAccount("buyer", engine.RiskLimits{MaxOpenOrders: 5})
+	live.CreateAccount("seller", engine.RiskLimits{})
+	live.AdjustBalance("buyer", engine.DefaultQuoteAsset, 10000)
+	live.AdjustBalance("seller", "BTCUSD", 10)
+	for _, o := range []*engine.Order{
+		{ID: "bid", AccountID: "buyer", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 5},
+		{ID: "ask", AccountID: "seller", Symbol: "BTCUSD", Side: engine.SideSell, Type: engine.OrderTypeLimit, Price: 100, Quantity: 3},
+	} {
+		if _, err := live.SubmitOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Half from the snapshot, half from replay
+	if _, err := (&Snapshotter{Engine: live, Writer: w}).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	live.AmendOrder("bid", 90, 0)
+	live.AdjustBalance("seller", engine.DefaultQuoteAsset, -100)
+	w.Close()
+
+	recovered := engine.NewEngine()
+	if _, err := Recover(dir, recovered); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"buyer", "seller"} {
+		want, _ := live.GetAccount(id)
+		got, err := recovered.GetAccount(id)
+		if err != nil || !reflect.DeepEqual(want, got) {
+			t.Errorf("account %s: got %+v (%v), want %+v", id, got, err, want)
+		}
+	}
+}
+
+func TestRecoverRestoresPendingStops(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, Options{Sync: SyncNone})
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	submit(t, live, engine.SideSell, 100, 1)
+	submit(t, live, engine.SideSell, 105, 1)
+	live.SubmitOrder(&engine.Order{ID: "stop", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeStopMarket, StopPrice: 100, Quantity: 1})
+	if _, err := (&Snapshotter{Engine: live, Writer: w}).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	trades, _ := live.SubmitOrder(&engine.Order{ID: "taker", Symbol: "BTCUSD", Side: engine.SideBuy, Type: engine.OrderTypeLimit, Price: 100, Quantity: 1})
+	w.Close()
+	if len(trades) != 2 {
+		t.Fatalf("expected the taker to trigger the stop, got %+v", trades)
+	}
+
+	recovered := engine.NewEngine()
+	if _, err := Recover(dir, recovered); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := recovered.GetOrder("stop"); got.Status != engine.OrderStatusFilled || got.TriggeredAt == 0 {
+		t.Errorf("expected restored stop to trigger during replay, got %+v", got)
+	}
+}
+
+func TestRecoverReplaysInstrumentChanges(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, Options{Sync: SyncNone})
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	live.AddInstrument(engine.Instrument{Symbol: "BTCUSD", TickSize: 5})
+	submit(t, live, engine.SideSell, 105, 1)
+	live.UpdateInstrument(engine.Instrument{Symbol: "BTCUSD", TickSize: 10})
+	// Valid under the old tick size only, so replay must apply the update first
+	rejected := submit(t, live, engine.SideSell, 115, 1)
+	w.Close()
+	if rejected.Status != engine.OrderStatusRejected {
+		t.Fatalf("expected off-tick order to be rejected, got %s", rejected.Status)
+	}
+
+	recovered := engine.NewEngine()
+	if _, err := Recover(dir, recovered); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := recovered.GetInstrument("BTCUSD"); got.TickSize != 10 {
+		t.Errorf("expected replayed tick size 10, got %+v", got)
+	}
+	if asks := recovered.GetOrderBook("BTCUSD").GetSnapshot(10).Asks; len(asks) != 1 || asks[0].Price != 105 {
+		t.Errorf("expected only the on-tick ask after replay, got %+v", asks)
+	}
+}
+
+func TestRecoverReplaysTradingStates(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, Options{Sync: SyncNone})
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	live.SetTradingConfig("BTCUSD", engine.TradingConfig{HaltPolicy: engine.HaltQueue})
+	submit(t, live, engine.SideSell, 100, 1)
+	live.SetTradingState("BTCUSD", engine.TradingHalted)
+	queued := submit(t, live, engine.SideBuy, 100, 1)
+	if _, err := (&Snapshotter{Engine: live, Writer: w}).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	trades, _ := live.SetTradingState("BTCUSD", engine.TradingOpen)
+	w.Close()
+	if len(trades) != 1 {
+		t.Fatalf("expected the queued order to trade on reopening, got %+v", trades)
+	}
+
+	recovered := engine.NewEngine()
+	if _, err := Recover(dir, recovered); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := recovered.GetOrder(queued.ID); got.Status != engine.OrderStatusFilled {
+		t.Errorf("expected queued order to fill on replay, got %+v", got)
+	}
+	if ob := recovered.GetOrderBook("BTCUSD"); ob.TradingState != engine.TradingOpen {
+		t.Errorf("expected replayed book to be open, got %s", ob.TradingState)
+	}
+}
+
+func TestRecoverReplaysAuctions(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := Open(dir, Options{Sync: SyncNone})
+	live := engine.NewEngine()
+	live.SetJournal(w)
+
+	live.TradingConfig.CircuitBreaker = engine.CircuitBreaker{MaxMoveBps: 1000, HaltMillis: 1000, AuctionMillis: 500}
+	submit(t, live, engine.SideSell, 100, 1)
+	submit(t, live, engine.SideBuy, 100, 1)
+	submit(t, live, engine.SideSell, 120, 2)
+	submit(t, live, engine.SideBuy, 120, 1) // Halts
+	ob := live.GetOrderBook("BTCUSD")
+	live.ReopenHalts(ob.HaltedUntil)
+	buy := submit(t, live, engine.SideBuy, 125, 1)
+	if _, err := (&Snapshotter{Engine: live, Writer: w}).Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	trades, _ := live.ReopenHalts(ob.AuctionUntil)
+	w.Close()
+	if len(trades) != 1 {
+		t.Fatalf("expected the reopening auction to trade, got %+v", trades)
+	}
+
+	recovered := engine.NewEngine()
+	if _, err := Recover(dir, recovered); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := recovered.GetOrder(buy.ID); got.Status != engine.OrderStatusFilled {
+		t.Errorf("expected the auction order to fill on replay, got %+v", got)
+	}
+	if ob := recovered.GetOrderBook("BTCUSD"); ob.TradingState != engine.TradingOpen || ob.LastTradePrice != 120 {
+		t.Errorf("expected replayed book to open at 120, got %s at %d", ob.TradingState, ob.LastTradePrice)
+	}
+}
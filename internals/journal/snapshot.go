@@ -0,0 +1,223 @@
+package journal
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// Snapshots are single-record files (same CRC + JSON framing as journal
+// records) holding an engine.EngineState, named after the last sequence
+// number they include: snapshot-00000000000000000500.snap.
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotExt    = ".snap"
+)
+
+func snapshotPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, seq, snapshotExt))
+}
+
+func parseSnapshotName(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotExt), 10, 64)
+	return seq, err == nil
+}
+
+// listSnapshots returns the sequence numbers of the snapshots in dir, newest first.
+func listSnapshots(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, entry := range entries {
+		if seq, ok := parseSnapshotName(entry.Name()); ok && !entry.IsDir() {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] > seqs[j] })
+	return seqs, nil
+}
+
+func latestSnapshotSeq(dir string) (uint64, error) {
+	seqs, err := listSnapshots(dir)
+	if err != nil || len(seqs) == 0 {
+		return 0, err
+	}
+	return seqs[0], nil
+}
+
+// WriteSnapshot atomically writes state to dir and returns the file path.
+func WriteSnapshot(dir string, state *engine.EngineState) (string, error) {
+	line, err := encode(state)
+	if err != nil {
+		return "", err
+	}
+
+	path := snapshotPath(dir, state.Seq)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, syncDir(dir)
+}
+
+// ReadSnapshot loads the snapshot file at path.
+func ReadSnapshot(path string) (*engine.EngineState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is truncated", utils.ErrJournalCorrupt, path)
+	}
+	var state engine.EngineState
+	if err := decode(line[:len(line)-1], &state); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, path)
+	}
+	if state.Version != engine.StateVersion {
+		return nil, fmt.Errorf("%w: %s has version %d", utils.ErrUnsupportedStateVersion, path, state.Version)
+	}
+	return &state, nil
+}
+
+// LatestSnapshot returns the newest readable snapshot in dir, skipping
+// damaged ones, or nil if there is none.
+func LatestSnapshot(dir string) (*engine.EngineState, error) {
+	seqs, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range seqs {
+		state, err := ReadSnapshot(snapshotPath(dir, seq))
+		if err == nil {
+			return state, nil
+		}
+		log.Printf("journal: skipping snapshot %d: %v", seq, err)
+	}
+	return nil, nil
+}
+
+// Prune removes the journal segments whose records are all at or before seq
+// and every snapshot older than the one at seq. The newest segment is never
+// removed.
+func Prune(dir string, seq uint64) error {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i+1].firstSeq > seq+1 {
+			break
+		}
+		if err := os.Remove(segments[i].path); err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s < seq {
+			if err := os.Remove(snapshotPath(dir, s)); err != nil {
+				return err
+			}
+		}
+	}
+	return syncDir(dir)
+}
+
+// Snapshotter takes snapshots of an engine that is journaling to Writer,
+// rolling the journal onto a new segment each time so older segments can be
+// pruned.
+type Snapshotter struct {
+	Engine *engine.Engine
+	Writer *Writer
+	// Prune removes covered segments and older snapshots after each snapshot.
+	Prune bool
+
+	mu sync.Mutex
+}
+
+// Snapshot writes a snapshot of the engine and returns its sequence number.
+func (s *Snapshotter) Snapshot() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.Engine.State()
+	if _, err := WriteSnapshot(s.Writer.dir, state); err != nil {
+		return 0, err
+	}
+	if err := s.Writer.Rotate(); err != nil {
+		return state.Seq, err
+	}
+	if s.Prune {
+		if err := Prune(s.Writer.dir, state.Seq); err != nil {
+			return state.Seq, err
+		}
+	}
+	return state.Seq, nil
+}
+
+// Run takes a snapshot every interval until stop is closed, skipping
+// intervals in which nothing was journaled.
+func (s *Snapshotter) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last uint64
+	for {
+		select {
+		case <-ticker.C:
+			if s.Engine.LastSeq() == last {
+				continue
+			}
+			seq, err := s.Snapshot()
+			if err != nil {
+				log.Printf("journal: snapshot failed: %v", err)
+				continue
+			}
+			last = seq
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package marketdata
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/candles"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ChannelBook   = "book"
+	ChannelTrades = "trades"
+	ChannelTicker = "ticker"
+	// Live OHLCV bars, when the hub streams candles
+	ChannelCandles = "candles"
+
+	sendBuffer = 1024
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Request is a message from a client.
+//
+//	{"op":"subscribe","symbol":"BTCUSD","channels":["book","trades","ticker","candles"]}
+//	{"op":"unsubscribe","symbol":"BTCUSD"}
+//
+// Subscribing to a symbol that is already subscribed sends a fresh snapshot,
+// which is how a client resyncs after detecting a gap.
+type Request struct {
+	Op       string   `json:"op"`
+	Symbol   string   `json:"symbol"`
+	Channels []string `json:"channels"`
+}
+
+// Message is sent to clients. Book messages carry the order book's sequence
+// number: a snapshot reflects every update up to Seq and each update is
+// exactly one more than the last. Trade messages are numbered separately
+// per symbol. Snapshots carry the book's trading state and updates carry it
+// when it changes; subscribers without the book channel get a status
+// message instead. Ticker messages are sent on subscribing and whenever a
+// trade or a change to the best prices updates it; they carry the book Seq
+// they reflect. Candle messages carry the current bar of each interval a
+// trade changed, starting with every current bar on subscribing.
+type Message struct {
+	Type      string               `json:"type"` // snapshot, update, trades, ticker, candles, status or error
+	Channel   string               `json:"channel,omitempty"`
+	Symbol    string               `json:"symbol,omitempty"`
+	Seq       uint64               `json:"seq"`
+	Timestamp int64                `json:"timestamp,omitempty"`
+	Bids      []engine.PriceLevel  `json:"bids,omitempty"`
+	Asks      []engine.PriceLevel  `json:"asks,omitempty"`
+	Levels    []engine.LevelUpdate `json:"levels,omitempty"`
+	Trades    []engine.Trade       `json:"trades,omitempty"`
+	State     engine.TradingState  `json:"state,omitempty"`
+	// Auction is the indicative uncrossing price and volume while the book
+	// is in an auction and crosses
+	Auction *engine.AuctionIndicative `json:"auction,omitempty"`
+	Ticker  *engine.Ticker            `json:"ticker,omitempty"`
+	Candles []candles.Bar             `json:"candles,omitempty"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// Hub fans order book updates out to WebSocket subscribers. It subscribes to
+// the engine's events.
+type Hub struct {
+	engine   Source
+	upgrader websocket.Upgrader
+
+	// topics maps symbols to their *topic. Each book publishes from its
+	// own goroutine, so each symbol has a lock of its own rather than
+	// books waiting on each other.
+	topics sync.Map
+	// mu guards candles
+	mu      sync.RWMutex
+	candles *candles.Aggregator
+}
+
+// topic is a symbol's subscribers.
+type topic struct {
+	mu       sync.Mutex
+	subs     map[*client]subscription
+	tradeSeq uint64
+}
+
+type subscription struct {
+	book    bool
+	trades  bool
+	ticker  bool
+	candles bool
+}
+
+// Source is the engine, or set of sharded engines, whose books the hub
+// serves.
+type Source interface {
+	Subscribe(sub engine.EventSubscriber) (unsubscribe func())
+	GetOrderBook(symbol string) *engine.OrderBook
+}
+
+func NewHub(e Source) *Hub {
+	h := &Hub{
+		engine: e,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+			CheckOrigin:     func(r *http.Request) bool { return true },
+		},
+	}
+	e.Subscribe(h)
+	return h
+}
+
+// StreamCandles serves a's bars on the candles channel.
+func (h *Hub) StreamCandles(a *candles.Aggregator) {
+	h.mu.Lock()
+	h.candles = a
+	h.mu.Unlock()
+	a.SetListener(h)
+}
+
+func (h *Hub) OnBars(bars []candles.Bar) {
+	if len(bars) == 0 {
+		return
+	}
+	t := h.topic(bars[0].Symbol)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := &Message{Type: "candles", Channel: ChannelCandles, Symbol: bars[0].Symbol, Candles: bars}
+	for c, sub := range t.subs {
+		if sub.candles {
+			c.send(m)
+		}
+	}
+}
+
+// OnEvent sends each book update to the book's subscribers.
+func (h *Hub) OnEvent(ev engine.Event) {
+	if ev, ok := ev.(*engine.BookUpdated); ok {
+		h.publish(ev.Update)
+	}
+}
+
+// topic returns symbol's topic, adding it if it has none. Topics stay once
+// added, so trade seqs carry on when subscribers come back.
+func (h *Hub) topic(symbol string) *topic {
+	if v, ok := h.topics.Load(symbol); ok {
+		return v.(*topic)
+	}
+	t, _ := h.topics.LoadOrStore(symbol, &topic{subs: make(map[*client]subscription)})
+	return t.(*topic)
+}
+
+func (h *Hub) publish(u engine.BookUpdate) {
+	t := h.topic(u.Symbol)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var trades *Message
+	if len(u.Trades) > 0 {
+		t.tradeSeq++
+		trades = &Message{
+			Type:      "trades",
+			Channel:   ChannelTrades,
+			Symbol:    u.Symbol,
+			Seq:       t.tradeSeq,
+			Timestamp: u.Timestamp,
+			Trades:    u.Trades,
+		}
+	}
+	update := &Message{
+		Type:      "update",
+		Channel:   ChannelBook,
+		Symbol:    u.Symbol,
+		Seq:       u.Seq,
+		Timestamp: u.Timestamp,
+		Levels:    u.Levels,
+		State:     u.State,
+		Auction:   u.Auction,
+	}
+	var ticker *Message
+	if u.Ticker != nil {
+		ticker = &Message{Type: "ticker", Channel: ChannelTicker, Symbol: u.Symbol, Seq: u.Seq, Timestamp: u.Timestamp, Ticker: u.Ticker}
+	}
+	var status *Message
+	if u.State != "" {
+		status = &Message{Type: "status", Symbol: u.Symbol, Timestamp: u.Timestamp, State: u.State}
+	}
+
+	for c, sub := range t.subs {
+		// Every update is sent, even without level changes (trades between
+		// hidden orders, a new indicative price), so seq has no gaps
+		if sub.book {
+			c.send(update)
+		}
+		if sub.trades && trades != nil {
+			c.send(trades)
+		}
+		if sub.ticker && ticker != nil {
+			c.send(ticker)
+		}
+		if !sub.book && status != nil {
+			c.send(status)
+		}
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket and serves subscriptions
+// until the client goes away.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an error
+	}
+
+	c := &client{
+		conn:    conn,
+		out:     make(chan *Message, sendBuffer),
+		closed:  make(chan struct{}),
+		pending: make(map[string][]*Message),
+	}
+	go c.writeLoop()
+	h.readLoop(c)
+
+	h.removeClient(c)
+	c.close()
+}
+
+func (h *Hub) readLoop(c *client) {
+	c.conn.SetReadLimit(4096)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var req Request
+		if err := c.conn.ReadJSON(&req); err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				c.send(&Message{Type: "error", Error: "malformed request"})
+				continue
+			}
+			return
+		}
+
+		switch req.Op {
+		case "subscribe":
+			h.subscribe(c, req)
+		case "unsubscribe":
+			h.unsubscribe(c, req.Symbol)
+		default:
+			c.send(&Message{Type: "error", Error: "unknown op " + req.Op})
+		}
+	}
+}
+
+func (h *Hub) subscribe(c *client, req Request) {
+	if req.Symbol == "" {
+		c.send(&Message{Type: "error", Error: "symbol is required"})
+		return
+	}
+
+	var sub subscription
+	if len(req.Channels) == 0 {
+		sub = subscription{book: true, trades: true}
+	}
+	for _, ch := range req.Channels {
+		switch ch {
+		case ChannelBook:
+			sub.book = true
+		case ChannelTrades:
+			sub.trades = true
+		case ChannelTicker:
+			sub.ticker = true
+		case ChannelCandles:
+			if h.candleSource() == nil {
+				c.send(&Message{Type: "error", Symbol: req.Symbol, Error: "candles are not available"})
+				return
+			}
+			sub.candles = true
+		default:
+			c.send(&Message{Type: "error", Symbol: req.Symbol, Error: "unknown channel " + ch})
+			return
+		}
+	}
+
+	// Register before taking the snapshot so no update can fall between
+	// them; the client's writer drops whatever the snapshot already covers.
+	ob := h.engine.GetOrderBook(req.Symbol)
+	if ob == nil {
+		c.send(&Message{Type: "error", Symbol: req.Symbol, Error: "unknown symbol"})
+		return
+	}
+	if sub.book {
+		c.awaitSnapshot(req.Symbol)
+	}
+	t := h.topic(req.Symbol)
+	t.mu.Lock()
+	t.subs[c] = sub
+	t.mu.Unlock()
+
+	if sub.book {
+		snap := ob.GetSnapshot(math.MaxInt32)
+		c.send(&Message{
+			Type:      "snapshot",
+			Channel:   ChannelBook,
+			Symbol:    snap.Symbol,
+			Seq:       snap.Seq,
+			Timestamp: snap.Timestamp,
+			Bids:      snap.Bids,
+			Asks:      snap.Asks,
+			State:     snap.State,
+			Auction:   snap.Auction,
+		})
+	}
+	// Registered first, so no change is missed; the writer drops a ticker
+	// older than one already sent
+	if sub.ticker {
+		t := ob.Ticker()
+		c.send(&Message{Type: "ticker", Channel: ChannelTicker, Symbol: t.Symbol, Seq: t.Seq, Timestamp: t.Timestamp, Ticker: &t})
+	}
+	// A bar may arrive twice
+	if sub.candles {
+		if bars := h.candleSource().Latest(req.Symbol); len(bars) > 0 {
+			c.send(&Message{Type: "candles", Channel: ChannelCandles, Symbol: req.Symbol, Candles: bars})
+		}
+	}
+}
+
+func (h *Hub) candleSource() *candles.Aggregator {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.candles
+}
+
+func (h *Hub) unsubscribe(c *client, symbol string) {
+	if v, ok := h.topics.Load(symbol); ok {
+		t := v.(*topic)
+		t.mu.Lock()
+		delete(t.subs, c)
+		t.mu.Unlock()
+	}
+}
+
+func (h *Hub) removeClient(c *client) {
+	h.topics.Range(func(_, v any) bool {
+		t := v.(*topic)
+		t.mu.Lock()
+		delete(t.subs, c)
+		t.mu.Unlock()
+		return true
+	})
+}
+
+type client struct {
+	conn      *websocket.Conn
+	out       chan *Message
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// Book updates that arrive for a symbol between subscribing and its
+	// snapshot being sent are held here. Owned by writeLoop except for
+	// awaitSnapshot, hence the lock.
+	mu      sync.Mutex
+	pending map[string][]*Message
+	synced  map[string]uint64
+	// Seq of the last ticker written per symbol. Owned by writeLoop.
+	tickerSeq map[string]uint64
+}
+
+// send queues m without blocking. A client that can't keep up is
+// disconnected; it will resync from a new snapshot when it reconnects.
+func (c *client) send(m *Message) {
+	select {
+	case c.out <- m:
+	case <-c.closed:
+	default:
+		log.Printf("marketdata: dropping slow client %s", c.conn.RemoteAddr())
+		c.close()
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+func (c *client) awaitSnapshot(symbol string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[symbol] = []*Message{}
+}
+
+// sequence filters m against the client's snapshot state and returns the
+// messages to write, in order.
+func (c *client) sequence(m *Message) []*Message {
+	if m.Channel == ChannelTicker {
+		if m.Seq < c.tickerSeq[m.Symbol] {
+			return nil
+		}
+		if c.tickerSeq == nil {
+			c.tickerSeq = make(map[string]uint64)
+		}
+		c.tickerSeq[m.Symbol] = m.Seq
+		return []*Message{m}
+	}
+	if m.Channel != ChannelBook {
+		return []*Message{m}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.synced == nil {
+		c.synced = make(map[string]uint64)
+	}
+	queued, waiting := c.pending[m.Symbol]
+
+	if m.Type == "snapshot" {
+		delete(c.pending, m.Symbol)
+		c.synced[m.Symbol] = m.Seq
+		out := []*Message{m}
+		for _, u := range queued {
+			if u.Seq > m.Seq {
+				out = append(out, u)
+				c.synced[m.Symbol] = u.Seq
+			}
+		}
+		return out
+	}
+	if waiting {
+		c.pending[m.Symbol] = append(queued, m)
+		return nil
+	}
+	if m.Seq <= c.synced[m.Symbol] {
+		return nil
+	}
+	c.synced[m.Symbol] = m.Seq
+	return []*Message{m}
+}
+
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer c.close()
+
+	for {
+		select {
+		case m := <-c.out:
+			for _, msg := range c.sequence(m) {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteJSON(msg); err != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
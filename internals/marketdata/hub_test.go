@@ -0,0 +1,183 @@
+package marketdata
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/candles"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+	"github.com/gorilla/websocket"
+)
+
+func dial(t *testing.T, h *Hub) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var m Message
+	if err := conn.ReadJSON(&m); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return m
+}
+
+func submit(e *engine.Engine, side engine.Side, price, qty int64) {
+	e.SubmitOrder(&engine.Order{
+		ID:       utils.GenerateUUID(),
+		Symbol:   "BTCUSD",
+		Side:     side,
+		Type:     engine.OrderTypeLimit,
+		Price:    price,
+		Quantity: qty,
+	})
+}
+
+func TestHub_SnapshotThenSequencedUpdates(t *testing.T) {
+	e := engine.NewEngine()
+	h := NewHub(e)
+	submit(e, engine.SideSell, 101, 5)
+
+	conn := dial(t, h)
+	conn.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD"})
+
+	snap := read(t, conn)
+	if snap.Type != "snapshot" || snap.Seq != 1 || len(snap.Asks) != 1 || snap.Asks[0].Quantity != 5 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	submit(e, engine.SideBuy, 101, 2)
+
+	update := read(t, conn)
+	if update.Type != "update" || update.Seq != snap.Seq+1 {
+		t.Fatalf("expected update with seq %d, got %+v", snap.Seq+1, update)
+	}
+	if len(update.Levels) != 1 || update.Levels[0].Price != 101 || update.Levels[0].Quantity != 3 {
+		t.Errorf("expected ask level 101 to drop to 3, got %+v", update.Levels)
+	}
+
+	trades := read(t, conn)
+	if trades.Type != "trades" || trades.Seq != 1 || len(trades.Trades) != 1 || trades.Trades[0].Quantity != 2 {
+		t.Errorf("unexpected trades message: %+v", trades)
+	}
+}
+
+func TestHub_TradesOnlySubscription(t *testing.T) {
+	e := engine.NewEngine()
+	e.AddInstrument(engine.Instrument{Symbol: "BTCUSD"})
+	h := NewHub(e)
+
+	conn := dial(t, h)
+	conn.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD", Channels: []string{ChannelTrades}})
+	conn.WriteJSON(Request{Op: "subscribe", Symbol: "", Channels: []string{ChannelTrades}})
+	if m := read(t, conn); m.Type != "error" {
+		t.Fatalf("expected error for missing symbol, got %+v", m)
+	}
+
+	submit(e, engine.SideSell, 100, 1)
+	submit(e, engine.SideBuy, 100, 1)
+
+	m := read(t, conn)
+	if m.Type != "trades" || m.Trades[0].Price != 100 {
+		t.Errorf("expected only a trades message, got %+v", m)
+	}
+}
+
+func TestHub_TradingStateChanges(t *testing.T) {
+	e := engine.NewEngine()
+	h := NewHub(e)
+	e.SetTradingState("BTCUSD", engine.TradingPreOpen)
+
+	book := dial(t, h)
+	book.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD", Channels: []string{ChannelBook}})
+	snap := read(t, book)
+	if snap.Type != "snapshot" || snap.State != engine.TradingPreOpen {
+		t.Fatalf("expected pre-open snapshot, got %+v", snap)
+	}
+	trades := dial(t, h)
+	trades.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD", Channels: []string{ChannelTrades}})
+	// Requests are handled in order, so the error means the subscription landed
+	trades.WriteJSON(Request{Op: "ping"})
+	if m := read(t, trades); m.Type != "error" {
+		t.Fatalf("expected error for unknown op, got %+v", m)
+	}
+
+	e.SetTradingState("BTCUSD", engine.TradingOpen)
+	if m := read(t, book); m.Type != "update" || m.Seq != snap.Seq+1 || m.State != engine.TradingOpen {
+		t.Errorf("expected sequenced update with the new state, got %+v", m)
+	}
+	if m := read(t, trades); m.Type != "status" || m.State != engine.TradingOpen {
+		t.Errorf("expected status message, got %+v", m)
+	}
+}
+
+func TestHub_CandlesChannel(t *testing.T) {
+	e := engine.NewEngine()
+	h := NewHub(e)
+	agg := candles.NewAggregator(0)
+	e.Subscribe(agg)
+	submit(e, engine.SideSell, 101, 5)
+	submit(e, engine.SideBuy, 101, 1)
+
+	conn := dial(t, NewHub(e))
+	conn.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD", Channels: []string{ChannelCandles}})
+	if m := read(t, conn); m.Type != "error" {
+		t.Fatalf("expected an error without candles, got %+v", m)
+	}
+
+	h.StreamCandles(agg)
+	conn = dial(t, h)
+	conn.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD", Channels: []string{ChannelCandles}})
+	m := read(t, conn)
+	if m.Type != "candles" || len(m.Candles) != len(candles.Intervals) || m.Candles[0].Volume != 1 {
+		t.Fatalf("expected the current bars, got %+v", m)
+	}
+
+	submit(e, engine.SideBuy, 101, 2)
+	m = read(t, conn)
+	if m.Type != "candles" || len(m.Candles) != len(candles.Intervals) || m.Candles[0].Volume != 3 || m.Candles[0].Trades != 2 {
+		t.Errorf("expected updated bars, got %+v", m)
+	}
+}
+
+func TestHub_TickerChannel(t *testing.T) {
+	e := engine.NewEngine()
+	e.AddInstrument(engine.Instrument{Symbol: "BTCUSD"})
+	h := NewHub(e)
+
+	conn := dial(t, h)
+	conn.WriteJSON(Request{Op: "subscribe", Symbol: "BTCUSD", Channels: []string{ChannelTicker}})
+	if m := read(t, conn); m.Type != "ticker" || m.Ticker == nil || m.Ticker.Symbol != "BTCUSD" {
+		t.Fatalf("expected the current ticker, got %+v", m)
+	}
+
+	submit(e, engine.SideSell, 101, 5)
+	if m := read(t, conn); m.Ticker == nil || m.Ticker.BestAsk != 101 || m.Ticker.BestAskQuantity != 5 {
+		t.Fatalf("expected a new best ask, got %+v", m)
+	}
+	submit(e, engine.SideBuy, 101, 2)
+	m := read(t, conn)
+	if m.Ticker == nil || m.Ticker.LastPrice != 101 || m.Ticker.Volume != 2 || m.Ticker.BestAskQuantity != 3 || m.Seq != m.Ticker.Seq {
+		t.Fatalf("expected the trade in the ticker, got %+v", m)
+	}
+
+	// Behind the best price, so no ticker
+	submit(e, engine.SideSell, 105, 1)
+	submit(e, engine.SideBuy, 99, 1)
+	if m := read(t, conn); m.Ticker == nil || m.Ticker.BestBid != 99 || m.Ticker.BestAsk != 101 {
+		t.Errorf("expected a new best bid, got %+v", m)
+	}
+}
@@ -0,0 +1,488 @@
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/journal"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+// A Set splits symbols across several engines in one process, each with its
+// own books, ledger and journal. A symbol belongs to one shard: the one
+// Options.Assign names, otherwise the one its hash picks, unless it has been
+// moved since. Order IDs carry the shard they were submitted to, so an order
+// is found without asking every shard.
+//
+// Accounts, their limits and instruments are kept on every shard. Funds are
+// not: each shard's ledger backs the orders of the symbols it trades, and
+// funds in an asset follow the last of a shard's symbols that trades it.
+
+type Options struct {
+	Shards int
+	// Assign pins symbols to shards; the rest are spread by hash.
+	Assign map[string]int
+	// Dir holds each shard's journal and snapshots in a directory of its
+	// own, shard-0, shard-1 and so on. Empty disables journaling.
+	Dir     string
+	Journal journal.Options
+	// Prune removes journal segments and snapshots a new snapshot
+	// supersedes.
+	Prune bool
+	// Configure, if set, is called with each shard's engine before it
+	// recovers, to apply settings such as the market order config.
+	Configure func(*engine.Engine)
+}
+
+type Shard struct {
+	Index  int
+	Engine *engine.Engine
+	// Snapshots is nil without a journal.
+	Snapshots *journal.Snapshotter
+	// Recovered describes what was replayed from the journal at Open.
+	Recovered journal.Report
+
+	writer *journal.Writer
+	// gate is held shared by every command on the shard, and exclusively
+	// by a move to or from it.
+	gate sync.RWMutex
+}
+
+type Set struct {
+	shards []*Shard
+	assign map[string]int
+	// moved maps symbols away from the shard Assign or the hash gives
+	// them. departed lists, for each shard, the symbols whose books hold
+	// orders submitted there but are now elsewhere: an order that isn't on
+	// the shard its ID names is on one of theirs. Both are replaced rather
+	// than changed, so reading needs no lock.
+	moved    atomic.Pointer[map[string]int]
+	departed atomic.Pointer[map[int]map[string]bool]
+	// mu serialises moves
+	mu sync.Mutex
+}
+
+// Open creates the shards, recovering each from its journal. A move the
+// process stopped in the middle of is rolled back or completed, whichever
+// the journals allow.
+func Open(opts Options) (*Set, error) {
+	if opts.Shards <= 0 {
+		return nil, utils.ErrUnknownShard
+	}
+	for _, n := range opts.Assign {
+		if n < 0 || n >= opts.Shards {
+			return nil, utils.ErrUnknownShard
+		}
+	}
+
+	s := &Set{assign: opts.Assign}
+	s.moved.Store(&map[string]int{})
+	s.departed.Store(&map[int]map[string]bool{})
+	for i := 0; i < opts.Shards; i++ {
+		sh := &Shard{Index: i, Engine: engine.NewEngine()}
+		s.shards = append(s.shards, sh)
+		if opts.Configure != nil {
+			opts.Configure(sh.Engine)
+		}
+		if opts.Dir == "" {
+			continue
+		}
+
+		dir := filepath.Join(opts.Dir, fmt.Sprintf("shard-%d", i))
+		report, err := journal.Recover(dir, sh.Engine)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		sh.Recovered = report
+		w, err := journal.Open(dir, opts.Journal)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
+		sh.writer = w
+		sh.Engine.SetJournal(w)
+		sh.Snapshots = &journal.Snapshotter{Engine: sh.Engine, Writer: w, Prune: opts.Prune}
+	}
+
+	if err := s.recoverRoutes(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recoverRoutes works out where each symbol lives from the shards' books.
+// A book on two shards means a move stopped between adoption and release;
+// the copies are identical, and the one on the symbol's home shard, or
+// else the first, is kept. The free funds the adopted copy brought are
+// taken back from whichever copy goes.
+func (s *Set) recoverRoutes() error {
+	holders := make(map[string][]int)
+	for _, sh := range s.shards {
+		for _, ob := range sh.Engine.OrderBooks() {
+			holders[ob.Symbol] = append(holders[ob.Symbol], sh.Index)
+		}
+	}
+
+	moved := make(map[string]int)
+	departed := make(map[int]map[string]bool)
+	for symbol, shards := range holders {
+		home := s.home(symbol)
+		keep := shards[0]
+		var funds []engine.Funds
+		for _, n := range shards {
+			if n == home {
+				keep = n
+			}
+			if adopted := s.shards[n].Engine.GetOrderBook(symbol).State().Adopted; adopted != nil {
+				funds = adopted
+			}
+		}
+		for _, n := range shards {
+			if n == keep {
+				continue
+			}
+			if err := s.shards[n].Engine.ReleaseBook(symbol, funds); err != nil {
+				return fmt.Errorf("shard %d: releasing %s: %w", n, symbol, err)
+			}
+		}
+		state := s.shards[keep].Engine.GetOrderBook(symbol).State()
+		if state.Adopted != nil {
+			if err := s.shards[keep].Engine.ConfirmAdoption(symbol); err != nil {
+				return fmt.Errorf("shard %d: confirming %s: %w", keep, symbol, err)
+			}
+		}
+		if keep != home {
+			moved[symbol] = keep
+		}
+		addDeparted(departed, symbol, keep, state.Orders)
+	}
+	s.moved.Store(&moved)
+	s.departed.Store(&departed)
+	return nil
+}
+
+// Close closes every shard's engine and journal.
+func (s *Set) Close() error {
+	var first error
+	for _, sh := range s.shards {
+		sh.Engine.Close()
+		if sh.writer != nil {
+			if err := sh.writer.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (s *Set) Shards() []*Shard {
+	return s.shards
+}
+
+// EncodeOrderID prefixes id with the shard it is submitted to.
+func EncodeOrderID(shard int, id string) string {
+	return "s" + strconv.Itoa(shard) + "-" + id
+}
+
+// DecodeOrderID returns the shard an order ID was submitted to.
+func DecodeOrderID(id string) (int, bool) {
+	rest, ok := strings.CutPrefix(id, "s")
+	if !ok {
+		return 0, false
+	}
+	prefix, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(prefix)
+	return n, err == nil && n >= 0
+}
+
+// LocalOrderID returns the ID an order was submitted with, before
+// EncodeOrderID named its shard.
+func LocalOrderID(id string) string {
+	if _, ok := DecodeOrderID(id); !ok {
+		return id
+	}
+	_, local, _ := strings.Cut(id, "-")
+	return local
+}
+
+// home is the shard symbol belongs to unless moved.
+func (s *Set) home(symbol string) int {
+	if n, ok := s.assign[symbol]; ok {
+		return n
+	}
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// Owner returns the shard that trades symbol.
+func (s *Set) Owner(symbol string) int {
+	if n, ok := (*s.moved.Load())[symbol]; ok {
+		return n
+	}
+	return s.home(symbol)
+}
+
+// holder returns the shard that has the order with the given ID: the one
+// the ID names, unless the order's book has moved on from there.
+func (s *Set) holder(orderID string) (int, bool) {
+	n, ok := DecodeOrderID(orderID)
+	if !ok || n >= len(s.shards) {
+		return 0, false
+	}
+	if s.shards[n].Engine.HasOrder(orderID) {
+		return n, true
+	}
+	for symbol := range (*s.departed.Load())[n] {
+		if m := s.Owner(symbol); s.shards[m].Engine.HasOrder(orderID) {
+			return m, true
+		}
+	}
+	// The shard it names reports it missing
+	return n, true
+}
+
+// WithSymbol runs fn on the shard that trades symbol. The symbol stays
+// there until fn returns.
+func (s *Set) WithSymbol(symbol string, fn func(*Shard) error) error {
+	for {
+		sh := s.shards[s.Owner(symbol)]
+		sh.gate.RLock()
+		if s.Owner(symbol) == sh.Index {
+			defer sh.gate.RUnlock()
+			return fn(sh)
+		}
+		// Moved while we waited
+		sh.gate.RUnlock()
+	}
+}
+
+// WithOrder runs fn on the shard that has the order with the given ID, or
+// returns utils.ErrOrderNotFound if the ID names no shard. The order stays
+// there until fn returns.
+func (s *Set) WithOrder(orderID string, fn func(*Shard) error) error {
+	for {
+		n, ok := s.holder(orderID)
+		if !ok {
+			return utils.ErrOrderNotFound
+		}
+		sh := s.shards[n]
+		sh.gate.RLock()
+		if n, _ := s.holder(orderID); n == sh.Index {
+			defer sh.gate.RUnlock()
+			return fn(sh)
+		}
+		sh.gate.RUnlock()
+	}
+}
+
+// Each runs fn on every shard, carrying on past failures so that repeating
+// a command fills in the shards it missed before. It returns the first
+// error.
+func (s *Set) Each(fn func(*Shard) error) error {
+	var first error
+	for _, sh := range s.shards {
+		if err := fn(sh); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Move hands symbol's book, with its resting orders and the funds they
+// hold, to another shard. Free balances in an asset that nothing else left
+// on the shard trades go too, so they aren't stranded where no symbol can
+// use them. Commands for the symbol wait until it is done;
+// other symbols of the two shards wait too, as commands are gated per
+// shard.
+func (s *Set) Move(symbol string, to int) error {
+	if to < 0 || to >= len(s.shards) {
+		return utils.ErrUnknownShard
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := s.Owner(symbol)
+	if from == to {
+		return nil
+	}
+	src, dst := s.shards[from], s.shards[to]
+	first, second := src, dst
+	if first.Index > second.Index {
+		first, second = second, first
+	}
+	first.gate.Lock()
+	defer first.gate.Unlock()
+	second.gate.Lock()
+	defer second.gate.Unlock()
+
+	h, err := src.Engine.ExportBook(symbol, s.leavingAssets(src, symbol)...)
+	if err != nil {
+		return err
+	}
+	if err := dst.Engine.AdoptBook(h); err != nil {
+		return err
+	}
+	// The destination has the book from here on. If the release isn't
+	// journaled, recovery finds the book on both shards and drops one.
+	err = src.Engine.ReleaseBook(symbol, h.Funds)
+	if err == nil {
+		err = dst.Engine.ConfirmAdoption(symbol)
+	}
+
+	moved := make(map[string]int)
+	for sym, n := range *s.moved.Load() {
+		moved[sym] = n
+	}
+	delete(moved, symbol)
+	if to != s.home(symbol) {
+		moved[symbol] = to
+	}
+	departed := make(map[int]map[string]bool)
+	for n, symbols := range *s.departed.Load() {
+		for sym := range symbols {
+			if sym != symbol {
+				depart(departed, n, sym)
+			}
+		}
+	}
+	addDeparted(departed, symbol, to, h.Book.Orders)
+	s.moved.Store(&moved)
+	s.departed.Store(&departed)
+	return err
+}
+
+// addDeparted notes symbol as departed from every shard but at that orders
+// of its book were submitted to.
+func addDeparted(departed map[int]map[string]bool, symbol string, at int, orders []engine.Order) {
+	for i := range orders {
+		if n, ok := DecodeOrderID(orders[i].ID); ok && n != at {
+			depart(departed, n, symbol)
+		}
+	}
+}
+
+func depart(departed map[int]map[string]bool, n int, symbol string) {
+	if departed[n] == nil {
+		departed[n] = make(map[string]bool)
+	}
+	departed[n][symbol] = true
+}
+
+// leavingAssets returns the assets of symbol that no other symbol of sh
+// trades, listed or with a book there.
+func (s *Set) leavingAssets(sh *Shard, symbol string) []string {
+	others := make(map[string]bool)
+	for _, inst := range sh.Engine.Instruments() {
+		if s.Owner(inst.Symbol) == sh.Index {
+			others[inst.Symbol] = true
+		}
+	}
+	for _, ob := range sh.Engine.OrderBooks() {
+		others[ob.Symbol] = true
+	}
+	delete(others, symbol)
+
+	used := make(map[string]bool)
+	for other := range others {
+		base, quote := sh.Engine.Assets(other)
+		used[base], used[quote] = true, true
+	}
+	var assets []string
+	base, quote := sh.Engine.Assets(symbol)
+	for _, asset := range []string{base, quote} {
+		if !used[asset] {
+			assets = append(assets, asset)
+		}
+	}
+	return assets
+}
+
+// GetOrderBook returns symbol's book on the shard that trades it.
+func (s *Set) GetOrderBook(symbol string) *engine.OrderBook {
+	return s.shards[s.Owner(symbol)].Engine.GetOrderBook(symbol)
+}
+
+// Symbols maps every symbol with a book to its shard.
+func (s *Set) Symbols() map[string]int {
+	symbols := make(map[string]int)
+	for _, sh := range s.shards {
+		for _, ob := range sh.Engine.OrderBooks() {
+			symbols[ob.Symbol] = sh.Index
+		}
+	}
+	return symbols
+}
+
+// Tickers returns the ticker of every book, by symbol.
+func (s *Set) Tickers() []engine.Ticker {
+	var tickers []engine.Ticker
+	for _, sh := range s.shards {
+		tickers = append(tickers, sh.Engine.Tickers()...)
+	}
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Symbol < tickers[j].Symbol })
+	return tickers
+}
+
+// Sweep expires DAY/GTD orders and reopens books whose circuit breaker
+// halt is over, on every shard. A shard whose journal has failed doesn't
+// stop the others being swept; the first error is returned.
+func (s *Set) Sweep(now int64) error {
+	var first error
+	for _, sh := range s.shards {
+		sh.gate.RLock()
+		_, err := sh.Engine.ExpireOrders(now)
+		if err == nil {
+			_, err = sh.Engine.ReopenHalts(now)
+		}
+		sh.gate.RUnlock()
+		if err != nil && first == nil {
+			first = fmt.Errorf("shard %d: %w", sh.Index, err)
+		}
+	}
+	return first
+}
+
+// Snapshot snapshots every journaling shard and returns the sum of their
+// sequence numbers, which grows with every command journaled anywhere.
+func (s *Set) Snapshot() (uint64, error) {
+	var total uint64
+	for _, sh := range s.shards {
+		if sh.Snapshots == nil {
+			continue
+		}
+		seq, err := sh.Snapshots.Snapshot()
+		if err != nil {
+			return total, fmt.Errorf("shard %d: %w", sh.Index, err)
+		}
+		total += seq
+	}
+	return total, nil
+}
+
+// Subscribe adds sub to every shard's event bus. A book's events carry on
+// in Seq order when it moves, but from the destination shard's goroutine.
+func (s *Set) Subscribe(sub engine.EventSubscriber) (unsubscribe func()) {
+	var unsubs []func()
+	for _, sh := range s.shards {
+		unsubs = append(unsubs, sh.Engine.Events().Subscribe(sub))
+	}
+	return func() {
+		for _, u := range unsubs {
+			u()
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/journal"
+	"github.com/Rishabhsingh78/orderMatchingEngine/pkg/utils"
+)
+
+func submit(t *testing.T, s *Set, id, account, symbol string, side engine.Side, price, qty int64) string {
+	t.Helper()
+	order := &engine.Order{
+		ID:        id,
+		AccountID: account,
+		Symbol:    symbol,
+		Side:      side,
+		Type:      engine.OrderTypeLimit,
+		Price:     price,
+		Quantity:  qty,
+		Status:    engine.OrderStatusAccepted,
+	}
+	err := s.WithSymbol(symbol, func(sh *Shard) error {
+		order.ID = EncodeOrderID(sh.Index, order.ID)
+		_, err := sh.Engine.SubmitOrder(order)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order.ID
+}
+
+func cancel(s *Set, orderID string) error {
+	return s.WithOrder(orderID, func(sh *Shard) error {
+		return sh.Engine.CancelOrder(orderID)
+	})
+}
+
+func TestOrderIDs(t *testing.T) {
+	id := EncodeOrderID(12, "0d3c2a4e-1b6f-4c3e-9a57-2f1e8d6b5c40")
+	if n, ok := DecodeOrderID(id); !ok || n != 12 {
+		t.Errorf("DecodeOrderID(%q) = %d, %v", id, n, ok)
+	}
+	for _, id := range []string{"0d3c2a4e-1b6f", "s-1", "sx-1", "s3"} {
+		if _, ok := DecodeOrderID(id); ok {
+			t.Errorf("DecodeOrderID(%q) found a shard", id)
+		}
+	}
+}
+
+func TestSet_RoutesBySymbolAndOrderID(t *testing.T) {
+	s, err := Open(Options{Shards: 2, Assign: map[string]int{"BTCUSD": 0, "ETHUSD": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	btc := submit(t, s, "a", "", "BTCUSD", engine.SideBuy, 100, 1)
+	eth := submit(t, s, "b", "", "ETHUSD", engine.SideBuy, 100, 1)
+	if n, _ := DecodeOrderID(btc); n != 0 {
+		t.Errorf("BTCUSD order %s went to shard %d", btc, n)
+	}
+	if n, _ := DecodeOrderID(eth); n != 1 {
+		t.Errorf("ETHUSD order %s went to shard %d", eth, n)
+	}
+	if got := s.Symbols(); got["BTCUSD"] != 0 || got["ETHUSD"] != 1 || len(got) != 2 {
+		t.Errorf("symbols %v", got)
+	}
+	if len(s.Shards()[0].Engine.OrderBooks()) != 1 {
+		t.Error("shard 0 has books of other shards' symbols")
+	}
+
+	if err := cancel(s, eth); err != nil {
+		t.Fatal(err)
+	}
+	if err := cancel(s, "s7-x"); err != utils.ErrOrderNotFound {
+		t.Errorf("cancel on a shard that doesn't exist: got %v", err)
+	}
+	if _, err := Open(Options{Shards: 2, Assign: map[string]int{"BTCUSD": 2}}); err != utils.ErrUnknownShard {
+		t.Errorf("assignment to a missing shard: got %v", err)
+	}
+}
+
+func TestSet_MoveKeepsOrdersReachable(t *testing.T) {
+	s, err := Open(Options{Shards: 2, Assign: map[string]int{"BTCUSD": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Each(func(sh *Shard) error { return sh.Engine.CreateAccount("acct", engine.RiskLimits{}) })
+	s.WithSymbol("BTCUSD", func(sh *Shard) error { return sh.Engine.AdjustBalance("acct", engine.DefaultQuoteAsset, 1000) })
+
+	bid := submit(t, s, "a", "acct", "BTCUSD", engine.SideBuy, 100, 5)
+	other := submit(t, s, "b", "acct", "BTCUSD", engine.SideBuy, 90, 5)
+
+	if err := s.Move("BTCUSD", 1); err != nil {
+		t.Fatal(err)
+	}
+	if s.Owner("BTCUSD") != 1 {
+		t.Fatalf("BTCUSD is on shard %d after the move", s.Owner("BTCUSD"))
+	}
+	if err := cancel(s, bid); err != nil {
+		t.Fatalf("cancel of an order that moved: %v", err)
+	}
+	sell := submit(t, s, "c", "", "BTCUSD", engine.SideSell, 90, 5)
+	if sell[:3] != "s1-" {
+		t.Errorf("new order %s after the move didn't go to shard 1", sell)
+	}
+	if o, err := s.Shards()[1].Engine.GetOrder(other); err != nil || o.Status != engine.OrderStatusFilled {
+		t.Errorf("the resting bid should have filled against the new sell: %+v, %v", o, err)
+	}
+	acct, _ := s.Shards()[1].Engine.GetAccount("acct")
+	if b := acct.Balances["BTCUSD"]; b == nil || b.Total != 5 {
+		t.Errorf("fill settled %+v on the destination", acct.Balances)
+	}
+
+	if err := s.Move("BTCUSD", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Orders are found through their symbol, however many there are
+	if got := *s.departed.Load(); len(got) != 1 || len(got[1]) != 1 || !got[1]["BTCUSD"] {
+		t.Errorf("departed %v after moving back", got)
+	}
+	for _, id := range []string{bid, other, sell} {
+		err := s.WithOrder(id, func(sh *Shard) error {
+			_, err := sh.Engine.GetOrder(id)
+			return err
+		})
+		if err != nil {
+			t.Errorf("order %s after moving back: %v", id, err)
+		}
+	}
+	if err := s.Move("BTCUSD", 5); err != utils.ErrUnknownShard {
+		t.Errorf("move to a missing shard: got %v", err)
+	}
+}
+
+func balances(t *testing.T, s *Set, account, asset string) []engine.Balance {
+	t.Helper()
+	var got []engine.Balance
+	for _, sh := range s.Shards() {
+		acct, err := sh.Engine.GetAccount(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b engine.Balance
+		if acct.Balances[asset] != nil {
+			b = *acct.Balances[asset]
+		}
+		got = append(got, b)
+	}
+	return got
+}
+
+func TestSet_MoveTakesFreeFundsNothingElseUses(t *testing.T) {
+	s, err := Open(Options{Shards: 2, Assign: map[string]int{"BTCUSD": 0, "ETHUSD": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Each(func(sh *Shard) error { return sh.Engine.CreateAccount("acct", engine.RiskLimits{}) })
+	s.WithSymbol("BTCUSD", func(sh *Shard) error {
+		sh.Engine.AdjustBalance("acct", "BTCUSD", 7)
+		return sh.Engine.AdjustBalance("acct", engine.DefaultQuoteAsset, 1000)
+	})
+	submit(t, s, "a", "acct", "BTCUSD", engine.SideBuy, 100, 5)
+	submit(t, s, "b", "acct", "ETHUSD", engine.SideBuy, 10, 1)
+
+	// ETHUSD still trades USD on shard 0, so only the bid's 500 goes
+	if err := s.Move("BTCUSD", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := balances(t, s, "acct", "BTCUSD"); got[0] != (engine.Balance{}) || got[1] != (engine.Balance{Total: 7}) {
+		t.Errorf("BTCUSD by shard %+v, want all 7 moved", got)
+	}
+	if got := balances(t, s, "acct", engine.DefaultQuoteAsset); got[0] != (engine.Balance{Total: 500, Reserved: 10}) ||
+		got[1] != (engine.Balance{Total: 500, Reserved: 500}) {
+		t.Errorf("USD by shard %+v", got)
+	}
+
+	// Now nothing on shard 0 trades USD
+	if err := s.Move("ETHUSD", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := balances(t, s, "acct", engine.DefaultQuoteAsset); got[0] != (engine.Balance{}) ||
+		got[1] != (engine.Balance{Total: 1000, Reserved: 510}) {
+		t.Errorf("USD by shard %+v after moving ETHUSD", got)
+	}
+}
+
+func TestSet_RecoversRoutesFromJournals(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Shards: 2, Assign: map[string]int{"BTCUSD": 0}, Dir: dir, Journal: journal.DefaultOptions()}
+	s, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := submit(t, s, "a", "", "BTCUSD", engine.SideBuy, 100, 5)
+	if err := s.Move("BTCUSD", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	later := submit(t, s, "b", "", "BTCUSD", engine.SideBuy, 99, 5)
+	s.Close()
+
+	s, err = Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Owner("BTCUSD") != 1 {
+		t.Errorf("BTCUSD recovered on shard %d, want 1", s.Owner("BTCUSD"))
+	}
+	for _, id := range []string{moved, later} {
+		if err := cancel(s, id); err != nil {
+			t.Errorf("cancel %s after recovery: %v", id, err)
+		}
+	}
+}
+
+func TestSet_RecoveryDropsHalfMovedCopy(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Shards: 2, Assign: map[string]int{"BTCUSD": 0}, Dir: dir, Journal: journal.DefaultOptions()}
+	s, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Each(func(sh *Shard) error { return sh.Engine.CreateAccount("acct", engine.RiskLimits{}) })
+	s.WithSymbol("BTCUSD", func(sh *Shard) error { return sh.Engine.AdjustBalance("acct", engine.DefaultQuoteAsset, 1000) })
+	id := submit(t, s, "a", "acct", "BTCUSD", engine.SideBuy, 100, 5)
+	// Stop between adoption and release
+	h, err := s.Shards()[0].Engine.ExportBook("BTCUSD", engine.DefaultQuoteAsset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shards()[1].Engine.AdoptBook(h); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.Symbols(); got["BTCUSD"] != 0 {
+		t.Errorf("BTCUSD kept on shard %d, want its home shard 0", got["BTCUSD"])
+	}
+	if len(s.Shards()[1].Engine.OrderBooks()) != 0 {
+		t.Error("the adopted copy was not released")
+	}
+	if got := balances(t, s, "acct", engine.DefaultQuoteAsset); got[0] != (engine.Balance{Total: 1000, Reserved: 500}) ||
+		got[1] != (engine.Balance{}) {
+		t.Errorf("USD by shard %+v after recovery", got)
+	}
+	if err := cancel(s, id); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSet_RecoveryFinishesHalfMoveWithFunds(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Shards: 2, Assign: map[string]int{"BTCUSD": 1}, Dir: dir, Journal: journal.DefaultOptions()}
+	s, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Each(func(sh *Shard) error { return sh.Engine.CreateAccount("acct", engine.RiskLimits{}) })
+	s.WithSymbol("BTCUSD", func(sh *Shard) error { return sh.Engine.AdjustBalance("acct", engine.DefaultQuoteAsset, 1000) })
+	submit(t, s, "a", "acct", "BTCUSD", engine.SideBuy, 100, 5)
+	if err := s.Move("BTCUSD", 0); err != nil {
+		t.Fatal(err)
+	}
+	// Stop between adoption and release on the way back home
+	h, err := s.Shards()[0].Engine.ExportBook("BTCUSD", engine.DefaultQuoteAsset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shards()[1].Engine.AdoptBook(h); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.Symbols(); got["BTCUSD"] != 1 || len(s.Shards()[0].Engine.OrderBooks()) != 0 {
+		t.Errorf("BTCUSD on %v, want only its home shard 1", got)
+	}
+	if got := balances(t, s, "acct", engine.DefaultQuoteAsset); got[0] != (engine.Balance{}) ||
+		got[1] != (engine.Balance{Total: 1000, Reserved: 500}) {
+		t.Errorf("USD by shard %+v after recovery", got)
+	}
+	if adopted := s.GetOrderBook("BTCUSD").State().Adopted; adopted != nil {
+		t.Errorf("move not confirmed: %+v", adopted)
+	}
+}
@@ -0,0 +1,363 @@
+package tradestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+)
+
+// A Store keeps every trade the engine reports: the most recent of each
+// symbol in memory, and optionally all of them in segment files named after
+// the sequence number of their first record (00000000000000000001.trades).
+// Each line of a segment is one JSON Record.
+
+const segmentExt = ".trades"
+
+// Record is a stored trade. Seq numbers every trade in the store in the
+// order it happened, across symbols.
+type Record struct {
+	Seq    uint64 `json:"seq"`
+	Symbol string `json:"symbol"`
+	engine.Trade
+}
+
+type Options struct {
+	// Capacity is how many trades of each symbol are kept in memory.
+	Capacity int
+	// Dir holds the segment files. Empty keeps trades in memory only, so
+	// older trades are lost.
+	Dir string
+	// SegmentSize is the size in bytes after which a new segment is
+	// started.
+	SegmentSize int64
+}
+
+func DefaultOptions() Options {
+	return Options{Capacity: 10000, SegmentSize: 64 << 20}
+}
+
+// Query selects a symbol's trades. Zero fields leave the query open.
+type Query struct {
+	Symbol string
+	From   int64  // Earliest trade timestamp, Unix milliseconds
+	To     int64  // Trades before this timestamp
+	After  uint64 // Only trades with a greater Seq, for paging
+	Limit  int
+}
+
+func (q Query) matches(r *Record) bool {
+	return (q.From == 0 || r.Timestamp >= q.From) && (q.To == 0 || r.Timestamp < q.To)
+}
+
+// Store keeps the trades of the engine's events.
+type Store struct {
+	mu     sync.RWMutex
+	opts   Options
+	seq    uint64
+	recent map[string]*ring
+
+	// Last record on disk when the store was opened; anything up to it
+	// may be missing from memory.
+	diskSeq uint64
+	diskAt  int64
+	f       *os.File
+	size    int64
+	err     error
+}
+
+// Open opens the store, creating Dir if needed. Records after a torn write
+// at the end of the newest segment are truncated away.
+func Open(opts Options) (*Store, error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultOptions().Capacity
+	}
+	s := &Store{opts: opts, recent: make(map[string]*ring)}
+	if opts.Dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	segments, err := listSegments(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return s, s.openSegment(1)
+	}
+
+	last := segments[len(segments)-1]
+	s.seq = last.firstSeq - 1
+	valid, err := readSegment(last.path, func(r *Record) bool {
+		s.seq, s.diskAt = r.Seq, r.Timestamp
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(last.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.f, s.size = f, valid
+	s.diskSeq = s.seq
+	return s, nil
+}
+
+func (s *Store) openSegment(firstSeq uint64) error {
+	f, err := os.OpenFile(segmentPath(s.opts.Dir, firstSeq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.f, s.size = f, 0
+	return nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// OnEvent records each trade.
+func (s *Store) OnEvent(ev engine.Event) {
+	if ev, ok := ev.(*engine.TradeExecuted); ok {
+		s.OnTrades(ev.Symbol, []engine.Trade{ev.Trade})
+	}
+}
+
+func (s *Store) OnTrades(symbol string, trades []engine.Trade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.ring(symbol)
+	var lines []byte
+	for _, t := range trades {
+		s.seq++
+		rec := Record{Seq: s.seq, Symbol: symbol, Trade: t}
+		r.push(rec)
+		if s.f != nil {
+			line, _ := json.Marshal(rec)
+			lines = append(append(lines, line...), '\n')
+		}
+	}
+	if len(lines) > 0 {
+		s.write(lines, s.seq-uint64(len(trades))+1)
+	}
+}
+
+// write appends lines, whose first record is firstSeq, to the current
+// segment. Failures are logged and stop further writes; memory keeps
+// working.
+func (s *Store) write(lines []byte, firstSeq uint64) {
+	if s.err != nil {
+		return
+	}
+	if s.opts.SegmentSize > 0 && s.size >= s.opts.SegmentSize {
+		if err := s.f.Close(); err != nil {
+			s.fail(err)
+			return
+		}
+		if err := s.openSegment(firstSeq); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+	n, err := s.f.Write(lines)
+	s.size += int64(n)
+	if err != nil {
+		s.fail(err)
+	}
+}
+
+func (s *Store) fail(err error) {
+	s.err = err
+	log.Printf("tradestore: writes stopped: %v", err)
+}
+
+func (s *Store) ring(symbol string) *ring {
+	r, ok := s.recent[symbol]
+	if !ok {
+		r = &ring{capacity: s.opts.Capacity, droppedSeq: s.diskSeq, droppedAt: s.diskAt}
+		s.recent[symbol] = r
+	}
+	return r
+}
+
+// Query returns the symbol's trades matching q, oldest first.
+func (s *Store) Query(q Query) ([]Record, error) {
+	complete := func(r *ring) bool {
+		return q.After >= r.droppedSeq || (q.From > 0 && q.From > r.droppedAt)
+	}
+	return s.find(q.Symbol, q.After, complete, q.matches, q.Limit)
+}
+
+// Fills returns the trades in symbol that orderID took part in, oldest
+// first. Trades before since, e.g. the order's arrival, are skipped.
+func (s *Store) Fills(symbol, orderID string, since int64) ([]Record, error) {
+	complete := func(r *ring) bool { return since > r.droppedAt }
+	return s.find(symbol, 0, complete, func(r *Record) bool {
+		return r.Timestamp >= since && (r.MakerOrderID == orderID || r.TakerOrderID == orderID)
+	}, 0)
+}
+
+// find returns up to limit of symbol's records after the given Seq that
+// match. It answers from memory when complete reports the symbol's ring
+// holds everything the query can match, or when there is no disk to read;
+// otherwise it reads the segments.
+func (s *Store) find(symbol string, after uint64, complete func(*ring) bool, match func(*Record) bool, limit int) ([]Record, error) {
+	s.mu.RLock()
+	r, ok := s.recent[symbol]
+	if !ok {
+		r = &ring{droppedSeq: s.diskSeq, droppedAt: s.diskAt}
+	}
+	if s.f == nil || r.droppedSeq == 0 || complete(r) {
+		defer s.mu.RUnlock()
+		var out []Record
+		r.each(func(rec *Record) bool {
+			if rec.Seq > after && match(rec) {
+				out = append(out, *rec)
+			}
+			return limit <= 0 || len(out) < limit
+		})
+		return out, nil
+	}
+	s.mu.RUnlock()
+
+	segments, err := listSegments(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for i, seg := range segments {
+		if i+1 < len(segments) && segments[i+1].firstSeq <= after+1 {
+			continue
+		}
+		_, err := readSegment(seg.path, func(rec *Record) bool {
+			if rec.Seq > after && rec.Symbol == symbol && match(rec) {
+				out = append(out, *rec)
+			}
+			return limit <= 0 || len(out) < limit
+		})
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// ring holds a symbol's most recent records.
+type ring struct {
+	buf      []Record
+	capacity int
+	start    int
+	// The newest record pushed out of memory
+	droppedSeq uint64
+	droppedAt  int64
+}
+
+func (r *ring) push(rec Record) {
+	if len(r.buf) < r.capacity {
+		r.buf = append(r.buf, rec)
+		return
+	}
+	old := r.buf[r.start]
+	r.droppedSeq, r.droppedAt = old.Seq, old.Timestamp
+	r.buf[r.start] = rec
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+func (r *ring) each(fn func(*Record) bool) {
+	for i := range r.buf {
+		if !fn(&r.buf[(r.start+i)%len(r.buf)]) {
+			return
+		}
+	}
+}
+
+type segment struct {
+	path     string
+	firstSeq uint64
+}
+
+func segmentPath(dir string, firstSeq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", firstSeq, segmentExt))
+}
+
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{path: filepath.Join(dir, name), firstSeq: seq})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].firstSeq < segments[j].firstSeq })
+	return segments, nil
+}
+
+// readSegment calls fn for each record in the segment until it returns
+// false, and returns the length of the segment's complete records. A
+// partial last line, from a torn or still running write, is ignored.
+func readSegment(path string, fn func(*Record) bool) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var valid int64
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			// With or without a partial line
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		var rec Record
+		if json.Unmarshal(line, &rec) != nil {
+			return valid, nil
+		}
+		valid += int64(len(line))
+		if !fn(&rec) {
+			return valid, nil
+		}
+	}
+}
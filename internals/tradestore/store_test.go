@@ -0,0 +1,89 @@
+package tradestore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Rishabhsingh78/orderMatchingEngine/internals/engine"
+)
+
+func trade(i int, maker, taker string) engine.Trade {
+	return engine.Trade{
+		ID:           fmt.Sprintf("t%d", i),
+		Price:        100 + int64(i),
+		Quantity:     1,
+		Timestamp:    int64(1000 * i),
+		MakerOrderID: maker,
+		TakerOrderID: taker,
+	}
+}
+
+func ids(records []Record) []string {
+	var out []string
+	for _, r := range records {
+		out = append(out, r.ID)
+	}
+	return out
+}
+
+func TestQueryPagesThroughMemory(t *testing.T) {
+	s, _ := Open(Options{Capacity: 10})
+	for i := 1; i <= 5; i++ {
+		s.OnTrades("BTCUSD", []engine.Trade{trade(i, "m", "t")})
+		s.OnTrades("ETHUSD", []engine.Trade{trade(i, "m", "t")})
+	}
+
+	page, _ := s.Query(Query{Symbol: "BTCUSD", From: 2000, To: 5000, Limit: 2})
+	if got := fmt.Sprint(ids(page)); got != "[t2 t3]" {
+		t.Fatalf("expected first page [t2 t3], got %s", got)
+	}
+	page, _ = s.Query(Query{Symbol: "BTCUSD", From: 2000, To: 5000, Limit: 2, After: page[1].Seq})
+	if got := fmt.Sprint(ids(page)); got != "[t4]" {
+		t.Errorf("expected second page [t4], got %s", got)
+	}
+}
+
+func TestDiskServesWhatMemoryDropped(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Capacity: 2, Dir: dir, SegmentSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 6; i++ {
+		s.OnTrades("BTCUSD", []engine.Trade{trade(i, "maker", fmt.Sprintf("taker%d", i))})
+	}
+	if segments, _ := listSegments(dir); len(segments) < 2 {
+		t.Fatalf("expected segments to roll, got %d", len(segments))
+	}
+
+	all, err := s.Query(Query{Symbol: "BTCUSD"})
+	if err != nil || len(all) != 6 {
+		t.Fatalf("expected all 6 trades from disk, got %d: %v", len(all), err)
+	}
+	fills, _ := s.Fills("BTCUSD", "maker", 0)
+	if len(fills) != 6 {
+		t.Errorf("expected 6 maker fills, got %d", len(fills))
+	}
+	s.Close()
+
+	// Reopening after a torn write continues the sequence
+	segments, _ := listSegments(dir)
+	f, _ := os.OpenFile(segments[len(segments)-1].path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"seq":7,"sym`)
+	f.Close()
+
+	s, err = Open(Options{Capacity: 2, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.OnTrades("BTCUSD", []engine.Trade{trade(7, "maker", "taker7")})
+	all, _ = s.Query(Query{Symbol: "BTCUSD", After: 5})
+	if len(all) != 2 || all[1].Seq != 7 || all[1].ID != "t7" {
+		t.Errorf("expected t6 and t7 after reopening, got %+v", all)
+	}
+	if fills, _ := s.Fills("BTCUSD", "taker2", 0); len(fills) != 1 || fills[0].ID != "t2" {
+		t.Errorf("expected taker2's fill from disk, got %+v", fills)
+	}
+}
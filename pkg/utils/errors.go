@@ -3,10 +3,62 @@ package utils
 import "errors"
 
 var (
-	ErrInvalidOrder          = errors.New("invalid order")
-	ErrOrderNotFound         = errors.New("order not found")
-	ErrInsufficientLiquidity = errors.New("insufficient liquidity")
-	ErrInvalidSymbol         = errors.New("invalid symbol")
-	ErrInvalidPrice          = errors.New("invalid price")
-	ErrInvalidQuantity       = errors.New("invalid quantity")
+	ErrInvalidOrder            = errors.New("invalid order")
+	ErrOrderNotFound           = errors.New("order not found")
+	ErrInsufficientLiquidity   = errors.New("insufficient liquidity")
+	ErrInvalidSymbol           = errors.New("invalid symbol")
+	ErrInvalidPrice            = errors.New("invalid price")
+	ErrInvalidQuantity         = errors.New("invalid quantity")
+	ErrInvalidTimeInForce      = errors.New("invalid time in force")
+	ErrInvalidExpireTime       = errors.New("invalid expire time")
+	ErrOrderNotOpen            = errors.New("order is not open")
+	ErrAmendUnchanged          = errors.New("amend does not change the order")
+	ErrInvalidMarketConfig     = errors.New("invalid market order config")
+	ErrJournalCorrupt          = errors.New("journal is corrupt")
+	ErrJournalFailed           = errors.New("journal write failed; engine stopped taking commands")
+	ErrAuditCorrupt            = errors.New("audit log is corrupt")
+	ErrAuditClosed             = errors.New("audit log is closed")
+	ErrAuditFailed             = errors.New("audit log write failed; orders are refused")
+	ErrFIXGarbled              = errors.New("garbled FIX message")
+	ErrGatewayClosed           = errors.New("gateway is closed")
+	ErrInvalidAPIKey           = errors.New("invalid API key")
+	ErrNotAuthenticated        = errors.New("authentication required")
+	ErrNotPermitted            = errors.New("not permitted for this client")
+	ErrReplayDiverged          = errors.New("journal replay diverged from recorded outcome")
+	ErrUnsupportedStateVersion = errors.New("unsupported snapshot version")
+	ErrEngineNotEmpty          = errors.New("engine already has state")
+	ErrUnknownAccount          = errors.New("unknown account")
+	ErrAccountExists           = errors.New("account already exists")
+	ErrInvalidAsset            = errors.New("invalid asset")
+	ErrInvalidRiskLimits       = errors.New("invalid risk limits")
+	ErrInsufficientFunds       = errors.New("insufficient funds")
+	ErrInsufficientPosition    = errors.New("insufficient position")
+	ErrMaxOrderSize            = errors.New("order exceeds max order size")
+	ErrMaxNotional             = errors.New("order exceeds max notional")
+	ErrMaxOpenOrders           = errors.New("too many open orders")
+	ErrNotionalOverflow        = errors.New("price times quantity is too large")
+
+	ErrInvalidSelfTradePrevention = errors.New("invalid self-trade prevention mode")
+	ErrInvalidOrderType           = errors.New("invalid order type")
+	ErrInvalidStopPrice           = errors.New("invalid stop price")
+	ErrAmendPendingStop           = errors.New("stop orders can't be amended before they trigger")
+	ErrInvalidDisplayQuantity     = errors.New("invalid display quantity")
+	ErrInvalidPostOnly            = errors.New("post-only orders must be limit orders that can rest")
+	ErrPostOnlyWouldCross         = errors.New("post-only order would take liquidity")
+	ErrInvalidHidden              = errors.New("hidden orders must be limit orders without a display quantity")
+	ErrUnknownSymbol              = errors.New("unknown symbol")
+	ErrInstrumentExists           = errors.New("instrument already exists")
+	ErrInvalidInstrument          = errors.New("invalid instrument")
+	ErrOffTick                    = errors.New("price is not a multiple of the tick size")
+	ErrOffLot                     = errors.New("quantity is not a multiple of the lot size")
+	ErrQuantityBelowMin           = errors.New("quantity below instrument minimum")
+	ErrQuantityAboveMax           = errors.New("quantity above instrument maximum")
+	ErrPriceOutOfBand             = errors.New("price outside instrument price band")
+	ErrMarketNotOpen              = errors.New("symbol is not open for trading")
+	ErrInvalidTradingState        = errors.New("invalid trading state")
+	ErrInvalidTradingConfig       = errors.New("invalid trading config")
+	ErrNotAuctionOrder            = errors.New("only orders that can rest may join an auction")
+	ErrBookExists                 = errors.New("symbol already has an order book with orders")
+	ErrUnknownShard               = errors.New("unknown shard")
+	ErrSymbolRequired             = errors.New("symbol is required to pick a shard")
 )